@@ -31,12 +31,27 @@ var (
 	commit  = "dev"
 )
 
+type cli struct {
+	Serve       parca.Flags            `cmd:"" default:"withargs" help:"Run the server, the default command."`
+	CheckConfig parca.CheckConfigFlags `cmd:"" name:"check-config" help:"Validate the config file without starting the server."`
+	Upload      parca.UploadFlags      `cmd:"" help:"Upload a pprof profile to a running server."`
+}
+
 func main() {
 	ctx := context.Background()
-	flags := &parca.Flags{}
+	cli := &cli{}
 
-	kong.Parse(flags)
+	kctx := kong.Parse(cli)
+	switch kctx.Command() {
+	case "check-config":
+		checkConfig(&cli.CheckConfig)
+		return
+	case "upload <path>":
+		upload(ctx, &cli.Upload)
+		return
+	}
 
+	flags := &cli.Serve
 	if flags.Version {
 		fmt.Printf("parca, version %s (commit: %s)\n", version, commit)
 		return
@@ -45,7 +60,7 @@ func main() {
 	serverStr := figure.NewColorFigure("Parca", "roman", "cyan", true)
 	serverStr.Print()
 
-	logger := parca.NewLogger(flags.LogLevel, parca.LogFormatLogfmt, "parca")
+	logger := parca.NewLogger(flags.LogLevel, flags.LogFormat, "parca")
 	level.Debug(logger).Log("msg", "parca initialized",
 		"version", version,
 		"commit", commit,
@@ -62,3 +77,21 @@ func main() {
 
 	level.Info(logger).Log("msg", "exited")
 }
+
+func checkConfig(flags *parca.CheckConfigFlags) {
+	cfg, err := parca.CheckConfig(flags.ConfigPath, flags.CheckDirectories)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("SUCCESS: %s is valid, %d scrape configs found\n", flags.ConfigPath, len(cfg.ScrapeConfigs))
+}
+
+func upload(ctx context.Context, flags *parca.UploadFlags) {
+	resp, err := parca.Upload(ctx, flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("uploaded %s, %d samples accepted, %d skipped\n", flags.Path, resp.AcceptedSamples, resp.SkippedSamples)
+}
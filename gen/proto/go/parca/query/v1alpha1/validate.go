@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -27,6 +28,7 @@ func (r *QueryRangeRequest) Validate() error {
 		validation.Field(&r.Start, validation.Required),
 		validation.Field(&r.End, validation.Required, isAfter(r.Start)),
 		validation.Field(&r.Query, validation.Required),
+		validation.Field(&r.Step, isPositiveDuration()),
 	)
 }
 
@@ -67,13 +69,45 @@ func (r *QueryRequest) Validate() error {
 		if err != nil {
 			return err
 		}
+	case QueryRequest_MODE_TRACE:
+		err := validateTrace(r.GetTrace())
+		if err != nil {
+			return err
+		}
+	case QueryRequest_MODE_UNION:
+		err := validateUnion(r.GetUnion())
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("invalid mode")
 	}
 
+	if r.Subtree != nil {
+		err := validateSubtree(r.Subtree, r.ReportType)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.LeavesOnly && r.ReportType != QueryRequest_REPORT_TYPE_TOP {
+		return fmt.Errorf("leaves_only is only supported for the top table")
+	}
+
 	return nil
 }
 
+func validateSubtree(subtree *Subtree, reportType QueryRequest_ReportType) error {
+	if reportType != QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED {
+		return fmt.Errorf("subtree is only supported for flame graphs")
+	}
+
+	return validation.ValidateStruct(subtree,
+		validation.Field(&subtree.FunctionName, validation.Required),
+		validation.Field(&subtree.Mode, validation.In(Subtree_MODE_MERGE_UNSPECIFIED, Subtree_MODE_LIST)),
+	)
+}
+
 func validateSingle(single *SingleProfile) error {
 	if single == nil {
 		return fmt.Errorf("single must not be unset")
@@ -97,6 +131,37 @@ func validateMerge(merge *MergeProfile) error {
 	)
 }
 
+func validateTrace(trace *TraceProfile) error {
+	if trace == nil {
+		return fmt.Errorf("trace must not be unset")
+	}
+
+	return validation.ValidateStruct(trace,
+		validation.Field(&trace.Start, validation.Required),
+		validation.Field(&trace.End, validation.Required, isAfter(trace.Start)),
+		validation.Field(&trace.Query, validation.Required),
+		validation.Field(&trace.TraceId, validation.Required),
+	)
+}
+
+func validateUnion(union *UnionProfile) error {
+	if union == nil {
+		return fmt.Errorf("union must not be unset")
+	}
+	if len(union.Merges) < 2 {
+		return fmt.Errorf("union must have at least two merges")
+	}
+
+	for _, merge := range union.Merges {
+		err := validateMerge(merge)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func validateDiff(diff *DiffProfile) error {
 	if diff == nil {
 		return fmt.Errorf("diff must not be unset")
@@ -225,6 +290,16 @@ func (o ProfileOptionMatchesRule) Validate(v interface{}) error {
 			return fmt.Errorf("invalid option for mode")
 		}
 		return nil
+	case QueryRequest_MODE_TRACE:
+		if _, ok := option.(*QueryRequest_Trace); !ok {
+			return fmt.Errorf("invalid option for mode")
+		}
+		return nil
+	case QueryRequest_MODE_UNION:
+		if _, ok := option.(*QueryRequest_Union); !ok {
+			return fmt.Errorf("invalid option for mode")
+		}
+		return nil
 	default:
 		return fmt.Errorf("invalid query request mode")
 	}
@@ -308,3 +383,28 @@ func (a AfterRule) Validate(t interface{}) error {
 
 	return nil
 }
+
+// PositiveDurationRule validates that the duration, if set, is positive.
+type PositiveDurationRule struct{}
+
+func isPositiveDuration() PositiveDurationRule { return PositiveDurationRule{} }
+
+// Validate runs the validation function for the PositiveDurationRule.
+func (r PositiveDurationRule) Validate(v interface{}) error {
+	d, ok := v.(*durationpb.Duration)
+	if !ok {
+		return fmt.Errorf("step is not a duration")
+	}
+	if d == nil {
+		return nil
+	}
+
+	if err := d.CheckValid(); err != nil {
+		return err
+	}
+	if d.AsDuration() <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+
+	return nil
+}
@@ -19,9 +19,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/alecthomas/units"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	commonconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
@@ -30,6 +33,7 @@ import (
 	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/thanos-io/objstore/client"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/yaml.v2"
 )
 
@@ -43,10 +47,306 @@ const (
 
 // Config holds all the configuration information for Parca.
 type Config struct {
-	ObjectStorage *ObjectStorage  `yaml:"object_storage,omitempty"`
-	ScrapeConfigs []*ScrapeConfig `yaml:"scrape_configs,omitempty"`
+	ObjectStorage       *ObjectStorage        `yaml:"object_storage,omitempty"`
+	ScrapeConfigs       []*ScrapeConfig       `yaml:"scrape_configs,omitempty"`
+	MappingPathRewrites []*MappingPathRewrite `yaml:"mapping_path_rewrites,omitempty"`
+	// SampleTypeAggregations configures how the values of sample types, like
+	// inuse_space, are aggregated over time when profiles are merged. Values
+	// are summed by default. They are read on startup only.
+	SampleTypeAggregations map[string]SampleTypeAggregation `yaml:"sample_type_aggregations,omitempty"`
+	// SeriesNameTemplate names the series of the sample types of written
+	// profiles, rather than storing them under the name of the profile. It
+	// is read on startup only.
+	SeriesNameTemplate SeriesNameTemplate `yaml:"series_name_template,omitempty"`
+	// Storage configures how long the profile data persisted to object
+	// storage is kept.
+	Storage *StorageConfig `yaml:"storage,omitempty"`
+	// DebugInfo configures where debuginfo missing from object storage is
+	// downloaded from.
+	DebugInfo *DebugInfoConfig `yaml:"debug_info,omitempty"`
+	// Auth configures the credentials requests to the server must carry. It
+	// is read on startup only.
+	Auth *AuthConfig `yaml:"auth,omitempty"`
+	// RemoteWriteConfigs configure the endpoints the profiles written to the
+	// store are forwarded to.
+	RemoteWriteConfigs []*RemoteWriteConfig `yaml:"remote_write,omitempty"`
+	// WriteRelabelConfigs relabel the series of written profiles before
+	// they are stored and forwarded to remote write endpoints, series may
+	// be dropped by them.
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs,omitempty"`
 }
 
+// AuthConfig configures the credentials gRPC requests and the requests of the
+// HTTP API must carry in their authorization header. Any of the configured
+// credentials is accepted. It takes precedence over the --auth-bearer-token
+// flags. Health checks, metrics and the UI stay open.
+type AuthConfig struct {
+	BearerToken     commonconfig.Secret `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string              `yaml:"bearer_token_file,omitempty"`
+	// BasicAuthUsers maps the usernames of basic auth to the bcrypt hashes
+	// of their passwords.
+	BasicAuthUsers map[string]commonconfig.Secret `yaml:"basic_auth_users,omitempty"`
+	// ExemptMethods are the gRPC methods, like
+	// /parca.query.v1alpha1.QueryService/Labels, and HTTP API paths, like
+	// /api/profiles/upload, that don't require credentials.
+	ExemptMethods []string `yaml:"exempt_methods,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *AuthConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain AuthConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return errors.New("at most one of bearer_token and bearer_token_file must be configured")
+	}
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash for basic auth user %q: %w", user, err)
+		}
+	}
+	for _, m := range c.ExemptMethods {
+		if !strings.HasPrefix(m, "/") {
+			return fmt.Errorf("exempt method %q must start with /", m)
+		}
+	}
+	if c.BearerToken == "" && c.BearerTokenFile == "" && len(c.BasicAuthUsers) == 0 {
+		return errors.New("auth requires a bearer token or basic auth users")
+	}
+	return nil
+}
+
+// SetDirectory joins the relative path of the bearer token file with dir.
+func (c *AuthConfig) SetDirectory(dir string) {
+	c.BearerTokenFile = commonconfig.JoinDir(dir, c.BearerTokenFile)
+}
+
+// DebugInfoConfig configures the debuginfod servers debuginfo is downloaded
+// from if it wasn't uploaded, in the order they are tried. It takes
+// precedence over the --debug-infod-upstream-servers flag, an empty list
+// disables downloads.
+type DebugInfoConfig struct {
+	DebuginfodURLs []string `yaml:"debuginfod_urls"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *DebugInfoConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DebugInfoConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	for _, u := range c.DebuginfodURLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("invalid debuginfod URL %q: %w", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("invalid debuginfod URL %q, must be an http or https URL", u)
+		}
+	}
+	return nil
+}
+
+// DefaultQueueConfig is the queue config of remote write endpoints without
+// one.
+var DefaultQueueConfig = QueueConfig{
+	Capacity:          1000,
+	MaxSamplesPerSend: 100,
+	BatchSendDeadline: model.Duration(5 * time.Second),
+	MinBackoff:        model.Duration(100 * time.Millisecond),
+	MaxBackoff:        model.Duration(10 * time.Second),
+	MaxRetries:        10,
+}
+
+// RemoteWriteConfig configures a Parca-compatible endpoint every profile
+// accepted by the store is forwarded to, after relabeling its series with
+// the write relabel configs. Profiles forwarded by another instance aren't
+// forwarded again, so two instances can forward to each other.
+type RemoteWriteConfig struct {
+	// Name identifies the endpoint in logs and metrics, it defaults to the
+	// address.
+	Name string `yaml:"name,omitempty"`
+	// Address is the gRPC address of the endpoint.
+	Address string `yaml:"address"`
+	// Insecure sends requests via plaintext instead of TLS.
+	Insecure        bool                   `yaml:"insecure,omitempty"`
+	TLSConfig       commonconfig.TLSConfig `yaml:"tls_config,omitempty"`
+	BearerToken     commonconfig.Secret    `yaml:"bearer_token,omitempty"`
+	BearerTokenFile string                 `yaml:"bearer_token_file,omitempty"`
+	// Headers are sent as gRPC metadata with every request.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// RemoteTimeout is the timeout of a request to the endpoint.
+	RemoteTimeout       model.Duration    `yaml:"remote_timeout,omitempty"`
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs,omitempty"`
+	QueueConfig         QueueConfig       `yaml:"queue_config,omitempty"`
+}
+
+// QueueConfig configures the buffering of the profiles forwarded to a remote
+// write endpoint.
+type QueueConfig struct {
+	// Capacity is the number of profiles buffered. Profiles are dropped
+	// while the buffer is full, writes are never blocked by an endpoint.
+	Capacity int `yaml:"capacity,omitempty"`
+	// MaxSamplesPerSend is the maximum number of profiles of a request.
+	MaxSamplesPerSend int `yaml:"max_samples_per_send,omitempty"`
+	// BatchSendDeadline is how long profiles wait for a request to fill up.
+	BatchSendDeadline model.Duration `yaml:"batch_send_deadline,omitempty"`
+	// Failed requests are retried after a backoff doubling from MinBackoff
+	// up to MaxBackoff, at most MaxRetries times.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+	MaxRetries int            `yaml:"max_retries,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = RemoteWriteConfig{
+		RemoteTimeout: model.Duration(30 * time.Second),
+		QueueConfig:   DefaultQueueConfig,
+	}
+	type plain RemoteWriteConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.Address == "" {
+		return errors.New("remote write address is empty")
+	}
+	if c.Name == "" {
+		c.Name = c.Address
+	}
+	if c.BearerToken != "" && c.BearerTokenFile != "" {
+		return fmt.Errorf("at most one of bearer_token and bearer_token_file must be configured for remote write %q", c.Name)
+	}
+	if c.RemoteTimeout <= 0 {
+		return fmt.Errorf("remote_timeout must be positive for remote write %q", c.Name)
+	}
+	for _, rlcfg := range c.WriteRelabelConfigs {
+		if rlcfg == nil {
+			return fmt.Errorf("empty or null relabeling rule in remote write %q", c.Name)
+		}
+	}
+
+	q := c.QueueConfig
+	if q.Capacity <= 0 || q.MaxSamplesPerSend <= 0 {
+		return fmt.Errorf("capacity and max_samples_per_send must be positive for remote write %q", c.Name)
+	}
+	if q.BatchSendDeadline <= 0 || q.MinBackoff <= 0 || q.MaxBackoff < q.MinBackoff {
+		return fmt.Errorf("batch_send_deadline and min_backoff must be positive and max_backoff at least min_backoff for remote write %q", c.Name)
+	}
+	if q.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative for remote write %q", c.Name)
+	}
+	return nil
+}
+
+// SetDirectory joins any relative file paths with dir.
+func (c *RemoteWriteConfig) SetDirectory(dir string) {
+	c.TLSConfig.SetDirectory(dir)
+	c.BearerTokenFile = commonconfig.JoinDir(dir, c.BearerTokenFile)
+}
+
+// StorageConfig configures the retention of the blocks of profile data
+// persisted to object storage. The oldest blocks are deleted once they are
+// older than the retention time or once all blocks together are larger than
+// the retention size. A value of 0 means no limit.
+type StorageConfig struct {
+	RetentionTime model.Duration   `yaml:"retention_time,omitempty"`
+	RetentionSize units.Base2Bytes `yaml:"retention_size,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *StorageConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain StorageConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.RetentionSize < 0 {
+		return fmt.Errorf("retention size must not be negative, got %s", c.RetentionSize)
+	}
+	return nil
+}
+
+// MappingPathRewrite rewrites the file names of mappings of ingested
+// profiles, so that the same binary at different paths, like versioned
+// temporary directories, is stored as the same mapping.
+type MappingPathRewrite struct {
+	// Regex is matched against the whole file name.
+	Regex relabel.Regexp `yaml:"regex"`
+	// Replacement replaces matching file names, it may refer to capture
+	// groups of the regex like $1.
+	Replacement string `yaml:"replacement"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *MappingPathRewrite) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain MappingPathRewrite
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if r.Regex.Regexp == nil {
+		return errors.New("mapping path rewrite regex is empty")
+	}
+	return nil
+}
+
+// SampleTypeAggregation is one of sum, max or last.
+type SampleTypeAggregation string
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *SampleTypeAggregation) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "sum", "max", "last":
+	default:
+		return fmt.Errorf("unknown sample type aggregation %q, must be one of sum, max or last", s)
+	}
+	*a = SampleTypeAggregation(s)
+	return nil
+}
+
+// SeriesNameTemplate is a text/template of the name of the series of a sample
+// type, executed with its .Name, .Type, .Unit, .PeriodType and .PeriodUnit.
+// Names may only consist of letters, digits and underscores, as queries
+// append the sample type to them separated by colons.
+type SeriesNameTemplate string
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *SeriesNameTemplate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	if s == "" {
+		*t = ""
+		return nil
+	}
+	tmpl, err := template.New("series_name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid series name template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]string{
+		"Name":       "memory",
+		"Type":       "alloc_objects",
+		"Unit":       "count",
+		"PeriodType": "space",
+		"PeriodUnit": "bytes",
+	}); err != nil {
+		return fmt.Errorf("invalid series name template: %w", err)
+	}
+	if !seriesNameRegexp.MatchString(b.String()) {
+		return fmt.Errorf("series name template results in invalid name %q, names may only consist of letters, digits and underscores", b.String())
+	}
+	*t = SeriesNameTemplate(s)
+	return nil
+}
+
+var seriesNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type ObjectStorage struct {
 	Bucket *client.BucketConfig `yaml:"bucket,omitempty"`
 }
@@ -58,6 +358,16 @@ func (c *Config) Validate() error {
 	)
 }
 
+// ValidateStatic returns an error if the config is not valid, like Validate,
+// but without checking that the directory of a filesystem bucket is
+// writable, so configs can be checked on another machine than they are used
+// on.
+func (c *Config) ValidateStatic() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.ObjectStorage, validation.Required, StaticValid),
+	)
+}
+
 func trueValue() *bool {
 	a := true
 	return &a
@@ -109,6 +419,12 @@ func (c *Config) SetDirectory(dir string) {
 	for _, c := range c.ScrapeConfigs {
 		c.SetDirectory(dir)
 	}
+	if c.Auth != nil {
+		c.Auth.SetDirectory(dir)
+	}
+	for _, c := range c.RemoteWriteConfigs {
+		c.SetDirectory(dir)
+	}
 }
 
 // Load parses the YAML input s into a Config.
@@ -120,6 +436,23 @@ func Load(s string) (*Config, error) {
 		return nil, err
 	}
 
+	// Metrics of remote write endpoints are labeled with their name.
+	names := map[string]struct{}{}
+	for _, rw := range cfg.RemoteWriteConfigs {
+		if rw == nil {
+			return nil, errors.New("empty or null remote write config")
+		}
+		if _, ok := names[rw.Name]; ok {
+			return nil, fmt.Errorf("found multiple remote write configs named %q", rw.Name)
+		}
+		names[rw.Name] = struct{}{}
+	}
+	for _, rlcfg := range cfg.WriteRelabelConfigs {
+		if rlcfg == nil {
+			return nil, errors.New("empty or null write relabeling rule")
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -147,6 +480,14 @@ type ScrapeConfig struct {
 	ScrapeInterval model.Duration `yaml:"scrape_interval,omitempty"`
 	// The timeout for scraping targets of this config.
 	ScrapeTimeout model.Duration `yaml:"scrape_timeout,omitempty"`
+	// The maximum number of scrapes of this config that may run at the same
+	// time, within the limit of --scrape-max-concurrency on the scrapes of
+	// all configs. A value of 0 means no limit.
+	MaxConcurrentScrapes int `yaml:"max_concurrent_scrapes,omitempty"`
+	// The maximum number of samples of scraped profiles. Larger profiles
+	// keep the samples with the highest values, the rest is added up in a
+	// single sample. A value of 0 means no limit.
+	MaxProfileSamples int `yaml:"max_profile_samples,omitempty"`
 	// The URL scheme with which to fetch metrics from targets.
 	Scheme string `yaml:"scheme,omitempty"`
 
@@ -251,6 +592,12 @@ func (c *ScrapeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if c.ScrapeTimeout == 0 {
 		c.ScrapeTimeout = c.ScrapeInterval
 	}
+	if c.MaxConcurrentScrapes < 0 {
+		return fmt.Errorf("max_concurrent_scrapes must not be negative for: %v", c.JobName)
+	}
+	if c.MaxProfileSamples < 0 {
+		return fmt.Errorf("max_profile_samples must not be negative for: %v", c.JobName)
+	}
 	if cfg, ok := c.ProfilingConfig.PprofConfig[pprofProcessCPU]; ok {
 		if *cfg.Enabled && c.ScrapeTimeout < model.Duration(time.Second*2) {
 			return fmt.Errorf("%v scrape_timeout must be at least 2 seconds in %v", pprofProcessCPU, c.JobName)
@@ -14,13 +14,17 @@
 package config
 
 import (
+	"path/filepath"
 	"testing"
 	"time"
 
+	commonconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/stretchr/testify/require"
 	"github.com/thanos-io/objstore/client"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestLoad(t *testing.T) {
@@ -35,6 +39,55 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestLoadNegativeMaxConcurrentScrapes(t *testing.T) {
+	t.Parallel()
+
+	_, err := Load(`scrape_configs:
+- job_name: 'test'
+  max_concurrent_scrapes: -1
+  static_configs:
+  - targets: ['localhost:8080']`)
+	require.Error(t, err)
+}
+
+func TestLoadMappingPathRewrites(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`mapping_path_rewrites:
+- regex: '/tmp/build-[0-9]+/(.*)'
+  replacement: '/opt/$1'`)
+	require.NoError(t, err)
+	require.Len(t, cfg.MappingPathRewrites, 1)
+	require.Equal(t, "/opt/$1", cfg.MappingPathRewrites[0].Replacement)
+	require.True(t, cfg.MappingPathRewrites[0].Regex.MatchString("/tmp/build-123/app"))
+	require.False(t, cfg.MappingPathRewrites[0].Regex.MatchString("/var/tmp/build-123/app"))
+
+	_, err = Load(`mapping_path_rewrites:
+- replacement: '/opt/app'`)
+	require.Error(t, err)
+
+	_, err = Load(`mapping_path_rewrites:
+- regex: '('`)
+	require.Error(t, err)
+}
+
+func TestLoadSampleTypeAggregations(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`sample_type_aggregations:
+  inuse_space: max
+  inuse_objects: last`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]SampleTypeAggregation{
+		"inuse_space":   "max",
+		"inuse_objects": "last",
+	}, cfg.SampleTypeAggregations)
+
+	_, err = Load(`sample_type_aggregations:
+  inuse_space: avg`)
+	require.Error(t, err)
+}
+
 func TestLoadComplex(t *testing.T) {
 	t.Parallel()
 
@@ -224,6 +277,30 @@ func Test_Config_Validation(t *testing.T) {
 				},
 			},
 		},
+		"unsupportedType": {
+			ObjectStorage: &ObjectStorage{
+				Bucket: &client.BucketConfig{
+					Type: "FTP",
+					Config: struct {
+						Bucket string
+					}{
+						Bucket: "parca",
+					},
+				},
+			},
+		},
+		"fileDirectory": {
+			ObjectStorage: &ObjectStorage{
+				Bucket: &client.BucketConfig{
+					Type: client.FILESYSTEM,
+					Config: struct {
+						Directory string
+					}{
+						Directory: "config.go",
+					},
+				},
+			},
+		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
@@ -232,3 +309,222 @@ func Test_Config_Validation(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateFilesystemDirectory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, directory := range []string{dir, filepath.Join(dir, "data")} {
+		c := Config{
+			ObjectStorage: &ObjectStorage{
+				Bucket: &client.BucketConfig{
+					Type: client.FILESYSTEM,
+					Config: map[string]interface{}{
+						"directory": directory,
+					},
+				},
+			},
+		}
+		require.NoError(t, c.Validate())
+	}
+
+	// Like the bucket client, the type is case-insensitive.
+	c := Config{
+		ObjectStorage: &ObjectStorage{
+			Bucket: &client.BucketConfig{
+				Type: "filesystem",
+				Config: map[string]interface{}{
+					"directory": "config.go",
+				},
+			},
+		},
+	}
+	require.ErrorContains(t, c.Validate(), "is not a directory")
+
+	// Every problem is reported, not only the first.
+	c = Config{
+		ObjectStorage: &ObjectStorage{
+			Bucket: &client.BucketConfig{},
+		},
+	}
+	err := c.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Config: cannot be blank")
+	require.Contains(t, err.Error(), "Type: cannot be blank")
+}
+
+func TestLoadSeriesNameTemplate(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`series_name_template: "{{.Name}}_{{.Type}}_{{.Unit}}"`)
+	require.NoError(t, err)
+	require.Equal(t, SeriesNameTemplate("{{.Name}}_{{.Type}}_{{.Unit}}"), cfg.SeriesNameTemplate)
+
+	// Colons separate the name from the sample type in queries.
+	_, err = Load(`series_name_template: "{{.Name}}:{{.Type}}"`)
+	require.Error(t, err)
+
+	_, err = Load(`series_name_template: "{{.Name}}_{{.Kind}}"`)
+	require.Error(t, err)
+}
+
+func TestLoadStorage(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`storage:
+  retention_time: 15d
+  retention_size: 50GB`)
+	require.NoError(t, err)
+	require.Equal(t, model.Duration(15*24*time.Hour), cfg.Storage.RetentionTime)
+	require.Equal(t, int64(50<<30), int64(cfg.Storage.RetentionSize))
+
+	_, err = Load(`storage:
+  retention_size: -1GB`)
+	require.Error(t, err)
+
+	_, err = Load(`storage:
+  retention_size: lots`)
+	require.Error(t, err)
+}
+
+func TestLoadDebugInfo(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`debug_info:
+  debuginfod_urls:
+  - https://debuginfod.example.com
+  - http://localhost:8002/`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://debuginfod.example.com", "http://localhost:8002/"}, cfg.DebugInfo.DebuginfodURLs)
+
+	// An empty list disables downloads, rather than falling back to the
+	// flag.
+	cfg, err = Load(`debug_info:
+  debuginfod_urls: []`)
+	require.NoError(t, err)
+	require.NotNil(t, cfg.DebugInfo)
+	require.Empty(t, cfg.DebugInfo.DebuginfodURLs)
+
+	_, err = Load(`debug_info:
+  debuginfod_urls:
+  - debuginfod.example.com`)
+	require.Error(t, err)
+}
+
+func TestLoadAuth(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	cfg, err := Load(`auth:
+  bearer_token_file: token
+  basic_auth_users:
+    agent: ` + string(hash) + `
+  exempt_methods:
+  - /grpc.health.v1.Health/Check`)
+	require.NoError(t, err)
+	require.Equal(t, commonconfig.Secret(hash), cfg.Auth.BasicAuthUsers["agent"])
+	cfg.SetDirectory("/etc/parca")
+	require.Equal(t, "/etc/parca/token", cfg.Auth.BearerTokenFile)
+
+	for name, c := range map[string]string{
+		"no credentials": `auth:
+  exempt_methods:
+  - /grpc.health.v1.Health/Check`,
+		"token and file": `auth:
+  bearer_token: secret
+  bearer_token_file: token`,
+		"invalid hash": `auth:
+  basic_auth_users:
+    agent: secret`,
+		"invalid method": `auth:
+  bearer_token: secret
+  exempt_methods:
+  - Check`,
+	} {
+		_, err := Load(c)
+		require.Error(t, err, name)
+	}
+}
+
+func TestLoadRemoteWrite(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`
+remote_write:
+  - address: central:7070
+    bearer_token: secret
+    write_relabel_configs:
+      - source_labels: [job]
+        regex: debug
+        action: drop
+  - name: replica
+    address: replica:7070
+    insecure: true
+    queue_config:
+      capacity: 10
+`)
+	require.NoError(t, err)
+	require.Len(t, cfg.RemoteWriteConfigs, 2)
+
+	central := cfg.RemoteWriteConfigs[0]
+	require.Equal(t, "central:7070", central.Name)
+	require.Equal(t, model.Duration(30*time.Second), central.RemoteTimeout)
+	require.Equal(t, DefaultQueueConfig, central.QueueConfig)
+	require.Len(t, central.WriteRelabelConfigs, 1)
+
+	// Unset queue settings keep their defaults.
+	replica := cfg.RemoteWriteConfigs[1]
+	require.Equal(t, 10, replica.QueueConfig.Capacity)
+	require.Equal(t, DefaultQueueConfig.MaxSamplesPerSend, replica.QueueConfig.MaxSamplesPerSend)
+
+	for name, s := range map[string]string{
+		"noAddress": `
+remote_write:
+  - name: central
+`,
+		"duplicateName": `
+remote_write:
+  - address: central:7070
+  - address: central:7070
+`,
+		"bothBearerTokens": `
+remote_write:
+  - address: central:7070
+    bearer_token: secret
+    bearer_token_file: token
+`,
+		"zeroCapacity": `
+remote_write:
+  - address: central:7070
+    queue_config:
+      capacity: 0
+`,
+	} {
+		_, err := Load(s)
+		require.Error(t, err, name)
+	}
+}
+
+func TestLoadWriteRelabel(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := Load(`
+write_relabel_configs:
+  - regex: pod_uid
+    action: labeldrop
+  - source_labels: [job]
+    regex: debug
+    action: drop
+`)
+	require.NoError(t, err)
+	require.Len(t, cfg.WriteRelabelConfigs, 2)
+	require.Equal(t, relabel.LabelDrop, cfg.WriteRelabelConfigs[0].Action)
+
+	_, err = Load(`
+write_relabel_configs:
+  -
+`)
+	require.Error(t, err)
+}
@@ -16,6 +16,12 @@ package config
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -24,10 +30,52 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// ComponentReloader describes how to reload a component.
+// ComponentReloader describes how to reload a component. Prepare checks the
+// config and builds what the component needs to apply it, without changing
+// the component. A config is only applied once every component prepared it,
+// so it is applied to all of them or to none.
 type ComponentReloader struct {
-	Name     string
-	Reloader func(*Config) error
+	Name    string
+	Prepare func(*Config) (Reload, error)
+}
+
+// Reload is a config prepared for a component.
+type Reload struct {
+	// Apply swaps the prepared config in, it can't fail anymore.
+	Apply func()
+	// Discard releases what was built for the config, if it isn't applied
+	// because another component failed to prepare it. It may be nil.
+	Discard func()
+}
+
+// StaticReloader returns a reloader rejecting configs that change the
+// sections of the config the components read on startup only, rather than
+// silently ignoring the changes until the next restart.
+func StaticReloader(current *Config) ComponentReloader {
+	return ComponentReloader{
+		Name: "static",
+		Prepare: func(cfg *Config) (Reload, error) {
+			var changed []string
+			for _, section := range []struct {
+				name          string
+				current, next interface{}
+			}{
+				{"object_storage", current.ObjectStorage, cfg.ObjectStorage},
+				{"mapping_path_rewrites", current.MappingPathRewrites, cfg.MappingPathRewrites},
+				{"sample_type_aggregations", current.SampleTypeAggregations, cfg.SampleTypeAggregations},
+				{"series_name_template", current.SeriesNameTemplate, cfg.SeriesNameTemplate},
+				{"auth", current.Auth, cfg.Auth},
+			} {
+				if !reflect.DeepEqual(section.current, section.next) {
+					changed = append(changed, section.name)
+				}
+			}
+			if len(changed) > 0 {
+				return Reload{}, fmt.Errorf("%s can't be reloaded, changing them requires a restart", strings.Join(changed, ", "))
+			}
+			return Reload{Apply: func() {}}, nil
+		},
+	}
 }
 
 // ConfigReloader holds all information required to reload Parca's config into its running components.
@@ -37,6 +85,7 @@ type ConfigReloader struct {
 	watcher           *fsnotify.Watcher
 	reloaders         []ComponentReloader
 	triggerReload     chan struct{}
+	reloadRequests    chan chan error
 	configSuccess     prometheus.Gauge
 	configSuccessTime prometheus.Gauge
 }
@@ -66,7 +115,8 @@ func NewConfigReloader(
 
 		reloaders: reloaders,
 
-		triggerReload: make(chan struct{}, 1),
+		triggerReload:  make(chan struct{}, 1),
+		reloadRequests: make(chan chan error),
 
 		configSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "parca_config_last_reload_successful",
@@ -134,17 +184,25 @@ func (r *ConfigReloader) reloadFile() (err error) {
 		return fmt.Errorf("parsed configuration invalid (--config-path=%q): %w", r.filename, err)
 	}
 
-	failed := false
+	// Every component prepares the config before any applies it, so a
+	// config failing for one component isn't partially applied.
+	reloads := make([]Reload, 0, len(r.reloaders))
 	for _, rl := range r.reloaders {
 		rstart := time.Now()
-		if err := rl.Reloader(cfg); err != nil {
-			level.Error(r.logger).Log("msg", "failed to apply configuration", "err", err)
-			failed = true
+		reload, err := rl.Prepare(cfg)
+		if err != nil {
+			for _, prepared := range reloads {
+				if prepared.Discard != nil {
+					prepared.Discard()
+				}
+			}
+			return fmt.Errorf("failed to prepare the new configuration of %s, no changes applied (--config-path=%q): %w", rl.Name, r.filename, err)
 		}
+		reloads = append(reloads, reload)
 		timings = append(timings, rl.Name, time.Since(rstart))
 	}
-	if failed {
-		return fmt.Errorf("one or more errors occurred while applying the new configuration (--config-path=%q)", r.filename)
+	for _, reload := range reloads {
+		reload.Apply()
 	}
 
 	l := []interface{}{"msg", "completed loading of configuration file", "filename", r.filename, "totalDuration", time.Since(start)}
@@ -152,8 +210,15 @@ func (r *ConfigReloader) reloadFile() (err error) {
 	return nil
 }
 
-// Run starts watching the config file and wait for reload triggers.
+// Run starts watching the config file and wait for reload triggers. The
+// config file is reloaded when it is written to or the process receives a
+// SIGHUP. The components keep their previous configuration if the new one
+// fails to load or to be prepared by any of them.
 func (r *ConfigReloader) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
 	go r.watchFile()
 	for {
 		select {
@@ -161,9 +226,56 @@ func (r *ConfigReloader) Run(ctx context.Context) error {
 			if err := r.reloadFile(); err != nil {
 				level.Error(r.logger).Log("msg", "failed to reload configuration file", "err", err)
 			}
+		case <-hup:
+			level.Info(r.logger).Log("msg", "received SIGHUP, reloading configuration file")
+			if err := r.reloadFile(); err != nil {
+				level.Error(r.logger).Log("msg", "failed to reload configuration file", "err", err)
+			}
+		case done := <-r.reloadRequests:
+			level.Info(r.logger).Log("msg", "reload requested, reloading configuration file")
+			err := r.reloadFile()
+			if err != nil {
+				level.Error(r.logger).Log("msg", "failed to reload configuration file", "err", err)
+			}
+			done <- err
 		case <-ctx.Done():
 			r.watcher.Close()
 			return nil
 		}
 	}
 }
+
+// Reload reloads the config file and returns once it is applied, or the
+// error it failed with. Reloads are serialized with the reloads of Run,
+// which has to be running.
+func (r *ConfigReloader) Reload(ctx context.Context) error {
+	done := make(chan error, 1)
+	select {
+	case r.reloadRequests <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handler returns an HTTP handler reloading the config file on POST and PUT
+// requests. It responds with an internal server error if the reload fails.
+func (r *ConfigReloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, "only POST and PUT requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(req.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
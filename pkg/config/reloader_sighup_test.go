@@ -0,0 +1,56 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package config
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReloadSIGHUP doesn't run in parallel, as the signal reloads the
+// reloaders of other tests too.
+func TestReloadSIGHUP(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	f, reloadConfig := setupReloader(ctx, t)
+	defer f.Close()
+
+	// The config is reloaded on SIGHUP even if it wasn't written to.
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case cfg := <-reloadConfig:
+		require.Equal(t, model.Duration(3*time.Second), cfg.ScrapeConfigs[0].ScrapeInterval)
+	case <-ctx.Done():
+		t.Fatal("configuration reload on SIGHUP timed out")
+	}
+
+	// An invalid config isn't applied, the previous one stays live.
+	_, err := f.WriteString("{")
+	require.NoError(t, err)
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	select {
+	case <-reloadConfig:
+		t.Fatal("invalid configuration was reloaded")
+	case <-ctx.Done():
+	}
+}
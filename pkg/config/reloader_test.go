@@ -15,8 +15,12 @@ package config
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -60,9 +64,10 @@ scrape_configs:
 	reloaders := []ComponentReloader{
 		{
 			Name: "test",
-			Reloader: func(cfg *Config) error {
-				reloadConfig <- cfg
-				return nil
+			Prepare: func(cfg *Config) (Reload, error) {
+				return Reload{Apply: func() {
+					reloadConfig <- cfg
+				}}, nil
 			},
 		},
 	}
@@ -124,3 +129,134 @@ func TestReloadInvalid(t *testing.T) {
 	case <-ctx.Done():
 	}
 }
+
+func TestReloadHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filename := filepath.Join(t.TempDir(), "parca.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`object_storage:
+  bucket:
+    type: "FILESYSTEM"
+    config:
+      directory: "./tmp"
+`), 0o644))
+
+	var reloads int64
+	r, err := NewConfigReloader(log.NewNopLogger(), prometheus.NewRegistry(), filename, []ComponentReloader{{
+		Name: "test",
+		Prepare: func(cfg *Config) (Reload, error) {
+			return Reload{Apply: func() {
+				atomic.AddInt64(&reloads, 1)
+			}}, nil
+		},
+	}})
+	require.NoError(t, err)
+	go r.Run(ctx)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.Handler().ServeHTTP(w, httptest.NewRequest(method, "/-/reload", nil))
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodPost).Code)
+	require.Equal(t, int64(1), atomic.LoadInt64(&reloads))
+	require.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet).Code)
+
+	// Invalid configs aren't applied.
+	require.NoError(t, os.WriteFile(filename, []byte("{"), 0o644))
+	before := atomic.LoadInt64(&reloads)
+	require.Equal(t, http.StatusInternalServerError, serve(http.MethodPut).Code)
+	require.Equal(t, before, atomic.LoadInt64(&reloads))
+}
+
+func TestReloadAllOrNothing(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filename := filepath.Join(t.TempDir(), "parca.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`object_storage:
+  bucket:
+    type: "FILESYSTEM"
+    config:
+      directory: "./tmp"
+`), 0o644))
+
+	var applied, discarded, fail int64
+	r, err := NewConfigReloader(log.NewNopLogger(), prometheus.NewRegistry(), filename, []ComponentReloader{{
+		Name: "first",
+		Prepare: func(cfg *Config) (Reload, error) {
+			return Reload{
+				Apply:   func() { atomic.AddInt64(&applied, 1) },
+				Discard: func() { atomic.AddInt64(&discarded, 1) },
+			}, nil
+		},
+	}, {
+		Name: "second",
+		Prepare: func(cfg *Config) (Reload, error) {
+			if atomic.LoadInt64(&fail) == 1 {
+				return Reload{}, errors.New("unsupported")
+			}
+			return Reload{Apply: func() { atomic.AddInt64(&applied, 1) }}, nil
+		},
+	}})
+	require.NoError(t, err)
+	go r.Run(ctx)
+
+	require.NoError(t, r.Reload(ctx))
+	require.Equal(t, int64(2), atomic.LoadInt64(&applied))
+
+	// A component failing to prepare the config keeps the others from
+	// applying it.
+	atomic.StoreInt64(&fail, 1)
+	err = r.Reload(ctx)
+	require.ErrorContains(t, err, "second")
+	require.Equal(t, int64(2), atomic.LoadInt64(&applied))
+	require.Equal(t, int64(1), atomic.LoadInt64(&discarded))
+}
+
+func TestStaticReloader(t *testing.T) {
+	t.Parallel()
+
+	current, err := Load(`object_storage:
+  bucket:
+    type: "FILESYSTEM"
+    config:
+      directory: "./tmp"
+series_name_template: "{{.Name}}_{{.Type}}"
+`)
+	require.NoError(t, err)
+	reloader := StaticReloader(current)
+
+	// Reloadable sections may change.
+	next, err := Load(`object_storage:
+  bucket:
+    type: "FILESYSTEM"
+    config:
+      directory: "./tmp"
+series_name_template: "{{.Name}}_{{.Type}}"
+scrape_configs:
+  - job_name: "default"
+    static_configs:
+      - targets: [ '127.0.0.1:7070' ]
+`)
+	require.NoError(t, err)
+	_, err = reloader.Prepare(next)
+	require.NoError(t, err)
+
+	next, err = Load(`object_storage:
+  bucket:
+    type: "FILESYSTEM"
+    config:
+      directory: "./data"
+series_name_template: "{{.Name}}"
+`)
+	require.NoError(t, err)
+	_, err = reloader.Prepare(next)
+	require.EqualError(t, err, "object_storage, series_name_template can't be reloaded, changing them requires a restart")
+}
@@ -15,16 +15,29 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/providers/filesystem"
+	"gopkg.in/yaml.v2"
 )
 
 // Valid is the ValidRule.
 var Valid = ValidRule{}
 
+// StaticValid is the ValidRule that doesn't touch the filesystem, see
+// Config.ValidateStatic.
+var StaticValid = ValidRule{static: true}
+
 // ValidRule is a validation rule for the Config. It implements the validation.Rule interface.
-type ValidRule struct{}
+type ValidRule struct {
+	static bool
+}
 
 // Validate returns an error if the config is not valid.
 func (v ValidRule) Validate(value interface{}) error {
@@ -33,13 +46,17 @@ func (v ValidRule) Validate(value interface{}) error {
 		return errors.New("DebugInfo is invalid")
 	}
 	return validation.ValidateStruct(c,
-		validation.Field(&c.Bucket, validation.Required, BucketValid),
+		validation.Field(&c.Bucket, validation.Required, BucketRule{static: v.static}),
 	)
 }
 
 var BucketValid = BucketRule{}
 
-type BucketRule struct{}
+// BucketRule is a validation rule for the bucket config. Unless static, the
+// directory of a filesystem bucket has to be writable.
+type BucketRule struct {
+	static bool
+}
 
 // Validate the bucket config.
 func (r BucketRule) Validate(value interface{}) error {
@@ -48,8 +65,102 @@ func (r BucketRule) Validate(value interface{}) error {
 		return errors.New("BucketConfig is invalid")
 	}
 
+	typ := client.ObjProvider(strings.ToUpper(string(b.Type)))
+	directory := writableDirectory
+	if r.static {
+		directory = filesystemDirectory
+	}
 	return validation.ValidateStruct(b,
-		validation.Field(&b.Type, validation.Required),
-		validation.Field(&b.Config, validation.Required),
+		validation.Field(&b.Type, validation.Required, validation.By(supportedBucketType)),
+		validation.Field(&b.Config, validation.Required, validation.When(typ == client.FILESYSTEM, validation.By(directory))),
 	)
 }
+
+// bucketTypes are the object storage providers a bucket can be created for.
+var bucketTypes = []client.ObjProvider{
+	client.FILESYSTEM,
+	client.GCS,
+	client.S3,
+	client.AZURE,
+	client.SWIFT,
+	client.COS,
+	client.ALIYUNOSS,
+	client.BOS,
+	client.OCI,
+}
+
+// supportedBucketType checks that the type of a bucket config is one of the
+// supported object storage providers. Like the bucket client, the type is
+// case-insensitive.
+func supportedBucketType(value interface{}) error {
+	typ, ok := value.(client.ObjProvider)
+	if !ok {
+		return errors.New("bucket type is invalid")
+	}
+	for _, t := range bucketTypes {
+		if strings.EqualFold(string(typ), string(t)) {
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(bucketTypes))
+	for _, t := range bucketTypes {
+		names = append(names, string(t))
+	}
+	return fmt.Errorf("unsupported bucket type %q, supported types are %s", typ, strings.Join(names, ", "))
+}
+
+// filesystemDirectory checks that the config of a filesystem bucket has a
+// directory, without touching the filesystem.
+func filesystemDirectory(value interface{}) error {
+	_, err := directoryOf(value)
+	return err
+}
+
+// directoryOf returns the directory of the config of a filesystem bucket.
+func directoryOf(value interface{}) (string, error) {
+	b, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	var c filesystem.Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return "", err
+	}
+	if c.Directory == "" {
+		return "", errors.New("directory is required")
+	}
+	return c.Directory, nil
+}
+
+// writableDirectory checks that the directory of the config of a filesystem
+// bucket is writable, or can be created if it doesn't exist yet.
+func writableDirectory(value interface{}) error {
+	dir, err := directoryOf(value)
+	if err != nil {
+		return err
+	}
+
+	// The bucket creates the directory on the first upload, so the closest
+	// existing parent is checked instead.
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".parca-write-check-")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
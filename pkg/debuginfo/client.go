@@ -61,6 +61,32 @@ func (c *Client) Exists(ctx context.Context, buildID, hash string) (bool, error)
 }
 
 func (c *Client) Upload(ctx context.Context, buildID, hash string, r io.Reader) (uint64, error) {
+	return c.upload(ctx, buildID, hash, 0, r)
+}
+
+// Resume uploads the debug info read from r like Upload, but skips the part
+// an interrupted upload of the debug info with the same hash already sent.
+// It returns ErrDebugInfoAlreadyExists without uploading anything if the
+// server has the debug info.
+func (c *Client) Resume(ctx context.Context, buildID, hash string, r io.ReadSeeker) (uint64, error) {
+	res, err := c.c.Exists(ctx, &debuginfopb.ExistsRequest{
+		BuildId: buildID,
+		Hash:    hash,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if res.Exists {
+		return 0, ErrDebugInfoAlreadyExists
+	}
+
+	if _, err := r.Seek(int64(res.UploadedSize), io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seek to resume upload at %d bytes: %w", res.UploadedSize, err)
+	}
+	return c.upload(ctx, buildID, hash, res.UploadedSize, r)
+}
+
+func (c *Client) upload(ctx context.Context, buildID, hash string, offset uint64, r io.Reader) (uint64, error) {
 	stream, err := c.c.Upload(ctx, grpc.MaxCallSendMsgSize(MaxMsgSize))
 	if err != nil {
 		return 0, fmt.Errorf("initiate upload: %w", err)
@@ -71,6 +97,7 @@ func (c *Client) Upload(ctx context.Context, buildID, hash string, r io.Reader)
 			Info: &debuginfopb.UploadInfo{
 				BuildId: buildID,
 				Hash:    hash,
+				Offset:  offset,
 			},
 		},
 	})
@@ -115,7 +115,7 @@ func (m *ObjectStoreMetadata) MarkAsCorrupted(ctx context.Context, buildID strin
 }
 
 func (m *ObjectStoreMetadata) MarkAsUploading(ctx context.Context, buildID string) error {
-	_, err := m.bucket.Get(ctx, metadataObjectPath(buildID))
+	_, err := m.bucket.Get(ctx, metadataObjectPath(key(ctx, buildID)))
 	// The metadata file should not exist yet. Not erroring here because there's
 	// room for a race condition.
 	if err == nil {
@@ -141,7 +141,7 @@ func (m *ObjectStoreMetadata) MarkAsUploading(ctx context.Context, buildID strin
 }
 
 func (m *ObjectStoreMetadata) MarkAsUploaded(ctx context.Context, buildID, hash string) error {
-	r, err := m.bucket.Get(ctx, metadataObjectPath(buildID))
+	r, err := m.bucket.Get(ctx, metadataObjectPath(key(ctx, buildID)))
 	if err != nil {
 		level.Error(m.logger).Log("msg", "expected metadata file", "err", err)
 		return ErrMetadataShouldExist
@@ -174,7 +174,7 @@ func (m *ObjectStoreMetadata) MarkAsUploaded(ctx context.Context, buildID, hash
 	metadataBytes, _ := json.MarshalIndent(&metaData, "", "\t")
 	newData := bytes.NewReader(metadataBytes)
 
-	if err := m.bucket.Upload(ctx, metadataObjectPath(buildID), newData); err != nil {
+	if err := m.bucket.Upload(ctx, metadataObjectPath(key(ctx, buildID)), newData); err != nil {
 		return err
 	}
 
@@ -183,7 +183,7 @@ func (m *ObjectStoreMetadata) MarkAsUploaded(ctx context.Context, buildID, hash
 }
 
 func (m *ObjectStoreMetadata) Fetch(ctx context.Context, buildID string) (*Metadata, error) {
-	r, err := m.bucket.Get(ctx, metadataObjectPath(buildID))
+	r, err := m.bucket.Get(ctx, metadataObjectPath(key(ctx, buildID)))
 	if err != nil {
 		if m.bucket.IsObjNotFoundErr(err) {
 			return nil, ErrMetadataNotFound
@@ -207,13 +207,13 @@ func (m *ObjectStoreMetadata) Fetch(ctx context.Context, buildID string) (*Metad
 func (m *ObjectStoreMetadata) write(ctx context.Context, buildID string, md *Metadata) error {
 	metadataBytes, _ := json.MarshalIndent(md, "", "\t")
 	r := bytes.NewReader(metadataBytes)
-	if err := m.bucket.Upload(ctx, metadataObjectPath(buildID), r); err != nil {
+	if err := m.bucket.Upload(ctx, metadataObjectPath(key(ctx, buildID)), r); err != nil {
 		level.Error(m.logger).Log("msg", "failed to create metadata file", "err", err)
 		return err
 	}
 	return nil
 }
 
-func metadataObjectPath(buildID string) string {
-	return path.Join(buildID, "metadata")
+func metadataObjectPath(key string) string {
+	return path.Join(key, "metadata")
 }
@@ -51,6 +51,7 @@ func TestMetadata(t *testing.T) {
 
 	store, err := NewStore(
 		logger,
+		prometheus.NewRegistry(),
 		cacheDir,
 		NewObjectStoreMetadata(logger, bucket),
 		bucket,
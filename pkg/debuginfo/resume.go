@@ -0,0 +1,118 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debuginfo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// partialUploadPath is the path of the file the debug info of the key with
+// the given hash is received into. It's kept when the upload is interrupted,
+// so the upload can be resumed.
+func (s *Store) partialUploadPath(key, hash string) string {
+	return path.Join(s.cacheDir, key, "upload-"+hash)
+}
+
+// uploadedSize returns the number of bytes received of an interrupted upload
+// of the debug info with the given hash, and whether there is one.
+func (s *Store) uploadedSize(key, hash string) (uint64, bool) {
+	if hash == "" || s.isUploading(key) {
+		return 0, false
+	}
+	info, err := os.Stat(s.partialUploadPath(key, hash))
+	if err != nil {
+		return 0, false
+	}
+	return uint64(info.Size()), true
+}
+
+// startUpload marks an upload of the key as in progress. It returns false
+// if one already is.
+func (s *Store) startUpload(key string) bool {
+	s.uploadingMtx.Lock()
+	defer s.uploadingMtx.Unlock()
+
+	if _, ok := s.uploading[key]; ok {
+		return false
+	}
+	s.uploading[key] = struct{}{}
+	return true
+}
+
+func (s *Store) finishUpload(key string) {
+	s.uploadingMtx.Lock()
+	defer s.uploadingMtx.Unlock()
+
+	delete(s.uploading, key)
+}
+
+func (s *Store) isUploading(key string) bool {
+	s.uploadingMtx.Lock()
+	defer s.uploadingMtx.Unlock()
+
+	_, ok := s.uploading[key]
+	return ok
+}
+
+// openUpload returns the file the upload is received into, positioned at the
+// offset the upload resumes from. Uploads without a hash can't be resumed,
+// they are received into a temporary file.
+func (s *Store) openUpload(key, hash string, offset uint64) (*os.File, error) {
+	if hash == "" {
+		f, err := os.CreateTemp(s.cacheDir, "symbol-upload-*")
+		if err != nil {
+			err = fmt.Errorf("create temp file: %w", err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return f, nil
+	}
+
+	p := s.partialUploadPath(key, hash)
+	if err := os.MkdirAll(path.Dir(p), 0o700); err != nil {
+		err = fmt.Errorf("create upload directory: %w", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		err = fmt.Errorf("open upload file: %w", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if offset > uint64(info.Size()) {
+		f.Close()
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot resume upload at offset %d, %d bytes were received", offset, info.Size())
+	}
+
+	// Anything received after the offset is sent again by the client.
+	if err := f.Truncate(int64(offset)); err != nil {
+		f.Close()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		f.Close()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return f, nil
+}
@@ -14,7 +14,6 @@
 package debuginfo
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
@@ -22,11 +21,12 @@ import (
 	"io"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"github.com/nanmu42/limitio"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
 	"google.golang.org/grpc/codes"
@@ -34,6 +34,7 @@ import (
 
 	debuginfopb "github.com/parca-dev/parca/gen/proto/go/parca/debuginfo/v1alpha1"
 	"github.com/parca-dev/parca/pkg/symbol/elfutils"
+	"github.com/parca-dev/parca/pkg/tenant"
 )
 
 var ErrDebugInfoNotFound = errors.New("debug info not found")
@@ -73,25 +74,70 @@ type Store struct {
 
 	bucket objstore.Bucket
 
-	metadata         MetadataManager
+	metadata MetadataManager
+
+	debuginfodMtx    sync.RWMutex
 	debuginfodClient DebugInfodClient
+
+	maxUploadSize int64
+
+	uploadingMtx sync.Mutex
+	uploading    map[string]struct{}
+
+	uploads *prometheus.CounterVec
+	fetches *prometheus.CounterVec
+}
+
+type Option func(*Store)
+
+// SetDebugInfodClient replaces the client downloading debuginfo from
+// debuginfod servers, for the debuginfod servers to be reloaded.
+func (s *Store) SetDebugInfodClient(c DebugInfodClient) {
+	s.debuginfodMtx.Lock()
+	defer s.debuginfodMtx.Unlock()
+
+	s.debuginfodClient = c
+}
+
+// WithMaxUploadSize rejects uploads of debug info files larger than size bytes.
+// Uploads of any size are accepted if size is 0.
+func WithMaxUploadSize(size int64) Option {
+	return func(s *Store) {
+		s.maxUploadSize = size
+	}
 }
 
 // NewStore returns a new debug info store.
 func NewStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	cacheDir string,
 	metadata MetadataManager,
 	bucket objstore.Bucket,
 	debuginfodClient DebugInfodClient,
+	opts ...Option,
 ) (*Store, error) {
-	return &Store{
+	s := &Store{
 		logger:           log.With(logger, "component", "debuginfo"),
 		bucket:           bucket,
 		cacheDir:         cacheDir,
 		metadata:         metadata,
 		debuginfodClient: debuginfodClient,
-	}, nil
+		uploading:        map[string]struct{}{},
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_debuginfo_uploads_total",
+			Help: "Total number of debug info uploads by result, which is success, already_exists or error.",
+		}, []string{"result"}),
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_debuginfo_fetches_total",
+			Help: "Total number of debug info fetches by the source it was found in, which is upload or debuginfod, and result.",
+		}, []string{"source", "result"}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	reg.MustRegister(s.uploads, s.fetches)
+	return s, nil
 }
 
 func (s *Store) Exists(ctx context.Context, req *debuginfopb.ExistsRequest) (*debuginfopb.ExistsResponse, error) {
@@ -100,7 +146,8 @@ func (s *Store) Exists(ctx context.Context, req *debuginfopb.ExistsRequest) (*de
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	found, err := s.find(ctx, buildID)
+	k := key(ctx, buildID)
+	found, err := s.find(ctx, k)
 	if err != nil {
 		return nil, err
 	}
@@ -109,7 +156,7 @@ func (s *Store) Exists(ctx context.Context, req *debuginfopb.ExistsRequest) (*de
 		metadataFile, err := s.metadata.Fetch(ctx, buildID)
 		if err != nil {
 			if errors.Is(err, ErrMetadataNotFound) {
-				return &debuginfopb.ExistsResponse{Exists: false}, nil
+				return s.notExists(k, req.Hash), nil
 			}
 			return nil, status.Error(codes.Internal, err.Error())
 		}
@@ -119,15 +166,22 @@ func (s *Store) Exists(ctx context.Context, req *debuginfopb.ExistsRequest) (*de
 			return &debuginfopb.ExistsResponse{Exists: true}, nil
 		}
 
-		var exists bool
 		// If it is not an exact version of the source object file what we have so, let the client try to upload it.
-		if metadataFile.State == MetadataStateUploading {
-			exists = !isStale(metadataFile)
+		if metadataFile.State == MetadataStateUploading && !isStale(metadataFile) {
+			if _, ok := s.uploadedSize(k, req.Hash); !ok {
+				return &debuginfopb.ExistsResponse{Exists: true}, nil
+			}
 		}
-		return &debuginfopb.ExistsResponse{Exists: exists}, nil
 	}
 
-	return &debuginfopb.ExistsResponse{Exists: false}, nil
+	return s.notExists(k, req.Hash), nil
+}
+
+// notExists returns the response for debug info that doesn't exist, with the
+// size of an interrupted upload of it to resume.
+func (s *Store) notExists(key, hash string) *debuginfopb.ExistsResponse {
+	size, _ := s.uploadedSize(key, hash)
+	return &debuginfopb.ExistsResponse{Exists: false, UploadedSize: size}
 }
 
 func (s *Store) Upload(stream debuginfopb.DebugInfoService_UploadServer) error {
@@ -141,20 +195,27 @@ func (s *Store) Upload(stream debuginfopb.DebugInfoService_UploadServer) error {
 	var (
 		buildID = req.GetInfo().BuildId
 		hash    = req.GetInfo().Hash
+		offset  = req.GetInfo().Offset
 		r       = &UploadReader{stream: stream}
 	)
-	if err := s.upload(stream.Context(), buildID, hash, r); err != nil {
+	if err := s.upload(stream.Context(), buildID, hash, offset, r); err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			s.uploads.WithLabelValues("already_exists").Inc()
+		} else {
+			s.uploads.WithLabelValues("error").Inc()
+		}
 		return err
 	}
+	s.uploads.WithLabelValues("success").Inc()
 
 	level.Debug(s.logger).Log("msg", "debug info uploaded", "buildid", buildID)
 	return stream.SendAndClose(&debuginfopb.UploadResponse{
 		BuildId: buildID,
-		Size:    r.size,
+		Size:    offset + r.size,
 	})
 }
 
-func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) error {
+func (s *Store) upload(ctx context.Context, buildID, hash string, offset uint64, r io.Reader) error {
 	if err := validateInput(buildID); err != nil {
 		err = fmt.Errorf("invalid build ID: %w", err)
 		return status.Error(codes.InvalidArgument, err.Error())
@@ -165,8 +226,14 @@ func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) e
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	if offset > 0 && hash == "" {
+		return status.Error(codes.InvalidArgument, "resuming an upload requires the hash")
+	}
+
 	level.Debug(s.logger).Log("msg", "trying to upload debug info", "buildid", buildID)
 
+	k := key(ctx, buildID)
+
 	metadataFile, err := s.metadata.Fetch(ctx, buildID)
 	if err == nil {
 		level.Debug(s.logger).Log("msg", "fetching metadata state", "result", metadataFile)
@@ -178,7 +245,8 @@ func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) e
 			// The debug info was fully uploaded.
 			return status.Error(codes.AlreadyExists, "debuginfo already exists")
 		case MetadataStateUploading:
-			if !isStale(metadataFile) {
+			// An interrupted upload can be resumed right away.
+			if _, ok := s.uploadedSize(k, hash); !ok && !isStale(metadataFile) {
 				return status.Error(codes.AlreadyExists, "debuginfo already exists, being uploaded right now")
 			}
 			// The debug info upload operation most likely failed.
@@ -191,7 +259,7 @@ func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) e
 		}
 	}
 
-	found, err := s.find(ctx, buildID)
+	found, err := s.find(ctx, k)
 	if err != nil {
 		return err
 	}
@@ -232,37 +300,60 @@ func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) e
 	// At this point we know that we received a better version of the debug information file,
 	// so let the client upload it.
 
+	if !s.startUpload(k) {
+		return status.Error(codes.AlreadyExists, "debuginfo already exists, being uploaded right now")
+	}
+	defer s.finishUpload(k)
+
 	if err := s.metadata.MarkAsUploading(ctx, buildID); err != nil {
 		err = fmt.Errorf("failed to update metadata before uploading: %w", err)
 		return status.Error(codes.Internal, err.Error())
 	}
 
-	// limitio.Writer is used to avoid buffer overflow.
-	// We only need to read the first 64 bytes (at most).
-	// The ELF header is 52 or 64 bytes long for 32-bit and 64-bit binaries respectively.
-	// If we receive a longer data, we will ignore the rest without an error.
-	b := bytes.NewBuffer(nil)
-	w := limitio.NewWriter(b, 64, true)
-
-	// Here we're optimistically uploading the received stream directly to the bucket,
-	// and if something goes wrong we mark it as corrupted, so it could be overwritten in subsequent calls.
-	// We only want to make sure we don't read a corrupted file while symbolizing.
-	// Ww also wanted to prevent any form of buffering for this data on the server-side,
-	// thus the optimistic writes directly to the object-store while also writing the header of the file into a buffer,
-	// so we can validate the ELF header.
-	if err := s.bucket.Upload(ctx, objectPath(buildID), io.TeeReader(r, w)); err != nil {
-		msg := "failed to upload"
-		level.Error(s.logger).Log("msg", msg, "err", err)
-		return status.Errorf(codes.Unknown, msg)
+	// The received stream is written to a local file first, so the debug info file is validated
+	// before it is stored in the bucket and used for symbolization.
+	f, err := s.openUpload(k, hash, offset)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
-	if err := elfutils.ValidateHeader(b); err != nil {
-		// Failed to validate. Mark the incoming stream as corrupted, and let the client try to upload it again.
+	err = s.receive(f, offset, r)
+	if err != nil && hash != "" && status.Code(err) == codes.Unknown {
+		// The upload was interrupted, what was received is kept for the client to resume the upload.
+		return err
+	}
+	if err == nil {
+		err = validateUpload(f.Name(), buildID)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		// Failed to receive or validate. Mark the incoming stream as corrupted, and let the client try to upload it again.
 		if err := s.metadata.MarkAsCorrupted(ctx, buildID); err != nil {
-			err = fmt.Errorf("failed to update metadata after uploaded, as corrupted: %w", err)
+			err = fmt.Errorf("failed to update metadata after upload, as corrupted: %w", err)
 			return status.Error(codes.Internal, err.Error())
 		}
-		return status.Error(codes.InvalidArgument, err.Error())
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := s.bucket.Upload(ctx, objectPath(k), f); err != nil {
+		os.Remove(f.Name())
+		msg := "failed to upload"
+		level.Error(s.logger).Log("msg", msg, "err", err)
+		return status.Errorf(codes.Unknown, msg)
+	}
+
+	// Replace a cached earlier upload, it's worse than the one just received.
+	err = os.MkdirAll(path.Dir(s.localCachePath(k)), 0o700)
+	if err == nil {
+		err = os.Rename(f.Name(), s.localCachePath(k))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		level.Debug(s.logger).Log("msg", "failed to cache uploaded debuginfo", "buildid", buildID, "err", err)
 	}
 
 	if err := s.metadata.MarkAsUploaded(ctx, buildID, hash); err != nil {
@@ -273,6 +364,22 @@ func (s *Store) upload(ctx context.Context, buildID, hash string, r io.Reader) e
 	return nil
 }
 
+// receive writes the uploaded debug info file to f, after the offset bytes
+// received by an earlier upload.
+func (s *Store) receive(f *os.File, offset uint64, r io.Reader) error {
+	if s.maxUploadSize > 0 {
+		r = io.LimitReader(r, s.maxUploadSize-int64(offset)+1)
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return status.Errorf(codes.Unknown, "failed to receive debuginfo: %v", err)
+	}
+	if s.maxUploadSize > 0 && int64(offset)+n > s.maxUploadSize {
+		return status.Errorf(codes.ResourceExhausted, "debuginfo exceeds the maximum upload size of %d bytes", s.maxUploadSize)
+	}
+	return nil
+}
+
 func isStale(metadataFile *Metadata) bool {
 	return time.Now().Add(-15 * time.Minute).After(time.Unix(metadataFile.UploadStartedAt, 0))
 }
@@ -283,7 +390,7 @@ func (s *Store) Download(req *debuginfopb.DownloadRequest, stream debuginfopb.De
 	}
 
 	ctx := stream.Context()
-	found, err := s.find(ctx, req.BuildId)
+	found, err := s.find(ctx, key(ctx, req.BuildId))
 	if err != nil {
 		return err
 	}
@@ -381,6 +488,16 @@ func (s *Store) FetchDebugInfo(ctx context.Context, buildID string) (string, deb
 	logger := log.With(s.logger, "buildid", buildID)
 
 	source := debuginfopb.DownloadInfo_SOURCE_UNKNOWN_UNSPECIFIED
+	if _, ok := tenant.FromContext(ctx); !ok {
+		// The symbolizer fetches debug info without a tenant, as the
+		// locations it symbolizes are shared by all tenants.
+		var err error
+		if ctx, err = s.uploader(ctx, buildID); err != nil {
+			s.fetches.WithLabelValues("none", "error").Inc()
+			return "", source, fmt.Errorf("failed to find uploader: %w", err)
+		}
+	}
+
 	objFile, err := s.fetchFromObjectStore(ctx, buildID)
 	if err != nil {
 		// It's ok if we don't have the symbols for given BuildID, it happens too often.
@@ -389,6 +506,7 @@ func (s *Store) FetchDebugInfo(ctx context.Context, buildID string) (string, deb
 		// Let's try to find a debug file from debuginfod servers.
 		objFile, err = s.fetchDebuginfodFile(ctx, buildID)
 		if err != nil {
+			s.fetches.WithLabelValues("none", "error").Inc()
 			return "", source, fmt.Errorf("failed to fetch: %w", err)
 		}
 		source = debuginfopb.DownloadInfo_SOURCE_DEBUGINFOD
@@ -435,17 +553,60 @@ func (s *Store) FetchDebugInfo(ctx context.Context, buildID string) (string, deb
 		}
 	}
 
+	s.fetches.WithLabelValues(sourceLabel(source), "success").Inc()
 	return objFile, source, nil
 }
 
+// sourceLabel returns the value of the source label of the fetch metrics.
+func sourceLabel(source debuginfopb.DownloadInfo_Source) string {
+	switch source {
+	case debuginfopb.DownloadInfo_SOURCE_UPLOAD:
+		return "upload"
+	case debuginfopb.DownloadInfo_SOURCE_DEBUGINFOD:
+		return "debuginfod"
+	default:
+		return "none"
+	}
+}
+
+// uploader returns the context of the tenant that uploaded the debug info of
+// the build ID. That's the default tenant, unless only another tenant
+// uploaded it.
+func (s *Store) uploader(ctx context.Context, buildID string) (context.Context, error) {
+	if _, err := os.Stat(s.localCachePath(buildID)); err == nil {
+		return ctx, nil
+	}
+	found, err := s.find(ctx, buildID)
+	if err != nil || found {
+		return ctx, err
+	}
+
+	var id string
+	err = s.bucket.Iter(ctx, "tenants", func(name string) error {
+		if id != "" {
+			return nil
+		}
+		found, err := s.find(ctx, path.Join("tenants", path.Base(name), buildID))
+		if found {
+			id = path.Base(name)
+		}
+		return err
+	})
+	if err != nil || id == "" {
+		return ctx, err
+	}
+	return tenant.WithTenant(ctx, id), nil
+}
+
 func (s *Store) fetchFromObjectStore(ctx context.Context, buildID string) (string, error) {
 	logger := log.With(s.logger, "buildid", buildID)
 
-	objFile := s.localCachePath(buildID)
+	k := key(ctx, buildID)
+	objFile := s.localCachePath(k)
 	// Check if it's already cached locally; if not download.
 	if _, err := os.Stat(objFile); os.IsNotExist(err) {
 		// Download the debuginfo file from the bucket.
-		r, err := s.bucket.Get(ctx, objectPath(buildID))
+		r, err := s.bucket.Get(ctx, objectPath(k))
 		if err != nil {
 			if s.bucket.IsObjNotFoundErr(err) {
 				level.Debug(logger).Log("msg", "failed to fetch object from object storage", "err", err)
@@ -467,9 +628,12 @@ func (s *Store) fetchDebuginfodFile(ctx context.Context, buildID string) (string
 	logger := log.With(s.logger, "buildid", buildID)
 	level.Debug(logger).Log("msg", "attempting to download from debuginfod servers")
 
-	objFile := s.localCachePath(buildID)
+	objFile := s.localCachePath(key(ctx, buildID))
 	// Try downloading the debuginfo file from the debuginfod server.
-	r, err := s.debuginfodClient.GetDebugInfo(ctx, buildID)
+	s.debuginfodMtx.RLock()
+	client := s.debuginfodClient
+	s.debuginfodMtx.RUnlock()
+	r, err := client.GetDebugInfo(ctx, buildID)
 	if err != nil {
 		level.Debug(logger).Log("msg", "failed to download debuginfo from debuginfod", "err", err)
 		return "", fmt.Errorf("failed to fetch from debuginfod: %w", err)
@@ -486,8 +650,8 @@ func (s *Store) fetchDebuginfodFile(ctx context.Context, buildID string) (string
 	return objFile, nil
 }
 
-func (s *Store) localCachePath(buildID string) string {
-	return path.Join(s.cacheDir, buildID, "debuginfo")
+func (s *Store) localCachePath(key string) string {
+	return path.Join(s.cacheDir, key, "debuginfo")
 }
 
 func (s *Store) cache(localPath string, r io.ReadCloser) error {
@@ -519,6 +683,16 @@ func (s *Store) cache(localPath string, r io.ReadCloser) error {
 	return nil
 }
 
-func objectPath(buildID string) string {
-	return path.Join(buildID, "debuginfo")
+// key returns the prefix of the objects of the debug info of a build ID. The
+// objects of tenants are prefixed with the tenant of the context, the same way
+// original profiles are.
+func key(ctx context.Context, buildID string) string {
+	if id, ok := tenant.FromContext(ctx); ok {
+		return path.Join("tenants", id, buildID)
+	}
+	return buildID
+}
+
+func objectPath(key string) string {
+	return path.Join(key, "debuginfo")
 }
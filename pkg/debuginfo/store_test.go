@@ -17,22 +17,30 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"io"
 	stdlog "log"
 	"net"
 	"os"
+	"path"
 	"testing"
+	"testing/iotest"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
 	"github.com/thanos-io/objstore/providers/filesystem"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v2"
 
 	debuginfopb "github.com/parca-dev/parca/gen/proto/go/parca/debuginfo/v1alpha1"
+	"github.com/parca-dev/parca/pkg/tenant"
 )
 
 func TestStore(t *testing.T) {
@@ -58,6 +66,7 @@ func TestStore(t *testing.T) {
 
 	s, err := NewStore(
 		logger,
+		prometheus.NewRegistry(),
 		cacheDir,
 		NewObjectStoreMetadata(logger, bucket),
 		bucket,
@@ -103,40 +112,275 @@ func TestStore(t *testing.T) {
 	_, err = c.Upload(context.Background(), hex.EncodeToString([]byte("nosection")), "abcd", nf)
 	require.Error(t, err)
 
+	// The file has a Go build ID note but neither debug information nor symbols.
 	wf, err := os.Open("testdata/validelf_withsections")
 	require.NoError(t, err)
 
-	size, err := c.Upload(context.Background(), hex.EncodeToString([]byte("section")), "abcd", wf)
+	_, err = c.Upload(context.Background(), hex.EncodeToString([]byte("section")), "abcd", wf)
+	require.ErrorContains(t, err, "does not match the build IDs of the ELF file")
+
+	_, err = wf.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	_, err = c.Upload(context.Background(), hex.EncodeToString([]byte("U5n8fflgcdRI6D1OUL2m/7rrrtM8SaFLy-_ofhO8K/6k6KUMuOoq3gv7IP7lZ9/etSn8Yna6laOrS0M5YXa")), "abcd", wf)
+	require.ErrorContains(t, err, "neither DWARF debug information nor symbols")
+
+	const (
+		buildID = "77364271716762793947664b3479416b4676642d2f417a333159574d503255743036584e4a3867414c2f4d77616c62673256647a494f4437674265727a472f37625364676957463368655f68784f7678745478"
+		objSize = 1132512
+	)
+	df, err := os.Open("../symbolizer/testdata/" + buildID + "/debuginfo")
 	require.NoError(t, err)
-	require.Equal(t, 7079, int(size))
 
-	obj, err := s.bucket.Get(context.Background(), hex.EncodeToString([]byte("section"))+"/debuginfo")
+	size, err := c.Upload(context.Background(), buildID, "abcd", df)
+	require.NoError(t, err)
+	require.Equal(t, objSize, int(size))
+
+	obj, err := s.bucket.Get(context.Background(), buildID+"/debuginfo")
 	require.NoError(t, err)
 
 	content, err := io.ReadAll(obj)
 	require.NoError(t, err)
-	require.Equal(t, 7079, len(content))
+	require.Equal(t, objSize, len(content))
 	require.Equal(t, []byte{0x7f, 'E', 'L', 'F'}, content[:4])
 
 	ctx := context.Background()
-	exists, err := c.Exists(context.Background(), hex.EncodeToString([]byte("section")), "abcd")
+	exists, err := c.Exists(context.Background(), buildID, "abcd")
 	require.NoError(t, err)
 	require.True(t, exists)
 
 	buf := bytes.NewBuffer(nil)
-	downloader, err := c.Downloader(ctx, hex.EncodeToString([]byte("section")))
+	downloader, err := c.Downloader(ctx, buildID)
 	require.NoError(t, err)
 	require.Equal(t, debuginfopb.DownloadInfo_SOURCE_UPLOAD, downloader.Info().Source)
 
 	written, err := downloader.Download(ctx, buf)
 	require.NoError(t, err)
-	require.Equal(t, 7079, written)
-	require.Equal(t, 7079, buf.Len())
+	require.Equal(t, objSize, written)
+	require.Equal(t, objSize, buf.Len())
 	require.NoError(t, downloader.Close())
 
 	// Test only reading the download info.
-	downloader, err = c.Downloader(ctx, hex.EncodeToString([]byte("section")))
+	downloader, err = c.Downloader(ctx, buildID)
 	require.NoError(t, err)
 	require.Equal(t, debuginfopb.DownloadInfo_SOURCE_UPLOAD, downloader.Info().Source)
 	require.NoError(t, downloader.Close())
+
+	require.Equal(t, 1.0, testutil.ToFloat64(s.uploads.WithLabelValues("success")))
+	require.Equal(t, 4.0, testutil.ToFloat64(s.uploads.WithLabelValues("error")))
+	require.Equal(t, 2.0, testutil.ToFloat64(s.fetches.WithLabelValues("upload", "success")))
+}
+
+func TestStoreUploadMaxSize(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	bucket, err := filesystem.NewBucket(t.TempDir())
+	require.NoError(t, err)
+
+	s, err := NewStore(
+		logger,
+		prometheus.NewRegistry(),
+		t.TempDir(),
+		NewObjectStoreMetadata(logger, bucket),
+		bucket,
+		NopDebugInfodClient{},
+		WithMaxUploadSize(1024),
+	)
+	require.NoError(t, err)
+
+	f, err := os.Open("testdata/validelf_withsections")
+	require.NoError(t, err)
+	defer f.Close()
+
+	ctx := context.Background()
+	buildID := hex.EncodeToString([]byte("section"))
+	err = s.upload(ctx, buildID, "abcd", 0, f)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Nothing is stored, the client can upload the file again.
+	found, err := s.find(ctx, objectPath(buildID))
+	require.NoError(t, err)
+	require.False(t, found)
+
+	m, err := s.metadata.Fetch(ctx, buildID)
+	require.NoError(t, err)
+	require.Equal(t, MetadataStateCorrupted, m.State)
+}
+
+func TestStoreResumeUpload(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	bucket, err := filesystem.NewBucket(t.TempDir())
+	require.NoError(t, err)
+
+	cacheDir := t.TempDir()
+	s, err := NewStore(
+		logger,
+		prometheus.NewRegistry(),
+		cacheDir,
+		NewObjectStoreMetadata(logger, bucket),
+		objstore.NewPrefixedBucket(bucket, "debuginfo"),
+		NopDebugInfodClient{},
+	)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	defer grpcServer.GracefulStop()
+	debuginfopb.RegisterDebugInfoServiceServer(grpcServer, s)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			stdlog.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	c := NewDebugInfoClient(conn)
+
+	const (
+		buildID = "77364271716762793947664b3479416b4676642d2f417a333159574d503255743036584e4a3867414c2f4d77616c62673256647a494f4437674265727a472f37625364676957463368655f68784f7678745478"
+		hash    = "abcd"
+	)
+	data, err := os.ReadFile("../symbolizer/testdata/" + buildID + "/debuginfo")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// Resuming requires the hash identifying the debug info.
+	err = s.upload(ctx, buildID, "", 4096, bytes.NewReader(data[4096:]))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Nothing was received that could be resumed.
+	err = s.upload(ctx, buildID, hash, 4096, bytes.NewReader(data[4096:]))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// The upload is interrupted after the first 8KiB.
+	err = s.upload(ctx, buildID, hash, 0, io.MultiReader(
+		bytes.NewReader(data[:8192]),
+		iotest.ErrReader(errors.New("connection reset")),
+	))
+	require.Error(t, err)
+
+	res, err := c.c.Exists(ctx, &debuginfopb.ExistsRequest{BuildId: buildID, Hash: hash})
+	require.NoError(t, err)
+	require.False(t, res.Exists)
+	require.Equal(t, uint64(8192), res.UploadedSize)
+
+	// Interrupted uploads of other versions of the debug info aren't resumed.
+	res, err = c.c.Exists(ctx, &debuginfopb.ExistsRequest{BuildId: buildID, Hash: "ef01"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), res.UploadedSize)
+
+	size, err := c.Resume(ctx, buildID, hash, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(data)), size)
+
+	obj, err := s.bucket.Get(ctx, objectPath(buildID))
+	require.NoError(t, err)
+	content, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
+
+	_, err = os.Stat(s.partialUploadPath(buildID, hash))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = c.Resume(ctx, buildID, hash, bytes.NewReader(data))
+	require.ErrorIs(t, err, ErrDebugInfoAlreadyExists)
+}
+
+// fakeDebugInfodClient serves the same debuginfo for every build ID.
+type fakeDebugInfodClient struct {
+	content []byte
+}
+
+func (c fakeDebugInfodClient) GetDebugInfo(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(c.content)), nil
+}
+
+func TestStoreSetDebugInfodClient(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	bucket, err := filesystem.NewBucket(t.TempDir())
+	require.NoError(t, err)
+
+	s, err := NewStore(
+		logger,
+		prometheus.NewRegistry(),
+		t.TempDir(),
+		NewObjectStoreMetadata(logger, bucket),
+		bucket,
+		NopDebugInfodClient{},
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	_, err = s.fetchDebuginfodFile(ctx, "abcd")
+	require.ErrorIs(t, err, ErrDebugInfoNotFound)
+
+	s.SetDebugInfodClient(fakeDebugInfodClient{content: []byte("debuginfo")})
+	path, err := s.fetchDebuginfodFile(ctx, "abcd")
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "debuginfo", string(content))
+}
+
+func TestStoreTenants(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+	bucket, err := filesystem.NewBucket(t.TempDir())
+	require.NoError(t, err)
+
+	s, err := NewStore(
+		logger,
+		prometheus.NewRegistry(),
+		t.TempDir(),
+		NewObjectStoreMetadata(logger, bucket),
+		bucket,
+		NopDebugInfodClient{},
+	)
+	require.NoError(t, err)
+
+	const (
+		buildID = "77364271716762793947664b3479416b4676642d2f417a333159574d503255743036584e4a3867414c2f4d77616c62673256647a494f4437674265727a472f37625364676957463368655f68784f7678745478"
+		hash    = "abcd"
+	)
+	data, err := os.ReadFile("../symbolizer/testdata/" + buildID + "/debuginfo")
+	require.NoError(t, err)
+
+	a := tenant.WithTenant(context.Background(), "a")
+	require.NoError(t, s.upload(a, buildID, hash, 0, bytes.NewReader(data)))
+
+	exists, err := bucket.Exists(a, path.Join("tenants", "a", buildID, "debuginfo"))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// Other tenants don't see the debug info, and can upload their own.
+	for _, tc := range []struct {
+		ctx    context.Context
+		exists bool
+	}{
+		{ctx: a, exists: true},
+		{ctx: context.Background(), exists: false},
+		{ctx: tenant.WithTenant(context.Background(), "b"), exists: false},
+	} {
+		res, err := s.Exists(tc.ctx, &debuginfopb.ExistsRequest{BuildId: buildID, Hash: hash})
+		require.NoError(t, err)
+		require.Equal(t, tc.exists, res.Exists)
+	}
+	require.NoError(t, s.upload(tenant.WithTenant(context.Background(), "b"), buildID, hash, 0, bytes.NewReader(data)))
+
+	// The symbolizer fetches debug info without a tenant.
+	objFile, source, err := s.FetchDebugInfo(context.Background(), buildID)
+	require.NoError(t, err)
+	require.Equal(t, debuginfopb.DownloadInfo_SOURCE_UPLOAD, source)
+	content, err := os.ReadFile(objFile)
+	require.NoError(t, err)
+	require.Equal(t, data, content)
 }
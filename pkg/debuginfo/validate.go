@@ -0,0 +1,82 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debuginfo
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/symbol/elfutils"
+)
+
+// validateUpload returns an InvalidArgument error if the uploaded file at path
+// is not an ELF file of the given build ID that can be used for symbolization.
+func validateUpload(path, buildID string) error {
+	if err := elfutils.ValidateFile(path); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid ELF file: %v", err)
+	}
+
+	ids, err := elfutils.BuildIDs(path)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid ELF file: %v", err)
+	}
+	// Files without build ID notes can't be checked, their build ID was
+	// computed by the client.
+	if len(ids) > 0 && !containsBuildID(ids, buildID) {
+		return status.Errorf(codes.InvalidArgument, "build ID %s does not match the build IDs of the ELF file: %s", buildID, strings.Join(ids, ", "))
+	}
+
+	ok, err := hasDebugInfo(path)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid ELF file: %v", err)
+	}
+	if !ok {
+		return status.Error(codes.InvalidArgument, "ELF file has neither DWARF debug information nor symbols")
+	}
+	return nil
+}
+
+func containsBuildID(ids []string, buildID string) bool {
+	for _, id := range ids {
+		if strings.EqualFold(id, buildID) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDebugInfo reports whether the file has any information used for
+// symbolization: DWARF, symbol tables, or the pclntab of Go binaries.
+func hasDebugInfo(path string) (bool, error) {
+	for _, check := range []func(string) (bool, error){
+		elfutils.HasDWARF,
+		elfutils.HasSymbols,
+	} {
+		ok, err := check(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read ELF file: %w", err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	// Unlike the other checks, this one returns an error if the file isn't
+	// symbolizable.
+	ok, _ := elfutils.IsSymbolizableGoObjFile(path)
+	return ok, nil
+}
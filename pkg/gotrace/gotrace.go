@@ -0,0 +1,380 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gotrace derives profiles from Go execution traces as written by
+// runtime/trace.
+package gotrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Event types of the execution trace format up to Go 1.21, see
+// src/internal/trace/parser.go of the Go distribution. Only the events
+// profiles are derived from are handled, all others are skipped.
+const (
+	evNone           = 0
+	evBatch          = 1  // [pid, timestamp]
+	evFrequency      = 2  // [ticks per second]
+	evStack          = 3  // [stack id, number of frames, {pc, func string id, file string id, line}...]
+	evGCStart        = 7  // [timestamp, seq, stack id]
+	evGCDone         = 8  // [timestamp]
+	evGCSTWStart     = 9  // [timestamp, kind]
+	evGCSTWDone      = 10 // [timestamp]
+	evGoCreate       = 13 // [timestamp, new goroutine id, new stack id, stack id]
+	evTimerGoroutine = 35 // [timer goroutine id]
+	evString         = 37 // [string id, length, bytes]
+	evUserLog        = 48 // [timestamp, task id, key string id, stack, value string]
+	evCPUSample      = 49 // [timestamp, real timestamp, real P id, goroutine id, stack id]
+	evCount          = 50
+)
+
+const (
+	// Traces written before Go 1.11 use a different encoding of the
+	// arguments, traces of Go 1.22 and later a different format altogether.
+	minVersion = 1011
+	maxVersion = 1021
+
+	maxStringLength = 1e6
+	maxStackFrames  = 1e4
+)
+
+// ErrUnsupportedVersion is returned for traces in a format that can't be
+// parsed.
+var ErrUnsupportedVersion = errors.New("unsupported trace version")
+
+type frame struct {
+	pc   uint64
+	fn   uint64
+	file uint64
+	line int64
+}
+
+// Trace is the aggregated content of an execution trace that profiles can be
+// derived from. Only the stacks and strings of the trace are kept in memory,
+// its events are aggregated as they are read.
+type Trace struct {
+	// Version is the Go version that wrote the trace, like 1019 for Go 1.19.
+	Version int
+
+	// frequency is the number of ticks of the timestamps per second.
+	frequency uint64
+	minTicks  int64
+	maxTicks  int64
+
+	strings map[uint64]string
+	stacks  map[uint64][]frame
+
+	cpuSamples         map[uint64]int64
+	goroutineCreations map[uint64]int64
+
+	gc  durationEvents
+	stw durationEvents
+}
+
+// durationEvents sums the timestamps of start and end events, the total
+// duration is their difference no matter how the events pair up.
+type durationEvents struct {
+	starts, ends         int64
+	startTicks, endTicks int64
+}
+
+// Parse reads an execution trace. The trace is streamed, so the memory used
+// depends on the number of distinct stacks rather than the size of the trace.
+func Parse(r io.Reader) (*Trace, error) {
+	br := bufio.NewReader(r)
+
+	version, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Trace{
+		Version:            version,
+		minTicks:           -1,
+		strings:            map[uint64]string{},
+		stacks:             map[uint64][]frame{},
+		cpuSamples:         map[uint64]int64{},
+		goroutineCreations: map[uint64]int64{},
+	}
+
+	var lastTicks int64
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		typ := b << 2 >> 2
+		if typ == evNone || typ >= evCount {
+			return nil, fmt.Errorf("unknown event type %d", typ)
+		}
+
+		if typ == evString {
+			if err := t.readString(br); err != nil {
+				return nil, fmt.Errorf("read string: %w", err)
+			}
+			continue
+		}
+
+		args, err := readArgs(br, b>>6+1)
+		if err != nil {
+			return nil, fmt.Errorf("read arguments of event type %d: %w", typ, err)
+		}
+		if typ == evUserLog {
+			// User logs are followed by their value.
+			if _, err := readBytes(br); err != nil {
+				return nil, fmt.Errorf("read user log: %w", err)
+			}
+		}
+
+		if err := t.event(typ, args, &lastTicks); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func readHeader(r *bufio.Reader) (int, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, fmt.Errorf("read header: %w", err)
+	}
+
+	var major, minor int
+	if n, _ := fmt.Sscanf(string(header), "go %d.%d trace\x00\x00\x00", &major, &minor); n != 2 {
+		return 0, errors.New("not a Go execution trace")
+	}
+	version := major*1000 + minor
+	if version < minVersion || version > maxVersion {
+		return 0, fmt.Errorf("%w: go %d.%d", ErrUnsupportedVersion, major, minor)
+	}
+
+	return version, nil
+}
+
+func (t *Trace) readString(r *bufio.Reader) error {
+	id, _, err := readUvarint(r)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		return errors.New("string has invalid id 0")
+	}
+
+	s, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	t.strings[id] = string(s)
+	return nil
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, _, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxStringLength {
+		return nil, fmt.Errorf("string of length %d is too long", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readArgs reads the arguments of an event. Up to three arguments are
+// inlined, events with more are prefixed with their length in bytes.
+func readArgs(r *bufio.Reader, n byte) ([]uint64, error) {
+	const inlineArgs = 4
+
+	if n < inlineArgs {
+		args := make([]uint64, 0, n)
+		for i := byte(0); i < n; i++ {
+			v, _, err := readUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		return args, nil
+	}
+
+	length, _, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		args []uint64
+		read uint64
+	)
+	for read < length {
+		v, n, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+		read += uint64(n)
+	}
+	if read != length {
+		return nil, fmt.Errorf("event has length %d but its arguments have %d bytes", length, read)
+	}
+
+	return args, nil
+}
+
+func readUvarint(r *bufio.Reader) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, i, err
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 10, errors.New("varint overflows 64 bits")
+}
+
+func (t *Trace) event(typ byte, args []uint64, lastTicks *int64) error {
+	if n := minArgs(typ); len(args) < n {
+		return fmt.Errorf("event type %d has %d arguments, expected at least %d", typ, len(args), n)
+	}
+
+	switch typ {
+	case evBatch:
+		*lastTicks = int64(args[1])
+		if t.minTicks < 0 || *lastTicks < t.minTicks {
+			t.minTicks = *lastTicks
+		}
+		t.observe(*lastTicks)
+		return nil
+	case evFrequency:
+		t.frequency = args[0]
+		return nil
+	case evStack:
+		return t.stack(args)
+	case evTimerGoroutine:
+		return nil
+	}
+
+	// Timestamps are relative to the previous event of the same batch.
+	*lastTicks += int64(args[0])
+	t.observe(*lastTicks)
+
+	switch typ {
+	case evCPUSample:
+		t.cpuSamples[args[len(args)-1]]++
+	case evGoCreate:
+		t.goroutineCreations[args[len(args)-1]]++
+	case evGCStart:
+		t.gc.starts++
+		t.gc.startTicks += *lastTicks
+	case evGCDone:
+		t.gc.ends++
+		t.gc.endTicks += *lastTicks
+	case evGCSTWStart:
+		t.stw.starts++
+		t.stw.startTicks += *lastTicks
+	case evGCSTWDone:
+		t.stw.ends++
+		t.stw.endTicks += *lastTicks
+	}
+
+	return nil
+}
+
+func minArgs(typ byte) int {
+	switch typ {
+	case evBatch, evStack:
+		return 2
+	case evGoCreate:
+		return 4
+	case evCPUSample:
+		return 5
+	default:
+		// Every other event starts with its timestamp.
+		return 1
+	}
+}
+
+func (t *Trace) observe(ticks int64) {
+	if ticks > t.maxTicks {
+		t.maxTicks = ticks
+	}
+}
+
+func (t *Trace) stack(args []uint64) error {
+	id, size := args[0], args[1]
+	if size > maxStackFrames {
+		return fmt.Errorf("stack %d has %d frames", id, size)
+	}
+	if uint64(len(args)) != 2+size*4 {
+		return fmt.Errorf("stack %d of %d frames has %d arguments", id, size, len(args))
+	}
+
+	frames := make([]frame, 0, size)
+	for i := 2; i < len(args); i += 4 {
+		frames = append(frames, frame{
+			pc:   args[i],
+			fn:   args[i+1],
+			file: args[i+2],
+			line: int64(args[i+3]),
+		})
+	}
+	t.stacks[id] = frames
+	return nil
+}
+
+// ticksToNanos converts a number of ticks to nanoseconds, it returns 0 if
+// the frequency of the ticks is unknown.
+func (t *Trace) ticksToNanos(ticks int64) int64 {
+	if t.frequency == 0 {
+		return 0
+	}
+	return int64(float64(ticks) * 1e9 / float64(t.frequency))
+}
+
+// DurationNanos is the time between the first and the last event of the
+// trace.
+func (t *Trace) DurationNanos() int64 {
+	if t.minTicks < 0 {
+		return 0
+	}
+	return t.ticksToNanos(t.maxTicks - t.minTicks)
+}
+
+// total returns the sum of the durations of the events, which is only known
+// if every start has an end.
+func (d durationEvents) total() (int64, bool) {
+	if d.starts != d.ends {
+		return 0, false
+	}
+	return d.endTicks - d.startTicks, true
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+type traceWriter struct {
+	bytes.Buffer
+}
+
+func newTraceWriter(version string) *traceWriter {
+	w := &traceWriter{}
+	w.WriteString("go " + version + " trace\x00\x00\x00")
+	return w
+}
+
+func (w *traceWriter) uvarint(v uint64) {
+	w.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *traceWriter) event(typ byte, args ...uint64) {
+	if len(args) < 4 {
+		w.WriteByte(typ | byte(len(args)-1)<<6)
+		for _, a := range args {
+			w.uvarint(a)
+		}
+		return
+	}
+
+	var b []byte
+	for _, a := range args {
+		b = binary.AppendUvarint(b, a)
+	}
+	w.WriteByte(typ | 3<<6)
+	w.uvarint(uint64(len(b)))
+	w.Write(b)
+}
+
+func (w *traceWriter) string(id uint64, s string) {
+	w.WriteByte(evString)
+	w.uvarint(id)
+	w.uvarint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+// testTrace has two stacks, main.main and main.work with main.inlined
+// inlined into it called by main.main.
+func testTrace() []byte {
+	w := newTraceWriter("1.19")
+	w.string(1, "main.main")
+	w.string(2, "main.go")
+	w.string(3, "main.work")
+	w.string(4, "main.inlined")
+
+	w.event(evBatch, 0, 1000)
+	w.event(evGoCreate, 0, 2, 0, 1)
+	w.event(evGCSTWStart, 10, 0) // 1010
+	w.event(evGCSTWDone, 5)      // 1015
+	w.event(evGCStart, 5, 1, 0)  // 1020
+	for i := 0; i < 3; i++ {
+		w.event(evCPUSample, 0, 1100, 0, 2, 2)
+	}
+	w.event(evCPUSample, 0, 1100, 0, 2, 1)
+
+	// User logs are followed by their message.
+	w.event(evUserLog, 1, 0, 0, 0)
+	w.uvarint(5)
+	w.WriteString("hello")
+
+	w.event(evBatch, 1, 2000)
+	w.event(evGCDone, 500)       // 2500
+	w.event(evGCSTWStart, 10, 0) // 2510
+	w.event(evGCSTWDone, 20)     // 2530
+
+	w.event(evFrequency, 1e9)
+	w.event(evStack, 1, 1, 0x10, 1, 2, 10)
+	w.event(evStack, 2, 3, 0x20, 4, 2, 30, 0x20, 3, 2, 20, 0x10, 1, 2, 10)
+
+	return w.Bytes()
+}
+
+func functionNames(p *pprofpb.Profile, locationIDs []uint64) [][]string {
+	var names [][]string
+	for _, id := range locationIDs {
+		var lines []string
+		for _, l := range p.Location[id-1].Line {
+			lines = append(lines, p.StringTable[p.Function[l.FunctionId-1].Name])
+		}
+		names = append(names, lines)
+	}
+	return names
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tr, err := Parse(bytes.NewReader(testTrace()))
+	require.NoError(t, err)
+	require.Equal(t, 1019, tr.Version)
+	require.Equal(t, int64(1530), tr.DurationNanos())
+
+	cpu := tr.CPUProfile(DefaultCPUSamplePeriod)
+	require.Equal(t, []string{"", "samples", "count", "cpu", "nanoseconds", "main.main", "main.go", "main.inlined", "main.work"}, cpu.StringTable)
+	require.Equal(t, DefaultCPUSamplePeriod.Nanoseconds(), cpu.Period)
+	require.Equal(t, int64(1530), cpu.DurationNanos)
+	require.Len(t, cpu.Sample, 2)
+	require.Equal(t, []int64{1, 10_000_000}, cpu.Sample[0].Value)
+	require.Equal(t, [][]string{{"main.main"}}, functionNames(cpu, cpu.Sample[0].LocationId))
+	require.Equal(t, []int64{3, 30_000_000}, cpu.Sample[1].Value)
+	require.Equal(t, [][]string{{"main.inlined", "main.work"}, {"main.main"}}, functionNames(cpu, cpu.Sample[1].LocationId))
+	require.Len(t, cpu.Location, 2)
+	require.Equal(t, []*pprofpb.Line{{FunctionId: 2, Line: 30}, {FunctionId: 3, Line: 20}}, cpu.Location[1].Line)
+
+	goroutines := tr.GoroutineCreationProfile()
+	require.Len(t, goroutines.Sample, 1)
+	require.Equal(t, []int64{1}, goroutines.Sample[0].Value)
+	require.Equal(t, [][]string{{"main.main"}}, functionNames(goroutines, goroutines.Sample[0].LocationId))
+
+	gc := tr.GCProfile()
+	require.Len(t, gc.SampleType, 3)
+	require.Equal(t, []*pprofpb.Sample{{Value: []int64{1, 1480, 25}}}, gc.Sample)
+}
+
+func TestParseIncompleteGC(t *testing.T) {
+	t.Parallel()
+
+	w := newTraceWriter("1.19")
+	w.event(evBatch, 0, 1000)
+	w.event(evGCStart, 5, 1, 0)
+	w.event(evFrequency, 1e9)
+
+	tr, err := Parse(bytes.NewReader(w.Bytes()))
+	require.NoError(t, err)
+	require.Nil(t, tr.CPUProfile(DefaultCPUSamplePeriod))
+
+	// The duration of garbage collections is only known if they completed.
+	gc := tr.GCProfile()
+	require.Len(t, gc.SampleType, 2)
+	require.Equal(t, "gc_cycles", gc.StringTable[gc.SampleType[0].Type])
+	require.Equal(t, "stw", gc.StringTable[gc.SampleType[1].Type])
+	require.Equal(t, []int64{1, 0}, gc.Sample[0].Value)
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	b := testTrace()
+	_, err := Parse(bytes.NewReader(b[:len(b)-1]))
+	require.Error(t, err)
+
+	_, err = Parse(bytes.NewReader(newTraceWriter("1.22").Bytes()))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = Parse(bytes.NewReader([]byte("not a trace at all")))
+	require.Error(t, err)
+
+	w := newTraceWriter("1.19")
+	w.event(evStack, 1, 2, 0x10, 1, 2, 10)
+	_, err = Parse(bytes.NewReader(w.Bytes()))
+	require.Error(t, err)
+}
+
+func TestParseRuntimeTrace(t *testing.T) {
+	t.Parallel()
+
+	// Written by runtime/trace of Go 1.19 with the CPU profiler running
+	// while four goroutines call main.burn.
+	f, err := os.Open("testdata/go1.19.trace")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+
+	tr, err := Parse(f)
+	require.NoError(t, err)
+	require.Equal(t, 1019, tr.Version)
+	require.Greater(t, tr.DurationNanos(), int64(0))
+
+	cpu := tr.CPUProfile(DefaultCPUSamplePeriod)
+	require.NotEmpty(t, cpu.Sample)
+	for _, s := range cpu.Sample {
+		require.Equal(t, "main.burn", functionNames(cpu, s.LocationId)[0][0])
+		require.Equal(t, s.Value[0]*DefaultCPUSamplePeriod.Nanoseconds(), s.Value[1])
+	}
+
+	var created int64
+	goroutines := tr.GoroutineCreationProfile()
+	for _, s := range goroutines.Sample {
+		if functionNames(goroutines, s.LocationId)[0][0] == "main.main" {
+			created += s.Value[0]
+		}
+	}
+	require.GreaterOrEqual(t, created, int64(4))
+
+	gc := tr.GCProfile()
+	require.Equal(t, int64(1), gc.Sample[0].Value[0])
+	require.Greater(t, gc.Sample[0].Value[1], int64(0))
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gotrace
+
+import (
+	"sort"
+	"time"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+// DefaultCPUSamplePeriod is the period between CPU profile samples at the
+// default rate of runtime/pprof.
+const DefaultCPUSamplePeriod = 10 * time.Millisecond
+
+// CPUProfile returns a CPU profile of the stacks sampled while the CPU
+// profiler was running during the trace. The trace doesn't record the
+// profiling rate, so it is assumed to be one sample per period. It returns
+// nil if the trace contains no CPU samples, like traces written before Go
+// 1.19.
+func (t *Trace) CPUProfile(period time.Duration) *pprofpb.Profile {
+	if len(t.cpuSamples) == 0 {
+		return nil
+	}
+
+	b := t.newProfileBuilder()
+	b.p.SampleType = []*pprofpb.ValueType{
+		b.valueType("samples", "count"),
+		b.valueType("cpu", "nanoseconds"),
+	}
+	b.p.PeriodType = b.valueType("cpu", "nanoseconds")
+	b.p.Period = period.Nanoseconds()
+
+	for _, id := range sortedStackIDs(t.cpuSamples) {
+		n := t.cpuSamples[id]
+		b.sample(id, n, n*period.Nanoseconds())
+	}
+
+	return b.p
+}
+
+// GoroutineCreationProfile returns a profile of the stacks that created
+// goroutines during the trace.
+func (t *Trace) GoroutineCreationProfile() *pprofpb.Profile {
+	b := t.newProfileBuilder()
+	b.p.SampleType = []*pprofpb.ValueType{b.valueType("goroutines", "count")}
+	b.p.PeriodType = b.valueType("goroutines", "count")
+	b.p.Period = 1
+
+	for _, id := range sortedStackIDs(t.goroutineCreations) {
+		b.sample(id, t.goroutineCreations[id])
+	}
+
+	return b.p
+}
+
+// GCProfile returns a profile of a single sample without stack with the
+// number of garbage collections during the trace and, if every collection
+// in the trace completed, the time spent collecting and stopping the world.
+func (t *Trace) GCProfile() *pprofpb.Profile {
+	b := t.newProfileBuilder()
+	b.p.SampleType = []*pprofpb.ValueType{b.valueType("gc_cycles", "count")}
+	b.p.PeriodType = b.valueType("gc_cycles", "count")
+	b.p.Period = 1
+
+	s := &pprofpb.Sample{Value: []int64{t.gc.starts}}
+	if ticks, ok := t.gc.total(); ok && t.frequency != 0 {
+		b.p.SampleType = append(b.p.SampleType, b.valueType("gc", "nanoseconds"))
+		s.Value = append(s.Value, t.ticksToNanos(ticks))
+	}
+	if ticks, ok := t.stw.total(); ok && t.frequency != 0 {
+		b.p.SampleType = append(b.p.SampleType, b.valueType("stw", "nanoseconds"))
+		s.Value = append(s.Value, t.ticksToNanos(ticks))
+	}
+	b.p.Sample = []*pprofpb.Sample{s}
+
+	return b.p
+}
+
+func sortedStackIDs(m map[uint64]int64) []uint64 {
+	ids := make([]uint64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type functionKey struct {
+	name, file uint64
+}
+
+// profileBuilder builds a pprof profile of the stacks of a trace. The IDs of
+// functions and locations are their index plus one.
+type profileBuilder struct {
+	t *Trace
+	p *pprofpb.Profile
+
+	strings   map[string]int64
+	functions map[functionKey]uint64
+	locations map[uint64]uint64
+}
+
+func (t *Trace) newProfileBuilder() *profileBuilder {
+	return &profileBuilder{
+		t: t,
+		p: &pprofpb.Profile{
+			StringTable:   []string{""},
+			DurationNanos: t.DurationNanos(),
+		},
+		strings:   map[string]int64{"": 0},
+		functions: map[functionKey]uint64{},
+		locations: map[uint64]uint64{},
+	}
+}
+
+func (b *profileBuilder) string(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.p.StringTable))
+	b.p.StringTable = append(b.p.StringTable, s)
+	b.strings[s] = i
+	return i
+}
+
+func (b *profileBuilder) valueType(typ, unit string) *pprofpb.ValueType {
+	return &pprofpb.ValueType{Type: b.string(typ), Unit: b.string(unit)}
+}
+
+func (b *profileBuilder) sample(stackID uint64, values ...int64) {
+	b.p.Sample = append(b.p.Sample, &pprofpb.Sample{
+		LocationId: b.locationIDs(b.t.stacks[stackID]),
+		Value:      values,
+	})
+}
+
+// locationIDs returns the locations of the frames of a stack. Consecutive
+// frames with the same PC are functions inlined at that PC and make up a
+// single location.
+func (b *profileBuilder) locationIDs(frames []frame) []uint64 {
+	ids := make([]uint64, 0, len(frames))
+	for i := 0; i < len(frames); {
+		j := i + 1
+		for j < len(frames) && frames[j].pc == frames[i].pc {
+			j++
+		}
+		ids = append(ids, b.location(frames[i:j]))
+		i = j
+	}
+	return ids
+}
+
+func (b *profileBuilder) location(frames []frame) uint64 {
+	if id, ok := b.locations[frames[0].pc]; ok {
+		return id
+	}
+
+	// The address is left empty as traces have no mappings, addresses of
+	// different binaries would be indistinguishable. Locations are then
+	// identified by their lines.
+	l := &pprofpb.Location{
+		Id:   uint64(len(b.p.Location) + 1),
+		Line: make([]*pprofpb.Line, 0, len(frames)),
+	}
+	for _, f := range frames {
+		l.Line = append(l.Line, &pprofpb.Line{
+			FunctionId: b.function(f),
+			Line:       f.line,
+		})
+	}
+	b.p.Location = append(b.p.Location, l)
+	b.locations[frames[0].pc] = l.Id
+	return l.Id
+}
+
+func (b *profileBuilder) function(f frame) uint64 {
+	key := functionKey{name: f.fn, file: f.file}
+	if id, ok := b.functions[key]; ok {
+		return id
+	}
+
+	name := b.string(b.t.strings[f.fn])
+	fn := &pprofpb.Function{
+		Id:         uint64(len(b.p.Function) + 1),
+		Name:       name,
+		SystemName: name,
+		Filename:   b.string(b.t.strings[f.file]),
+	}
+	b.p.Function = append(b.p.Function, fn)
+	b.functions[key] = fn.Id
+	return fn.Id
+}
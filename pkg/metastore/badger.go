@@ -15,7 +15,9 @@ package metastore
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/go-kit/log"
@@ -34,9 +36,35 @@ type BadgerMetastore struct {
 
 	db *badger.DB
 
+	interner *stringInterner
+
 	pb.UnimplementedMetastoreServiceServer
 }
 
+type badgerOptions struct {
+	internerSize         int
+	statsRefreshInterval time.Duration
+}
+
+// Option configures a BadgerMetastore.
+type Option func(*badgerOptions)
+
+// WithStatsRefreshInterval sets the minimum interval between two scans of
+// the store computing the metrics about its size and composition.
+func WithStatsRefreshInterval(d time.Duration) Option {
+	return func(o *badgerOptions) {
+		o.statsRefreshInterval = d
+	}
+}
+
+// WithStringInternerSize sets the number of distinct function and file names
+// kept interned in memory. Interning is disabled if size is zero.
+func WithStringInternerSize(size int) Option {
+	return func(o *badgerOptions) {
+		o.internerSize = size
+	}
+}
+
 type BadgerLogger struct {
 	Logger log.Logger
 }
@@ -66,15 +94,51 @@ func NewBadgerMetastore(
 	reg prometheus.Registerer,
 	tracer trace.Tracer,
 	db *badger.DB,
+	opts ...Option,
 ) *BadgerMetastore {
-	return &BadgerMetastore{
-		db:     db,
-		tracer: tracer,
-		logger: logger,
+	o := &badgerOptions{
+		internerSize:         DefaultStringInternerSize,
+		statsRefreshInterval: DefaultStatsRefreshInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	m := &BadgerMetastore{
+		db:       db,
+		tracer:   tracer,
+		logger:   logger,
+		interner: newStringInterner(reg, o.internerSize),
+	}
+	if reg != nil {
+		reg.MustRegister(newStatsCollector(m, o.statsRefreshInterval))
 	}
+
+	return m
+}
+
+// maxConflictRetries is how often transactions conflicting with concurrent
+// transactions are run.
+const maxConflictRetries = 5
+
+// update runs fn in a read-write transaction, running it again if it
+// conflicts with a concurrent transaction. Concurrent writes of profiles of
+// the same binaries create the same keys, running the transaction again
+// reads the keys the concurrent transaction created.
+func (m *BadgerMetastore) update(fn func(txn *badger.Txn) error) error {
+	var err error
+	for i := 0; i < maxConflictRetries; i++ {
+		if err = m.db.Update(fn); !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return err
 }
 
 func (m *BadgerMetastore) Mappings(ctx context.Context, r *pb.MappingsRequest) (*pb.MappingsResponse, error) {
+	_, span := m.tracer.Start(ctx, "Mappings")
+	defer span.End()
+
 	res := &pb.MappingsResponse{
 		Mappings: make([]*pb.Mapping, 0, len(r.MappingIds)),
 	}
@@ -113,6 +177,9 @@ func (m *BadgerMetastore) Mappings(ctx context.Context, r *pb.MappingsRequest) (
 }
 
 func (m *BadgerMetastore) GetOrCreateMappings(ctx context.Context, r *pb.GetOrCreateMappingsRequest) (*pb.GetOrCreateMappingsResponse, error) {
+	_, span := m.tracer.Start(ctx, "GetOrCreateMappings")
+	defer span.End()
+
 	res := &pb.GetOrCreateMappingsResponse{
 		Mappings: make([]*pb.Mapping, 0, len(r.Mappings)),
 	}
@@ -122,7 +189,8 @@ func (m *BadgerMetastore) GetOrCreateMappings(ctx context.Context, r *pb.GetOrCr
 		mappingKeys = append(mappingKeys, MakeMappingKey(id))
 	}
 
-	err := m.db.Update(func(txn *badger.Txn) error {
+	err := m.update(func(txn *badger.Txn) error {
+		res.Mappings = res.Mappings[:0]
 		for i, mappingKey := range mappingKeys {
 			item, err := txn.Get([]byte(mappingKey))
 			if err != nil && err != badger.ErrKeyNotFound {
@@ -165,6 +233,9 @@ func (m *BadgerMetastore) GetOrCreateMappings(ctx context.Context, r *pb.GetOrCr
 }
 
 func (m *BadgerMetastore) Functions(ctx context.Context, r *pb.FunctionsRequest) (*pb.FunctionsResponse, error) {
+	_, span := m.tracer.Start(ctx, "Functions")
+	defer span.End()
+
 	res := &pb.FunctionsResponse{
 		Functions: make([]*pb.Function, 0, len(r.FunctionIds)),
 	}
@@ -188,6 +259,7 @@ func (m *BadgerMetastore) Functions(ctx context.Context, r *pb.FunctionsRequest)
 					return err
 				}
 
+				m.interner.internFunction(function)
 				res.Functions = append(res.Functions, function)
 				return nil
 			})
@@ -203,6 +275,9 @@ func (m *BadgerMetastore) Functions(ctx context.Context, r *pb.FunctionsRequest)
 }
 
 func (m *BadgerMetastore) GetOrCreateFunctions(ctx context.Context, r *pb.GetOrCreateFunctionsRequest) (*pb.GetOrCreateFunctionsResponse, error) {
+	_, span := m.tracer.Start(ctx, "GetOrCreateFunctions")
+	defer span.End()
+
 	res := &pb.GetOrCreateFunctionsResponse{
 		Functions: make([]*pb.Function, 0, len(r.Functions)),
 	}
@@ -212,7 +287,8 @@ func (m *BadgerMetastore) GetOrCreateFunctions(ctx context.Context, r *pb.GetOrC
 		functionKeys = append(functionKeys, MakeFunctionKey(function))
 	}
 
-	err := m.db.Update(func(txn *badger.Txn) error {
+	err := m.update(func(txn *badger.Txn) error {
+		res.Functions = res.Functions[:0]
 		for i, functionKey := range functionKeys {
 			item, err := txn.Get([]byte(functionKey))
 			if err != nil && err != badger.ErrKeyNotFound {
@@ -222,6 +298,7 @@ func (m *BadgerMetastore) GetOrCreateFunctions(ctx context.Context, r *pb.GetOrC
 			if err == badger.ErrKeyNotFound {
 				function := r.Functions[i]
 				function.Id = FunctionIDFromKey(functionKey)
+				m.interner.internFunction(function)
 				b, err := function.MarshalVT()
 				if err != nil {
 					return err
@@ -240,6 +317,7 @@ func (m *BadgerMetastore) GetOrCreateFunctions(ctx context.Context, r *pb.GetOrC
 					return err
 				}
 
+				m.interner.internFunction(function)
 				res.Functions = append(res.Functions, function)
 				return nil
 			})
@@ -255,6 +333,9 @@ func (m *BadgerMetastore) GetOrCreateFunctions(ctx context.Context, r *pb.GetOrC
 }
 
 func (m *BadgerMetastore) Locations(ctx context.Context, r *pb.LocationsRequest) (*pb.LocationsResponse, error) {
+	ctx, span := m.tracer.Start(ctx, "Locations")
+	defer span.End()
+
 	res := &pb.LocationsResponse{
 		Locations: make([]*pb.Location, 0, len(r.LocationIds)),
 	}
@@ -299,6 +380,9 @@ func (m *BadgerMetastore) locations(ctx context.Context, txn *badger.Txn, locati
 }
 
 func (m *BadgerMetastore) GetOrCreateLocations(ctx context.Context, r *pb.GetOrCreateLocationsRequest) (*pb.GetOrCreateLocationsResponse, error) {
+	_, span := m.tracer.Start(ctx, "GetOrCreateLocations")
+	defer span.End()
+
 	res := &pb.GetOrCreateLocationsResponse{
 		Locations: make([]*pb.Location, 0, len(r.Locations)),
 	}
@@ -308,7 +392,8 @@ func (m *BadgerMetastore) GetOrCreateLocations(ctx context.Context, r *pb.GetOrC
 		locationKeys = append(locationKeys, MakeLocationKey(location))
 	}
 
-	err := m.db.Update(func(txn *badger.Txn) error {
+	err := m.update(func(txn *badger.Txn) error {
+		res.Locations = res.Locations[:0]
 		for i, locationKey := range locationKeys {
 			item, err := txn.Get([]byte(locationKey))
 			if err != nil && err != badger.ErrKeyNotFound {
@@ -360,6 +445,9 @@ func (m *BadgerMetastore) GetOrCreateLocations(ctx context.Context, r *pb.GetOrC
 }
 
 func (m *BadgerMetastore) UnsymbolizedLocations(ctx context.Context, r *pb.UnsymbolizedLocationsRequest) (*pb.UnsymbolizedLocationsResponse, error) {
+	ctx, span := m.tracer.Start(ctx, "UnsymbolizedLocations")
+	defer span.End()
+
 	var locations []*pb.Location
 
 	maxKey := ""
@@ -410,7 +498,10 @@ func (m *BadgerMetastore) UnsymbolizedLocations(ctx context.Context, r *pb.Unsym
 }
 
 func (m *BadgerMetastore) CreateLocationLines(ctx context.Context, r *pb.CreateLocationLinesRequest) (*pb.CreateLocationLinesResponse, error) {
-	err := m.db.Update(func(txn *badger.Txn) error {
+	_, span := m.tracer.Start(ctx, "CreateLocationLines")
+	defer span.End()
+
+	err := m.update(func(txn *badger.Txn) error {
 		for _, location := range r.Locations {
 			b, err := location.MarshalVT()
 			if err != nil {
@@ -434,6 +525,9 @@ func (m *BadgerMetastore) CreateLocationLines(ctx context.Context, r *pb.CreateL
 }
 
 func (m *BadgerMetastore) GetOrCreateStacktraces(ctx context.Context, r *pb.GetOrCreateStacktracesRequest) (*pb.GetOrCreateStacktracesResponse, error) {
+	_, span := m.tracer.Start(ctx, "GetOrCreateStacktraces")
+	defer span.End()
+
 	res := &pb.GetOrCreateStacktracesResponse{
 		Stacktraces: make([]*pb.Stacktrace, 0, len(r.Stacktraces)),
 	}
@@ -476,7 +570,8 @@ type retryableGetOrCreateStacktraces struct {
 
 func (m *BadgerMetastore) retryableGetOrCreateStacktraces(r *pb.GetOrCreateStacktracesRequest, stacktraceKeys []string) (retryableGetOrCreateStacktraces, error) {
 	result := retryableGetOrCreateStacktraces{}
-	err := m.db.Update(func(txn *badger.Txn) error {
+	err := m.update(func(txn *badger.Txn) error {
+		result = retryableGetOrCreateStacktraces{}
 		for i, stacktraceKey := range stacktraceKeys {
 			item, err := txn.Get([]byte(stacktraceKey))
 			if err != nil && err != badger.ErrKeyNotFound {
@@ -528,6 +623,9 @@ func (m *BadgerMetastore) retryableGetOrCreateStacktraces(r *pb.GetOrCreateStack
 }
 
 func (m *BadgerMetastore) Stacktraces(ctx context.Context, r *pb.StacktracesRequest) (*pb.StacktracesResponse, error) {
+	_, span := m.tracer.Start(ctx, "Stacktraces")
+	defer span.End()
+
 	res := &pb.StacktracesResponse{
 		Stacktraces: make([]*pb.Stacktrace, 0, len(r.StacktraceIds)),
 	}
@@ -0,0 +1,109 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+)
+
+// DefaultStringInternerSize is the number of distinct strings kept by the
+// interner of the metastore unless configured otherwise.
+const DefaultStringInternerSize = 65536
+
+// stringInterner deduplicates strings so that equal strings share a single
+// backing array. Function names and file names are decoded from the store
+// over and over again, interning them keeps a single copy of each in memory.
+// The table is bounded, the least recently used strings are evicted first.
+type stringInterner struct {
+	mtx     sync.Mutex
+	size    int
+	strings map[string]*list.Element
+	lru     *list.List
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+}
+
+func newStringInterner(reg prometheus.Registerer, size int) *stringInterner {
+	i := &stringInterner{
+		size:    size,
+		strings: make(map[string]*list.Element, size),
+		lru:     list.New(),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_metastore_interned_strings_hits_total",
+			Help: "Number of strings that were already interned.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_metastore_interned_strings_misses_total",
+			Help: "Number of strings that were added to the interner.",
+		}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_metastore_interned_strings_evictions_total",
+			Help: "Number of strings evicted from the interner.",
+		}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(i.hits, i.misses, i.evictions)
+	}
+
+	return i
+}
+
+// Intern returns a string equal to s, sharing its backing array with
+// previously interned equal strings.
+func (i *stringInterner) Intern(s string) string {
+	if i == nil || i.size <= 0 || s == "" {
+		return s
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if e, ok := i.strings[s]; ok {
+		i.lru.MoveToFront(e)
+		i.hits.Inc()
+		return e.Value.(string)
+	}
+
+	i.misses.Inc()
+	if i.lru.Len() >= i.size {
+		oldest := i.lru.Back()
+		i.lru.Remove(oldest)
+		delete(i.strings, oldest.Value.(string))
+		i.evictions.Inc()
+	}
+	i.strings[s] = i.lru.PushFront(s)
+
+	return s
+}
+
+// Len returns the number of strings currently interned.
+func (i *stringInterner) Len() int {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	return i.lru.Len()
+}
+
+func (i *stringInterner) internFunction(f *pb.Function) {
+	f.Name = i.Intern(f.Name)
+	f.SystemName = i.Intern(f.SystemName)
+	f.Filename = i.Intern(f.Filename)
+}
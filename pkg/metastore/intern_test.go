@@ -0,0 +1,221 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+)
+
+func stringData(s string) uintptr {
+	return (*[2]uintptr)(unsafe.Pointer(&s))[0]
+}
+
+func TestStringInterner(t *testing.T) {
+	t.Parallel()
+
+	i := newStringInterner(prometheus.NewRegistry(), 2)
+
+	a := i.Intern(string([]byte("main.main")))
+	b := i.Intern(string([]byte("main.main")))
+	require.Equal(t, "main.main", b)
+	require.Equal(t, stringData(a), stringData(b))
+	require.Equal(t, 1.0, testutil.ToFloat64(i.hits))
+
+	// Filling the table evicts the least recently used string.
+	i.Intern("runtime.main")
+	i.Intern("main.main")
+	i.Intern("runtime.goexit")
+	require.Equal(t, 2, i.Len())
+	require.Equal(t, 1.0, testutil.ToFloat64(i.evictions))
+	require.Equal(t, stringData(a), stringData(i.Intern(string([]byte("main.main")))))
+
+	c := string([]byte("runtime.main"))
+	require.Equal(t, stringData(c), stringData(i.Intern(c)))
+}
+
+func TestStringInternerDisabled(t *testing.T) {
+	t.Parallel()
+
+	i := newStringInterner(nil, 0)
+	s := string([]byte("main.main"))
+	require.Equal(t, stringData(s), stringData(i.Intern(s)))
+	require.Equal(t, 0, i.Len())
+}
+
+func TestStringInternerConcurrent(t *testing.T) {
+	t.Parallel()
+
+	i := newStringInterner(nil, 16)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 1000; n++ {
+				s := fmt.Sprintf("func%d", (n+g)%32)
+				require.Equal(t, s, i.Intern(s))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	require.LessOrEqual(t, i.Len(), 16)
+}
+
+func newTestBadgerMetastore(t testing.TB, opts ...Option) *BadgerMetastore {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return NewBadgerMetastore(
+		log.NewNopLogger(),
+		prometheus.NewRegistry(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		db,
+		opts...,
+	)
+}
+
+func TestBadgerMetastoreInternsFunctions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := newTestBadgerMetastore(t)
+
+	res, err := m.GetOrCreateFunctions(ctx, &pb.GetOrCreateFunctionsRequest{
+		Functions: []*pb.Function{
+			{Name: "main.main", SystemName: "main.main", Filename: "main.go"},
+			{Name: "main.run", SystemName: "main.run", Filename: "main.go"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Functions, 2)
+
+	fres, err := m.Functions(ctx, &pb.FunctionsRequest{
+		FunctionIds: []string{res.Functions[0].Id, res.Functions[1].Id, res.Functions[0].Id},
+	})
+	require.NoError(t, err)
+	require.Len(t, fres.Functions, 3)
+
+	require.Equal(t, "main.main", fres.Functions[0].Name)
+	require.Equal(t, "main.run", fres.Functions[1].Name)
+	require.Equal(t, stringData(fres.Functions[0].Name), stringData(fres.Functions[2].Name))
+	require.Equal(t, stringData(fres.Functions[0].Name), stringData(fres.Functions[0].SystemName))
+	require.Equal(t, stringData(fres.Functions[0].Filename), stringData(fres.Functions[1].Filename))
+}
+
+func benchmarkFunctions(b *testing.B, opts ...Option) {
+	ctx := context.Background()
+	m := newTestBadgerMetastore(b, opts...)
+
+	const n = 1000
+	functions := make([]*pb.Function, 0, n)
+	for i := 0; i < n; i++ {
+		functions = append(functions, &pb.Function{
+			Name:       fmt.Sprintf("github.com/parca-dev/parca/pkg/example.(*Type).Method%d", i),
+			SystemName: fmt.Sprintf("github.com/parca-dev/parca/pkg/example.(*Type).Method%d", i),
+			Filename:   "/home/parca/go/src/github.com/parca-dev/parca/pkg/example/example.go",
+		})
+	}
+	res, err := m.GetOrCreateFunctions(ctx, &pb.GetOrCreateFunctionsRequest{Functions: functions})
+	require.NoError(b, err)
+
+	ids := make([]string, 0, n)
+	for _, f := range res.Functions {
+		ids = append(ids, f.Id)
+	}
+
+	// Keep the result of every iteration alive, like profiles holding on to
+	// their functions, and report the heap they retain.
+	retained := make([]*pb.FunctionsResponse, 0, b.N)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fres, err := m.Functions(ctx, &pb.FunctionsRequest{FunctionIds: ids})
+		if err != nil {
+			b.Fatal(err)
+		}
+		retained = append(retained, fres)
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "retained-B/op")
+	runtime.KeepAlive(retained)
+}
+
+func BenchmarkFunctions(b *testing.B) {
+	b.Run("interned", func(b *testing.B) {
+		benchmarkFunctions(b)
+	})
+	b.Run("not-interned", func(b *testing.B) {
+		benchmarkFunctions(b, WithStringInternerSize(0))
+	})
+}
+
+func TestBadgerMetastoreConcurrentGetOrCreate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := newTestBadgerMetastore(t)
+
+	functions := func() []*pb.Function {
+		fs := make([]*pb.Function, 0, 1024)
+		for n := 0; n < cap(fs); n++ {
+			fs = append(fs, &pb.Function{Name: fmt.Sprintf("func%d", n), Filename: "main.go"})
+		}
+		return fs
+	}
+
+	// Concurrent writes of the same functions conflict, they are retried
+	// rather than failed.
+	ids := make([][]string, 16)
+	var wg sync.WaitGroup
+	for g := range ids {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			res, err := m.GetOrCreateFunctions(ctx, &pb.GetOrCreateFunctionsRequest{Functions: functions()})
+			require.NoError(t, err)
+			for _, f := range res.Functions {
+				ids[g] = append(ids[g], f.Id)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, got := range ids {
+		require.Equal(t, ids[0], got)
+	}
+	require.Len(t, ids[0], 1024)
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultStatsRefreshInterval is the minimum interval between two scans of
+// the metastore to compute its statistics unless configured otherwise.
+const DefaultStatsRefreshInterval = time.Minute
+
+// Kinds of entities in the metastore.
+const (
+	KindFunctions             = "functions"
+	KindLocations             = "locations"
+	KindMappings              = "mappings"
+	KindStacktraces           = "stacktraces"
+	KindUnsymbolizedLocations = "unsymbolized_locations"
+)
+
+var statsKindPrefixes = []struct {
+	kind   string
+	prefix string
+}{
+	{KindFunctions, functionKeyPrefix},
+	{KindLocations, locationsKeyPrefix},
+	{KindMappings, mappingKeyPrefix},
+	{KindStacktraces, stacktraceKeyPrefix},
+	{KindUnsymbolizedLocations, UnsymbolizedLocationLinesKeyPrefix},
+}
+
+// KindStats are the statistics of one kind of entity in the metastore.
+type KindStats struct {
+	// Entries is the number of entities.
+	Entries int64
+	// Bytes is the approximate size of the keys and values of the entities.
+	Bytes int64
+}
+
+// Stats returns the number and approximate size of the entities stored in
+// the metastore by kind. Only keys are read, values are not fetched.
+func (m *BadgerMetastore) Stats() (map[string]KindStats, error) {
+	stats := make(map[string]KindStats, len(statsKindPrefixes))
+
+	err := m.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for _, k := range statsKindPrefixes {
+			s := KindStats{}
+			prefix := []byte(k.prefix)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				s.Entries++
+				s.Bytes += it.Item().EstimatedSize()
+			}
+			stats[k.kind] = s
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// statsCollector exposes the statistics of the metastore. Computing them
+// requires a scan of all keys, so the result is cached for refreshInterval.
+type statsCollector struct {
+	m               *BadgerMetastore
+	refreshInterval time.Duration
+
+	entries  *prometheus.Desc
+	bytes    *prometheus.Desc
+	duration prometheus.Histogram
+
+	mtx       sync.Mutex
+	stats     map[string]KindStats
+	refreshed time.Time
+}
+
+func newStatsCollector(m *BadgerMetastore, refreshInterval time.Duration) *statsCollector {
+	return &statsCollector{
+		m:               m,
+		refreshInterval: refreshInterval,
+		entries: prometheus.NewDesc(
+			"parca_metastore_entries",
+			"Number of entities in the metastore by kind.",
+			[]string{"kind"}, nil,
+		),
+		bytes: prometheus.NewDesc(
+			"parca_metastore_size_bytes",
+			"Approximate size of the keys and values of the entities in the metastore by kind.",
+			[]string{"kind"}, nil,
+		),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "parca_metastore_stats_refresh_duration_seconds",
+			Help:    "Duration of the scans of the metastore computing its statistics.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.bytes
+	c.duration.Describe(ch)
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	if c.stats == nil || time.Since(c.refreshed) >= c.refreshInterval {
+		start := time.Now()
+		stats, err := c.m.Stats()
+		if err != nil {
+			level.Warn(c.m.logger).Log("msg", "failed to compute metastore statistics", "err", err)
+		} else {
+			c.stats = stats
+			c.refreshed = time.Now()
+			c.duration.Observe(time.Since(start).Seconds())
+		}
+	}
+	stats := c.stats
+	c.mtx.Unlock()
+
+	for kind, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries), kind)
+		ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(s.Bytes), kind)
+	}
+	c.duration.Collect(ch)
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metastoretest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+func gatherMetastoreStats(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+
+	res := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != "parca_metastore_entries" && mf.GetName() != "parca_metastore_size_bytes" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			res[fmt.Sprintf("%s{kind=%q}", mf.GetName(), m.GetLabel()[0].GetValue())] = m.GetGauge().GetValue()
+		}
+	}
+	return res
+}
+
+func TestMetastoreStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+
+	db, err := badger.Open(
+		badger.DefaultOptions("").
+			WithInMemory(true).
+			WithLogger(&metastore.BadgerLogger{Logger: logger}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m := metastore.NewBadgerMetastore(
+		logger,
+		reg,
+		trace.NewNoopTracerProvider().Tracer(""),
+		db,
+		metastore.WithStatsRefreshInterval(0),
+	)
+
+	stats, err := m.Stats()
+	require.NoError(t, err)
+	for _, s := range stats {
+		require.Equal(t, metastore.KindStats{}, s)
+	}
+
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(content))
+
+	_, err = parcacol.NewNormalizer(metastore.NewInProcessClient(m)).NormalizePprof(ctx, "memory", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+
+	stacktraces := map[string]struct{}{}
+	for _, s := range p.Sample {
+		stacktraces[fmt.Sprint(s.LocationId)] = struct{}{}
+	}
+
+	stats, err = m.Stats()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(p.Function)), stats[metastore.KindFunctions].Entries)
+	require.Equal(t, int64(len(p.Location)), stats[metastore.KindLocations].Entries)
+	require.Equal(t, int64(len(p.Mapping)), stats[metastore.KindMappings].Entries)
+	require.Equal(t, int64(len(stacktraces)), stats[metastore.KindStacktraces].Entries)
+	require.Equal(t, int64(0), stats[metastore.KindUnsymbolizedLocations].Entries)
+	for kind, s := range stats {
+		if s.Entries > 0 {
+			require.Greater(t, s.Bytes, int64(0), kind)
+		}
+	}
+
+	metrics := gatherMetastoreStats(t, reg)
+	for kind, s := range stats {
+		require.Equal(t, float64(s.Entries), metrics[fmt.Sprintf("parca_metastore_entries{kind=%q}", kind)])
+		require.Equal(t, float64(s.Bytes), metrics[fmt.Sprintf("parca_metastore_size_bytes{kind=%q}", kind)])
+	}
+
+	// An unsymbolized location is reflected in the next collection.
+	mres, err := m.GetOrCreateMappings(ctx, &pb.GetOrCreateMappingsRequest{
+		Mappings: []*pb.Mapping{{Start: 1, Limit: 2, BuildId: "build-id"}},
+	})
+	require.NoError(t, err)
+	_, err = m.GetOrCreateLocations(ctx, &pb.GetOrCreateLocationsRequest{
+		Locations: []*pb.Location{{MappingId: mres.Mappings[0].Id, Address: 0x1}},
+	})
+	require.NoError(t, err)
+
+	metrics = gatherMetastoreStats(t, reg)
+	require.Equal(t, float64(len(p.Mapping)+1), metrics[`parca_metastore_entries{kind="mappings"}`])
+	require.Equal(t, float64(len(p.Location)+1), metrics[`parca_metastore_entries{kind="locations"}`])
+	require.Equal(t, 1.0, metrics[`parca_metastore_entries{kind="unsymbolized_locations"}`])
+}
+
+func TestMetastoreStatsRefreshInterval(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+
+	db, err := badger.Open(
+		badger.DefaultOptions("").
+			WithInMemory(true).
+			WithLogger(&metastore.BadgerLogger{Logger: logger}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m := metastore.NewBadgerMetastore(
+		logger,
+		reg,
+		trace.NewNoopTracerProvider().Tracer(""),
+		db,
+		metastore.WithStatsRefreshInterval(time.Hour),
+	)
+
+	require.Equal(t, 0.0, gatherMetastoreStats(t, reg)[`parca_metastore_entries{kind="functions"}`])
+
+	_, err = m.GetOrCreateFunctions(ctx, &pb.GetOrCreateFunctionsRequest{
+		Functions: []*pb.Function{{Name: "main.main"}},
+	})
+	require.NoError(t, err)
+
+	// The statistics are cached until the refresh interval has passed.
+	require.Equal(t, 0.0, gatherMetastoreStats(t, reg)[`parca_metastore_entries{kind="functions"}`])
+
+	stats, err := m.Stats()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats[metastore.KindFunctions].Entries)
+}
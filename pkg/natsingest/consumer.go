@@ -0,0 +1,197 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natsingest ingests profiles consumed from a NATS JetStream subject.
+package natsingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// DeadLetterErrorHeader is the header of dead-lettered messages holding the
+// reason they couldn't be ingested.
+const DeadLetterErrorHeader = "Parca-Ingest-Error"
+
+const (
+	fetchBatchSize = 16
+	fetchTimeout   = 5 * time.Second
+	fetchBackoff   = time.Second
+)
+
+// Config configures the consumption of a subject.
+type Config struct {
+	// Subject is the subject of the messages, each of which is a
+	// WriteRawRequest encoded as protobuf. It must be bound to a stream.
+	Subject string
+
+	// Durable is the name of the durable consumer, which keeps track of
+	// the messages acknowledged across restarts.
+	Durable string
+
+	// DeadLetterSubject is the subject messages that can't be ingested are
+	// published to. It must be bound to a stream as well. Such messages are
+	// dropped if it is empty.
+	DeadLetterSubject string
+
+	// MaxDeliveries is the number of deliveries after which a message that
+	// failed to be ingested is dead-lettered rather than redelivered, no
+	// matter the error. Unlimited if 0.
+	MaxDeliveries int
+}
+
+// Consumer writes the profiles of the messages of a subject to a profile
+// store. Messages are acknowledged once they are stored, so every message is
+// stored at least once.
+type Consumer struct {
+	logger log.Logger
+	js     nats.JetStreamContext
+	store  profilestorepb.ProfileStoreServiceServer
+	cfg    Config
+
+	messages *prometheus.CounterVec
+}
+
+func NewConsumer(logger log.Logger, reg prometheus.Registerer, nc *nats.Conn, store profilestorepb.ProfileStoreServiceServer, cfg Config) (*Consumer, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	c := &Consumer{
+		logger: log.With(logger, "component", "nats_ingest", "subject", cfg.Subject),
+		js:     js,
+		store:  store,
+		cfg:    cfg,
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_nats_ingest_messages_total",
+			Help: "Total number of messages consumed from NATS by result, which is stored, retried, dead_lettered or dropped.",
+		}, []string{"result"}),
+	}
+	reg.MustRegister(c.messages)
+
+	return c, nil
+}
+
+// Run consumes messages until the context is canceled.
+func (c *Consumer) Run(ctx context.Context) error {
+	sub, err := c.js.PullSubscribe(c.cfg.Subject, c.cfg.Durable)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", c.cfg.Subject, err)
+	}
+
+	for ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		msgs, err := sub.Fetch(fetchBatchSize, nats.Context(fetchCtx))
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			level.Warn(c.logger).Log("msg", "failed to fetch messages", "err", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(fetchBackoff):
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			c.handle(ctx, msg)
+		}
+	}
+
+	return nil
+}
+
+func (c *Consumer) handle(ctx context.Context, msg *nats.Msg) {
+	req := &profilestorepb.WriteRawRequest{}
+	if err := req.UnmarshalVT(msg.Data); err != nil {
+		c.deadLetter(msg, fmt.Errorf("failed to unmarshal write request: %w", err))
+		return
+	}
+
+	_, err := c.store.WriteRaw(ctx, req)
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to acknowledge message", "err", err)
+		}
+		c.messages.WithLabelValues("stored").Inc()
+		return
+	}
+
+	// Messages the store rejects are rejected on every delivery.
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.PermissionDenied:
+		c.deadLetter(msg, err)
+		return
+	}
+
+	if md, mdErr := msg.Metadata(); mdErr == nil && c.cfg.MaxDeliveries > 0 && md.NumDelivered >= uint64(c.cfg.MaxDeliveries) {
+		c.deadLetter(msg, fmt.Errorf("failed after %d deliveries: %w", md.NumDelivered, err))
+		return
+	}
+
+	level.Debug(c.logger).Log("msg", "failed to store profiles, message will be redelivered", "err", err)
+	if err := msg.Nak(); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to negatively acknowledge message", "err", err)
+	}
+	c.messages.WithLabelValues("retried").Inc()
+}
+
+// deadLetter publishes the message to the dead-letter subject, or drops it
+// if there is none. The message is redelivered if publishing fails, so it
+// is never lost.
+func (c *Consumer) deadLetter(msg *nats.Msg, reason error) {
+	level.Warn(c.logger).Log("msg", "failed to ingest message", "err", reason)
+
+	if c.cfg.DeadLetterSubject == "" {
+		if err := msg.Term(); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to terminate message", "err", err)
+		}
+		c.messages.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	dl := nats.NewMsg(c.cfg.DeadLetterSubject)
+	dl.Data = msg.Data
+	dl.Header.Set(DeadLetterErrorHeader, reason.Error())
+	if _, err := c.js.PublishMsg(dl); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to publish message to dead-letter subject", "err", err)
+		if err := msg.Nak(); err != nil {
+			level.Warn(c.logger).Log("msg", "failed to negatively acknowledge message", "err", err)
+		}
+		c.messages.WithLabelValues("retried").Inc()
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to acknowledge message", "err", err)
+	}
+	c.messages.WithLabelValues("dead_lettered").Inc()
+}
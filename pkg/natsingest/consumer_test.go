@@ -0,0 +1,159 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package natsingest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// fakeStore stores requests by the value of their job label, a job of
+// "invalid" is rejected and a job of "flaky" fails on its first write.
+type fakeStore struct {
+	profilestorepb.UnimplementedProfileStoreServiceServer
+
+	mtx    sync.Mutex
+	writes map[string]int
+	stored map[string]int
+}
+
+func (s *fakeStore) WriteRaw(_ context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job := req.Series[0].Labels.Labels[0].Value
+	s.writes[job]++
+	switch {
+	case job == "invalid":
+		return nil, status.Error(codes.InvalidArgument, "invalid profile")
+	case job == "flaky" && s.writes[job] == 1:
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	s.stored[job]++
+	return &profilestorepb.WriteRawResponse{}, nil
+}
+
+func (s *fakeStore) storedJobs() map[string]int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stored := make(map[string]int, len(s.stored))
+	for job, n := range s.stored {
+		stored[job] = n
+	}
+	return stored
+}
+
+func runServer(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	require.True(t, ns.ReadyForConnections(10*time.Second))
+
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func writeRawRequest(t *testing.T, job string) []byte {
+	t.Helper()
+
+	b, err := (&profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+				{Name: "job", Value: job},
+			}},
+		}},
+	}).MarshalVT()
+	require.NoError(t, err)
+	return b
+}
+
+func TestConsumer(t *testing.T) {
+	t.Parallel()
+
+	nc := runServer(t)
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "profiles",
+		Subjects: []string{"profiles", "profiles.dead"},
+	})
+	require.NoError(t, err)
+
+	for _, data := range [][]byte{
+		writeRawRequest(t, "ok"),
+		writeRawRequest(t, "invalid"),
+		writeRawRequest(t, "flaky"),
+		[]byte("not a write request"),
+	} {
+		_, err := js.Publish("profiles", data)
+		require.NoError(t, err)
+	}
+
+	store := &fakeStore{writes: map[string]int{}, stored: map[string]int{}}
+	c, err := NewConsumer(log.NewNopLogger(), prometheus.NewRegistry(), nc, store, Config{
+		Subject:           "profiles",
+		Durable:           "parca",
+		DeadLetterSubject: "profiles.dead",
+		MaxDeliveries:     5,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return len(store.storedJobs()) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]int{"ok": 1, "flaky": 1}, store.storedJobs())
+
+	dead, err := js.SubscribeSync("profiles.dead", nats.DeliverAll())
+	require.NoError(t, err)
+	var reasons []string
+	for i := 0; i < 2; i++ {
+		msg, err := dead.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		reasons = append(reasons, msg.Header.Get(DeadLetterErrorHeader))
+	}
+	require.Contains(t, reasons[0], "invalid profile")
+	require.Contains(t, reasons[1], "failed to unmarshal write request")
+
+	cancel()
+	require.NoError(t, <-done)
+}
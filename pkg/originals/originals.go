@@ -0,0 +1,112 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package originals keeps the profiles written to Parca in an object store
+// as they were written, so they can be downloaded as such instead of being
+// reconstructed from the stored samples.
+package originals
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/objstore"
+
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// Store stores gzip-compressed pprof profiles by their series and
+// timestamp.
+type Store struct {
+	bucket objstore.Bucket
+
+	operations *prometheus.CounterVec
+}
+
+func NewStore(reg prometheus.Registerer, bucket objstore.Bucket) *Store {
+	s := &Store{
+		bucket: bucket,
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_original_profiles_operations_total",
+			Help: "Total number of uploads and downloads of original profiles by result, which is success, not_found or error.",
+		}, []string{"operation", "result"}),
+	}
+	reg.MustRegister(s.operations)
+	return s
+}
+
+// key returns the name of the object of the profile of a series, including
+// its __name__ label, taken at the timestamp in milliseconds. The objects of
+// tenants are prefixed with the tenant of the context.
+func key(ctx context.Context, ls labels.Labels, timestamp int64) string {
+	k := fmt.Sprintf("%016x/%d.pb.gz", labels.New(ls...).Hash(), timestamp)
+	if id, ok := tenant.FromContext(ctx); ok {
+		return path.Join("tenants", id, k)
+	}
+	return k
+}
+
+// Upload stores a profile, which is gzip-compressed unless it already is.
+func (s *Store) Upload(ctx context.Context, ls labels.Labels, timestamp int64, profile []byte) error {
+	if !isGzip(profile) {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(profile); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		profile = buf.Bytes()
+	}
+
+	if err := s.bucket.Upload(ctx, key(ctx, ls, timestamp), bytes.NewReader(profile)); err != nil {
+		s.operations.WithLabelValues("upload", "error").Inc()
+		return fmt.Errorf("upload original profile: %w", err)
+	}
+	s.operations.WithLabelValues("upload", "success").Inc()
+	return nil
+}
+
+// Download returns the gzip-compressed profile of a series taken at the
+// timestamp. The second return value is false if there is none.
+func (s *Store) Download(ctx context.Context, ls labels.Labels, timestamp int64) ([]byte, bool, error) {
+	r, err := s.bucket.Get(ctx, key(ctx, ls, timestamp))
+	if s.bucket.IsObjNotFoundErr(err) {
+		s.operations.WithLabelValues("download", "not_found").Inc()
+		return nil, false, nil
+	}
+	if err != nil {
+		s.operations.WithLabelValues("download", "error").Inc()
+		return nil, false, fmt.Errorf("download original profile: %w", err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		s.operations.WithLabelValues("download", "error").Inc()
+		return nil, false, fmt.Errorf("read original profile: %w", err)
+	}
+	s.operations.WithLabelValues("download", "success").Inc()
+	return b, true, nil
+}
+
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"fmt"
+
+	"github.com/parca-dev/parca/pkg/config"
+)
+
+// CheckConfigFlags are the flags of the check-config command.
+type CheckConfigFlags struct {
+	ConfigPath       string `default:"parca.yaml" help:"Path to config file."`
+	CheckDirectories bool   `default:"false" help:"Also check that the directory of a filesystem bucket is writable, like the server does on startup."`
+}
+
+// CheckConfig loads and validates the config file at the path like the
+// server does on startup, including its scrape and relabeling rules, without
+// starting it. Unless checkDirectories is set, the filesystem isn't touched.
+func CheckConfig(path string, checkDirectories bool) (*config.Config, error) {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	validate := cfg.ValidateStatic
+	if checkDirectories {
+		validate = cfg.Validate
+	}
+	if err := validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
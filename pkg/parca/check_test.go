@@ -0,0 +1,75 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := CheckConfig("testdata/parca.yaml", false)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"noObjectStorage": `
+scrape_configs:
+  - job_name: parca
+    static_configs:
+      - targets: [localhost:7070]
+`,
+		"invalidRelabelRule": `
+object_storage:
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: ./data
+write_relabel_configs:
+  - action: unknown
+`,
+		"invalidSeriesNameTemplate": `
+object_storage:
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: ./data
+series_name_template: "{{ .Unknown"
+`,
+	} {
+		path := filepath.Join(dir, name+".yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		_, err := CheckConfig(path, false)
+		require.Error(t, err, name)
+	}
+
+	// The directory of a filesystem bucket is only checked if asked to.
+	path := filepath.Join(dir, "unwritableDirectory.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+object_storage:
+  bucket:
+    type: FILESYSTEM
+    config:
+      directory: `+filepath.Join(dir, "noObjectStorage.yaml", "data")+`
+`), 0o644))
+	_, err = CheckConfig(path, false)
+	require.NoError(t, err)
+	_, err = CheckConfig(path, true)
+	require.ErrorContains(t, err, "not a directory")
+}
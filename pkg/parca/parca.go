@@ -18,8 +18,10 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	goruntime "runtime"
 	"strings"
 	"syscall"
@@ -27,18 +29,22 @@ import (
 
 	"github.com/apache/arrow/go/v8/arrow/memory"
 	"github.com/dgraph-io/badger/v3"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/nats-io/nats.go"
 	"github.com/oklog/run"
 	"github.com/polarsignals/frostdb"
 	"github.com/polarsignals/frostdb/query"
+	"github.com/polarsignals/frostdb/query/logicalplan"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/thanos-io/objstore"
 	"github.com/thanos-io/objstore/client"
+	"github.com/thanos-io/objstore/providers/filesystem"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
@@ -60,11 +66,17 @@ import (
 	"github.com/parca-dev/parca/pkg/config"
 	"github.com/parca-dev/parca/pkg/debuginfo"
 	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/natsingest"
+	"github.com/parca-dev/parca/pkg/originals"
 	"github.com/parca-dev/parca/pkg/parcacol"
 	"github.com/parca-dev/parca/pkg/profilestore"
 	queryservice "github.com/parca-dev/parca/pkg/query"
+	"github.com/parca-dev/parca/pkg/remotewrite"
+	"github.com/parca-dev/parca/pkg/retention"
 	"github.com/parca-dev/parca/pkg/scrape"
+	"github.com/parca-dev/parca/pkg/selfprofile"
 	"github.com/parca-dev/parca/pkg/server"
+	"github.com/parca-dev/parca/pkg/snapshot"
 	"github.com/parca-dev/parca/pkg/symbol"
 	"github.com/parca-dev/parca/pkg/symbolizer"
 )
@@ -73,39 +85,150 @@ const (
 	symbolizationInterval = 10 * time.Second
 	flagModeScraperOnly   = "scraper-only"
 	metaStoreBadger       = "badger"
+
+	// defaultGracefulShutdownTimeout is how long the server waits for
+	// in-flight requests on shutdown if no timeout is set.
+	defaultGracefulShutdownTimeout = 30 * time.Second
 )
 
 type Flags struct {
-	ConfigPath         string   `default:"parca.yaml" help:"Path to config file."`
-	Mode               string   `default:"all" enum:"all,scraper-only" help:"Scraper only runs a scraper that sends to a remote gRPC endpoint. All runs all components."`
-	LogLevel           string   `default:"info" enum:"error,warn,info,debug" help:"log level."`
-	Port               string   `default:":7070" help:"Port string for server"`
-	CORSAllowedOrigins []string `help:"Allowed CORS origins."`
-	OTLPAddress        string   `help:"OpenTelemetry collector address to send traces to."`
-	Version            bool     `help:"Show application version."`
-	PathPrefix         string   `default:"" help:"Path prefix for the UI"`
+	ConfigPath              string        `default:"parca.yaml" help:"Path to config file."`
+	Mode                    string        `default:"all" enum:"all,scraper-only" help:"Scraper only runs a scraper that sends to a remote gRPC endpoint. All runs all components."`
+	ScrapeMaxConcurrency    int           `default:"0" help:"Maximum number of scrapes of all scrape jobs running at the same time. The max_concurrent_scrapes of scrape configs bound the scrapes of their job further. Unbounded if 0."`
+	LogLevel                string        `default:"info" enum:"error,warn,info,debug" help:"log level."`
+	LogFormat               string        `default:"logfmt" enum:"logfmt,json" help:"Format of the log lines."`
+	Port                    string        `default:":7070" help:"Port string for server"`
+	CORSAllowedOrigins      []string      `help:"Allowed CORS origins."`
+	OTLPAddress             string        `help:"OpenTelemetry collector address to send traces to."`
+	OTLPSamplingRatio       float64       `default:"1" help:"Ratio of traces to send to the OpenTelemetry collector, between 0 and 1. Traces continuing sampled traces of clients are always sent."`
+	ConnectionMetrics       bool          `default:"false" help:"Expose metrics about the open connections of the server and the bytes of gRPC messages sent and received."`
+	ReusePort               bool          `default:"false" help:"Set SO_REUSEPORT on the server's listener, so a new process can bind the port before the old one exits on restarts."`
+	GracefulShutdownTimeout time.Duration `default:"30s" help:"Time to wait for in-flight requests to finish on shutdown, before they are cut off."`
+	ShutdownDelay           time.Duration `default:"0s" help:"Time to keep serving on shutdown while /readyz already fails, so load balancers stop routing requests first. It counts towards the graceful shutdown timeout."`
+	TLSCertFile             string        `default:"" help:"Path to the certificate to serve TLS with, requires --tls-key-file. The certificate is reloaded when the file changes. Plaintext is served if unset."`
+	TLSKeyFile              string        `default:"" help:"Path to the key of the certificate to serve TLS with, requires --tls-cert-file."`
+	TLSClientCAFile         string        `default:"" help:"Path to the CA certificates to verify client certificates with, requires --tls-cert-file. Every client, including agents and browsers, must present a certificate signed by them when set. The serving certificate is presented by the HTTP API to the gRPC server, so it must be signed by them and valid for client authentication too."`
+	TLSMinVersion           string        `default:"1.2" enum:"1.2,1.3" help:"Minimum TLS version to serve."`
+	TenantHeader            string        `default:"" help:"Header, or gRPC metadata, to take the tenant of requests from, like X-Scope-OrgID. The profiles written by a tenant are stored in tables of their own, which its queries are scoped to. Requests without it are made by the default tenant, so it must be set by an authenticating proxy. Single-tenant if empty."`
+	TenantAllowed           []string      `help:"Tenants allowed to write profiles, writes of other tenants are rejected. Any tenant may write if empty."`
+	TenantMaxTenants        int           `default:"0" help:"Maximum number of tenants that may write profiles, writes of further tenants are rejected. Unlimited if 0."`
+	AuthBearerToken         string        `default:"" help:"Bearer token gRPC requests and the requests of the HTTP API must carry in their authorization header. Health checks, metrics and the UI stay open. Disabled if empty. The auth section of the config file takes precedence."`
+	AuthBearerTokenFile     string        `default:"" help:"File to read the bearer token requests must carry from, see --auth-bearer-token."`
+	Version                 bool          `help:"Show application version."`
+	WebPathPrefix           string        `name:"web.path-prefix" default:"" help:"Path prefix the UI and the HTTP API are served under, like /parca, for running behind a reverse proxy that forwards requests without stripping it. Health checks, metrics and gRPC are served without it too."`
+	PathPrefix              string        `default:"" hidden:"" help:"Deprecated: use --web.path-prefix."`
 
 	MutexProfileFraction int `default:"0" help:"Fraction of mutex profile samples to collect."`
 	BlockProfileRate     int `default:"0" help:"Sample rate for block profile."`
 
+	SelfProfiling         bool          `default:"false" help:"Profile the CPU, heap and goroutines of the server itself and store the profiles with the job=\"parca\" label. The CPU profile of the server can't be taken through its pprof endpoint meanwhile, so scrapes of it fail."`
+	SelfProfilingInterval time.Duration `default:"10s" help:"Interval at which the server stores profiles of itself with --self-profiling. The CPU profiles span the whole interval."`
+
 	EnablePersistence bool `default:"false" help:"Turn on persistent storage for the metastore and profile storage."`
 
 	StorageDebugValueLog bool   `default:"false" help:"Log every value written to the database into a separate file. This is only for debugging purposes to produce data to replay situations in tests."`
 	StorageGranuleSize   int    `default:"8196" help:"Granule size for storage."`
 	StorageActiveMemory  int64  `default:"536870912" help:"Amount of memory to use for active storage. Defaults to 512MB."`
 	StoragePath          string `default:"data" help:"Path to storage directory."`
-	StorageEnableWAL     bool   `default:"false" help:"Enables write ahead log for profile storage."`
+	StorageEnableWAL     bool   `default:"false" help:"Enables write ahead log for profile storage. It is replayed on startup, so with --enable-persistence no written profiles are lost on restarts."`
+
+	StorageQuarantineCorruptWAL bool `default:"false" help:"Move the databases directory aside and start with empty storage if the write ahead log is detected to be corrupt on startup. Otherwise Parca fails to start. Other errors replaying the write ahead log always fail startup."`
+
+	StorageRetention         time.Duration `default:"0" help:"Age after which blocks of profile data persisted to object storage with --enable-persistence are deleted, like 6h. Kept forever if 0. The retention_time of the storage section of the config file takes precedence."`
+	StorageRetentionInterval time.Duration `default:"5m" help:"Interval at which blocks older than --storage-retention are deleted."`
+
+	SnapshotDir     string `default:"" help:"Directory to write the snapshots of the profile storage and the metastore taken with POST /admin/snapshot to. They are written to the object storage bucket under snapshots/ if empty."`
+	RestoreSnapshot string `default:"" help:"Name of a snapshot to restore from the snapshot directory or bucket on startup, before profiles are written. Restored snapshots are recorded in the metastore and skipped on later startups."`
+
+	StorageRollupGranularity []time.Duration `help:"Granularities of the sums of the values of written profiles stored alongside their samples, like 1m,5m,1h. Range queries whose start, end and step are multiples of a granularity read the sums of the coarsest such granularity instead of every sample. Disabled if empty."`
+
+	ExposeIngestSource bool   `default:"false" help:"Store whether a profile was pushed, scraped or taken of the server itself as the ingest_source label."`
+	WritePolicyFile    string `default:"" help:"Path to a file mapping client identities to the series they are allowed to write. All identities may write any series if unset."`
+
+	PeerLabel      string   `default:"" help:"Name of a label to set to the IP of clients pushing profiles, unless they set it themselves. Disabled if empty."`
+	TrustedProxies []string `help:"IPs or CIDRs of proxies whose X-Forwarded-For header is trusted to carry the IP of the client pushing profiles."`
+
+	WriteRateLimitRequests     float64 `default:"0" help:"Write requests per second each client may make with WriteRaw, WriteRawStream, WriteTrace and profile uploads. Clients are told by their identity, otherwise by their IP, see --trusted-proxies. Writes exceeding the limit are rejected with ResourceExhausted and the time to retry after. Unlimited if 0."`
+	WriteRateLimitRequestBurst int     `default:"0" help:"Write requests each client may make at once, --write-rate-limit-requests rounded up if 0."`
+	WriteRateLimitBytes        float64 `default:"0" help:"Bytes of write requests per second each client may write, rejected like the requests exceeding --write-rate-limit-requests. Unlimited if 0."`
+	WriteRateLimitByteBurst    int     `default:"0" help:"Bytes each client may write at once, larger write requests are always rejected. --write-rate-limit-bytes rounded up if 0."`
+
+	StoreOriginalProfiles bool `default:"false" help:"Keep written profiles as written in the object storage, so downloads of single profiles return them rather than profiles reconstructed from their samples. Profiles whose timestamp is rounded are still reconstructed."`
+
+	LowercaseLabels string `default:"none" enum:"none,names,all" help:"Lowercase the names (names) or the names and values (all) of the labels of written series, so series only differing in case are stored as one."`
+
+	AppendAttempts     int           `default:"1" help:"How often appending a written profile to the storage is attempted if it fails with a transient error."`
+	AppendRetryBackoff time.Duration `default:"100ms" help:"Time to wait before retrying a failed append of a written profile."`
+
+	MaxDecompressionRatio int64 `default:"0" help:"Maximum ratio of the decompressed to the compressed size of written profiles, rejecting decompression bombs. Disabled if 0."`
+	MaxDecompressedSize   int64 `default:"0" help:"Maximum size in bytes written profiles may decompress to. Disabled if 0."`
+	MaxProfileSize        int64 `default:"0" help:"Maximum size in bytes of written profiles before they are decompressed, including profiles written in chunks with WriteRawStream. Writes of larger profiles are rejected. Disabled if 0."`
+
+	MaxSampleAge        time.Duration `default:"0" help:"Maximum age of the timestamps of written profiles. Writes of older profiles are rejected. Disabled if 0."`
+	MaxSampleFuture     time.Duration `default:"0" help:"Maximum time the timestamps of written profiles may lie in the future. Writes of such profiles are rejected. Disabled if 0."`
+	MaxProfileSamples   int           `default:"0" help:"Maximum number of samples of written profiles. Writes of profiles of more samples are rejected. Disabled if 0."`
+	MaxSeriesLabels     int           `default:"0" help:"Maximum number of labels, including the name, of written series. Writes of series of more labels are rejected. Disabled if 0."`
+	MaxLabelNameLength  int           `default:"0" help:"Maximum length in bytes of the label names of written series. Writes of series with longer names are rejected. Disabled if 0."`
+	MaxLabelValueLength int           `default:"0" help:"Maximum length in bytes of the label values of written series. Writes of series with longer values are rejected. Disabled if 0."`
+
+	WriteMode        string `default:"sync" enum:"sync,async" help:"Whether writes respond once their profiles are stored (sync), or right away once queued (async), with empty counts of accepted and skipped samples. Failures of queued writes are only logged and counted by the metrics."`
+	WriteQueueSize   int    `default:"1024" help:"Number of writes queued in the async write mode. Writes are rejected while the queue is full."`
+	WriteConcurrency int    `default:"1" help:"Number of series of writes stored concurrently, across all writes. Series of a write are stored one after the other, in the order written, if 1."`
+
+	StacktraceCacheSize int `default:"65536" help:"Number of stacktraces known to be stored in the metastore kept in memory, so stacktraces written again aren't looked up in the metastore. Set to 0 to disable the cache."`
+
+	LocationlessProfiles string `default:"accept" enum:"accept,warn,reject" help:"What to do with written profiles whose samples reference no locations and can't be shown in flame graphs: store them (accept), store them and log a warning (warn) or reject the write (reject)."`
+
+	SeriesMinSampleInterval time.Duration `default:"0" help:"Minimum interval between the samples of a series. Samples arriving faster are dropped or merged, see --series-sample-limit-mode. Disabled if 0."`
+	SeriesSampleLimitMode   string        `default:"drop" enum:"drop,merge" help:"What to do with samples arriving within the minimum sample interval of their series. Merge adds them to the next written sample and is meant for delta profiles."`
+
+	QuarantineMaxSamples  int      `default:"0" help:"Quarantine written profiles of more samples than this. Quarantined profiles are stored separately, labeled with the quarantine_reason, and only returned by queries asking for them. Disabled if 0."`
+	QuarantineMaxLabels   int      `default:"0" help:"Quarantine written profiles whose series has more labels than this. Disabled if 0."`
+	QuarantineSampleTypes []string `help:"Sample types written profiles are expected to have, like cpu or alloc_space. Profiles with any other sample type are quarantined. Disabled if empty."`
+
+	LenientProfileParsing bool          `default:"false" help:"Store the samples that can be recovered from truncated or malformed profiles, labeled partial=true, instead of rejecting them."`
+	SampleWeightLabel     string        `default:"" help:"Name of a numeric pprof label whose value the values of a sample are multiplied by, for profiles of pre-weighted samples. Samples without it have a weight of 1. Disabled if empty."`
+	TimestampGranularity  time.Duration `default:"0" help:"Granularity to round the timestamps of ingested profiles to, like 1s or 10s, aligning them across series. Timestamps in the past are never rounded into the future. Disabled if 0."`
+
+	IngestNATSURL               string `default:"" help:"URL of a NATS server to consume profiles from, as WriteRawRequests encoded as protobuf. Disabled if empty."`
+	IngestNATSSubject           string `default:"parca.profiles" help:"JetStream subject to consume profiles from."`
+	IngestNATSConsumer          string `default:"parca" help:"Name of the durable JetStream consumer keeping track of the consumed profiles."`
+	IngestNATSDeadLetterSubject string `default:"" help:"JetStream subject to publish messages that can't be ingested to. They are dropped if empty."`
+	IngestNATSMaxDeliveries     int    `default:"5" help:"Number of deliveries after which a message that failed to be ingested is dead-lettered. Unlimited if 0."`
+
+	LoadRatioWeights     map[string]float64 `default:"queue=1;latency=1;memory=1" help:"Weights of the components (queue, latency, memory) of the parca_load_ratio metric."`
+	LoadRatioMaxInflight int                `default:"64" help:"Number of in-flight writes at which the queue component of the load ratio is saturated."`
+	LoadRatioMaxLatency  time.Duration      `default:"5s" help:"Append latency at which the latency component of the load ratio is saturated."`
+	LoadRatioMemoryLimit int64              `default:"0" help:"Heap usage in bytes at which the memory component of the load ratio is saturated. Defaults to the storage active memory."`
 
 	SymbolizerDemangleMode  string `default:"simple" help:"Mode to demangle C++ symbols. Default mode is simplified: no parameters, no templates, no return type" enum:"simple,full,none,templates"`
 	SymbolizerNumberOfTries int    `default:"3" help:"Number of tries to attempt to symbolize an unsybolized location"`
 
-	Metastore string `default:"badger" help:"Which metastore implementation to use" enum:"badger"`
+	Metastore                     string        `default:"badger" help:"Which metastore implementation to use" enum:"badger"`
+	MetastoreInternSize           int           `default:"65536" help:"Number of distinct function and file names the metastore keeps interned in memory. Set to 0 to disable interning."`
+	MetastoreStatsRefreshInterval time.Duration `default:"1m" help:"Minimum interval between two scans of the metastore computing the metrics about its size and composition."`
+
+	QueryMaxMergeSpan      time.Duration `default:"0" help:"Maximum time between the first and the last sample of a merge. Disabled if 0."`
+	QueryMergeSpanExceeded string        `default:"warn" enum:"warn,error" help:"Whether merges exceeding the maximum merge span return a warning or fail."`
+	QueryMaxSeries         int           `default:"0" help:"Maximum number of series a range query may return, queries matching more fail. Disabled if 0."`
+	QueryRequiredLabels    []string      `help:"Labels of which every query selector must select at least one, with a matcher that doesn't match the empty value. Other queries are rejected."`
+	QueryMaxRegexSize      int           `default:"0" help:"Maximum number of instructions the regexes of query selectors may compile to, queries with more complex regexes are rejected. Disabled if 0."`
+
+	QueryCPUBudget              time.Duration `default:"0" help:"Maximum CPU time a query of profiles may consume before it is aborted with ResourceExhausted. Each query is charged its own CPU time only, on Linux. Disabled if 0."`
+	QueryCPUBudgetCheckInterval time.Duration `default:"100ms" help:"Interval at which the CPU time of queries is checked against the budget."`
+
+	QueryReplicaLabel string `default:"" help:"Name of a label distinguishing redundant agents profiling the same process. Range queries deduplicate series only differing by it, taking the samples of one replica and filling its gaps from the others. Disabled if empty."`
+
+	QueryLive             bool   `default:"false" help:"Serve range queries whose results are pushed as server-sent events whenever series they select are written, at /api/query_range/live. Streams end after the HTTP write timeout of a minute, clients are expected to reconnect."`
+	QueryLiveBackpressure string `default:"drop" enum:"drop,buffer" help:"What to do when series of a live query are written faster than its client receives updates: coalesce them into a single update (drop) or queue them, ending the stream of clients falling behind by more than the buffer size (buffer)."`
+	QueryLiveBufferSize   int    `default:"16" help:"Number of updates queued per live query client with the buffer backpressure mode."`
 
 	ProfileShareServer string `default:"api.pprof.me:443" help:"gRPC address to send share profile requests to."`
 
-	DebugInfodUpstreamServers    []string      `default:"https://debuginfod.elfutils.org" help:"Upstream debuginfod servers. Defaults to https://debuginfod.elfutils.org. It is an ordered list of servers to try. Learn more at https://sourceware.org/elfutils/Debuginfod.html"`
+	DebugInfodUpstreamServers    []string      `default:"https://debuginfod.elfutils.org" help:"Upstream debuginfod servers. Defaults to https://debuginfod.elfutils.org. It is an ordered list of servers to try. Learn more at https://sourceware.org/elfutils/Debuginfod.html. The debuginfod_urls of the debug_info section of the config file take precedence."`
 	DebugInfodHTTPRequestTimeout time.Duration `default:"5m" help:"Timeout duration for HTTP request to upstream debuginfod server. Defaults to 5m"`
 	DebuginfoCacheDir            string        `default:"/tmp" help:"Path to directory where debuginfo is cached."`
+	DebuginfoUploadMaxSize       int64         `default:"1073741824" help:"Maximum size in bytes of uploaded debuginfo files. Uploads are validated in the cache directory before they are stored, so it needs room for concurrent uploads. Unlimited if 0."`
 
 	StoreAddress       string            `kong:"help='gRPC address to send profiles and symbols to.'"`
 	BearerToken        string            `kong:"help='Bearer token to authenticate with store.'"`
@@ -115,8 +238,220 @@ type Flags struct {
 	ExternalLabel      map[string]string `kong:"help='Label(s) to attach to all profiles in scraper-only mode.'"`
 }
 
+// gracefulShutdownTimeout returns the time to wait for in-flight requests on
+// shutdown, falling back to the default if unset.
+func (f *Flags) gracefulShutdownTimeout() time.Duration {
+	if f.GracefulShutdownTimeout == 0 {
+		return defaultGracefulShutdownTimeout
+	}
+	return f.GracefulShutdownTimeout
+}
+
+// authBearerToken returns the bearer token requests to the server must
+// carry, or an empty string if they don't need to.
+func (f *Flags) authBearerToken() (string, error) {
+	if f.AuthBearerTokenFile == "" {
+		return f.AuthBearerToken, nil
+	}
+	if f.AuthBearerToken != "" {
+		return "", errors.New("only one of --auth-bearer-token and --auth-bearer-token-file may be set")
+	}
+	b, err := os.ReadFile(f.AuthBearerTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bearer token from file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("bearer token file %s is empty", f.AuthBearerTokenFile)
+	}
+	return token, nil
+}
+
+// serverAuthOptions returns the options of the server to authenticate
+// requests with, the auth section of the config file taking precedence over
+// the flags.
+func (f *Flags) serverAuthOptions(cfg *config.Config) ([]server.Option, error) {
+	if cfg.Auth == nil {
+		token, err := f.authBearerToken()
+		if err != nil || token == "" {
+			return nil, err
+		}
+		return []server.Option{server.WithBearerToken(token)}, nil
+	}
+
+	var opts []server.Option
+	token := string(cfg.Auth.BearerToken)
+	if cfg.Auth.BearerTokenFile != "" {
+		b, err := os.ReadFile(cfg.Auth.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token from file: %w", err)
+		}
+		if token = strings.TrimSpace(string(b)); token == "" {
+			return nil, fmt.Errorf("bearer token file %s is empty", cfg.Auth.BearerTokenFile)
+		}
+	}
+	if token != "" {
+		opts = append(opts, server.WithBearerToken(token))
+	}
+	if len(cfg.Auth.BasicAuthUsers) > 0 {
+		users := make(map[string]string, len(cfg.Auth.BasicAuthUsers))
+		for user, hash := range cfg.Auth.BasicAuthUsers {
+			users[user] = string(hash)
+		}
+		opts = append(opts, server.WithBasicAuth(users))
+	}
+	if len(cfg.Auth.ExemptMethods) > 0 {
+		opts = append(opts, server.WithAuthExemptions(cfg.Auth.ExemptMethods...))
+	}
+	return opts, nil
+}
+
+// serverTLSOptions returns the options of the server to serve TLS with, none
+// if plaintext is served.
+func (f *Flags) serverTLSOptions(logger log.Logger) ([]server.Option, error) {
+	if f.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	certs, err := server.NewCertReloader(logger, f.TLSCertFile, f.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	opts := []server.Option{server.WithTLS(certs)}
+
+	if f.TLSMinVersion == "1.3" {
+		opts = append(opts, server.WithTLSMinVersion(tls.VersionTLS13))
+	}
+
+	if f.TLSClientCAFile != "" {
+		pool, err := server.LoadClientCAs(f.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, server.WithClientCAs(pool))
+	}
+	return opts, nil
+}
+
+// webPathPrefix returns the path prefix to serve the UI under, falling back
+// to the deprecated --path-prefix.
+func (f *Flags) webPathPrefix() string {
+	if f.WebPathPrefix != "" {
+		return f.WebPathPrefix
+	}
+	return f.PathPrefix
+}
+
+// storageRetention returns the retention time and size of the blocks of
+// profile data, the config file taking precedence over the flag.
+func (f *Flags) storageRetention(cfg *config.Config) (time.Duration, int64) {
+	if cfg.Storage == nil {
+		return f.StorageRetention, 0
+	}
+	retention := time.Duration(cfg.Storage.RetentionTime)
+	if retention == 0 {
+		retention = f.StorageRetention
+	}
+	return retention, int64(cfg.Storage.RetentionSize)
+}
+
+// debugInfodClient returns the client downloading debuginfo from the
+// debuginfod servers of the config, or of the flags if the config has no
+// debug_info section, caching downloads in the bucket.
+func (f *Flags) debugInfodClient(logger log.Logger, bucket objstore.Bucket, cfg *config.Config) (debuginfo.DebugInfodClient, error) {
+	servers := f.DebugInfodUpstreamServers
+	if cfg.DebugInfo != nil {
+		servers = cfg.DebugInfo.DebuginfodURLs
+	}
+	if len(servers) == 0 {
+		return debuginfo.NopDebugInfodClient{}, nil
+	}
+
+	httpDebugInfoClient, err := debuginfo.NewHTTPDebugInfodClient(logger, servers, f.DebugInfodHTTPRequestTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize debuginfod http client: %w", err)
+	}
+	client, err := debuginfo.NewDebugInfodClientWithObjectStorageCache(
+		logger,
+		objstore.NewPrefixedBucket(bucket, "debuginfod-cache"),
+		httpDebugInfoClient,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize debuginfod client cache: %w", err)
+	}
+	return client, nil
+}
+
+// rollupTableName returns the name of the table of the rollups of the
+// granularity given as the i-th. The rollups of the first granularity keep
+// the name of the table of the single granularity that could once be given.
+func rollupTableName(i int, granularity time.Duration) string {
+	if i == 0 {
+		return "rollups"
+	}
+	return "rollups_" + granularity.String()
+}
+
 // Run the parca server.
 func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags *Flags, version string) error {
+	if flags.GracefulShutdownTimeout < 0 {
+		err := fmt.Errorf("graceful shutdown timeout must not be negative, got %s", flags.GracefulShutdownTimeout)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+	if (flags.TLSCertFile == "") != (flags.TLSKeyFile == "") {
+		err := errors.New("both --tls-cert-file and --tls-key-file must be set to serve TLS")
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+	if flags.TLSClientCAFile != "" && flags.TLSCertFile == "" {
+		err := errors.New("--tls-client-ca-file requires --tls-cert-file to be set")
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+	if flags.WriteConcurrency < 1 {
+		err := fmt.Errorf("write concurrency must be at least 1, got %d", flags.WriteConcurrency)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+	if flags.WriteMode == "async" && flags.WriteQueueSize < 1 {
+		err := fmt.Errorf("write queue size must be at least 1, got %d", flags.WriteQueueSize)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+	seenGranularities := make(map[time.Duration]struct{}, len(flags.StorageRollupGranularity))
+	for _, g := range flags.StorageRollupGranularity {
+		if _, ok := seenGranularities[g]; ok || g <= 0 || g%time.Millisecond != 0 {
+			err := fmt.Errorf("rollup granularities must be distinct positive multiples of a millisecond, got %s", g)
+			level.Error(logger).Log("msg", "invalid flags", "err", err)
+			return err
+		}
+		seenGranularities[g] = struct{}{}
+	}
+	if flags.OTLPSamplingRatio < 0 || flags.OTLPSamplingRatio > 1 {
+		err := fmt.Errorf("OTLP sampling ratio must be between 0 and 1, got %v", flags.OTLPSamplingRatio)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+
+	if flags.WriteRateLimitRequests < 0 || flags.WriteRateLimitBytes < 0 || flags.WriteRateLimitRequestBurst < 0 || flags.WriteRateLimitByteBurst < 0 {
+		err := errors.New("write rate limits must not be negative")
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+
+	if flags.SelfProfiling && flags.SelfProfilingInterval <= 0 {
+		err := fmt.Errorf("self-profiling interval must be positive, got %s", flags.SelfProfilingInterval)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+
+	if flags.DebuginfoUploadMaxSize < 0 {
+		err := fmt.Errorf("debuginfo upload max size must not be negative, got %d", flags.DebuginfoUploadMaxSize)
+		level.Error(logger).Log("msg", "invalid flags", "err", err)
+		return err
+	}
+
 	goruntime.SetBlockProfileRate(flags.BlockProfileRate)
 	goruntime.SetMutexProfileFraction(flags.MutexProfileFraction)
 
@@ -124,7 +459,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 	if flags.OTLPAddress != "" {
 		var closer func()
 		var err error
-		tracerProvider, closer, err = initTracer(logger, flags.OTLPAddress)
+		tracerProvider, closer, err = initTracer(logger, flags.OTLPAddress, flags.OTLPSamplingRatio)
 		if err != nil {
 			level.Error(logger).Log("msg", "failed to initialize tracing", "err", err)
 			return err
@@ -140,7 +475,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 
 	if err := cfg.Validate(); err != nil {
 		level.Error(logger).Log("msg", "parsed config invalid", "err", err, "path", flags.ConfigPath)
-		return err
+		return fmt.Errorf("invalid config file %s: %w", flags.ConfigPath, err)
 	}
 
 	if flags.Mode == flagModeScraperOnly {
@@ -159,7 +494,10 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		return err
 	}
 
-	var mStr metastorepb.MetastoreServiceServer
+	var (
+		mStr        metastorepb.MetastoreServiceServer
+		metastoreDB *badger.DB
+	)
 	switch flags.Metastore {
 	case metaStoreBadger:
 		var badgerOptions badger.Options
@@ -171,7 +509,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		}
 
 		badgerOptions = badgerOptions.WithLogger(&metastore.BadgerLogger{Logger: logger})
-		db, err := badger.Open(badgerOptions)
+		metastoreDB, err = badger.Open(badgerOptions)
 		if err != nil {
 			level.Error(logger).Log("msg", "failed to open badger database for metastore", "err", err)
 			return err
@@ -181,7 +519,9 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 			logger,
 			reg,
 			tracerProvider.Tracer(metaStoreBadger),
-			db,
+			metastoreDB,
+			metastore.WithStringInternerSize(flags.MetastoreInternSize),
+			metastore.WithStatsRefreshInterval(flags.MetastoreStatsRefreshInterval),
 		)
 	default:
 		err := fmt.Errorf("unknown metastore implementation: %s", flags.Metastore)
@@ -204,21 +544,12 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		frostdbOptions = append(frostdbOptions, frostdb.WithWAL(), frostdb.WithStoragePath(flags.StoragePath))
 	}
 
-	col, err := frostdb.New(
-		logger,
-		reg,
-		frostdbOptions...,
-	)
+	col, err := openColumnStore(ctx, logger, reg, flags.StorageQuarantineCorruptWAL, frostdbOptions...)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to initialize storage", "err", err)
 		return err
 	}
 
-	if err := col.ReplayWALs(context.Background()); err != nil {
-		level.Error(logger).Log("msg", "failed to replay WAL", "err", err)
-		return err
-	}
-
 	colDB, err := col.DB(ctx, "parca")
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to load database", "err", err)
@@ -237,18 +568,319 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		return err
 	}
 
+	rollups := make([]*frostdb.Table, len(flags.StorageRollupGranularity))
+	for i, g := range flags.StorageRollupGranularity {
+		rollups[i], err = colDB.Table(rollupTableName(i, g), frostdb.NewTableConfig(schema))
+		if err != nil {
+			level.Error(logger).Log("msg", "create rollup table", "err", err)
+			return err
+		}
+	}
+
+	var quarantine *frostdb.Table
+	if flags.QuarantineMaxSamples > 0 || flags.QuarantineMaxLabels > 0 || len(flags.QuarantineSampleTypes) > 0 {
+		quarantine, err = colDB.Table("quarantine", frostdb.NewTableConfig(schema))
+		if err != nil {
+			level.Error(logger).Log("msg", "create quarantine table", "err", err)
+			return err
+		}
+	}
+
+	defaultTables := map[string]*frostdb.Table{"stacktraces": table}
+	for i, g := range flags.StorageRollupGranularity {
+		defaultTables[rollupTableName(i, g)] = rollups[i]
+	}
+	if quarantine != nil {
+		defaultTables["quarantine"] = quarantine
+	}
+
+	var tenants *parcacol.TenantTables
+	if flags.TenantHeader != "" {
+		tenantOpts := []parcacol.TenantTablesOption{parcacol.WithMaxTenants(flags.TenantMaxTenants)}
+		if len(flags.TenantAllowed) > 0 {
+			tenantOpts = append(tenantOpts, parcacol.WithAllowedTenants(flags.TenantAllowed...))
+		}
+		if flags.StorageEnableWAL {
+			tenantOpts = append(tenantOpts, parcacol.WithTenantRegistry(filepath.Join(flags.StoragePath, "tenants")))
+		}
+		tenants, err = parcacol.NewTenantTables(colDB, frostdb.NewTableConfig(schema), defaultTables, tenantOpts...)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load tenants", "err", err)
+			return err
+		}
+	}
+
+	var snapshotBucket objstore.Bucket = objstore.NewPrefixedBucket(bucket, "snapshots")
+	if flags.SnapshotDir != "" {
+		snapshotBucket, err = filesystem.NewBucket(flags.SnapshotDir)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to open snapshot directory", "err", err)
+			return err
+		}
+	}
+	var persistedBlocks objstore.Bucket
+	if flags.EnablePersistence {
+		persistedBlocks = objstore.NewPrefixedBucket(bucket, "blocks/parca")
+	}
+	// The tables of tenants include the tables replayed from the WAL and
+	// restored from snapshots.
+	snapshotTables := func() map[string]*frostdb.Table {
+		tables := make(map[string]*frostdb.Table, len(defaultTables))
+		for name, t := range defaultTables {
+			tables[name] = t
+		}
+		return tables
+	}
+	var registerTable func(string) error
+	if tenants != nil {
+		snapshotTables, registerTable = tenants.Tables, tenants.RegisterTable
+	}
+	snapshotter := snapshot.New(
+		logger,
+		snapshotBucket,
+		metastoreDB,
+		colDB,
+		frostdb.NewTableConfig(schema),
+		snapshotTables,
+		registerTable,
+		persistedBlocks,
+	)
+	if flags.RestoreSnapshot != "" {
+		if err := snapshotter.Restore(ctx, flags.RestoreSnapshot); err != nil {
+			level.Error(logger).Log("msg", "failed to restore snapshot", "snapshot", flags.RestoreSnapshot, "err", err)
+			return err
+		}
+	}
+
+	loadRatioMemoryLimit := flags.LoadRatioMemoryLimit
+	if loadRatioMemoryLimit == 0 {
+		loadRatioMemoryLimit = flags.StorageActiveMemory
+	}
+
+	loadRatioWeights, err := profilestore.LoadWeightsFromMap(flags.LoadRatioWeights)
+	if err != nil {
+		level.Error(logger).Log("msg", "invalid load ratio weights", "err", err)
+		return err
+	}
+	storeOpts := []profilestore.Option{
+		profilestore.WithLoadTracker(profilestore.NewLoadTracker(
+			reg,
+			profilestore.LoadLimits{
+				QueueDepth:    float64(flags.LoadRatioMaxInflight),
+				AppendLatency: flags.LoadRatioMaxLatency,
+				MemoryBytes:   uint64(loadRatioMemoryLimit),
+			},
+			loadRatioWeights,
+		)),
+	}
+	if flags.ExposeIngestSource {
+		storeOpts = append(storeOpts, profilestore.WithExposedIngestSource())
+	}
+	if flags.WritePolicyFile != "" {
+		policy, err := profilestore.LoadWritePolicyFile(flags.WritePolicyFile)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load write policy", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithWritePolicy(policy))
+	}
+	if flags.LenientProfileParsing {
+		storeOpts = append(storeOpts, profilestore.WithLenientParsing())
+	}
+	if flags.PeerLabel != "" {
+		proxies, err := profilestore.ParseTrustedProxies(flags.TrustedProxies)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to parse trusted proxies", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithPeerLabel(flags.PeerLabel, proxies))
+	}
+	if flags.WriteRateLimitRequests > 0 || flags.WriteRateLimitBytes > 0 {
+		proxies, err := profilestore.ParseTrustedProxies(flags.TrustedProxies)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to parse trusted proxies", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithRateLimiter(profilestore.NewRateLimiter(reg, profilestore.RateLimits{
+			RequestsPerSecond: flags.WriteRateLimitRequests,
+			RequestBurst:      flags.WriteRateLimitRequestBurst,
+			BytesPerSecond:    flags.WriteRateLimitBytes,
+			ByteBurst:         flags.WriteRateLimitByteBurst,
+		}, proxies)))
+	}
+	var originalProfiles *originals.Store
+	if flags.StoreOriginalProfiles {
+		originalProfiles = originals.NewStore(reg, objstore.NewPrefixedBucket(bucket, "originals"))
+		storeOpts = append(storeOpts, profilestore.WithOriginals(originalProfiles))
+	}
+	if flags.LowercaseLabels != "none" {
+		storeOpts = append(storeOpts, profilestore.WithLowercaseLabels(flags.LowercaseLabels == "all"))
+	}
+	for i, g := range flags.StorageRollupGranularity {
+		storeOpts = append(storeOpts, profilestore.WithRollups(rollups[i], g))
+	}
+	if flags.AppendAttempts > 1 {
+		storeOpts = append(storeOpts, profilestore.WithAppendRetries(flags.AppendAttempts, flags.AppendRetryBackoff))
+	}
+	if flags.MaxProfileSize > 0 {
+		storeOpts = append(storeOpts, profilestore.WithMaxProfileSize(flags.MaxProfileSize))
+	}
+	storeOpts = append(storeOpts, profilestore.WithLimits(profilestore.Limits{
+		MaxSampleAge:        flags.MaxSampleAge,
+		MaxSampleFuture:     flags.MaxSampleFuture,
+		MaxSamples:          flags.MaxProfileSamples,
+		MaxLabels:           flags.MaxSeriesLabels,
+		MaxLabelNameLength:  flags.MaxLabelNameLength,
+		MaxLabelValueLength: flags.MaxLabelValueLength,
+	}))
+	if flags.MaxDecompressionRatio > 0 || flags.MaxDecompressedSize > 0 {
+		storeOpts = append(storeOpts, profilestore.WithDecompressionGuard(profilestore.NewDecompressionGuard(reg, flags.MaxDecompressionRatio, flags.MaxDecompressedSize)))
+	}
+	var notifier *queryservice.WriteNotifier
+	if flags.QueryLive {
+		notifier, err = queryservice.NewWriteNotifier(flags.QueryLiveBackpressure, flags.QueryLiveBufferSize)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to create live query notifier", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithWriteHook(notifier.Written))
+	}
+	if tenants != nil {
+		storeOpts = append(storeOpts, profilestore.WithTenantTables(tenants))
+	}
+	if flags.LocationlessProfiles != "accept" {
+		storeOpts = append(storeOpts, profilestore.WithLocationlessProfiles(flags.LocationlessProfiles))
+	}
+	if flags.SeriesMinSampleInterval > 0 {
+		limiter, err := profilestore.NewSampleLimiter(reg, flags.SeriesMinSampleInterval, flags.SeriesSampleLimitMode)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to create sample limiter", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithSampleLimiter(limiter))
+	}
+	if quarantine != nil {
+		storeOpts = append(storeOpts, profilestore.WithQuarantine(profilestore.NewQuarantine(reg, quarantine, flags.QuarantineMaxSamples, flags.QuarantineMaxLabels, flags.QuarantineSampleTypes)))
+	}
+	if len(cfg.MappingPathRewrites) > 0 {
+		rewrites := make([]parcacol.MappingFileRewrite, 0, len(cfg.MappingPathRewrites))
+		for _, r := range cfg.MappingPathRewrites {
+			rewrites = append(rewrites, parcacol.MappingFileRewrite{
+				Regex:       regexp.MustCompile(r.Regex.String()),
+				Replacement: r.Replacement,
+			})
+		}
+		storeOpts = append(storeOpts, profilestore.WithNormalizerOptions(parcacol.WithMappingFileRewrites(rewrites...)))
+	}
+	if cfg.SeriesNameTemplate != "" {
+		nameTemplate, err := parcacol.ParseSeriesNameTemplate(string(cfg.SeriesNameTemplate))
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to parse series name template", "err", err)
+			return err
+		}
+		storeOpts = append(storeOpts, profilestore.WithIngesterOptions(parcacol.WithSeriesNameTemplate(nameTemplate)))
+	}
+	if flags.SampleWeightLabel != "" {
+		storeOpts = append(storeOpts, profilestore.WithNormalizerOptions(parcacol.WithSampleWeightLabel(flags.SampleWeightLabel)))
+	}
+	if flags.TimestampGranularity > 0 {
+		storeOpts = append(storeOpts, profilestore.WithNormalizerOptions(parcacol.WithTimestampGranularity(flags.TimestampGranularity)))
+	}
+	if flags.StacktraceCacheSize > 0 {
+		storeOpts = append(storeOpts, profilestore.WithNormalizerOptions(parcacol.WithStacktraceCache(parcacol.NewStacktraceCache(reg, flags.StacktraceCacheSize))))
+	}
+	storeOpts = append(storeOpts, profilestore.WithWriteConcurrency(flags.WriteConcurrency))
+	relabeler := profilestore.NewRelabeler(reg, cfg.WriteRelabelConfigs)
+	storeOpts = append(storeOpts, profilestore.WithRelabeler(relabeler))
+
+	var remoteWriteOpts []remotewrite.Option
+	if flags.TenantHeader != "" {
+		remoteWriteOpts = append(remoteWriteOpts, remotewrite.WithTenantHeader(flags.TenantHeader))
+	}
+	remoteWrite := remotewrite.NewManager(logger, reg, remoteWriteOpts...)
+	if err := remoteWrite.ApplyConfig(cfg.RemoteWriteConfigs); err != nil {
+		level.Error(logger).Log("msg", "failed to apply remote write configs", "err", err)
+		return err
+	}
+	storeOpts = append(storeOpts, profilestore.WithForwarder(remoteWrite))
+	if flags.WriteMode == "async" {
+		storeOpts = append(storeOpts, profilestore.WithAsyncWrites(flags.WriteQueueSize, flags.WriteConcurrency))
+	}
+
 	s := profilestore.NewProfileColumnStore(
 		logger,
+		reg,
 		tracerProvider.Tracer("profilestore"),
 		metastore,
 		table,
 		schema,
 		flags.StorageDebugValueLog,
+		storeOpts...,
 	)
+
+	var natsConsumer *natsingest.Consumer
+	if flags.IngestNATSURL != "" {
+		nc, err := nats.Connect(flags.IngestNATSURL, nats.Name("parca"), nats.MaxReconnects(-1))
+		if err != nil {
+			return fmt.Errorf("failed to connect to NATS server %s: %w", flags.IngestNATSURL, err)
+		}
+		defer nc.Close()
+
+		natsConsumer, err = natsingest.NewConsumer(logger, reg, nc, s, natsingest.Config{
+			Subject:           flags.IngestNATSSubject,
+			Durable:           flags.IngestNATSConsumer,
+			DeadLetterSubject: flags.IngestNATSDeadLetterSubject,
+			MaxDeliveries:     flags.IngestNATSMaxDeliveries,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var selfProfiler *selfprofile.Profiler
+	if flags.SelfProfiling {
+		selfProfiler = selfprofile.NewProfiler(logger, reg, s, flags.SelfProfilingInterval)
+	}
 	conn, err := grpc.Dial(flags.ProfileShareServer, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
 	if err != nil {
 		return fmt.Errorf("failed to create gRPC connection to ProfileShareServer: %s, %w", flags.ProfileShareServer, err)
 	}
+	queryOpts := []queryservice.Option{
+		queryservice.WithMaxMergeSpan(flags.QueryMaxMergeSpan, flags.QueryMergeSpanExceeded),
+		queryservice.WithMaxSeriesPerQuery(flags.QueryMaxSeries),
+		queryservice.WithRequiredLabels(flags.QueryRequiredLabels...),
+		queryservice.WithMaxRegexSize(flags.QueryMaxRegexSize),
+	}
+	if originalProfiles != nil {
+		queryOpts = append(queryOpts, queryservice.WithOriginals(originalProfiles))
+	}
+	if flags.QueryCPUBudget > 0 {
+		queryOpts = append(queryOpts, queryservice.WithCPUBudget(flags.QueryCPUBudget, flags.QueryCPUBudgetCheckInterval))
+	}
+	if flags.QueryReplicaLabel != "" {
+		queryOpts = append(queryOpts, queryservice.WithReplicaLabel(flags.QueryReplicaLabel))
+	}
+	if notifier != nil {
+		queryOpts = append(queryOpts, queryservice.WithWriteNotifier(notifier))
+	}
+	var querierOpts []parcacol.QuerierOption
+	if len(cfg.SampleTypeAggregations) > 0 {
+		aggregations := make(map[string]parcacol.Aggregation, len(cfg.SampleTypeAggregations))
+		for sampleType, a := range cfg.SampleTypeAggregations {
+			aggregations[sampleType] = parcacol.Aggregation(a)
+		}
+		querierOpts = append(querierOpts, parcacol.WithSampleTypeAggregations(aggregations))
+	}
+	for i, g := range flags.StorageRollupGranularity {
+		querierOpts = append(querierOpts, parcacol.WithRollupTable(rollupTableName(i, g), g))
+	}
+	if quarantine != nil {
+		querierOpts = append(querierOpts, parcacol.WithQuarantineTable("quarantine"))
+		queryOpts = append(queryOpts, queryservice.WithQuarantine())
+	}
+	var tableProvider logicalplan.TableProvider = colDB.TableProvider()
+	if tenants != nil {
+		tableProvider = tenants
+	}
 	q := queryservice.NewColumnQueryAPI(
 		logger,
 		tracerProvider.Tracer("query-service"),
@@ -257,11 +889,13 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 			tracerProvider.Tracer("querier"),
 			query.NewEngine(
 				memory.DefaultAllocator,
-				colDB.TableProvider(),
+				tableProvider,
 			),
 			"stacktraces",
 			metastore,
+			querierOpts...,
 		),
+		queryOpts...,
 	)
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -272,7 +906,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		return err
 	}
 
-	m := scrape.NewManager(logger, reg, s, cfg.ScrapeConfigs, labels.Labels{})
+	m := scrape.NewManager(logger, reg, s, cfg.ScrapeConfigs, labels.Labels{}, scrape.WithMaxConcurrentScrapes(flags.ScrapeMaxConcurrency))
 	if err := m.ApplyConfig(cfg.ScrapeConfigs); err != nil {
 		level.Error(logger).Log("msg", "failed to apply scrape configs", "err", err)
 		return err
@@ -288,32 +922,21 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 		return err
 	}
 
-	var debugInfodClient debuginfo.DebugInfodClient = debuginfo.NopDebugInfodClient{}
-	if len(flags.DebugInfodUpstreamServers) > 0 {
-		httpDebugInfoClient, err := debuginfo.NewHTTPDebugInfodClient(logger, flags.DebugInfodUpstreamServers, flags.DebugInfodHTTPRequestTimeout)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed to initialize debuginfod http client", "err", err)
-			return err
-		}
-
-		debugInfodClient, err = debuginfo.NewDebugInfodClientWithObjectStorageCache(
-			logger,
-			objstore.NewPrefixedBucket(bucket, "debuginfod-cache"),
-			httpDebugInfoClient,
-		)
-		if err != nil {
-			level.Error(logger).Log("msg", "failed to initialize debuginfod client cache", "err", err)
-			return err
-		}
+	debugInfodClient, err := flags.debugInfodClient(logger, bucket, cfg)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to initialize debuginfod client", "err", err)
+		return err
 	}
 
 	dbgInfoMetadata := debuginfo.NewObjectStoreMetadata(logger, bucket)
 	dbgInfo, err := debuginfo.NewStore(
 		logger,
+		reg,
 		flags.DebuginfoCacheDir,
 		dbgInfoMetadata,
 		objstore.NewPrefixedBucket(bucket, "debuginfo"),
 		debugInfodClient,
+		debuginfo.WithMaxUploadSize(flags.DebuginfoUploadMaxSize),
 	)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to initialize debug info store", "err", err)
@@ -323,16 +946,67 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 	reloaders := []config.ComponentReloader{
 		{
 			Name: "scrape_sd",
-			Reloader: func(cfg *config.Config) error {
-				return discoveryManager.ApplyConfig(getDiscoveryConfigs(cfg.ScrapeConfigs))
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					if err := discoveryManager.ApplyConfig(getDiscoveryConfigs(cfg.ScrapeConfigs)); err != nil {
+						level.Error(logger).Log("msg", "failed to apply service discovery configs", "err", err)
+					}
+				}}, nil
 			},
 		},
 		{
 			Name: "scrape",
-			Reloader: func(cfg *config.Config) error {
-				return m.ApplyConfig(cfg.ScrapeConfigs)
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					if err := m.ApplyConfig(cfg.ScrapeConfigs); err != nil {
+						level.Error(logger).Log("msg", "failed to apply scrape configs", "err", err)
+					}
+				}}, nil
+			},
+		},
+		{
+			Name: "remote_write",
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return remoteWrite.PrepareConfig(cfg.RemoteWriteConfigs)
+			},
+		},
+		{
+			Name: "debug_info",
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				client, err := flags.debugInfodClient(logger, bucket, cfg)
+				if err != nil {
+					return config.Reload{}, err
+				}
+				return config.Reload{Apply: func() {
+					dbgInfo.SetDebugInfodClient(client)
+				}}, nil
 			},
 		},
+		{
+			Name: "write_relabel",
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					relabeler.ApplyConfig(cfg.WriteRelabelConfigs)
+				}}, nil
+			},
+		},
+		config.StaticReloader(cfg),
+	}
+
+	// Blocks are only persisted, and thereby subject to retention, with
+	// persistence enabled.
+	var ret *retention.Retention
+	if flags.EnablePersistence {
+		ret = retention.New(logger, reg, objstore.NewPrefixedBucket(bucket, "blocks"))
+		ret.ApplyConfig(flags.storageRetention(cfg))
+		reloaders = append(reloaders, config.ComponentReloader{
+			Name: "retention",
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					ret.ApplyConfig(flags.storageRetention(cfg))
+				}}, nil
+			},
+		})
 	}
 
 	cfgReloader, err := config.NewConfigReloader(logger, reg, flags.ConfigPath, reloaders)
@@ -391,7 +1065,76 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 			cancel()
 		},
 	)
-	parcaserver := server.NewServer(reg, version)
+	if ret != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(
+			func() error {
+				return ret.Run(ctx, flags.StorageRetentionInterval)
+			},
+			func(_ error) {
+				level.Debug(logger).Log("msg", "retention exiting")
+				cancel()
+			},
+		)
+	}
+	if natsConsumer != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(
+			func() error {
+				return natsConsumer.Run(ctx)
+			},
+			func(_ error) {
+				level.Debug(logger).Log("msg", "NATS consumer exiting")
+				cancel()
+			},
+		)
+	}
+	if selfProfiler != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		gr.Add(
+			func() error {
+				return selfProfiler.Run(ctx)
+			},
+			func(_ error) {
+				level.Debug(logger).Log("msg", "self profiler exiting")
+				cancel()
+			},
+		)
+	}
+
+	adminHandler := chi.NewRouter()
+	adminHandler.Handle("/snapshot", snapshotter.Handler())
+	adminHandler.Mount("/", q.AdminHandler())
+
+	serverOpts := []server.Option{
+		server.WithAdminHandler(adminHandler),
+		server.WithReloadHandler(cfgReloader.Handler()),
+	}
+	if flags.ConnectionMetrics {
+		serverOpts = append(serverOpts, server.WithConnectionMetrics())
+	}
+	if flags.TenantHeader != "" {
+		serverOpts = append(serverOpts, server.WithTenantHeader(flags.TenantHeader))
+	}
+	if flags.ReusePort {
+		serverOpts = append(serverOpts, server.WithReusePort())
+	}
+	if flags.ShutdownDelay > 0 {
+		serverOpts = append(serverOpts, server.WithShutdownDelay(flags.ShutdownDelay))
+	}
+	tlsOpts, err := flags.serverTLSOptions(logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load TLS certificates", "err", err)
+		return err
+	}
+	serverOpts = append(serverOpts, tlsOpts...)
+	authOpts, err := flags.serverAuthOptions(cfg)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to configure authentication", "err", err)
+		return err
+	}
+	serverOpts = append(serverOpts, authOpts...)
+	parcaserver := server.NewServer(reg, version, serverOpts...)
 	gr.Add(
 		func() error {
 			return parcaserver.ListenAndServe(
@@ -399,7 +1142,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 				logger,
 				flags.Port,
 				flags.CORSAllowedOrigins,
-				flags.PathPrefix,
+				flags.webPathPrefix(),
 				server.RegisterableFunc(func(ctx context.Context, srv *grpc.Server, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error {
 					debuginfopb.RegisterDebugInfoServiceServer(srv, dbgInfo)
 					profilestorepb.RegisterProfileStoreServiceServer(srv, s)
@@ -418,6 +1161,30 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 						return err
 					}
 
+					if err := mux.HandlePath(http.MethodPost, "/api/profiles/upload", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+						s.Upload(w, r)
+					}); err != nil {
+						return err
+					}
+
+					if err := mux.HandlePath(http.MethodGet, "/api/profiles/trace", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+						q.ChromeTrace(w, r)
+					}); err != nil {
+						return err
+					}
+
+					if err := mux.HandlePath(http.MethodGet, "/api/profiles/pprof", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+						q.PprofDownload(w, r)
+					}); err != nil {
+						return err
+					}
+
+					if err := mux.HandlePath(http.MethodGet, "/api/query_range/live", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+						q.LiveQueryRange(w, r)
+					}); err != nil {
+						return err
+					}
+
 					if err := scrapepb.RegisterScrapeServiceHandlerFromEndpoint(ctx, mux, endpoint, opts); err != nil {
 						return err
 					}
@@ -427,7 +1194,7 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 			)
 		},
 		func(_ error) {
-			ctx, cancel := context.WithTimeout(ctx, 30*time.Second) // TODO make this a graceful shutdown config setting
+			ctx, cancel := context.WithTimeout(ctx, flags.gracefulShutdownTimeout())
 			defer cancel()
 
 			level.Debug(logger).Log("msg", "server shutting down")
@@ -436,6 +1203,16 @@ func Run(ctx context.Context, logger log.Logger, reg *prometheus.Registry, flags
 				level.Error(logger).Log("msg", "error shutting down server", "err", err)
 			}
 
+			// Wait for the writes still in flight. The server doesn't
+			// wait for gRPC streams of plaintext HTTP/2 connections,
+			// which are hijacked from it.
+			if err := s.Drain(ctx); err != nil {
+				level.Error(logger).Log("msg", "error draining profile store", "err", err)
+			}
+			if err := remoteWrite.Close(ctx); err != nil {
+				level.Error(logger).Log("msg", "error sending buffered profiles to remote write endpoints", "err", err)
+			}
+
 			// Close the columnstore after the parcaserver has shutdown to ensure no more writes occur against it.
 			if err := col.Close(); err != nil {
 				level.Error(logger).Log("msg", "error closing columnstore", "err", err)
@@ -469,36 +1246,13 @@ func runScraper(
 	metrics.EnableClientHandlingTimeHistogram()
 	reg.MustRegister(metrics)
 
-	opts := []grpc.DialOption{
-		grpc.WithUnaryInterceptor(
-			metrics.UnaryClientInterceptor(),
-		),
-	}
-	if flags.Insecure {
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	} else {
-		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-			InsecureSkipVerify: flags.InsecureSkipVerify,
-		})))
-	}
-
-	if flags.BearerToken != "" {
-		opts = append(opts, grpc.WithPerRPCCredentials(&perRequestBearerToken{
-			token:    flags.BearerToken,
-			insecure: flags.Insecure,
-		}))
-	}
-
-	if flags.BearerTokenFile != "" {
-		b, err := os.ReadFile(flags.BearerTokenFile)
-		if err != nil {
-			return fmt.Errorf("failed to read bearer token from file: %w", err)
-		}
-		opts = append(opts, grpc.WithPerRPCCredentials(&perRequestBearerToken{
-			token:    strings.TrimSpace(string(b)),
-			insecure: flags.Insecure,
-		}))
+	opts, err := storeDialOptions(flags.Insecure, flags.InsecureSkipVerify, flags.BearerToken, flags.BearerTokenFile)
+	if err != nil {
+		return err
 	}
+	opts = append(opts, grpc.WithUnaryInterceptor(
+		metrics.UnaryClientInterceptor(),
+	))
 
 	conn, err := grpc.Dial(flags.StoreAddress, opts...)
 	if err != nil {
@@ -520,7 +1274,7 @@ func runScraper(
 		externalLabels = append(externalLabels, labels.Label{Name: name, Value: value})
 	}
 
-	m := scrape.NewManager(logger, reg, store, cfg.ScrapeConfigs, externalLabels)
+	m := scrape.NewManager(logger, reg, store, cfg.ScrapeConfigs, externalLabels, scrape.WithMaxConcurrentScrapes(flags.ScrapeMaxConcurrency))
 	if err := m.ApplyConfig(cfg.ScrapeConfigs); err != nil {
 		level.Error(logger).Log("msg", "failed to apply scrape configs", "err", err)
 		return err
@@ -529,14 +1283,22 @@ func runScraper(
 	reloaders := []config.ComponentReloader{
 		{
 			Name: "scrape_sd",
-			Reloader: func(cfg *config.Config) error {
-				return discoveryManager.ApplyConfig(getDiscoveryConfigs(cfg.ScrapeConfigs))
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					if err := discoveryManager.ApplyConfig(getDiscoveryConfigs(cfg.ScrapeConfigs)); err != nil {
+						level.Error(logger).Log("msg", "failed to apply service discovery configs", "err", err)
+					}
+				}}, nil
 			},
 		},
 		{
 			Name: "scrape",
-			Reloader: func(cfg *config.Config) error {
-				return m.ApplyConfig(cfg.ScrapeConfigs)
+			Prepare: func(cfg *config.Config) (config.Reload, error) {
+				return config.Reload{Apply: func() {
+					if err := m.ApplyConfig(cfg.ScrapeConfigs); err != nil {
+						level.Error(logger).Log("msg", "failed to apply scrape configs", "err", err)
+					}
+				}}, nil
 			},
 		},
 	}
@@ -577,7 +1339,29 @@ func runScraper(
 		},
 	)
 
-	parcaserver := server.NewServer(reg, version)
+	serverOpts := []server.Option{server.WithReloadHandler(cfgReloader.Handler())}
+	if flags.ConnectionMetrics {
+		serverOpts = append(serverOpts, server.WithConnectionMetrics())
+	}
+	if flags.ReusePort {
+		serverOpts = append(serverOpts, server.WithReusePort())
+	}
+	if flags.ShutdownDelay > 0 {
+		serverOpts = append(serverOpts, server.WithShutdownDelay(flags.ShutdownDelay))
+	}
+	tlsOpts, err := flags.serverTLSOptions(logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load TLS certificates", "err", err)
+		return err
+	}
+	serverOpts = append(serverOpts, tlsOpts...)
+	authOpts, err := flags.serverAuthOptions(cfg)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to configure authentication", "err", err)
+		return err
+	}
+	serverOpts = append(serverOpts, authOpts...)
+	parcaserver := server.NewServer(reg, version, serverOpts...)
 	gr.Add(
 		func() error {
 			return parcaserver.ListenAndServe(
@@ -585,7 +1369,7 @@ func runScraper(
 				logger,
 				flags.Port,
 				flags.CORSAllowedOrigins,
-				flags.PathPrefix,
+				flags.webPathPrefix(),
 				server.RegisterableFunc(func(ctx context.Context, srv *grpc.Server, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error {
 					scrapepb.RegisterScrapeServiceServer(srv, m)
 					if err := scrapepb.RegisterScrapeServiceHandlerFromEndpoint(ctx, mux, endpoint, opts); err != nil {
@@ -596,7 +1380,7 @@ func runScraper(
 			)
 		},
 		func(_ error) {
-			ctx, cancel := context.WithTimeout(ctx, 30*time.Second) // TODO make this a graceful shutdown config setting
+			ctx, cancel := context.WithTimeout(ctx, flags.gracefulShutdownTimeout())
 			defer cancel()
 
 			level.Debug(logger).Log("msg", "server shutting down")
@@ -617,6 +1401,38 @@ func runScraper(
 	return nil
 }
 
+// storeDialOptions returns the options to dial a store with, via plaintext or
+// TLS, authenticating with the bearer token and the one of the token file.
+func storeDialOptions(plaintext, insecureSkipVerify bool, token, tokenFile string) ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	if plaintext {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			InsecureSkipVerify: insecureSkipVerify,
+		})))
+	}
+
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(&perRequestBearerToken{
+			token:    token,
+			insecure: plaintext,
+		}))
+	}
+
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token from file: %w", err)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(&perRequestBearerToken{
+			token:    strings.TrimSpace(string(b)),
+			insecure: plaintext,
+		}))
+	}
+	return opts, nil
+}
+
 type perRequestBearerToken struct {
 	token    string
 	insecure bool
@@ -640,7 +1456,7 @@ func getDiscoveryConfigs(cfgs []*config.ScrapeConfig) map[string]discovery.Confi
 	return c
 }
 
-func initTracer(logger log.Logger, otlpAddress string) (trace.TracerProvider, func(), error) {
+func initTracer(logger log.Logger, otlpAddress string, samplingRatio float64) (trace.TracerProvider, func(), error) {
 	ctx := context.Background()
 
 	res, err := resource.New(ctx,
@@ -665,7 +1481,7 @@ func initTracer(logger log.Logger, otlpAddress string) (trace.TracerProvider, fu
 	// span processor to aggregate spans before export.
 	bsp := sdktrace.NewBatchSpanProcessor(exporter)
 	provider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(samplingRatio))),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
 	)
@@ -34,6 +34,7 @@ import (
 	"github.com/polarsignals/frostdb"
 	"github.com/polarsignals/frostdb/query"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/promql/parser"
@@ -49,6 +50,7 @@ import (
 	querypb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
 	"github.com/parca-dev/parca/gen/proto/go/share"
 	sharepb "github.com/parca-dev/parca/gen/proto/go/share"
+	"github.com/parca-dev/parca/pkg/config"
 	"github.com/parca-dev/parca/pkg/metastore"
 	"github.com/parca-dev/parca/pkg/metastoretest"
 	"github.com/parca-dev/parca/pkg/parcacol"
@@ -424,3 +426,43 @@ func TestConsistency(t *testing.T) {
 
 	require.Equal(t, len(compactedOriginalProfile.Sample), len(resProf.Sample))
 }
+
+func TestGracefulShutdownTimeout(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 30*time.Second, (&Flags{}).gracefulShutdownTimeout())
+	require.Equal(t, time.Minute, (&Flags{GracefulShutdownTimeout: time.Minute}).gracefulShutdownTimeout())
+
+	err := Run(context.Background(), log.NewNopLogger(), prometheus.NewRegistry(), &Flags{
+		ConfigPath:              "testdata/parca.yaml",
+		GracefulShutdownTimeout: -time.Second,
+	}, "test-version")
+	require.Error(t, err)
+}
+
+func TestTLSFlags(t *testing.T) {
+	t.Parallel()
+
+	err := Run(context.Background(), log.NewNopLogger(), prometheus.NewRegistry(), &Flags{
+		ConfigPath:  "testdata/parca.yaml",
+		TLSCertFile: "tls.crt",
+	}, "test-version")
+	require.EqualError(t, err, "both --tls-cert-file and --tls-key-file must be set to serve TLS")
+}
+
+func TestStorageRetention(t *testing.T) {
+	t.Parallel()
+
+	f := &Flags{StorageRetention: 6 * time.Hour}
+
+	retention, size := f.storageRetention(&config.Config{})
+	require.Equal(t, 6*time.Hour, retention)
+	require.Equal(t, int64(0), size)
+
+	retention, size = f.storageRetention(&config.Config{Storage: &config.StorageConfig{RetentionSize: 1 << 30}})
+	require.Equal(t, 6*time.Hour, retention)
+	require.Equal(t, int64(1<<30), size)
+
+	retention, _ = f.storageRetention(&config.Config{Storage: &config.StorageConfig{RetentionTime: model.Duration(24 * time.Hour)}})
+	require.Equal(t, 24*time.Hour, retention)
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/polarsignals/frostdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tidwall/wal"
+)
+
+// openColumnStore creates the column store and replays its write-ahead logs.
+// Failing to replay them fails, unless the logs are corrupt and quarantine is
+// set: then the databases directory is moved aside and the store is reopened
+// empty, so that a corrupt or partially written WAL doesn't keep Parca from
+// starting. Other errors, like failing to read the logs, never discard them.
+func openColumnStore(ctx context.Context, logger log.Logger, reg prometheus.Registerer, quarantine bool, opts ...frostdb.Option) (*frostdb.ColumnStore, error) {
+	treg := &trackingRegisterer{Registerer: reg}
+	col, err := frostdb.New(logger, treg, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("initialize storage: %w", err)
+	}
+
+	replayErr := col.ReplayWALs(ctx)
+	if replayErr == nil {
+		return col, nil
+	}
+
+	if err := col.Close(); err != nil {
+		level.Warn(logger).Log("msg", "failed to close storage", "err", err)
+	}
+	if !isWALCorruption(replayErr) {
+		return nil, fmt.Errorf("replay WAL: %w", replayErr)
+	}
+	if !quarantine {
+		return nil, fmt.Errorf("replay WAL, it is corrupt, start with --storage-quarantine-corrupt-wal to move the databases aside and start with empty storage: %w", replayErr)
+	}
+
+	level.Error(logger).Log("msg", "WAL is corrupt, moving the databases aside and starting with empty storage", "err", replayErr)
+	// The databases created while replaying registered their metrics, they
+	// have to be removed before the same databases are created again.
+	treg.unregisterAll()
+
+	dir := col.DatabasesDir()
+	corrupt := fmt.Sprintf("%s.corrupt-%d", dir, time.Now().Unix())
+	if err := os.Rename(dir, corrupt); err != nil {
+		return nil, fmt.Errorf("move aside databases after failed WAL replay (%v): %w", replayErr, err)
+	}
+	level.Warn(logger).Log("msg", "moved aside databases that failed to replay", "path", corrupt)
+
+	col, err = frostdb.New(logger, reg, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("initialize storage: %w", err)
+	}
+	return col, nil
+}
+
+// isWALCorruption returns whether replaying the WAL failed because of its
+// contents rather than failing to read them. frostdb doesn't type the errors
+// of records failing to decode, they are recognized by their message.
+func isWALCorruption(err error) bool {
+	return errors.Is(err, wal.ErrCorrupt) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(err.Error(), "unmarshal WAL record")
+}
+
+// trackingRegisterer remembers the collectors registered through it so they
+// can be unregistered again.
+type trackingRegisterer struct {
+	prometheus.Registerer
+
+	mtx        sync.Mutex
+	collectors []prometheus.Collector
+}
+
+func (r *trackingRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *trackingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *trackingRegisterer) unregisterAll() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// writeWAL opens a column store in the directory writing a WAL, returning
+// the directory of the WAL.
+func writeWAL(t *testing.T, dir string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	col, err := openColumnStore(ctx, log.NewNopLogger(), prometheus.NewRegistry(), false, frostdb.WithWAL(), frostdb.WithStoragePath(dir))
+	require.NoError(t, err)
+	_, err = col.DB(ctx, "parca")
+	require.NoError(t, err)
+	require.NoError(t, col.Close())
+
+	return filepath.Join(dir, "databases", "parca", "wal")
+}
+
+func TestOpenColumnStoreCorruptWAL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	dir := t.TempDir()
+	opts := []frostdb.Option{frostdb.WithWAL(), frostdb.WithStoragePath(dir)}
+
+	walDir := writeWAL(t, dir)
+	entries, err := os.ReadDir(walDir)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	for _, e := range entries {
+		require.NoError(t, os.WriteFile(filepath.Join(walDir, e.Name()), []byte("corrupt"), 0o644))
+	}
+
+	// Corrupt logs are kept unless quarantine is enabled.
+	_, err = openColumnStore(ctx, logger, prometheus.NewRegistry(), false, opts...)
+	require.Error(t, err)
+	require.DirExists(t, walDir)
+
+	reg := prometheus.NewRegistry()
+	col, err := openColumnStore(ctx, logger, reg, true, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { col.Close() })
+
+	_, err = col.DB(ctx, "parca")
+	require.NoError(t, err)
+
+	corrupt, err := filepath.Glob(filepath.Join(dir, "databases.corrupt-*"))
+	require.NoError(t, err)
+	require.Len(t, corrupt, 1)
+}
+
+func TestOpenColumnStoreUnreadableWAL(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	walDir := writeWAL(t, dir)
+	require.NoError(t, os.RemoveAll(walDir))
+	require.NoError(t, os.WriteFile(walDir, nil, 0o644))
+
+	// Failing to open the logs is no corruption, they are never moved aside.
+	_, err := openColumnStore(context.Background(), log.NewNopLogger(), prometheus.NewRegistry(), true, frostdb.WithWAL(), frostdb.WithStoragePath(dir))
+	require.Error(t, err)
+	require.FileExists(t, walDir)
+
+	corrupt, err := filepath.Glob(filepath.Join(dir, "databases.corrupt-*"))
+	require.NoError(t, err)
+	require.Empty(t, corrupt)
+}
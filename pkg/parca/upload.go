@@ -0,0 +1,88 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// UploadFlags are the flags of the upload command.
+type UploadFlags struct {
+	Path               string            `arg:"" type:"existingfile" help:"Path of the pprof profile to upload, gzipped or not."`
+	Name               string            `required:"" help:"Name of the profile, like memory or process_cpu."`
+	Label              map[string]string `help:"Label(s) to attach to the profile."`
+	StoreAddress       string            `default:"localhost:7070" help:"gRPC address of the server to upload the profile to."`
+	BearerToken        string            `help:"Bearer token to authenticate with the server."`
+	BearerTokenFile    string            `help:"File to read bearer token from to authenticate with the server."`
+	Insecure           bool              `help:"Send gRPC requests via plaintext instead of TLS."`
+	InsecureSkipVerify bool              `help:"Skip TLS certificate verification."`
+	Timeout            time.Duration     `default:"30s" help:"Timeout of the upload."`
+}
+
+// Upload writes the profile of the file at the path of the flags to the
+// store of a running server, labeled with the name and labels of the flags.
+// The server parses the profile, so invalid profiles fail the upload.
+func Upload(ctx context.Context, flags *UploadFlags) (*profilestorepb.WriteRawResponse, error) {
+	if _, ok := flags.Label[labels.MetricName]; ok {
+		return nil, fmt.Errorf("the %s label is set by --name", labels.MetricName)
+	}
+	content, err := os.ReadFile(flags.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	ls := make([]*profilestorepb.Label, 0, len(flags.Label)+1)
+	ls = append(ls, &profilestorepb.Label{Name: labels.MetricName, Value: flags.Name})
+	for name, value := range flags.Label {
+		ls = append(ls, &profilestorepb.Label{Name: name, Value: value})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		return ls[i].Name < ls[j].Name
+	})
+
+	opts, err := storeDialOptions(flags.Insecure, flags.InsecureSkipVerify, flags.BearerToken, flags.BearerTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(flags.StoreAddress, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection: %w", err)
+	}
+	defer conn.Close()
+
+	if flags.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.Timeout)
+		defer cancel()
+	}
+	resp, err := profilestorepb.NewProfileStoreServiceClient(conn).WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels:  &profilestorepb.LabelSet{Labels: ls},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload profile: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parca
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+type recordingProfileStore struct {
+	profilestorepb.UnimplementedProfileStoreServiceServer
+
+	reqs chan *profilestorepb.WriteRawRequest
+}
+
+func (s *recordingProfileStore) WriteRaw(_ context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
+	s.reqs <- req
+	return &profilestorepb.WriteRawResponse{AcceptedSamples: 1}, nil
+}
+
+func TestUpload(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	store := &recordingProfileStore{reqs: make(chan *profilestorepb.WriteRawRequest, 1)}
+	profilestorepb.RegisterProfileStoreServiceServer(srv, store)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	path := filepath.Join(t.TempDir(), "profile.pb.gz")
+	require.NoError(t, os.WriteFile(path, []byte("profile"), 0o644))
+
+	resp, err := Upload(context.Background(), &UploadFlags{
+		Path:         path,
+		Name:         "memory",
+		Label:        map[string]string{"job": "ci", "commit": "abc"},
+		StoreAddress: lis.Addr().String(),
+		Insecure:     true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), resp.AcceptedSamples)
+
+	req := <-store.reqs
+	require.Len(t, req.Series, 1)
+	require.Equal(t, []*profilestorepb.Label{
+		{Name: "__name__", Value: "memory"},
+		{Name: "commit", Value: "abc"},
+		{Name: "job", Value: "ci"},
+	}, req.Series[0].Labels.Labels)
+	require.Equal(t, []byte("profile"), req.Series[0].Samples[0].RawProfile)
+
+	_, err = Upload(context.Background(), &UploadFlags{
+		Path:         path,
+		Name:         "memory",
+		Label:        map[string]string{"__name__": "cpu"},
+		StoreAddress: lis.Addr().String(),
+		Insecure:     true,
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow/go/v8/arrow"
+	"github.com/apache/arrow/go/v8/arrow/array"
+	"github.com/apache/arrow/go/v8/arrow/memory"
+)
+
+// Aggregation is how the values of a stack trace are aggregated over time
+// when profiles are merged.
+type Aggregation string
+
+const (
+	// AggregationSum adds up the values, fit for sample types counting
+	// events, like allocations or CPU time.
+	AggregationSum Aggregation = "sum"
+	// AggregationMax keeps the largest value, fit for gauges like the memory
+	// in use.
+	AggregationMax Aggregation = "max"
+	// AggregationLast keeps the most recent value.
+	AggregationLast Aggregation = "last"
+)
+
+type QuerierOption func(*Querier)
+
+// WithSampleTypeAggregations aggregates the values of the sample types, like
+// inuse_space, in the given way when merging profiles, rather than adding
+// them up. The values of the series written at the same time are still added
+// up before being aggregated over time.
+func WithSampleTypeAggregations(aggregations map[string]Aggregation) QuerierOption {
+	return func(q *Querier) {
+		q.aggregations = aggregations
+	}
+}
+
+// aggregation returns the aggregation of the values of the sample type.
+func (q *Querier) aggregation(sampleType string) Aggregation {
+	if a, ok := q.aggregations[sampleType]; ok {
+		return a
+	}
+	return AggregationSum
+}
+
+// aggregateOverTime aggregates the summed values of a record of stack traces
+// and timestamps to a record of just stack traces, in the way of the
+// aggregation.
+func aggregateOverTime(r arrow.Record, valueColumn string, aggregation Aggregation) (arrow.Record, error) {
+	stacktraces, err := BinaryFieldFromRecord(r, "stacktrace")
+	if err != nil {
+		return nil, err
+	}
+	timestamps, err := Int64FieldFromRecord(r, "timestamp")
+	if err != nil {
+		return nil, err
+	}
+	values, err := Int64FieldFromRecord(r, valueColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	type sample struct {
+		timestamp int64
+		value     int64
+	}
+	var (
+		order   []string
+		samples = map[string]*sample{}
+	)
+	for i := 0; i < int(r.NumRows()); i++ {
+		stacktrace := string(stacktraces.Value(i))
+		s, ok := samples[stacktrace]
+		if !ok {
+			order = append(order, stacktrace)
+			samples[stacktrace] = &sample{timestamp: timestamps.Value(i), value: values.Value(i)}
+			continue
+		}
+		switch aggregation {
+		case AggregationMax:
+			if values.Value(i) > s.value {
+				s.value = values.Value(i)
+			}
+		case AggregationLast:
+			if timestamps.Value(i) > s.timestamp {
+				s.timestamp = timestamps.Value(i)
+				s.value = values.Value(i)
+			}
+		default:
+			return nil, fmt.Errorf("unknown aggregation %q", aggregation)
+		}
+	}
+
+	stacktraceBuilder := array.NewBinaryBuilder(memory.DefaultAllocator, arrow.BinaryTypes.Binary)
+	defer stacktraceBuilder.Release()
+	valueBuilder := array.NewInt64Builder(memory.DefaultAllocator)
+	defer valueBuilder.Release()
+	for _, stacktrace := range order {
+		stacktraceBuilder.Append([]byte(stacktrace))
+		valueBuilder.Append(samples[stacktrace].value)
+	}
+
+	stacktraceArray := stacktraceBuilder.NewArray()
+	defer stacktraceArray.Release()
+	valueArray := valueBuilder.NewArray()
+	defer valueArray.Release()
+
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "stacktrace", Type: arrow.BinaryTypes.Binary},
+		{Name: valueColumn, Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	return array.NewRecord(schema, []arrow.Array{stacktraceArray, valueArray}, int64(len(order))), nil
+}
@@ -18,11 +18,14 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"text/template"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/polarsignals/frostdb/dynparquet"
 	"github.com/prometheus/prometheus/model/labels"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	pprofproto "github.com/parca-dev/parca/gen/proto/go/google/pprof"
 	"github.com/parca-dev/parca/pkg/profile"
@@ -35,18 +38,36 @@ type Table interface {
 
 type Ingester struct {
 	logger     log.Logger
+	tracer     trace.Tracer
 	table      Table
 	normalizer *Normalizer
 	schema     *dynparquet.Schema
+
+	rollups []rollup
+
+	nameTemplate *template.Template
 }
 
-func NewIngester(logger log.Logger, normalizer *Normalizer, table Table, schema *dynparquet.Schema) *Ingester {
-	return &Ingester{
+func NewIngester(logger log.Logger, normalizer *Normalizer, table Table, schema *dynparquet.Schema, opts ...IngesterOption) *Ingester {
+	ing := &Ingester{
 		logger:     logger,
+		tracer:     trace.NewNoopTracerProvider().Tracer(""),
 		normalizer: normalizer,
 		table:      table,
 		schema:     schema,
 	}
+	for _, opt := range opts {
+		opt(ing)
+	}
+	return ing
+}
+
+// WithIngesterTracer traces the normalization and insertion of ingested
+// profiles.
+func WithIngesterTracer(tracer trace.Tracer) IngesterOption {
+	return func(ing *Ingester) {
+		ing.tracer = tracer
+	}
 }
 
 var ErrMissingNameLabel = errors.New("missing __name__ label")
@@ -74,16 +95,25 @@ func separateNameFromLabels(ls labels.Labels) (string, map[string]struct{}, labe
 }
 
 func (ing Ingester) Ingest(ctx context.Context, ls labels.Labels, p *pprofproto.Profile, normalized bool) error {
+	ctx, span := ing.tracer.Start(ctx, "ingest")
+	defer span.End()
+
 	name, names, ls, err := separateNameFromLabels(ls)
 	if err != nil {
 		return fmt.Errorf("prepare labels: %w", err)
 	}
+	span.SetAttributes(
+		attribute.String("name", name),
+		attribute.Int("samples", len(p.Sample)),
+	)
 
 	if err := validatePprofProfile(p); err != nil {
 		return err
 	}
 
-	normalizedProfiles, err := ing.normalizer.NormalizePprof(ctx, name, names, p, normalized)
+	normalizeCtx, normalizeSpan := ing.tracer.Start(ctx, "normalize")
+	normalizedProfiles, err := ing.normalizer.NormalizePprof(normalizeCtx, name, names, p, normalized)
+	normalizeSpan.End()
 	if err != nil {
 		return fmt.Errorf("normalize profile: %w", err)
 	}
@@ -94,15 +124,27 @@ func (ing Ingester) Ingest(ctx context.Context, ls labels.Labels, p *pprofproto.
 			continue
 		}
 
+		p.Meta.Name, err = ing.seriesName(p.Meta)
+		if err != nil {
+			return err
+		}
 		if err := ing.IngestProfile(ctx, ls, p); err != nil {
 			return fmt.Errorf("ingest profile: %w", err)
 		}
+		for _, r := range ing.rollups {
+			if err := ing.ingestRollup(ctx, r, ls, p); err != nil {
+				return fmt.Errorf("ingest rollup: %w", err)
+			}
+		}
 	}
 
 	return nil
 }
 
 func (ing Ingester) IngestProfile(ctx context.Context, ls labels.Labels, p *profile.NormalizedProfile) error {
+	ctx, span := ing.tracer.Start(ctx, "insert-buffer")
+	defer span.End()
+
 	buffer, err := NormalizedProfileToParquetBuffer(ing.schema, ls, p)
 	if err != nil {
 		return fmt.Errorf("failed to convert samples to buffer: %w", err)
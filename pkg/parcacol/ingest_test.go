@@ -22,11 +22,14 @@ import (
 	"testing"
 
 	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
 	"github.com/polarsignals/frostdb/dynparquet"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/segmentio/parquet-go"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.opentelemetry.io/otel/trace"
 
 	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
@@ -95,3 +98,50 @@ func TestPprofToParquet(t *testing.T) {
 		}
 	}
 }
+
+func TestIngestTracing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+
+	col, err := frostdb.New(logger, reg)
+	require.NoError(t, err)
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := Schema()
+	require.NoError(t, err)
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, trace.NewNoopTracerProvider().Tracer("")))
+
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("")
+	ingester := NewIngester(logger, NewNormalizer(m), table, schema, WithIngesterTracer(tracer))
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "../query/testdata/alloc_objects.pb.gz")))
+	require.NoError(t, ingester.Ingest(ctx, labels.Labels{{Name: "__name__", Value: "memory"}}, p, false))
+
+	spans := recorder.Ended()
+	names := make(map[string]int, len(spans))
+	var root sdktrace.ReadOnlySpan
+	for _, s := range spans {
+		names[s.Name()]++
+		if s.Name() == "ingest" {
+			root = s
+		}
+	}
+	require.Equal(t, map[string]int{
+		"ingest":        1,
+		"normalize":     1,
+		"insert-buffer": 4,
+	}, names)
+	for _, s := range spans {
+		if s != root {
+			require.Equal(t, root.SpanContext().SpanID(), s.Parent().SpanID())
+		}
+	}
+}
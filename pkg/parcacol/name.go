@@ -0,0 +1,95 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// SeriesName is what templates of the names of the series of sample types
+// are executed with.
+type SeriesName struct {
+	// Name is the value of the __name__ label of the written profile.
+	Name       string
+	Type       string
+	Unit       string
+	PeriodType string
+	PeriodUnit string
+}
+
+// validSeriesName matches names that can be queried. Names may not contain
+// colons, as queries select sample types by appending them to the name,
+// separated by colons.
+var validSeriesName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ParseSeriesNameTemplate parses a text/template of the names of the series
+// of sample types, like {{.Name}}_{{.Type}}, executed with a SeriesName.
+func ParseSeriesNameTemplate(text string) (*template.Template, error) {
+	t, err := template.New("series_name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse series name template: %w", err)
+	}
+	if _, err := executeSeriesNameTemplate(t, SeriesName{
+		Name:       "memory",
+		Type:       "alloc_objects",
+		Unit:       "count",
+		PeriodType: "space",
+		PeriodUnit: "bytes",
+	}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// WithSeriesNameTemplate names the series of each sample type of ingested
+// profiles by executing the template, instead of storing them all under the
+// name of the profile. Queries select the sample types under their new
+// names, like memory_alloc_objects:alloc_objects:count:space:bytes.
+func WithSeriesNameTemplate(t *template.Template) IngesterOption {
+	return func(ing *Ingester) {
+		ing.nameTemplate = t
+	}
+}
+
+func executeSeriesNameTemplate(t *template.Template, n SeriesName) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, n); err != nil {
+		return "", fmt.Errorf("execute series name template: %w", err)
+	}
+	name := b.String()
+	if !validSeriesName.MatchString(name) {
+		return "", fmt.Errorf("series name template results in invalid name %q, names may only consist of letters, digits and underscores", name)
+	}
+	return name, nil
+}
+
+// seriesName returns the name of the series of the sample type of the
+// profile.
+func (ing Ingester) seriesName(meta profile.Meta) (string, error) {
+	if ing.nameTemplate == nil {
+		return meta.Name, nil
+	}
+	return executeSeriesNameTemplate(ing.nameTemplate, SeriesName{
+		Name:       meta.Name,
+		Type:       meta.SampleType.Type,
+		Unit:       meta.SampleType.Unit,
+		PeriodType: meta.PeriodType.Type,
+		PeriodUnit: meta.PeriodType.Unit,
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+)
+
+func TestIngestSeriesNameTemplate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	col, err := frostdb.New(logger, reg)
+	require.NoError(t, err)
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := Schema()
+	require.NoError(t, err)
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, tracer))
+
+	tmpl, err := ParseSeriesNameTemplate("{{.Name}}_{{.Type}}")
+	require.NoError(t, err)
+	ingester := NewIngester(logger, NewNormalizer(m), table, schema, WithSeriesNameTemplate(tmpl))
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "../query/testdata/alloc_objects.pb.gz")))
+	require.NoError(t, ingester.Ingest(ctx, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}}, p, false))
+
+	types, err := NewQuerier(
+		tracer,
+		query.NewEngine(memory.DefaultAllocator, colDB.TableProvider()),
+		"stacktraces",
+		m,
+	).ProfileTypes(ctx)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(types))
+	for _, typ := range types {
+		require.Equal(t, "memory_"+typ.SampleType, typ.Name)
+		names = append(names, typ.Name)
+	}
+	sort.Strings(names)
+	require.Equal(t, []string{
+		"memory_alloc_objects",
+		"memory_alloc_space",
+		"memory_inuse_objects",
+		"memory_inuse_space",
+	}, names)
+}
+
+func TestParseSeriesNameTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, text := range []string{
+		"{{.Name}}:{{.Type}}",
+		"{{.Name}}_{{.Kind}}",
+		"{{.Name",
+		"",
+	} {
+		_, err := ParseSeriesNameTemplate(text)
+		require.Error(t, err, text)
+	}
+}
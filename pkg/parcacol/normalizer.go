@@ -16,10 +16,13 @@ package parcacol
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"sort"
+	"time"
 
 	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
 	pb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
 	"github.com/parca-dev/parca/pkg/profile"
 )
 
@@ -29,12 +32,72 @@ const (
 
 type Normalizer struct {
 	metastore pb.MetastoreServiceClient
+
+	mappingFileRewrites  []MappingFileRewrite
+	sampleWeightLabel    string
+	timestampGranularity int64
+	stacktraces          *StacktraceCache
+	now                  func() time.Time
+}
+
+type NormalizerOption func(*Normalizer)
+
+// MappingFileRewrite replaces the file name of mappings that Regex matches in
+// full with Replacement, which may refer to capture groups like $1.
+type MappingFileRewrite struct {
+	Regex       *regexp.Regexp
+	Replacement string
 }
 
-func NewNormalizer(metastore pb.MetastoreServiceClient) *Normalizer {
-	return &Normalizer{
+// WithMappingFileRewrites rewrites the file names of normalized mappings with
+// the first matching rewrite. Mappings without a build ID are identified by
+// their file name, so this aggregates the same binary found at different
+// paths into a single mapping.
+func WithMappingFileRewrites(rewrites ...MappingFileRewrite) NormalizerOption {
+	return func(n *Normalizer) {
+		n.mappingFileRewrites = append(n.mappingFileRewrites, rewrites...)
+	}
+}
+
+// WithSampleWeightLabel multiplies the values of samples by their numeric
+// pprof label of the given name, so a single sample can stand for many. The
+// label itself isn't stored. Samples without the label have a weight of 1,
+// samples of weight 0 are dropped and negative weights are rejected.
+func WithSampleWeightLabel(name string) NormalizerOption {
+	return func(n *Normalizer) {
+		n.sampleWeightLabel = name
+	}
+}
+
+// WithTimestampGranularity rounds the timestamps of profiles to the nearest
+// multiple of the granularity, so profiles of different series taken around
+// the same time are aligned. Timestamps are rounded down instead if rounding
+// up would move them past the current time. Granularities below a
+// millisecond, the precision timestamps are stored at, are ignored.
+func WithTimestampGranularity(granularity time.Duration) NormalizerOption {
+	return func(n *Normalizer) {
+		n.timestampGranularity = granularity.Milliseconds()
+	}
+}
+
+// WithStacktraceCache only asks the metastore to create the stacktraces of
+// normalized profiles that are missing from the cache. The cache may be
+// shared by normalizers of the same metastore.
+func WithStacktraceCache(c *StacktraceCache) NormalizerOption {
+	return func(n *Normalizer) {
+		n.stacktraces = c
+	}
+}
+
+func NewNormalizer(metastore pb.MetastoreServiceClient, opts ...NormalizerOption) *Normalizer {
+	n := &Normalizer{
 		metastore: metastore,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(n)
 	}
+	return n
 }
 
 func (n *Normalizer) NormalizePprof(ctx context.Context, name string, takenLabelNames map[string]struct{}, p *pprofpb.Profile, normalizedAddress bool) ([]*profile.NormalizedProfile, error) {
@@ -72,21 +135,38 @@ func (n *Normalizer) NormalizePprof(ctx context.Context, name string, takenLabel
 			Meta:    profile.MetaFromPprof(p, name, i),
 			Samples: make([]*profile.NormalizedSample, 0, len(p.Sample)),
 		}
+		normalizedProfile.Meta.Timestamp = n.roundTimestamp(normalizedProfile.Meta.Timestamp)
 		profiles = append(profiles, normalizedProfile)
 		sampleIndex[i] = map[string]int{}
 	}
 
 	for i, sample := range p.Sample {
+		weight, err := n.sampleWeight(sample, p.StringTable)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i, err)
+		}
+		if weight == 0 {
+			continue
+		}
+
 		labels, numLabels := labelsFromSample(takenLabelNames, p.StringTable, sample.Label)
+		if n.sampleWeightLabel != "" {
+			delete(numLabels, n.sampleWeightLabel)
+		}
 		key := sampleKey(stacktraces[i].Id, labels, numLabels)
 		for j, value := range sample.Value {
 			if value == 0 {
 				continue
 			}
 
+			weighted := value * weight
+			if weighted/weight != value {
+				return nil, fmt.Errorf("sample %d: value %d overflows with weight %d", i, value, weight)
+			}
+
 			ns := &profile.NormalizedSample{
 				StacktraceID: stacktraces[i].Id,
-				Value:        sample.Value[j],
+				Value:        weighted,
 				Label:        labels,
 				NumLabel:     numLabels,
 			}
@@ -104,6 +184,48 @@ func (n *Normalizer) NormalizePprof(ctx context.Context, name string, takenLabel
 	return profiles, nil
 }
 
+// roundTimestamp rounds a timestamp in milliseconds to the configured
+// granularity. Timestamps at or before now are never rounded past it.
+func (n *Normalizer) roundTimestamp(ts int64) int64 {
+	g := n.timestampGranularity
+	if g <= 1 {
+		return ts
+	}
+
+	down := ts - ts%g
+	if ts%g < 0 {
+		down -= g
+	}
+	if ts-down < g-(ts-down) {
+		return down
+	}
+
+	up := down + g
+	if now := n.now().UnixMilli(); ts <= now && up > now {
+		return down
+	}
+	return up
+}
+
+// sampleWeight returns the weight of a sample, which is 1 unless weights are
+// read from a label.
+func (n *Normalizer) sampleWeight(sample *pprofpb.Sample, stringTable []string) (int64, error) {
+	if n.sampleWeightLabel == "" {
+		return 1, nil
+	}
+
+	for _, label := range sample.Label {
+		if label.Str != 0 || stringTable[label.Key] != n.sampleWeightLabel {
+			continue
+		}
+		if label.Num < 0 {
+			return 0, fmt.Errorf("negative weight %d", label.Num)
+		}
+		return label.Num, nil
+	}
+	return 1, nil
+}
+
 func sampleKey(stacktraceID string, labels map[string]string, numLabels map[string]int64) string {
 	key := stacktraceID + ";"
 	for k, v := range labels {
@@ -174,7 +296,7 @@ func (n *Normalizer) NormalizeMappings(ctx context.Context, mappings []*pprofpb.
 			Start:           mapping.MemoryStart,
 			Limit:           mapping.MemoryLimit,
 			Offset:          mapping.FileOffset,
-			File:            stringTable[mapping.Filename],
+			File:            n.rewriteMappingFile(stringTable[mapping.Filename]),
 			BuildId:         stringTable[mapping.BuildId],
 			HasFunctions:    mapping.HasFunctions,
 			HasFilenames:    mapping.HasFilenames,
@@ -199,6 +321,15 @@ func (n *Normalizer) NormalizeMappings(ctx context.Context, mappings []*pprofpb.
 	return mapInfos, nil
 }
 
+func (n *Normalizer) rewriteMappingFile(file string) string {
+	for _, r := range n.mappingFileRewrites {
+		if loc := r.Regex.FindStringSubmatchIndex(file); loc != nil && loc[0] == 0 && loc[1] == len(file) {
+			return string(r.Regex.ExpandString(nil, r.Replacement, file, loc))
+		}
+	}
+	return file
+}
+
 func (n *Normalizer) NormalizeFunctions(ctx context.Context, functions []*pprofpb.Function, stringTable []string) ([]*pb.Function, error) {
 	req := &pb.GetOrCreateFunctionsRequest{
 		Functions: make([]*pb.Function, 0, len(functions)),
@@ -302,6 +433,10 @@ func (n *Normalizer) NormalizeStacktraces(ctx context.Context, samples []*pprofp
 		})
 	}
 
+	if n.stacktraces != nil {
+		return n.normalizeCachedStacktraces(ctx, req.Stacktraces)
+	}
+
 	res, err := n.metastore.GetOrCreateStacktraces(ctx, req)
 	if err != nil {
 		return nil, err
@@ -309,3 +444,30 @@ func (n *Normalizer) NormalizeStacktraces(ctx context.Context, samples []*pprofp
 
 	return res.Stacktraces, nil
 }
+
+// normalizeCachedStacktraces asks the metastore to create the stacktraces
+// missing from the cache, each only once.
+func (n *Normalizer) normalizeCachedStacktraces(ctx context.Context, stacktraces []*pb.Stacktrace) ([]*pb.Stacktrace, error) {
+	req := &pb.GetOrCreateStacktracesRequest{}
+	missing := map[string]struct{}{}
+	for _, st := range stacktraces {
+		st.Id = metastore.MakeStacktraceID(st)
+		if _, ok := missing[st.Id]; ok || n.stacktraces.contains(st.Id) {
+			continue
+		}
+		missing[st.Id] = struct{}{}
+		req.Stacktraces = append(req.Stacktraces, st)
+	}
+	if len(req.Stacktraces) == 0 {
+		return stacktraces, nil
+	}
+
+	if _, err := n.metastore.GetOrCreateStacktraces(ctx, req); err != nil {
+		return nil, err
+	}
+	for _, st := range req.Stacktraces {
+		n.stacktraces.add(st.Id)
+	}
+
+	return stacktraces, nil
+}
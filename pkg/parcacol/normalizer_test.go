@@ -14,11 +14,20 @@
 package parcacol
 
 import (
+	"context"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 
 	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
 )
 
 func TestLabelsFromSample(t *testing.T) {
@@ -75,3 +84,125 @@ func TestLabelsFromSample(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeMappingsFileRewrites(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		log.NewNopLogger(),
+		prometheus.NewRegistry(),
+		trace.NewNoopTracerProvider().Tracer(""),
+	))
+
+	stringTable := []string{"", "/tmp/build-123/app", "/tmp/build-456/app", "/usr/lib/libc.so"}
+	mappings := []*pprofpb.Mapping{
+		{Id: 1, MemoryStart: 0x1000, MemoryLimit: 0x2000, Filename: 1},
+		{Id: 2, MemoryStart: 0x1000, MemoryLimit: 0x2000, Filename: 2},
+		{Id: 3, MemoryStart: 0x1000, MemoryLimit: 0x2000, Filename: 3},
+	}
+
+	res, err := NewNormalizer(m).NormalizeMappings(ctx, mappings, stringTable)
+	require.NoError(t, err)
+	require.NotEqual(t, res[0].id, res[1].id)
+
+	n := NewNormalizer(m, WithMappingFileRewrites(MappingFileRewrite{
+		Regex:       regexp.MustCompile(`/tmp/build-[0-9]+/(.*)`),
+		Replacement: "/opt/$1",
+	}))
+	res, err = n.NormalizeMappings(ctx, mappings, stringTable)
+	require.NoError(t, err)
+	require.Equal(t, res[0].id, res[1].id)
+	require.NotEqual(t, res[0].id, res[2].id)
+
+	stored, err := m.Mappings(ctx, &metastorepb.MappingsRequest{MappingIds: []string{res[0].id, res[2].id}})
+	require.NoError(t, err)
+	require.Equal(t, "/opt/app", stored.Mappings[0].File)
+	require.Equal(t, "/usr/lib/libc.so", stored.Mappings[1].File)
+}
+
+func TestNormalizePprofSampleWeights(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		log.NewNopLogger(),
+		prometheus.NewRegistry(),
+		trace.NewNoopTracerProvider().Tracer(""),
+	))
+
+	weight := func(w int64) []*pprofpb.Label {
+		return []*pprofpb.Label{{Key: 5, Num: w}}
+	}
+	p := &pprofpb.Profile{
+		StringTable: []string{"", "samples", "count", "main", "main.go", "weight"},
+		SampleType:  []*pprofpb.ValueType{{Type: 1, Unit: 2}},
+		Function:    []*pprofpb.Function{{Id: 1, Name: 3, Filename: 4}},
+		Location:    []*pprofpb.Location{{Id: 1, Line: []*pprofpb.Line{{FunctionId: 1, Line: 1}}}},
+		Sample: []*pprofpb.Sample{
+			{LocationId: []uint64{1}, Value: []int64{2}, Label: weight(5)},
+			{LocationId: []uint64{1}, Value: []int64{3}},
+			{LocationId: []uint64{1}, Value: []int64{7}, Label: weight(0)},
+		},
+	}
+
+	profiles, err := NewNormalizer(m).NormalizePprof(ctx, "test", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+	require.Len(t, profiles[0].Samples, 2)
+
+	n := NewNormalizer(m, WithSampleWeightLabel("weight"))
+	profiles, err = n.NormalizePprof(ctx, "test", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+	require.Len(t, profiles[0].Samples, 1)
+	require.Equal(t, int64(2*5+3), profiles[0].Samples[0].Value)
+	require.Empty(t, profiles[0].Samples[0].NumLabel)
+
+	p.Sample[0].Label = weight(-1)
+	_, err = n.NormalizePprof(ctx, "test", map[string]struct{}{}, p, false)
+	require.Error(t, err)
+
+	p.Sample[0].Label = weight(1 << 62)
+	_, err = n.NormalizePprof(ctx, "test", map[string]struct{}{}, p, false)
+	require.Error(t, err)
+}
+
+func TestNormalizePprofTimestampGranularity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		log.NewNopLogger(),
+		prometheus.NewRegistry(),
+		trace.NewNoopTracerProvider().Tracer(""),
+	))
+
+	n := NewNormalizer(m, WithTimestampGranularity(10*time.Second))
+	n.now = func() time.Time { return time.UnixMilli(98_000) }
+
+	for _, tc := range []struct {
+		timestamp int64
+		expected  int64
+	}{
+		{timestamp: 20_000, expected: 20_000},
+		{timestamp: 24_999, expected: 20_000},
+		{timestamp: 25_000, expected: 30_000},
+		{timestamp: 29_001, expected: 30_000},
+		// Rounding up would move these past now.
+		{timestamp: 96_000, expected: 90_000},
+		{timestamp: 98_000, expected: 90_000},
+		// Timestamps already in the future are rounded as usual.
+		{timestamp: 106_000, expected: 110_000},
+	} {
+		p := &pprofpb.Profile{
+			StringTable: []string{"", "samples", "count"},
+			SampleType:  []*pprofpb.ValueType{{Type: 1, Unit: 2}},
+			TimeNanos:   tc.timestamp * time.Millisecond.Nanoseconds(),
+		}
+		profiles, err := n.NormalizePprof(ctx, "test", map[string]struct{}{}, p, false)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, profiles[0].Meta.Timestamp, "timestamp %d", tc.timestamp)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow/go/v8/arrow"
+	"github.com/polarsignals/frostdb/query/logicalplan"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+type primarySampleTypeKey struct{}
+
+// WithPrimarySampleType makes queries select the samples of the primary
+// sample type of the stored profiles, that is their default sample type, no
+// matter the sample type of the query.
+func WithPrimarySampleType(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primarySampleTypeKey{}, true)
+}
+
+func primarySampleTypeFromContext(ctx context.Context) bool {
+	primary, _ := ctx.Value(primarySampleTypeKey{}).(bool)
+	return primary
+}
+
+// primarySampleType returns the primary sample type of the profiles the
+// filter selects, which must be the same for all of them. It returns the
+// given sample type if the filter selects nothing.
+func (q *Querier) primarySampleType(ctx context.Context, filterExpr logicalplan.Expr, sampleType profile.ValueType) (profile.ValueType, error) {
+	ctx, span := q.tracer.Start(ctx, "primarySampleType")
+	defer span.End()
+
+	seen := map[profile.ValueType]struct{}{}
+	err := q.engine.ScanTable(q.table(ctx)).
+		Filter(filterExpr).
+		Distinct(
+			logicalplan.Col(ColumnSampleType),
+			logicalplan.Col(ColumnSampleUnit),
+		).
+		Execute(ctx, func(ar arrow.Record) error {
+			typeColumn, err := BinaryFieldFromRecord(ar, ColumnSampleType)
+			if err != nil {
+				return err
+			}
+			unitColumn, err := BinaryFieldFromRecord(ar, ColumnSampleUnit)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < int(ar.NumRows()); i++ {
+				seen[profile.ValueType{
+					Type: string(typeColumn.Value(i)),
+					Unit: string(unitColumn.Value(i)),
+				}] = struct{}{}
+			}
+			return nil
+		})
+	if err != nil {
+		return profile.ValueType{}, fmt.Errorf("resolve primary sample type: %w", err)
+	}
+
+	switch len(seen) {
+	case 0:
+		return sampleType, nil
+	case 1:
+		for vt := range seen {
+			return vt, nil
+		}
+	}
+
+	types := make([]string, 0, len(seen))
+	for vt := range seen {
+		types = append(types, vt.Type+":"+vt.Unit)
+	}
+	sort.Strings(types)
+	return profile.ValueType{}, status.Errorf(codes.InvalidArgument, "the selected profiles have different primary sample types: %s", strings.Join(types, ", "))
+}
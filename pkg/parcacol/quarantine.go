@@ -0,0 +1,45 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import "context"
+
+type quarantinedKey struct{}
+
+// WithQuarantined makes queries select the profiles of the quarantine table
+// rather than the profiles stored normally.
+func WithQuarantined(ctx context.Context) context.Context {
+	return context.WithValue(ctx, quarantinedKey{}, true)
+}
+
+func quarantinedFromContext(ctx context.Context) bool {
+	quarantined, _ := ctx.Value(quarantinedKey{}).(bool)
+	return quarantined
+}
+
+// WithQuarantineTable reads the profiles written to the quarantine table of
+// the given name for queries of quarantined profiles.
+func WithQuarantineTable(tableName string) QuerierOption {
+	return func(q *Querier) {
+		q.quarantineTableName = tableName
+	}
+}
+
+// table returns the table a query reads.
+func (q *Querier) table(ctx context.Context) string {
+	if quarantinedFromContext(ctx) && q.quarantineTableName != "" {
+		return TenantTableName(ctx, q.quarantineTableName)
+	}
+	return TenantTableName(ctx, q.tableName)
+}
@@ -55,8 +55,9 @@ func NewQuerier(
 	engine Engine,
 	tableName string,
 	metastore metastorepb.MetastoreServiceClient,
+	opts ...QuerierOption,
 ) *Querier {
-	return &Querier{
+	q := &Querier{
 		tracer:    tracer,
 		engine:    engine,
 		tableName: tableName,
@@ -65,13 +66,22 @@ func NewQuerier(
 			metastore,
 		),
 	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
 }
 
 type Querier struct {
-	engine    Engine
-	tableName string
-	converter *ArrowToProfileConverter
-	tracer    trace.Tracer
+	engine       Engine
+	tableName    string
+	converter    *ArrowToProfileConverter
+	tracer       trace.Tracer
+	aggregations map[string]Aggregation
+
+	rollupTables []rollupTable
+
+	quarantineTableName string
 }
 
 func (q *Querier) Labels(
@@ -81,7 +91,7 @@ func (q *Querier) Labels(
 ) ([]string, error) {
 	seen := map[string]struct{}{}
 
-	err := q.engine.ScanSchema(q.tableName).
+	err := q.engine.ScanSchema(q.table(ctx)).
 		Distinct(logicalplan.Col("name")).
 		Filter(logicalplan.Col("name").RegexMatch("^labels\\..+$")).
 		Execute(ctx, func(ar arrow.Record) error {
@@ -116,15 +126,27 @@ func (q *Querier) Labels(
 	return vals, nil
 }
 
+// Values returns the values of a label. If selectors are given, only the
+// values of the series matching any of them are returned. A zero start or end
+// leaves the time range open on that side.
 func (q *Querier) Values(
 	ctx context.Context,
 	labelName string,
 	match []string,
 	start, end time.Time,
 ) ([]string, error) {
-	vals := []string{}
+	filterExpr, err := valuesFilter(match, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	b := q.engine.ScanTable(q.table(ctx))
+	if filterExpr != nil {
+		b = b.Filter(filterExpr)
+	}
 
-	err := q.engine.ScanTable(q.tableName).
+	vals := []string{}
+	err = b.
 		Distinct(logicalplan.Col("labels."+labelName)).
 		Execute(ctx, func(ar arrow.Record) error {
 			if ar.NumCols() != 1 {
@@ -138,6 +160,10 @@ func (q *Querier) Values(
 			}
 
 			for i := 0; i < stringCol.Len(); i++ {
+				// Series without the label are null.
+				if stringCol.IsNull(i) {
+					continue
+				}
 				val := stringCol.Value(i)
 				vals = append(vals, string(val))
 			}
@@ -152,6 +178,45 @@ func (q *Querier) Values(
 	return vals, nil
 }
 
+// valuesFilter returns the filter of a values request, nil if it matches
+// everything. Selectors with a profile-type selection are restricted to that
+// profile type, selectors without one match any profile type.
+func valuesFilter(match []string, start, end time.Time) (logicalplan.Expr, error) {
+	selectorExprs := make([]logicalplan.Expr, 0, len(match))
+	for _, m := range match {
+		matchers, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to parse match %q", m)
+		}
+
+		hasName := false
+		for _, matcher := range matchers {
+			if matcher.Name == labels.MetricName {
+				hasName = true
+			}
+		}
+
+		var exprs []logicalplan.Expr
+		if hasName {
+			if _, exprs, err = QueryToFilterExprs(m); err != nil {
+				return nil, err
+			}
+		} else if exprs, err = MatchersToBooleanExpressions(matchers); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to build match %q", m)
+		}
+		selectorExprs = append(selectorExprs, logicalplan.And(exprs...))
+	}
+
+	exprs := []logicalplan.Expr{logicalplan.Or(selectorExprs...)}
+	if !start.IsZero() {
+		exprs = append(exprs, logicalplan.Col("timestamp").GtEq(logicalplan.Literal(timestamp.FromTime(start))))
+	}
+	if !end.IsZero() {
+		exprs = append(exprs, logicalplan.Col("timestamp").LtEq(logicalplan.Literal(timestamp.FromTime(end))))
+	}
+	return logicalplan.And(exprs...), nil
+}
+
 func MatcherToBooleanExpression(matcher *labels.Matcher) (logicalplan.Expr, error) {
 	ref := logicalplan.Col("labels." + matcher.Name)
 	switch matcher.Type {
@@ -184,6 +249,13 @@ func MatchersToBooleanExpressions(matchers []*labels.Matcher) ([]logicalplan.Exp
 }
 
 func QueryToFilterExprs(query string) (profile.Meta, []logicalplan.Expr, error) {
+	return queryToFilterExprs(query, false)
+}
+
+// queryToFilterExprs returns the filter of a query. If primary is set, it
+// selects the samples of the primary sample type of the stored profiles
+// rather than the sample type of the query.
+func queryToFilterExprs(query string, primary bool) (profile.Meta, []logicalplan.Expr, error) {
 	parsedSelector, err := parser.ParseMetricSelector(query)
 	if err != nil {
 		return profile.Meta{}, nil, status.Error(codes.InvalidArgument, "failed to parse query")
@@ -216,13 +288,20 @@ func QueryToFilterExprs(query string) (profile.Meta, []logicalplan.Expr, error)
 		return profile.Meta{}, nil, status.Error(codes.InvalidArgument, "failed to build query")
 	}
 
-	exprs := append([]logicalplan.Expr{
-		logicalplan.Col("name").Eq(logicalplan.Literal(name)),
-		logicalplan.Col("sample_type").Eq(logicalplan.Literal(sampleType)),
-		logicalplan.Col("sample_unit").Eq(logicalplan.Literal(sampleUnit)),
+	exprs := []logicalplan.Expr{logicalplan.Col("name").Eq(logicalplan.Literal(name))}
+	if primary {
+		exprs = append(exprs, logicalplan.Col(ColumnPrimary).Eq(logicalplan.Literal(1)))
+	} else {
+		exprs = append(exprs,
+			logicalplan.Col("sample_type").Eq(logicalplan.Literal(sampleType)),
+			logicalplan.Col("sample_unit").Eq(logicalplan.Literal(sampleUnit)),
+		)
+	}
+	exprs = append(exprs,
 		logicalplan.Col("period_type").Eq(logicalplan.Literal(periodType)),
 		logicalplan.Col("period_unit").Eq(logicalplan.Literal(periodUnit)),
-	}, labelFilterExpressions...)
+	)
+	exprs = append(exprs, labelFilterExpressions...)
 
 	deltaPlan := logicalplan.Col("duration").Eq(logicalplan.Literal(0))
 	if delta {
@@ -244,7 +323,8 @@ func (q *Querier) QueryRange(
 	startTime, endTime time.Time,
 	limit uint32,
 ) ([]*pb.MetricsSeries, error) {
-	_, selectorExprs, err := QueryToFilterExprs(query)
+	primary := primarySampleTypeFromContext(ctx)
+	meta, selectorExprs, err := queryToFilterExprs(query, primary)
 	if err != nil {
 		return nil, err
 	}
@@ -252,21 +332,36 @@ func (q *Querier) QueryRange(
 	start := timestamp.FromTime(startTime)
 	end := timestamp.FromTime(endTime)
 
+	// Queries with a step select samples from the start on, like their first
+	// bucket and the rollup at the start do.
+	table := q.rangeTable(ctx, start, end)
+	startExpr := logicalplan.Col("timestamp").Gt(logicalplan.Literal(start))
+	if stepFromContext(ctx) > 0 {
+		startExpr = logicalplan.Col("timestamp").GtEq(logicalplan.Literal(start))
+	}
+
 	exprs := append(
 		selectorExprs,
-		logicalplan.Col("timestamp").Gt(logicalplan.Literal(start)),
+		startExpr,
 		logicalplan.Col("timestamp").Lt(logicalplan.Literal(end)),
 	)
 
 	filterExpr := logicalplan.And(exprs...)
 
+	// Values of different sample types can't be added up.
+	if primary {
+		if _, err := q.primarySampleType(ctx, filterExpr, meta.SampleType); err != nil {
+			return nil, err
+		}
+	}
+
 	resSeries := []*pb.MetricsSeries{}
 	labelsetToIndex := map[string]int{}
 
 	labelSet := labels.Labels{}
 
 	var ar arrow.Record
-	err = q.engine.ScanTable(q.tableName).
+	err = q.engine.ScanTable(table).
 		Filter(filterExpr).
 		Aggregate(
 			logicalplan.Sum(logicalplan.Col("value")),
@@ -373,7 +468,7 @@ func (q *Querier) ProfileTypes(
 	seen := map[string]struct{}{}
 	res := []*pb.ProfileType{}
 
-	err := q.engine.ScanTable(q.tableName).
+	err := q.engine.ScanTable(q.table(ctx)).
 		Distinct(
 			logicalplan.Col(ColumnName),
 			logicalplan.Col(ColumnSampleType),
@@ -468,6 +563,20 @@ func BinaryFieldFromRecord(ar arrow.Record, name string) (*array.Binary, error)
 	return col, nil
 }
 
+func Int64FieldFromRecord(ar arrow.Record, name string) (*array.Int64, error) {
+	indices := ar.Schema().FieldIndices(name)
+	if len(indices) != 1 {
+		return nil, fmt.Errorf("expected 1 column named %q, got %d", name, len(indices))
+	}
+
+	col, ok := ar.Column(indices[0]).(*array.Int64)
+	if !ok {
+		return nil, fmt.Errorf("expected column %q to be an int64 column, got %T", name, ar.Column(indices[0]))
+	}
+
+	return col, nil
+}
+
 func BooleanFieldFromRecord(ar arrow.Record, name string) (*array.Boolean, error) {
 	indices := ar.Schema().FieldIndices(name)
 	if len(indices) != 1 {
@@ -541,7 +650,8 @@ func (q *Querier) findSingle(ctx context.Context, query string, t time.Time) (ar
 	span.SetAttributes(attribute.Int64("time", t.Unix()))
 	defer span.End()
 
-	meta, selectorExprs, err := QueryToFilterExprs(query)
+	primary := primarySampleTypeFromContext(ctx)
+	meta, selectorExprs, err := queryToFilterExprs(query, primary)
 	if err != nil {
 		return nil, "", profile.Meta{}, err
 	}
@@ -552,9 +662,15 @@ func (q *Querier) findSingle(ctx context.Context, query string, t time.Time) (ar
 			logicalplan.Col("timestamp").Eq(logicalplan.Literal(requestedTime)),
 		)...,
 	)
+	if primary {
+		meta.SampleType, err = q.primarySampleType(ctx, filterExpr, meta.SampleType)
+		if err != nil {
+			return nil, "", profile.Meta{}, err
+		}
+	}
 
 	var ar arrow.Record
-	err = q.engine.ScanTable(q.tableName).
+	err = q.engine.ScanTable(q.table(ctx)).
 		Filter(filterExpr).
 		Aggregate(
 			logicalplan.Sum(logicalplan.Col("value")),
@@ -605,11 +721,70 @@ func (q *Querier) QueryMerge(ctx context.Context, query string, start, end time.
 	return p, nil
 }
 
-func (q *Querier) selectMerge(ctx context.Context, query string, startTime, endTime time.Time) (arrow.Record, string, profile.Meta, error) {
+// QueryByTraceID merges the samples matching the query that were captured
+// during the given trace, and span of it if the span ID isn't empty. Samples
+// are associated with a trace and span by trace_id and span_id pprof labels,
+// or labels of the whole series, see profilestore.TraceIDMetadataKey for
+// labeling the series of a write.
+func (q *Querier) QueryByTraceID(ctx context.Context, query, traceID, spanID string, start, end time.Time) (*profile.Profile, error) {
+	ctx, span := q.tracer.Start(ctx, "QueryByTraceID")
+	span.SetAttributes(attribute.String("trace_id", traceID), attribute.String("span_id", spanID))
+	defer span.End()
+
+	// Both labels are queried separately, as a disjunction over a label
+	// that is missing from the table would match every row.
+	var (
+		r           arrow.Record
+		valueColumn string
+		meta        profile.Meta
+		err         error
+	)
+	for _, column := range []string{ColumnPprofLabels, ColumnLabels} {
+		filters := []logicalplan.Expr{
+			logicalplan.Col(column + "." + TraceIDLabel).Eq(logicalplan.Literal(traceID)),
+		}
+		if spanID != "" {
+			filters = append(filters, logicalplan.Col(column+"."+SpanIDLabel).Eq(logicalplan.Literal(spanID)))
+		}
+		r, valueColumn, meta, err = q.selectMerge(ctx, query, start, end, filters...)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && r.NumRows() > 0 {
+			break
+		}
+		if r != nil {
+			r.Release()
+			r = nil
+		}
+	}
+	if r == nil {
+		if spanID != "" {
+			return nil, status.Errorf(codes.NotFound, "could not find samples for span %q of trace %q", spanID, traceID)
+		}
+		return nil, status.Errorf(codes.NotFound, "could not find samples for trace %q", traceID)
+	}
+	defer r.Release()
+
+	p, err := q.arrowRecordToProfile(
+		ctx,
+		r,
+		valueColumn,
+		meta,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (q *Querier) selectMerge(ctx context.Context, query string, startTime, endTime time.Time, filters ...logicalplan.Expr) (arrow.Record, string, profile.Meta, error) {
 	ctx, span := q.tracer.Start(ctx, "selectMerge")
 	defer span.End()
 
-	meta, selectorExprs, err := QueryToFilterExprs(query)
+	primary := primarySampleTypeFromContext(ctx)
+	meta, selectorExprs, err := queryToFilterExprs(query, primary)
 	if err != nil {
 		return nil, "", profile.Meta{}, err
 	}
@@ -620,17 +795,34 @@ func (q *Querier) selectMerge(ctx context.Context, query string, startTime, endT
 	filterExpr := logicalplan.And(
 		append(
 			selectorExprs,
-			logicalplan.Col("timestamp").Gt(logicalplan.Literal(start)),
-			logicalplan.Col("timestamp").Lt(logicalplan.Literal(end)),
+			append(
+				filters,
+				logicalplan.Col("timestamp").Gt(logicalplan.Literal(start)),
+				logicalplan.Col("timestamp").Lt(logicalplan.Literal(end)),
+			)...,
 		)...,
 	)
+	if primary {
+		meta.SampleType, err = q.primarySampleType(ctx, filterExpr, meta.SampleType)
+		if err != nil {
+			return nil, "", profile.Meta{}, err
+		}
+	}
+
+	// Values are summed per timestamp first if they aren't summed over
+	// time.
+	aggregation := q.aggregation(meta.SampleType.Type)
+	groupBy := []logicalplan.Expr{logicalplan.Col("stacktrace")}
+	if aggregation != AggregationSum {
+		groupBy = append(groupBy, logicalplan.Col("timestamp"))
+	}
 
 	var ar arrow.Record
-	err = q.engine.ScanTable(q.tableName).
+	err = q.engine.ScanTable(q.table(ctx)).
 		Filter(filterExpr).
 		Aggregate(
 			logicalplan.Sum(logicalplan.Col("value")),
-			logicalplan.Col("stacktrace"),
+			groupBy...,
 		).
 		Execute(ctx, func(r arrow.Record) error {
 			r.Retain()
@@ -640,6 +832,14 @@ func (q *Querier) selectMerge(ctx context.Context, query string, startTime, endT
 	if err != nil {
 		return nil, "", profile.Meta{}, err
 	}
+	if ar != nil && aggregation != AggregationSum {
+		aggregated, err := aggregateOverTime(ar, "sum(value)", aggregation)
+		ar.Release()
+		if err != nil {
+			return nil, "", profile.Meta{}, err
+		}
+		ar = aggregated
+	}
 
 	return ar,
 		"sum(value)",
@@ -0,0 +1,124 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+type IngesterOption func(*Ingester)
+
+// rollup is a table of the sums of the values of profiles at a granularity
+// in milliseconds.
+type rollup struct {
+	table       Table
+	granularity int64
+}
+
+// WithRollups appends the sum of the values of each ingested profile to the
+// rollup table as well, at its timestamp rounded down to the granularity.
+// Range queries read these single rows instead of every sample, see
+// WithRollupTable. The rollup table has the schema of the table of the
+// samples. Given more than once, profiles are rolled up into each table at
+// its granularity.
+func WithRollups(table Table, granularity time.Duration) IngesterOption {
+	return func(ing *Ingester) {
+		ing.rollups = append(ing.rollups, rollup{table: table, granularity: granularity.Milliseconds()})
+	}
+}
+
+// ingestRollup appends the sum of the values of the profile to the rollup
+// table.
+func (ing Ingester) ingestRollup(ctx context.Context, r rollup, ls labels.Labels, p *profile.NormalizedProfile) error {
+	sum := int64(0)
+	for _, s := range p.Samples {
+		sum += s.Value
+	}
+
+	meta := p.Meta
+	meta.Timestamp -= meta.Timestamp % r.granularity
+	buffer, err := NormalizedProfileToParquetBuffer(ing.schema, ls, &profile.NormalizedProfile{
+		Meta:    meta,
+		Samples: []*profile.NormalizedSample{{Value: sum}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.table.InsertBuffer(ctx, buffer)
+	return err
+}
+
+type stepKey struct{}
+
+// WithStep tells range queries that their samples are summed in buckets of
+// the step aligned to their start, so they can read rollups instead of
+// samples. Samples at exactly the start are then selected too.
+func WithStep(ctx context.Context, step time.Duration) context.Context {
+	return context.WithValue(ctx, stepKey{}, step)
+}
+
+func stepFromContext(ctx context.Context) time.Duration {
+	step, _ := ctx.Value(stepKey{}).(time.Duration)
+	return step
+}
+
+// rollupTable is the name of a table of rollups at a granularity in
+// milliseconds.
+type rollupTable struct {
+	name        string
+	granularity int64
+}
+
+// WithRollupTable reads the rollups of the table written by ingesters with
+// the same granularity for range queries whose start, end and step are
+// multiples of the granularity. Their buckets then consist of whole
+// rollups, whose sums are the same as the ones of the samples. Given more
+// than once, queries read the table of the coarsest granularity they can.
+func WithRollupTable(tableName string, granularity time.Duration) QuerierOption {
+	return func(q *Querier) {
+		if g := granularity.Milliseconds(); g > 0 {
+			q.rollupTables = append(q.rollupTables, rollupTable{name: tableName, granularity: g})
+		}
+	}
+}
+
+// rangeTable returns the table a range query from start to end reads,
+// which is the rollup table of the coarsest granularity its buckets consist
+// of whole rollups of.
+func (q *Querier) rangeTable(ctx context.Context, start, end int64) string {
+	step := stepFromContext(ctx).Milliseconds()
+	if len(q.rollupTables) == 0 || step <= 0 || quarantinedFromContext(ctx) {
+		return q.table(ctx)
+	}
+	var coarsest *rollupTable
+	for i, r := range q.rollupTables {
+		g := r.granularity
+		if step%g != 0 || start%g != 0 || end%g != 0 {
+			continue
+		}
+		if coarsest == nil || g > coarsest.granularity {
+			coarsest = &q.rollupTables[i]
+		}
+	}
+	if coarsest == nil {
+		return TenantTableName(ctx, q.tableName)
+	}
+	return TenantTableName(ctx, coarsest.name)
+}
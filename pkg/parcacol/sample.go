@@ -136,6 +136,13 @@ func SampleToParquetRow(
 		case ColumnPeriodUnit:
 			row = append(row, parquet.ValueOf(meta.PeriodType.Unit).Level(0, 0, columnIndex))
 			columnIndex++
+		case ColumnPrimary:
+			primary := int64(0)
+			if meta.Primary {
+				primary = 1
+			}
+			row = append(row, parquet.ValueOf(primary).Level(0, 0, columnIndex))
+			columnIndex++
 		case ColumnSampleType:
 			row = append(row, parquet.ValueOf(meta.SampleType.Type).Level(0, 0, columnIndex))
 			columnIndex++
@@ -29,11 +29,19 @@ const (
 	ColumnPeriodUnit     = "period_unit"
 	ColumnPprofLabels    = "pprof_labels"
 	ColumnPprofNumLabels = "pprof_num_labels"
+	ColumnPrimary        = "primary"
 	ColumnSampleType     = "sample_type"
 	ColumnSampleUnit     = "sample_unit"
 	ColumnStacktrace     = "stacktrace"
 	ColumnTimestamp      = "timestamp"
 	ColumnValue          = "value"
+
+	// TraceIDLabel is the label associating samples with the trace they
+	// were captured during.
+	TraceIDLabel = "trace_id"
+	// SpanIDLabel is the label associating samples with the span of their
+	// trace they were captured during.
+	SpanIDLabel = "span_id"
 )
 
 func Schema() (*dynparquet.Schema, error) {
@@ -99,6 +107,15 @@ func Schema() (*dynparquet.Schema, error) {
 					Nullable: true,
 				},
 				Dynamic: true,
+			}, {
+				// Primary is 1 for the samples of the default sample type
+				// of their profile, and 0 otherwise.
+				Name: ColumnPrimary,
+				StorageLayout: &schemapb.StorageLayout{
+					Type:     schemapb.StorageLayout_TYPE_INT64,
+					Encoding: schemapb.StorageLayout_ENCODING_RLE_DICTIONARY,
+				},
+				Dynamic: false,
 			}, {
 				Name: ColumnSampleType,
 				StorageLayout: &schemapb.StorageLayout{
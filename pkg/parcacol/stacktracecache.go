@@ -0,0 +1,101 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StacktraceCache remembers the IDs of the stacktraces known to be stored in
+// the metastore. The IDs of stacktraces are derived from their locations, so
+// normalizers only ask the metastore to create stacktraces missing from the
+// cache. Agents profiling the same binaries write the same stacktraces over
+// and over again. The cache is bounded, the least recently used IDs are
+// evicted first.
+type StacktraceCache struct {
+	mtx  sync.Mutex
+	size int
+	ids  map[string]*list.Element
+	lru  *list.List
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+}
+
+// NewStacktraceCache returns a cache of the IDs of up to size stacktraces.
+func NewStacktraceCache(reg prometheus.Registerer, size int) *StacktraceCache {
+	c := &StacktraceCache{
+		size: size,
+		ids:  make(map[string]*list.Element, size),
+		lru:  list.New(),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_normalizer_stacktrace_cache_hits_total",
+			Help: "Number of stacktraces of written profiles known to be stored in the metastore.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_normalizer_stacktrace_cache_misses_total",
+			Help: "Number of stacktraces of written profiles looked up in the metastore.",
+		}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_normalizer_stacktrace_cache_evictions_total",
+			Help: "Number of stacktraces evicted from the cache.",
+		}),
+	}
+	reg.MustRegister(c.hits, c.misses, c.evictions)
+	return c
+}
+
+// contains returns whether the stacktrace of the ID is known to be stored.
+func (c *StacktraceCache) contains(id string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.ids[id]
+	if !ok {
+		c.misses.Inc()
+		return false
+	}
+	c.lru.MoveToFront(e)
+	c.hits.Inc()
+	return true
+}
+
+// add remembers that the stacktrace of the ID is stored.
+func (c *StacktraceCache) add(id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if e, ok := c.ids[id]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.ids, oldest.Value.(string))
+		c.evictions.Inc()
+	}
+	c.ids[id] = c.lru.PushFront(id)
+}
+
+// Len returns the number of stacktraces in the cache.
+func (c *StacktraceCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+)
+
+// countingMetastore counts the stacktraces it is asked to create.
+type countingMetastore struct {
+	pb.MetastoreServiceClient
+	stacktraces int
+}
+
+func (m *countingMetastore) GetOrCreateStacktraces(ctx context.Context, in *pb.GetOrCreateStacktracesRequest, opts ...grpc.CallOption) (*pb.GetOrCreateStacktracesResponse, error) {
+	m.stacktraces += len(in.Stacktraces)
+	return m.MetastoreServiceClient.GetOrCreateStacktraces(ctx, in, opts...)
+}
+
+func TestStacktraceCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	m := &countingMetastore{MetastoreServiceClient: metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, trace.NewNoopTracerProvider().Tracer("")))}
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "../query/testdata/alloc_objects.pb.gz")))
+
+	want, err := NewNormalizer(m).NormalizePprof(ctx, "memory", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+	uncached := m.stacktraces
+
+	cache := NewStacktraceCache(prometheus.NewRegistry(), len(p.Sample))
+	n := NewNormalizer(m, WithStacktraceCache(cache))
+	m.stacktraces = 0
+	got, err := n.NormalizePprof(ctx, "memory", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	// Samples of the same stacktrace are only created once.
+	require.LessOrEqual(t, m.stacktraces, uncached)
+	require.Equal(t, m.stacktraces, cache.Len())
+
+	// The stacktraces of the profile are known the second time.
+	m.stacktraces = 0
+	got, err = n.NormalizePprof(ctx, "memory", map[string]struct{}{}, p, false)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, 0, m.stacktraces)
+	require.Equal(t, float64(len(p.Sample)), testutil.ToFloat64(cache.hits))
+}
+
+func TestStacktraceCacheEviction(t *testing.T) {
+	t.Parallel()
+
+	c := NewStacktraceCache(prometheus.NewRegistry(), 2)
+	c.add("a")
+	c.add("b")
+	require.True(t, c.contains("a"))
+	c.add("c")
+
+	// b is the least recently used.
+	require.False(t, c.contains("b"))
+	require.True(t, c.contains("a"))
+	require.True(t, c.contains("c"))
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, 1.0, testutil.ToFloat64(c.evictions))
+}
@@ -0,0 +1,297 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v8/arrow"
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/dynparquet"
+	"github.com/polarsignals/frostdb/query/logicalplan"
+
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// TenantTableName returns the name of the table of the tenant of the context
+// corresponding to the table of the default tenant of the given name. Tables
+// of tenants are prefixed with the tenant.
+func TenantTableName(ctx context.Context, name string) string {
+	if id, ok := tenant.FromContext(ctx); ok {
+		return id + "." + name
+	}
+	return name
+}
+
+// ErrTenantNotAllowed and ErrTooManyTenants are returned when tables would be
+// created for a tenant not allowed to write, see WithAllowedTenants and
+// WithMaxTenants.
+var (
+	ErrTenantNotAllowed = errors.New("tenant is not allowed to write profiles")
+	ErrTooManyTenants   = errors.New("maximum number of tenants reached")
+)
+
+// TenantTables resolves the tables of tenants. They are created by the first
+// write of a tenant, reads never create tables.
+type TenantTables struct {
+	db       *frostdb.DB
+	config   *frostdb.TableConfig
+	defaults map[string]*frostdb.Table
+	names    map[*frostdb.Table]string
+
+	allowed  map[string]struct{}
+	max      int
+	registry string
+
+	mtx     sync.Mutex
+	tenants map[string]struct{}
+	tables  map[string]*frostdb.Table
+}
+
+// TenantTablesOption configures TenantTables.
+type TenantTablesOption func(*TenantTables)
+
+// WithAllowedTenants only creates the tables of the given tenants, writes of
+// other tenants fail with ErrTenantNotAllowed.
+func WithAllowedTenants(ids ...string) TenantTablesOption {
+	return func(t *TenantTables) {
+		t.allowed = make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			t.allowed[id] = struct{}{}
+		}
+	}
+}
+
+// WithMaxTenants creates the tables of up to n tenants, writes of further
+// tenants fail with ErrTooManyTenants. Unlimited if 0.
+func WithMaxTenants(n int) TenantTablesOption {
+	return func(t *TenantTables) {
+		t.max = n
+	}
+}
+
+// WithTenantRegistry records the tenants tables are created for in the file
+// at the path, so that the tables of tenants replayed from the WAL are known
+// after restarts, and count towards the maximum number of tenants.
+func WithTenantRegistry(path string) TenantTablesOption {
+	return func(t *TenantTables) {
+		t.registry = path
+	}
+}
+
+// NewTenantTables resolves the tables of tenants corresponding to the tables
+// of the default tenant, given by their names. They are created with the
+// config.
+func NewTenantTables(db *frostdb.DB, config *frostdb.TableConfig, tables map[string]*frostdb.Table, opts ...TenantTablesOption) (*TenantTables, error) {
+	names := make(map[*frostdb.Table]string, len(tables))
+	for name, table := range tables {
+		names[table] = name
+	}
+	t := &TenantTables{
+		db:       db,
+		config:   config,
+		defaults: tables,
+		names:    names,
+		tenants:  map[string]struct{}{},
+		tables:   map[string]*frostdb.Table{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if err := t.loadRegistry(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadRegistry resolves the tables of the tenants of the registry that exist
+// in the database.
+func (t *TenantTables) loadRegistry() error {
+	if t.registry == "" {
+		return nil
+	}
+	b, err := os.ReadFile(t.registry)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read tenant registry: %w", err)
+	}
+	for _, id := range strings.Fields(string(b)) {
+		t.tenants[id] = struct{}{}
+		for name := range t.defaults {
+			table, err := t.db.GetTable(id + "." + name)
+			if err == nil {
+				t.tables[id+"."+name] = table
+			}
+		}
+	}
+	return nil
+}
+
+// Table returns the table of the tenant of the context corresponding to the
+// table of the default tenant, creating it if it doesn't exist yet. It is
+// meant for writes.
+func (t *TenantTables) Table(ctx context.Context, table *frostdb.Table) (*frostdb.Table, error) {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return table, nil
+	}
+	name, ok := t.names[table]
+	if !ok {
+		return nil, fmt.Errorf("table has no tenant tables")
+	}
+	name = TenantTableName(ctx, name)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if table, ok := t.tables[name]; ok {
+		return table, nil
+	}
+	if _, ok := t.tenants[id]; !ok {
+		if _, ok := t.allowed[id]; t.allowed != nil && !ok {
+			return nil, ErrTenantNotAllowed
+		}
+		if t.max > 0 && len(t.tenants) >= t.max {
+			return nil, ErrTooManyTenants
+		}
+		if err := t.addTenant(id); err != nil {
+			return nil, err
+		}
+	}
+	table, err := t.db.Table(name, t.config)
+	if err != nil {
+		return nil, err
+	}
+	t.tables[name] = table
+	return table, nil
+}
+
+// addTenant adds the tenant to the tenants tables are created for, t.mtx must
+// be held.
+func (t *TenantTables) addTenant(id string) error {
+	if t.registry != "" {
+		// The tenant is recorded before its tables are written to, so that
+		// no table replayed from the WAL is missed.
+		f, err := os.OpenFile(t.registry, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("open tenant registry: %w", err)
+		}
+		_, err = f.WriteString(id + "\n")
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("record tenant in registry: %w", err)
+		}
+	}
+	t.tenants[id] = struct{}{}
+	return nil
+}
+
+// RegisterTable makes a table of a tenant created outside of TenantTables
+// known, like a table restored from a snapshot. Its tenant is recorded in the
+// registry, regardless of the allowed tenants and the maximum number of them.
+// Tables of the default tenant are ignored.
+func (t *TenantTables) RegisterTable(name string) error {
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return nil
+	}
+	id := name[:i]
+	if _, ok := t.defaults[name[i+1:]]; !ok {
+		return fmt.Errorf("table %s is no table of a tenant", name)
+	}
+	table, err := t.db.GetTable(name)
+	if err != nil {
+		return err
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if _, ok := t.tenants[id]; !ok {
+		if err := t.addTenant(id); err != nil {
+			return err
+		}
+	}
+	t.tables[name] = table
+	return nil
+}
+
+// GetTable implements logicalplan.TableProvider for query engines. Tenants
+// that haven't written any profiles query empty tables, which aren't
+// created.
+func (t *TenantTables) GetTable(name string) logicalplan.TableReader {
+	if table, _ := t.db.TableProvider().GetTable(name).(*frostdb.Table); table != nil {
+		return table
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		if table, ok := t.defaults[name[i+1:]]; ok {
+			return emptyTable{schema: table.Schema()}
+		}
+	}
+	return nil
+}
+
+// Tables returns the tables of the tenants by their names, and the tables of
+// the default tenant.
+func (t *TenantTables) Tables() map[string]*frostdb.Table {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	tables := make(map[string]*frostdb.Table, len(t.defaults)+len(t.tables))
+	for name, table := range t.defaults {
+		tables[name] = table
+	}
+	for name, table := range t.tables {
+		tables[name] = table
+	}
+	return tables
+}
+
+// emptyTable is a table without any rows.
+type emptyTable struct {
+	schema *dynparquet.Schema
+}
+
+func (e emptyTable) View(fn func(tx uint64) error) error {
+	return fn(0)
+}
+
+func (e emptyTable) Iterator(context.Context, uint64, memory.Allocator, *arrow.Schema, []logicalplan.Expr, []logicalplan.Expr, logicalplan.Expr, []logicalplan.Expr, func(arrow.Record) error) error {
+	return nil
+}
+
+func (e emptyTable) SchemaIterator(context.Context, uint64, memory.Allocator, []logicalplan.Expr, []logicalplan.Expr, logicalplan.Expr, []logicalplan.Expr, func(arrow.Record) error) error {
+	return nil
+}
+
+func (e emptyTable) ArrowSchema(context.Context, uint64, memory.Allocator, []logicalplan.Expr, []logicalplan.Expr, logicalplan.Expr, []logicalplan.Expr) (*arrow.Schema, error) {
+	return arrow.NewSchema(nil, nil), nil
+}
+
+func (e emptyTable) Schema() *dynparquet.Schema {
+	return e.schema
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parcacol
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+func TestTenantTables(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	col, err := frostdb.New(logger, reg)
+	require.NoError(t, err)
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := Schema()
+	require.NoError(t, err)
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+	tenants, err := NewTenantTables(colDB, frostdb.NewTableConfig(schema), map[string]*frostdb.Table{"stacktraces": table})
+	require.NoError(t, err)
+
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, tracer))
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "../query/testdata/alloc_objects.pb.gz")))
+
+	teamA := tenant.WithTenant(ctx, "team-a")
+	teamB := tenant.WithTenant(ctx, "team-b")
+	for _, w := range []struct {
+		ctx context.Context
+		job string
+	}{
+		{ctx, "default"},
+		{teamA, "a"},
+	} {
+		tenantTable, err := tenants.Table(w.ctx, table)
+		require.NoError(t, err)
+		ingester := NewIngester(logger, NewNormalizer(m), tenantTable, schema)
+		require.NoError(t, ingester.Ingest(w.ctx, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: w.job}}, p, false))
+	}
+
+	defaultTable, err := tenants.Table(ctx, table)
+	require.NoError(t, err)
+	require.Same(t, table, defaultTable)
+
+	querier := NewQuerier(
+		tracer,
+		query.NewEngine(memory.DefaultAllocator, tenants),
+		"stacktraces",
+		m,
+	)
+	for _, q := range []struct {
+		ctx  context.Context
+		jobs []string
+	}{
+		{ctx, []string{"default"}},
+		{teamA, []string{"a"}},
+		// Tenants that haven't written profiles query empty tables.
+		{teamB, []string{}},
+	} {
+		jobs, err := querier.Values(q.ctx, "job", nil, time.Time{}, time.Time{})
+		require.NoError(t, err)
+		require.Equal(t, q.jobs, jobs)
+	}
+
+	_, err = querier.QueryRange(teamB, "memory:alloc_objects:count:space:bytes", time.Unix(0, 0), time.Now(), 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Queries don't create the tables of tenants.
+	_, err = colDB.GetTable("team-b.stacktraces")
+	require.Error(t, err)
+	require.Equal(t, []string{"stacktraces", "team-a.stacktraces"}, tableNames(tenants))
+}
+
+func tableNames(tenants *TenantTables) []string {
+	names := []string{}
+	for name := range tenants.Tables() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTenantTablesLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	col, err := frostdb.New(log.NewNopLogger(), prometheus.NewRegistry())
+	require.NoError(t, err)
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := Schema()
+	require.NoError(t, err)
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+
+	tenants, err := NewTenantTables(colDB, frostdb.NewTableConfig(schema), map[string]*frostdb.Table{"stacktraces": table},
+		WithAllowedTenants("team-a", "team-b", "team-c"),
+		WithMaxTenants(2),
+	)
+	require.NoError(t, err)
+
+	_, err = tenants.Table(tenant.WithTenant(ctx, "team-d"), table)
+	require.ErrorIs(t, err, ErrTenantNotAllowed)
+	for _, id := range []string{"team-a", "team-b", "team-a"} {
+		_, err = tenants.Table(tenant.WithTenant(ctx, id), table)
+		require.NoError(t, err)
+	}
+	_, err = tenants.Table(tenant.WithTenant(ctx, "team-c"), table)
+	require.ErrorIs(t, err, ErrTooManyTenants)
+
+	// The default tenant is never limited.
+	_, err = tenants.Table(ctx, table)
+	require.NoError(t, err)
+}
+
+func TestTenantTablesRegistry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	dir := t.TempDir()
+	registry := filepath.Join(dir, "tenants")
+	schema, err := Schema()
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, tracer))
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "../query/testdata/alloc_objects.pb.gz")))
+
+	open := func() (*frostdb.ColumnStore, *TenantTables, *frostdb.Table) {
+		col, err := frostdb.New(logger, prometheus.NewRegistry(), frostdb.WithWAL(), frostdb.WithStoragePath(dir))
+		require.NoError(t, err)
+		require.NoError(t, col.ReplayWALs(ctx))
+		colDB, err := col.DB(ctx, "parca")
+		require.NoError(t, err)
+		table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+		require.NoError(t, err)
+		tenants, err := NewTenantTables(colDB, frostdb.NewTableConfig(schema), map[string]*frostdb.Table{"stacktraces": table},
+			WithTenantRegistry(registry),
+			WithMaxTenants(1),
+		)
+		require.NoError(t, err)
+		return col, tenants, table
+	}
+
+	col, tenants, table := open()
+	teamA := tenant.WithTenant(ctx, "team-a")
+	tenantTable, err := tenants.Table(teamA, table)
+	require.NoError(t, err)
+	ingester := NewIngester(logger, NewNormalizer(m), tenantTable, schema)
+	require.NoError(t, ingester.Ingest(teamA, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}}, p, false))
+	require.NoError(t, col.Close())
+
+	// The tables of tenants replayed from the WAL are known after a
+	// restart, and count towards the maximum number of tenants.
+	col, tenants, table = open()
+	t.Cleanup(func() { col.Close() })
+	require.Equal(t, []string{"stacktraces", "team-a.stacktraces"}, tableNames(tenants))
+	_, err = tenants.Table(tenant.WithTenant(ctx, "team-b"), table)
+	require.ErrorIs(t, err, ErrTooManyTenants)
+
+	// Tables created outside of them, like by restoring snapshots, are
+	// registered regardless of the limits.
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	_, err = colDB.Table("team-b.stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+	require.NoError(t, tenants.RegisterTable("team-b.stacktraces"))
+	require.NoError(t, tenants.RegisterTable("stacktraces"))
+	require.Equal(t, []string{"stacktraces", "team-a.stacktraces", "team-b.stacktraces"}, tableNames(tenants))
+	b, err := os.ReadFile(registry)
+	require.NoError(t, err)
+	require.Equal(t, "team-a\nteam-b\n", string(b))
+}
@@ -0,0 +1,79 @@
+// SKIP LICENSE INSERTION
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package prober
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+type check func() bool
+
+// HTTPProbe represents health and readiness status of given component, and provides HTTP integration.
+type HTTPProbe struct {
+	ready   uint32
+	healthy uint32
+}
+
+// NewHTTP returns HTTPProbe representing readiness and healthiness of given component.
+func NewHTTP() *HTTPProbe {
+	return &HTTPProbe{}
+}
+
+// HealthyHandler returns a HTTP Handler which responds health checks.
+func (p *HTTPProbe) HealthyHandler(logger log.Logger) http.HandlerFunc {
+	return p.handler(logger, p.IsHealthy)
+}
+
+// ReadyHandler returns a HTTP Handler which responds readiness checks.
+func (p *HTTPProbe) ReadyHandler(logger log.Logger) http.HandlerFunc {
+	return p.handler(logger, p.IsReady)
+}
+
+func (p *HTTPProbe) handler(logger log.Logger, c check) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if !c() {
+			http.Error(w, "NOT OK", http.StatusServiceUnavailable)
+			return
+		}
+		if _, err := io.WriteString(w, "OK"); err != nil {
+			level.Error(logger).Log("msg", "failed to write probe response", "err", err)
+		}
+	}
+}
+
+// IsReady returns true if component is ready.
+func (p *HTTPProbe) IsReady() bool {
+	return atomic.LoadUint32(&p.ready) > 0
+}
+
+// IsHealthy returns true if component is healthy.
+func (p *HTTPProbe) IsHealthy() bool {
+	return atomic.LoadUint32(&p.healthy) > 0
+}
+
+// Ready sets components status to ready.
+func (p *HTTPProbe) Ready() {
+	atomic.StoreUint32(&p.ready, 1)
+}
+
+// NotReady sets components status to not ready with given error as a cause.
+func (p *HTTPProbe) NotReady(err error) {
+	atomic.StoreUint32(&p.ready, 0)
+}
+
+// Healthy sets components status to healthy.
+func (p *HTTPProbe) Healthy() {
+	atomic.StoreUint32(&p.healthy, 1)
+}
+
+// NotHealthy sets components status to not healthy with given error as a cause.
+func (p *HTTPProbe) NotHealthy(err error) {
+	atomic.StoreUint32(&p.healthy, 0)
+}
@@ -81,6 +81,10 @@ type Meta struct {
 	Timestamp  int64
 	Duration   int64
 	Period     int64
+
+	// Primary is whether the sample type is the default sample type of the
+	// profile.
+	Primary bool
 }
 
 func MetaFromPprof(p *pprofproto.Profile, name string, sampleIndex int) Meta {
@@ -101,5 +105,20 @@ func MetaFromPprof(p *pprofproto.Profile, name string, sampleIndex int) Meta {
 		Period:     p.Period,
 		PeriodType: periodType,
 		SampleType: sampleType,
+		Primary:    sampleIndex == defaultSampleIndex(p),
+	}
+}
+
+// defaultSampleIndex returns the index of the default sample type of a
+// profile, which like in pprof is the last unless the profile says
+// otherwise.
+func defaultSampleIndex(p *pprofproto.Profile) int {
+	if p.DefaultSampleType != 0 {
+		for i, st := range p.SampleType {
+			if st.Type == p.DefaultSampleType {
+				return i
+			}
+		}
 	}
+	return len(p.SampleType) - 1
 }
@@ -0,0 +1,127 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+// Formats of raw profiles.
+const (
+	FormatPprof  = "pprof"
+	FormatFolded = "folded"
+)
+
+// Decoder converts raw profiles of a format other than pprof, once
+// decompressed, into pprof profiles.
+type Decoder interface {
+	Decode(b []byte) (*pprofpb.Profile, error)
+}
+
+// DecoderFunc is a function implementing the Decoder interface.
+type DecoderFunc func(b []byte) (*pprofpb.Profile, error)
+
+func (f DecoderFunc) Decode(b []byte) (*pprofpb.Profile, error) {
+	return f(b)
+}
+
+// defaultDecoders are the decoders of the formats supported out of the box.
+func defaultDecoders() map[string]Decoder {
+	return map[string]Decoder{
+		FormatFolded: DecoderFunc(decodeFolded),
+	}
+}
+
+// decodeFolded decodes collapsed stacks, a line per stack of semicolon
+// separated frames, root first, followed by a space and the number of
+// samples. Folded stacks carry neither addresses nor a time, the functions
+// of the frames are stored as symbolized locations and the profile as taken
+// now.
+func decodeFolded(b []byte) (*pprofpb.Profile, error) {
+	p := &pprofpb.Profile{
+		StringTable: []string{""},
+		TimeNanos:   time.Now().UnixNano(),
+	}
+	str := func(s string) int64 {
+		p.StringTable = append(p.StringTable, s)
+		return int64(len(p.StringTable) - 1)
+	}
+	p.SampleType = []*pprofpb.ValueType{{Type: str("samples"), Unit: str("count")}}
+
+	// There is a location per function, as frames are only told apart by
+	// their name.
+	locations := map[string]uint64{}
+	location := func(name string) uint64 {
+		if id, ok := locations[name]; ok {
+			return id
+		}
+		id := uint64(len(p.Location) + 1)
+		p.Function = append(p.Function, &pprofpb.Function{
+			Id:         id,
+			Name:       str(name),
+			SystemName: int64(len(p.StringTable) - 1),
+		})
+		p.Location = append(p.Location, &pprofpb.Location{
+			Id:   id,
+			Line: []*pprofpb.Line{{FunctionId: id}},
+		})
+		locations[name] = id
+		return id
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, len(b)+1)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing number of samples", n)
+		}
+		value, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid number of samples: %w", n, err)
+		}
+		frames := strings.Split(strings.TrimSpace(line[:i]), ";")
+
+		ids := make([]uint64, 0, len(frames))
+		// Locations of samples are leaf first.
+		for j := len(frames) - 1; j >= 0; j-- {
+			if frames[j] == "" {
+				return nil, fmt.Errorf("line %d: empty frame", n)
+			}
+			ids = append(ids, location(frames[j]))
+		}
+		p.Sample = append(p.Sample, &pprofpb.Sample{
+			LocationId: ids,
+			Value:      []int64{value},
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.Sample) == 0 {
+		return nil, fmt.Errorf("no stacks")
+	}
+	return p, nil
+}
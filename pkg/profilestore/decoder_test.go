@@ -0,0 +1,94 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestDecodeFolded(t *testing.T) {
+	t.Parallel()
+
+	p, err := decodeFolded([]byte("main;run;work 3\n\nmain;run 2\n"))
+	require.NoError(t, err)
+	require.Equal(t, "samples", p.StringTable[p.SampleType[0].Type])
+	require.Equal(t, "count", p.StringTable[p.SampleType[0].Unit])
+	require.NotZero(t, p.TimeNanos)
+	require.Len(t, p.Location, 3)
+
+	stacks := []string{}
+	for _, s := range p.Sample {
+		frames := []string{}
+		for _, id := range s.LocationId {
+			fn := p.Function[p.Location[id-1].Line[0].FunctionId-1]
+			frames = append(frames, p.StringTable[fn.Name])
+		}
+		stacks = append(stacks, strings.Join(frames, ";"))
+		require.Len(t, s.Value, 1)
+	}
+	// Leaf first.
+	require.Equal(t, []string{"work;run;main", "run;main"}, stacks)
+	require.Equal(t, int64(3), p.Sample[0].Value[0])
+	require.Equal(t, int64(2), p.Sample[1].Value[0])
+
+	for _, invalid := range []string{"", "main", "main;run x", "main;;run 1"} {
+		_, err := decodeFolded([]byte(invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestWriteRawFormats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, querier := newTestProfileColumnStore(t, WithDecoder("failing", DecoderFunc(func([]byte) (*pprofpb.Profile, error) {
+		return nil, errors.New("always fails")
+	})))
+
+	write := func(format string, raw string) error {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "perf"}}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: []byte(raw), Format: format}},
+			}},
+		})
+		return err
+	}
+
+	require.NoError(t, write(FormatFolded, "main;run;work 3\nmain;run 2\n"))
+
+	p, err := querier.QueryMerge(ctx, "perf:samples:count::", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	var total int64
+	for _, s := range p.Samples {
+		total += s.Value
+		require.Equal(t, "main", s.Locations[len(s.Locations)-1].Lines[0].Function.Name)
+	}
+	require.Equal(t, int64(5), total)
+
+	require.Equal(t, codes.InvalidArgument, status.Code(write(FormatFolded, "main;run")))
+	require.Equal(t, codes.InvalidArgument, status.Code(write("jfr", "")))
+	require.Equal(t, codes.InvalidArgument, status.Code(write("failing", "")))
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errDecompressionLimit = errors.New("decompressed profile exceeds the limit")
+
+// DecompressionGuard bounds the size profiles may decompress to, both
+// relative to their compressed size and absolutely, so that small payloads
+// can't expand to exhaust the memory of the server. Profiles exceeding the
+// bounds are rejected before they are decompressed in full.
+type DecompressionGuard struct {
+	maxRatio int64
+	maxSize  int64
+
+	exceeded prometheus.Counter
+}
+
+// NewDecompressionGuard bounds the decompressed size of profiles to maxRatio
+// times their compressed size and to maxSize bytes. A bound of 0 is no
+// bound.
+func NewDecompressionGuard(reg prometheus.Registerer, maxRatio, maxSize int64) *DecompressionGuard {
+	g := &DecompressionGuard{
+		maxRatio: maxRatio,
+		maxSize:  maxSize,
+		exceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_profilestore_decompression_limit_exceeded_total",
+			Help: "Number of written profiles rejected for decompressing beyond the maximum ratio or size.",
+		}),
+	}
+	reg.MustRegister(g.exceeded)
+	return g
+}
+
+// limit returns the number of bytes a profile of the compressed size may
+// decompress to, or -1 if it is unbounded.
+func (g *DecompressionGuard) limit(compressed int) int64 {
+	limit := int64(-1)
+	if g.maxRatio > 0 {
+		limit = g.maxRatio * int64(compressed)
+	}
+	if g.maxSize > 0 && (limit < 0 || g.maxSize < limit) {
+		limit = g.maxSize
+	}
+	return limit
+}
+
+// readAll reads the decompressed content of a profile of the compressed
+// size, reading no more than one byte past the limit.
+func (g *DecompressionGuard) readAll(r io.Reader, compressed int) ([]byte, error) {
+	limit := g.limit(compressed)
+	if limit < 0 {
+		return io.ReadAll(r)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(b)) > limit {
+		g.exceeded.Inc()
+		return nil, fmt.Errorf("%w of %d bytes for %d compressed bytes", errDecompressionLimit, limit, compressed)
+	}
+	return b, err
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func gzipBomb(t *testing.T, size int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompressionGuard(t *testing.T) {
+	t.Parallel()
+
+	bomb := gzipBomb(t, 16<<20)
+
+	tests := map[string]struct {
+		maxRatio int64
+		maxSize  int64
+		limit    int64
+	}{
+		"ratio": {
+			maxRatio: 10,
+			limit:    10 * int64(len(bomb)),
+		},
+		"size": {
+			maxSize: 1 << 20,
+			limit:   1 << 20,
+		},
+		"lower of both": {
+			maxRatio: 10,
+			maxSize:  1024,
+			limit:    1024,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			reg := prometheus.NewRegistry()
+			g := NewDecompressionGuard(reg, test.maxRatio, test.maxSize)
+
+			r, err := gzip.NewReader(bytes.NewReader(bomb))
+			require.NoError(t, err)
+			cr := &countingReader{r: r}
+			_, err = g.readAll(cr, len(bomb))
+			require.ErrorIs(t, err, errDecompressionLimit)
+			require.Equal(t, test.limit+1, cr.n)
+			require.Equal(t, float64(1), testutil.ToFloat64(g.exceeded))
+		})
+	}
+}
+
+func TestWriteRawDecompressionGuard(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, encoding string, raw []byte) error {
+		_, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: raw, ContentEncoding: encoding}},
+			}},
+		})
+		return err
+	}
+
+	store, _ := newTestProfileColumnStore(t, WithDecompressionGuard(NewDecompressionGuard(prometheus.NewRegistry(), 100, 0)))
+	require.NoError(t, write(store, "", content))
+	require.Equal(t, codes.InvalidArgument, status.Code(write(store, ContentEncodingGzip, gzipBomb(t, 16<<20))))
+
+	// Uncompressed profiles can't expand.
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	uncompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+	store, _ = newTestProfileColumnStore(t, WithDecompressionGuard(NewDecompressionGuard(prometheus.NewRegistry(), 1, 0)))
+	require.NoError(t, write(store, ContentEncodingNone, uncompressed))
+}
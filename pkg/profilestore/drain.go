@@ -0,0 +1,91 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errDraining is returned by writes that start once the store is drained.
+var errDraining = status.Error(codes.Unavailable, "profile store is shutting down")
+
+// drainer tracks the writes in flight, so they can be waited for on
+// shutdown.
+type drainer struct {
+	mu       sync.Mutex
+	inflight int
+	draining bool
+	done     chan struct{}
+}
+
+// begin registers a write, it returns false once the store is drained. The
+// returned function must be called when the write is done.
+func (d *drainer) begin() (func(), bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return nil, false
+	}
+	d.inflight++
+	return d.end, true
+}
+
+func (d *drainer) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inflight--
+	if d.draining && d.inflight == 0 {
+		close(d.done)
+	}
+}
+
+// drain rejects new writes and waits for the writes in flight to finish.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		d.done = make(chan struct{})
+		if d.inflight == 0 {
+			close(d.done)
+		}
+	}
+	done := d.done
+	d.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain rejects new writes and waits for the writes in flight to finish,
+// including queued writes, or the context to be done. The store has to be
+// drained before the columnstore is closed on shutdown, so no written
+// profile is lost.
+func (s *ProfileColumnStore) Drain(ctx context.Context) error {
+	if err := s.drainer.drain(ctx); err != nil {
+		return err
+	}
+	if s.queue != nil {
+		// No write is queued anymore once drained.
+		s.closeQueue.Do(func() { close(s.queue) })
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestDrainer(t *testing.T) {
+	t.Parallel()
+
+	var d drainer
+	end, ok := d.begin()
+	require.True(t, ok)
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- d.drain(context.Background())
+	}()
+
+	// New writes are rejected while the write in flight is waited for.
+	require.Eventually(t, func() bool {
+		_, ok := d.begin()
+		return !ok
+	}, 5*time.Second, time.Millisecond)
+	select {
+	case <-drained:
+		t.Fatal("drained before the write in flight finished")
+	default:
+	}
+
+	end()
+	require.NoError(t, <-drained)
+
+	// Draining again returns right away.
+	require.NoError(t, d.drain(context.Background()))
+}
+
+func TestDrainerContext(t *testing.T) {
+	t.Parallel()
+
+	var d drainer
+	_, ok := d.begin()
+	require.True(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, d.drain(ctx), context.Canceled)
+}
+
+func TestWriteRawDrained(t *testing.T) {
+	t.Parallel()
+
+	store, _ := newTestProfileColumnStore(t)
+	require.NoError(t, store.Drain(context.Background()))
+
+	_, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,74 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of raw profiles.
+const (
+	ContentEncodingNone = "none"
+	ContentEncodingGzip = "gzip"
+	ContentEncodingZstd = "zstd"
+)
+
+var (
+	errUnknownContentEncoding = errors.New("unknown content encoding")
+	errMalformedGzip          = errors.New("malformed gzip")
+)
+
+// gzipMagic are the first bytes of gzip compressed content.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip returns whether the raw profile is gzip compressed.
+func isGzip(b []byte) bool {
+	return bytes.HasPrefix(b, gzipMagic)
+}
+
+// decompressor returns a reader of the decompressed content of a raw profile.
+// Profiles without content encoding are usually gzip compressed, like the
+// profiles served by net/http/pprof, but some agents send them uncompressed.
+// They are told apart by the gzip magic bytes.
+func decompressor(encoding string, b []byte) (io.ReadCloser, error) {
+	switch encoding {
+	case "":
+		if !isGzip(b) {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		fallthrough
+	case ContentEncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedGzip, err)
+		}
+		return r, nil
+	case ContentEncodingZstd:
+		d, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case ContentEncodingNone:
+		return io.NopCloser(bytes.NewReader(b)), nil
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownContentEncoding, encoding)
+	}
+}
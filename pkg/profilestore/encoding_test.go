@@ -0,0 +1,170 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+func TestWriteRawContentEncoding(t *testing.T) {
+	t.Parallel()
+
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	var zstdCompressed bytes.Buffer
+	w, err := zstd.NewWriter(&zstdCompressed)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	tests := map[string]struct {
+		encoding string
+		raw      []byte
+		code     codes.Code
+	}{
+		"default": {
+			raw:  compressed,
+			code: codes.OK,
+		},
+		"default uncompressed": {
+			raw:  content,
+			code: codes.OK,
+		},
+		"default malformed gzip": {
+			raw:  append([]byte{0x1f, 0x8b}, content...),
+			code: codes.InvalidArgument,
+		},
+		"gzip": {
+			encoding: ContentEncodingGzip,
+			raw:      compressed,
+			code:     codes.OK,
+		},
+		"zstd": {
+			encoding: ContentEncodingZstd,
+			raw:      zstdCompressed.Bytes(),
+			code:     codes.OK,
+		},
+		"none": {
+			encoding: ContentEncodingNone,
+			raw:      content,
+			code:     codes.OK,
+		},
+		"mismatch": {
+			encoding: ContentEncodingZstd,
+			raw:      compressed,
+			code:     codes.InvalidArgument,
+		},
+		"unknown": {
+			encoding: "br",
+			raw:      compressed,
+			code:     codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store, querier := newTestProfileColumnStore(t)
+			_, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+				Series: []*profilestorepb.RawProfileSeries{{
+					Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+						{Name: "__name__", Value: "memory"},
+					}},
+					Samples: []*profilestorepb.RawSample{{
+						RawProfile:      test.raw,
+						ContentEncoding: test.encoding,
+					}},
+				}},
+			})
+			require.Equal(t, test.code, status.Code(err))
+			if test.code != codes.OK {
+				return
+			}
+
+			types, err := querier.ProfileTypes(context.Background())
+			require.NoError(t, err)
+			require.NotEmpty(t, types)
+		})
+	}
+}
+
+func TestWriteRawDetectGzip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	// Both profiles are written without content encoding.
+	write := func(raw []byte) *parcacol.Querier {
+		store, querier := newTestProfileColumnStore(t)
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+					{Name: "__name__", Value: "memory"},
+					{Name: "job", Value: "default"},
+				}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: raw}},
+			}},
+		})
+		require.NoError(t, err)
+		return querier
+	}
+	gzipped, plain := write(compressed), write(content)
+
+	const query = `memory:alloc_objects:count:space:bytes{job="default"}`
+	start, end := time.Unix(0, 0), time.Unix(math.MaxInt32, 0)
+
+	gzippedSeries, err := gzipped.QueryRange(ctx, query, start, end, 0)
+	require.NoError(t, err)
+	plainSeries, err := plain.QueryRange(ctx, query, start, end, 0)
+	require.NoError(t, err)
+	require.Len(t, gzippedSeries, 1)
+	require.Len(t, plainSeries, 1)
+	require.True(t, proto.Equal(gzippedSeries[0], plainSeries[0]))
+
+	gzippedProfile, err := gzipped.QueryMerge(ctx, query, start, end)
+	require.NoError(t, err)
+	plainProfile, err := plain.QueryMerge(ctx, query, start, end)
+	require.NoError(t, err)
+	require.Equal(t, gzippedProfile, plainProfile)
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/goburrow/cache"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Enricher derives labels of written series from an external source, like
+// the team and environment owning their instance.
+type Enricher interface {
+	// Enrich returns the labels to add to the series of the labels. Labels
+	// the series already has are not overwritten.
+	Enrich(ctx context.Context, ls labels.Labels) (labels.Labels, error)
+}
+
+// EnricherFunc is a function implementing the Enricher interface.
+type EnricherFunc func(ctx context.Context, ls labels.Labels) (labels.Labels, error)
+
+func (f EnricherFunc) Enrich(ctx context.Context, ls labels.Labels) (labels.Labels, error) {
+	return f(ctx, ls)
+}
+
+// NopEnricher adds no labels.
+type NopEnricher struct{}
+
+func (NopEnricher) Enrich(context.Context, labels.Labels) (labels.Labels, error) {
+	return nil, nil
+}
+
+// CachingEnricher caches the labels an enricher derives for each series and
+// bounds the time it may take to derive them. Failed lookups aren't cached.
+type CachingEnricher struct {
+	enricher Enricher
+	timeout  time.Duration
+	cache    cache.Cache
+}
+
+// NewCachingEnricher caches up to size label sets of the enricher for the
+// ttl. Lookups taking longer than the timeout fail, unless it is 0.
+func NewCachingEnricher(enricher Enricher, size int, ttl, timeout time.Duration) *CachingEnricher {
+	return &CachingEnricher{
+		enricher: enricher,
+		timeout:  timeout,
+		cache: cache.New(
+			cache.WithMaximumSize(size),
+			cache.WithExpireAfterWrite(ttl),
+		),
+	}
+}
+
+func (e *CachingEnricher) Enrich(ctx context.Context, ls labels.Labels) (labels.Labels, error) {
+	key := ls.Hash()
+	if v, ok := e.cache.GetIfPresent(key); ok {
+		return v.(labels.Labels), nil
+	}
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	derived, err := e.enricher.Enrich(ctx, ls)
+	if err != nil {
+		return nil, err
+	}
+	e.cache.Put(key, derived)
+	return derived, nil
+}
+
+// Close stops the expiry of cached label sets.
+func (e *CachingEnricher) Close() error {
+	return e.cache.Close()
+}
+
+// enrich adds the labels the enricher derives to a written series. If that
+// fails the series is written as is if the store fails open.
+func (s *ProfileColumnStore) enrich(ctx context.Context, ls labels.Labels) (labels.Labels, error) {
+	derived, err := s.enricher.Enrich(ctx, ls)
+	if err != nil {
+		if !s.enrichFailOpen {
+			return nil, status.Errorf(codes.Unavailable, "failed to enrich labels of series %s: %v", ls.String(), err)
+		}
+		level.Warn(s.logger).Log("msg", "failed to enrich labels, writing series as is", "labels", ls.String(), "err", err)
+		return ls, nil
+	}
+	if len(derived) == 0 {
+		return ls, nil
+	}
+
+	enriched := make(labels.Labels, len(ls), len(ls)+len(derived))
+	copy(enriched, ls)
+	for _, l := range derived {
+		if l.Name == IngestSourceLabel || l.Name == ExposedIngestSourceLabel || ls.Has(l.Name) {
+			continue
+		}
+		enriched = append(enriched, l)
+	}
+	return enriched, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+// PartialLabel is the label added to series whose profiles were only
+// partially recovered in lenient parsing mode.
+const PartialLabel = "partial"
+
+// recoverProfile parses the longest prefix of complete top-level fields of a
+// truncated or otherwise malformed profile. Entities referring to strings,
+// functions, mappings or locations that didn't make it into the prefix are
+// removed. An error is returned if no sample could be recovered.
+func recoverProfile(b []byte) (*pprofpb.Profile, error) {
+	var end int
+	for end < len(b) {
+		_, _, n := protowire.ConsumeField(b[end:])
+		if n < 0 {
+			break
+		}
+		end += n
+	}
+
+	p := &pprofpb.Profile{}
+	if err := p.UnmarshalVT(b[:end]); err != nil {
+		return nil, fmt.Errorf("parse profile prefix: %w", err)
+	}
+
+	validString := func(i int64) bool {
+		return i >= 0 && i < int64(len(p.StringTable))
+	}
+
+	if len(p.SampleType) == 0 {
+		return nil, errors.New("no sample types recovered")
+	}
+	for _, st := range p.SampleType {
+		if !validString(st.Type) || !validString(st.Unit) {
+			return nil, errors.New("sample types refer to strings that were not recovered")
+		}
+	}
+	if p.PeriodType != nil && (!validString(p.PeriodType.Type) || !validString(p.PeriodType.Unit)) {
+		p.PeriodType = nil
+	}
+	if !validString(p.DropFrames) {
+		p.DropFrames = 0
+	}
+	if !validString(p.KeepFrames) {
+		p.KeepFrames = 0
+	}
+	if !validString(p.DefaultSampleType) {
+		p.DefaultSampleType = 0
+	}
+	comments := p.Comment[:0]
+	for _, c := range p.Comment {
+		if validString(c) {
+			comments = append(comments, c)
+		}
+	}
+	p.Comment = comments
+
+	mappings := map[uint64]struct{}{}
+	keptMappings := p.Mapping[:0]
+	for _, m := range p.Mapping {
+		if validString(m.Filename) && validString(m.BuildId) {
+			keptMappings = append(keptMappings, m)
+			mappings[m.Id] = struct{}{}
+		}
+	}
+	p.Mapping = keptMappings
+
+	functions := map[uint64]struct{}{}
+	keptFunctions := p.Function[:0]
+	for _, f := range p.Function {
+		if validString(f.Name) && validString(f.SystemName) && validString(f.Filename) {
+			keptFunctions = append(keptFunctions, f)
+			functions[f.Id] = struct{}{}
+		}
+	}
+	p.Function = keptFunctions
+
+	locations := map[uint64]struct{}{}
+	keptLocations := p.Location[:0]
+	for _, l := range p.Location {
+		if !locationRecovered(l, mappings, functions) {
+			continue
+		}
+		keptLocations = append(keptLocations, l)
+		locations[l.Id] = struct{}{}
+	}
+	p.Location = keptLocations
+
+	keptSamples := p.Sample[:0]
+	for _, s := range p.Sample {
+		if len(s.Value) != len(p.SampleType) {
+			continue
+		}
+
+		complete := true
+		for _, id := range s.LocationId {
+			if _, ok := locations[id]; !ok {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			continue
+		}
+
+		labels := s.Label[:0]
+		for _, l := range s.Label {
+			if validString(l.Key) && validString(l.Str) && validString(l.NumUnit) {
+				labels = append(labels, l)
+			}
+		}
+		s.Label = labels
+
+		keptSamples = append(keptSamples, s)
+	}
+	p.Sample = keptSamples
+
+	if len(p.Sample) == 0 {
+		return nil, errors.New("no samples recovered")
+	}
+
+	return p, nil
+}
+
+func locationRecovered(l *pprofpb.Location, mappings, functions map[uint64]struct{}) bool {
+	if l.MappingId != 0 {
+		if _, ok := mappings[l.MappingId]; !ok {
+			return false
+		}
+	}
+	for _, line := range l.Line {
+		if _, ok := functions[line.FunctionId]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// samplesLastProfile returns the test profile encoded with its samples after
+// all other fields, like a profile that is streamed sample by sample, so that
+// a truncation only loses trailing samples.
+func samplesLastProfile(t *testing.T) (*pprofpb.Profile, []byte) {
+	t.Helper()
+
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(content))
+
+	samples := p.Sample
+	p.Sample = nil
+	b, err := p.MarshalVT()
+	require.NoError(t, err)
+	p.Sample = samples
+
+	for _, s := range p.Sample {
+		sb, err := s.MarshalVT()
+		require.NoError(t, err)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+
+	return p, b
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestRecoverProfile(t *testing.T) {
+	t.Parallel()
+
+	p, b := samplesLastProfile(t)
+
+	// Cut into the last sample.
+	recovered, err := recoverProfile(b[:len(b)-3])
+	require.NoError(t, err)
+	require.Len(t, recovered.Sample, len(p.Sample)-1)
+	require.Equal(t, p.StringTable, recovered.StringTable)
+	require.Len(t, recovered.Location, len(p.Location))
+
+	// Profiles encoded in the usual field order lose their string table when
+	// truncated, nothing can be recovered.
+	b, err = p.MarshalVT()
+	require.NoError(t, err)
+	_, err = recoverProfile(b[:len(b)/2])
+	require.Error(t, err)
+
+	_, err = recoverProfile([]byte{0xff, 0xff})
+	require.Error(t, err)
+}
+
+func TestRecoverProfileRemovesDanglingReferences(t *testing.T) {
+	t.Parallel()
+
+	p := &pprofpb.Profile{
+		StringTable: []string{"", "alloc_objects", "count", "main"},
+		SampleType:  []*pprofpb.ValueType{{Type: 1, Unit: 2}},
+		Function: []*pprofpb.Function{
+			{Id: 1, Name: 3},
+			// Refers to a string that was lost.
+			{Id: 2, Name: 10},
+		},
+		Location: []*pprofpb.Location{
+			{Id: 1, Line: []*pprofpb.Line{{FunctionId: 1}}},
+			{Id: 2, Line: []*pprofpb.Line{{FunctionId: 2}}},
+			// Refers to a mapping that was lost.
+			{Id: 3, MappingId: 1, Address: 0x1},
+		},
+		Sample: []*pprofpb.Sample{
+			{LocationId: []uint64{1}, Value: []int64{1}, Label: []*pprofpb.Label{{Key: 10, Str: 3}}},
+			{LocationId: []uint64{2, 1}, Value: []int64{2}},
+			{LocationId: []uint64{3}, Value: []int64{3}},
+			{LocationId: []uint64{4}, Value: []int64{4}},
+			// Wrong number of values.
+			{LocationId: []uint64{1}, Value: []int64{5, 5}},
+		},
+	}
+	b, err := p.MarshalVT()
+	require.NoError(t, err)
+
+	recovered, err := recoverProfile(b)
+	require.NoError(t, err)
+	require.Len(t, recovered.Function, 1)
+	require.Len(t, recovered.Location, 1)
+	require.Len(t, recovered.Sample, 1)
+	require.Equal(t, []int64{1}, recovered.Sample[0].Value)
+	require.Empty(t, recovered.Sample[0].Label)
+}
+
+func TestWriteRawLenientParsing(t *testing.T) {
+	t.Parallel()
+
+	_, b := samplesLastProfile(t)
+	compressed := gzipBytes(t, b)
+
+	tests := map[string]struct {
+		raw []byte
+	}{
+		"truncated profile": {
+			raw: gzipBytes(t, b[:len(b)-3]),
+		},
+		"truncated compression": {
+			raw: compressed[:len(compressed)*3/4],
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := &profilestorepb.WriteRawRequest{
+				Series: []*profilestorepb.RawProfileSeries{{
+					Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+						{Name: "__name__", Value: "memory"},
+						{Name: "job", Value: "test"},
+					}},
+					Samples: []*profilestorepb.RawSample{{RawProfile: test.raw}},
+				}},
+			}
+
+			strict, _ := newTestProfileColumnStore(t)
+			_, err := strict.WriteRaw(context.Background(), req)
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+			lenient, querier := newTestProfileColumnStore(t, WithLenientParsing())
+			_, err = lenient.WriteRaw(context.Background(), req)
+			require.NoError(t, err)
+
+			vals, err := querier.Values(context.Background(), PartialLabel, nil, time.Unix(0, 0), time.Now())
+			require.NoError(t, err)
+			require.Equal(t, []string{"true"}, vals)
+		})
+	}
+
+	// Complete profiles are not marked as partial.
+	lenient, querier := newTestProfileColumnStore(t, WithLenientParsing())
+	_, err := lenient.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+				{Name: "__name__", Value: "memory"},
+			}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: compressed}},
+		}},
+	})
+	require.NoError(t, err)
+	names, err := querier.Labels(context.Background(), nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.NotContains(t, names, PartialLabel)
+}
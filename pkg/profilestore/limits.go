@@ -0,0 +1,88 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+// Reasons written samples are rejected for by the limits.
+const (
+	rejectTooLarge          = "too_large"
+	rejectTooOld            = "too_old"
+	rejectTooNew            = "too_new"
+	rejectTooManySamples    = "too_many_samples"
+	rejectTooManyLabels     = "too_many_labels"
+	rejectLabelNameTooLong  = "label_name_too_long"
+	rejectLabelValueTooLong = "label_value_too_long"
+)
+
+// Limits reject writes of profiles and series exceeding them, unlike the
+// quarantine, which stores such profiles separately. Limits of 0 are
+// disabled.
+type Limits struct {
+	// MaxSampleAge is how far the timestamp of a profile may lie in the
+	// past.
+	MaxSampleAge time.Duration
+	// MaxSampleFuture is how far the timestamp of a profile may lie in
+	// the future.
+	MaxSampleFuture time.Duration
+	// MaxSamples is the number of samples of a profile.
+	MaxSamples int
+	// MaxLabels is the number of labels of a series, including its name.
+	MaxLabels int
+	// MaxLabelNameLength and MaxLabelValueLength are the lengths in bytes
+	// of the names and values of the labels of a series.
+	MaxLabelNameLength  int
+	MaxLabelValueLength int
+}
+
+// checkSeries returns the reason the labels of a series are rejected for
+// along with the error to return, if any.
+func (l Limits) checkSeries(ls labels.Labels) (string, error) {
+	if l.MaxLabels > 0 && len(ls) > l.MaxLabels {
+		return rejectTooManyLabels, status.Errorf(codes.InvalidArgument, "series %s has %d labels, more than the limit of %d", ls.String(), len(ls), l.MaxLabels)
+	}
+	for _, label := range ls {
+		if l.MaxLabelNameLength > 0 && len(label.Name) > l.MaxLabelNameLength {
+			return rejectLabelNameTooLong, status.Errorf(codes.InvalidArgument, "label name %q of series %s is longer than the limit of %d bytes", label.Name, ls.String(), l.MaxLabelNameLength)
+		}
+		if l.MaxLabelValueLength > 0 && len(label.Value) > l.MaxLabelValueLength {
+			return rejectLabelValueTooLong, status.Errorf(codes.InvalidArgument, "value of label %q of series %s is longer than the limit of %d bytes", label.Name, ls.String(), l.MaxLabelValueLength)
+		}
+	}
+	return "", nil
+}
+
+// checkProfile returns the reason the profile of a series is rejected for at
+// the time now along with the error to return, if any.
+func (l Limits) checkProfile(ls labels.Labels, p *pprofpb.Profile, now time.Time) (string, error) {
+	ts := time.Unix(0, p.TimeNanos)
+	if l.MaxSampleAge > 0 && ts.Before(now.Add(-l.MaxSampleAge)) {
+		return rejectTooOld, status.Errorf(codes.OutOfRange, "profile of series %s at %s is older than the limit of %s", ls.String(), ts.UTC().Format(time.RFC3339), l.MaxSampleAge)
+	}
+	if l.MaxSampleFuture > 0 && ts.After(now.Add(l.MaxSampleFuture)) {
+		return rejectTooNew, status.Errorf(codes.OutOfRange, "profile of series %s at %s is further in the future than the limit of %s", ls.String(), ts.UTC().Format(time.RFC3339), l.MaxSampleFuture)
+	}
+	if l.MaxSamples > 0 && len(p.Sample) > l.MaxSamples {
+		return rejectTooManySamples, status.Errorf(codes.ResourceExhausted, "profile of series %s has %d samples, more than the limit of %d", ls.String(), len(p.Sample), l.MaxSamples)
+	}
+	return "", nil
+}
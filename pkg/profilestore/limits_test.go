@@ -0,0 +1,159 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestLimitsCheckSeries(t *testing.T) {
+	t.Parallel()
+
+	limits := Limits{MaxLabels: 2, MaxLabelNameLength: 8, MaxLabelValueLength: 4}
+	for _, tc := range []struct {
+		name   string
+		ls     labels.Labels
+		reason string
+	}{{
+		name: "within",
+		ls:   labels.FromStrings("__name__", "cpu", "job", "api"),
+	}, {
+		name:   "too many labels",
+		ls:     labels.FromStrings("__name__", "cpu", "job", "api", "zone", "a"),
+		reason: rejectTooManyLabels,
+	}, {
+		name:   "name too long",
+		ls:     labels.FromStrings("__name__", "cpu", "instance_id", "a"),
+		reason: rejectLabelNameTooLong,
+	}, {
+		name:   "value too long",
+		ls:     labels.FromStrings("__name__", "cpu", "job", "frontend"),
+		reason: rejectLabelValueTooLong,
+	}} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reason, err := limits.checkSeries(tc.ls)
+			require.Equal(t, tc.reason, reason)
+			if tc.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+
+	reason, err := Limits{}.checkSeries(labels.FromStrings("__name__", "cpu", "instance", strings.Repeat("a", 1<<10)))
+	require.NoError(t, err)
+	require.Empty(t, reason)
+}
+
+func TestLimitsCheckProfile(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_000_000, 0)
+	limits := Limits{MaxSampleAge: time.Hour, MaxSampleFuture: time.Minute, MaxSamples: 1}
+	for _, tc := range []struct {
+		name   string
+		p      *pprofpb.Profile
+		reason string
+		code   codes.Code
+	}{{
+		name: "within",
+		p:    &pprofpb.Profile{TimeNanos: now.Add(-time.Hour).UnixNano(), Sample: []*pprofpb.Sample{{}}},
+	}, {
+		name:   "too old",
+		p:      &pprofpb.Profile{TimeNanos: now.Add(-time.Hour - 1).UnixNano()},
+		reason: rejectTooOld,
+		code:   codes.OutOfRange,
+	}, {
+		name:   "too new",
+		p:      &pprofpb.Profile{TimeNanos: now.Add(time.Minute + 1).UnixNano()},
+		reason: rejectTooNew,
+		code:   codes.OutOfRange,
+	}, {
+		name:   "too many samples",
+		p:      &pprofpb.Profile{TimeNanos: now.UnixNano(), Sample: []*pprofpb.Sample{{}, {}}},
+		reason: rejectTooManySamples,
+		code:   codes.ResourceExhausted,
+	}} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reason, err := limits.checkProfile(labels.FromStrings("__name__", "cpu"), tc.p, now)
+			require.Equal(t, tc.reason, reason)
+			if tc.reason == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
+
+func TestWriteRawLimits(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, ls ...*profilestorepb.Label) error {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: append([]*profilestorepb.Label{{Name: "__name__", Value: "memory"}}, ls...)},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}, {RawProfile: content}},
+			}},
+		})
+		return err
+	}
+
+	store, _ := newTestProfileColumnStore(t, WithLimits(Limits{MaxLabels: 1}))
+	err = write(store, &profilestorepb.Label{Name: "job", Value: "api"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, 2.0, testutil.ToFloat64(store.metrics.rejected.WithLabelValues(rejectTooManyLabels)))
+	require.Equal(t, 2.0, testutil.ToFloat64(store.metrics.failed.WithLabelValues(failureInvalid)))
+
+	// The profile of the test data was taken long ago.
+	store, querier := newTestProfileColumnStore(t, WithLimits(Limits{MaxSampleAge: time.Hour}))
+	err = write(store)
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+	require.Equal(t, 1.0, testutil.ToFloat64(store.metrics.rejected.WithLabelValues(rejectTooOld)))
+	types, err := querier.ProfileTypes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, types)
+
+	store, _ = newTestProfileColumnStore(t, WithLimits(Limits{MaxSamples: 1}))
+	err = write(store)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, 1.0, testutil.ToFloat64(store.metrics.rejected.WithLabelValues(rejectTooManySamples)))
+
+	store, _ = newTestProfileColumnStore(t, WithLimits(Limits{MaxLabels: 2, MaxSampleFuture: time.Hour}))
+	require.NoError(t, write(store, &profilestorepb.Label{Name: "job", Value: "api"}))
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	LoadComponentQueue   = "queue"
+	LoadComponentLatency = "latency"
+	LoadComponentMemory  = "memory"
+
+	// latencyEWMAAlpha is the smoothing factor of the append latency moving
+	// average. Higher values react faster to changes in latency.
+	latencyEWMAAlpha = 0.2
+	// latencyHalfLife is the time after which the append latency moving
+	// average has halved without writes finishing, so it decays while the
+	// server is idle rather than staying at the latency of the last burst.
+	latencyHalfLife = 10 * time.Second
+)
+
+// LoadComponents are the raw signals the load ratio is computed from.
+type LoadComponents struct {
+	QueueDepth    float64
+	AppendLatency time.Duration
+	MemoryBytes   uint64
+}
+
+// LoadLimits are the values at which each component is considered saturated.
+// A zero limit disables the component.
+type LoadLimits struct {
+	QueueDepth    float64
+	AppendLatency time.Duration
+	MemoryBytes   uint64
+}
+
+// LoadWeights are the relative weights of each component in the load ratio.
+type LoadWeights struct {
+	QueueDepth    float64
+	AppendLatency float64
+	Memory        float64
+}
+
+// LoadWeightsFromMap builds LoadWeights from a map keyed by component name,
+// components missing from the map have a weight of 0. Unknown components are
+// an error.
+func LoadWeightsFromMap(m map[string]float64) (LoadWeights, error) {
+	for name := range m {
+		switch name {
+		case LoadComponentQueue, LoadComponentLatency, LoadComponentMemory:
+		default:
+			return LoadWeights{}, fmt.Errorf("unknown load component %q, expected one of %s, %s or %s", name, LoadComponentQueue, LoadComponentLatency, LoadComponentMemory)
+		}
+	}
+	return LoadWeights{
+		QueueDepth:    m[LoadComponentQueue],
+		AppendLatency: m[LoadComponentLatency],
+		Memory:        m[LoadComponentMemory],
+	}, nil
+}
+
+// ComputeLoadRatio computes a normalized load signal between 0 and 1. Every
+// component is divided by its limit and capped at 1, the result is the
+// weighted average of these utilizations:
+//
+//	ratio = Σ w_i * min(1, v_i / limit_i) / Σ w_i
+//
+// Components with a zero limit or a non-positive weight are left out of both
+// sums. If no component remains the ratio is 0.
+func ComputeLoadRatio(c LoadComponents, l LoadLimits, w LoadWeights) float64 {
+	var sum, weights float64
+
+	add := func(value, limit, weight float64) {
+		if limit <= 0 || weight <= 0 {
+			return
+		}
+		u := value / limit
+		if u > 1 {
+			u = 1
+		}
+		if u < 0 {
+			u = 0
+		}
+		sum += weight * u
+		weights += weight
+	}
+
+	add(c.QueueDepth, l.QueueDepth, w.QueueDepth)
+	add(float64(c.AppendLatency), float64(l.AppendLatency), w.AppendLatency)
+	add(float64(c.MemoryBytes), float64(l.MemoryBytes), w.Memory)
+
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// LoadTracker keeps track of in-flight writes and their latency and exposes
+// the resulting load ratio as the parca_load_ratio metric, suitable as a
+// target for external autoscalers.
+type LoadTracker struct {
+	limits  LoadLimits
+	weights LoadWeights
+
+	// memoryBytes returns the current memory usage, it is settable for
+	// testing convenience.
+	memoryBytes func() uint64
+	// now returns the current time, it is settable for testing convenience.
+	now func() time.Time
+
+	mtx      sync.Mutex
+	inflight float64
+	latency  float64
+	// decayed is when the latency was last decayed.
+	decayed time.Time
+}
+
+func NewLoadTracker(reg prometheus.Registerer, limits LoadLimits, weights LoadWeights) *LoadTracker {
+	t := &LoadTracker{
+		limits:      limits,
+		weights:     weights,
+		memoryBytes: heapInuseBytes,
+		now:         time.Now,
+	}
+
+	reg.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "parca_load_ratio",
+			Help: "Weighted load of the ingestion path between 0 and 1, combining in-flight writes, append latency and memory usage relative to their configured limits.",
+		},
+		t.Ratio,
+	))
+
+	return t
+}
+
+// Begin marks the start of a write and returns a function to be called once
+// the write has finished.
+func (t *LoadTracker) Begin() func() {
+	start := t.now()
+
+	t.mtx.Lock()
+	t.inflight++
+	t.decay(start)
+	t.mtx.Unlock()
+
+	return func() {
+		now := t.now()
+		d := float64(now.Sub(start))
+
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+
+		t.inflight--
+		t.decay(now)
+		if t.latency == 0 {
+			t.latency = d
+			return
+		}
+		t.latency = latencyEWMAAlpha*d + (1-latencyEWMAAlpha)*t.latency
+	}
+}
+
+// decay halves the latency every half-life since it was last decayed, the
+// mutex must be held.
+func (t *LoadTracker) decay(now time.Time) {
+	if elapsed := now.Sub(t.decayed); t.latency > 0 && elapsed > 0 {
+		t.latency *= math.Pow(0.5, float64(elapsed)/float64(latencyHalfLife))
+	}
+	t.decayed = now
+}
+
+// Components returns the current values of the load components.
+func (t *LoadTracker) Components() LoadComponents {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.decay(t.now())
+	return LoadComponents{
+		QueueDepth:    t.inflight,
+		AppendLatency: time.Duration(t.latency),
+		MemoryBytes:   t.memoryBytes(),
+	}
+}
+
+// Ratio returns the current load ratio.
+func (t *LoadTracker) Ratio() float64 {
+	return ComputeLoadRatio(t.Components(), t.limits, t.weights)
+}
+
+func heapInuseBytes() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeLoadRatio(t *testing.T) {
+	t.Parallel()
+
+	limits := LoadLimits{
+		QueueDepth:    10,
+		AppendLatency: time.Second,
+		MemoryBytes:   1000,
+	}
+
+	tests := map[string]struct {
+		components LoadComponents
+		weights    LoadWeights
+		expected   float64
+	}{
+		"idle": {
+			components: LoadComponents{},
+			weights:    LoadWeights{QueueDepth: 1, AppendLatency: 1, Memory: 1},
+			expected:   0,
+		},
+		"equal weights": {
+			components: LoadComponents{QueueDepth: 5, AppendLatency: 250 * time.Millisecond, MemoryBytes: 900},
+			weights:    LoadWeights{QueueDepth: 1, AppendLatency: 1, Memory: 1},
+			expected:   (0.5 + 0.25 + 0.9) / 3,
+		},
+		"saturated components are capped": {
+			components: LoadComponents{QueueDepth: 100, AppendLatency: time.Minute, MemoryBytes: 5000},
+			weights:    LoadWeights{QueueDepth: 1, AppendLatency: 1, Memory: 1},
+			expected:   1,
+		},
+		"weighted": {
+			components: LoadComponents{QueueDepth: 10, AppendLatency: 0, MemoryBytes: 0},
+			weights:    LoadWeights{QueueDepth: 3, AppendLatency: 1, Memory: 0},
+			expected:   0.75,
+		},
+		"no weights": {
+			components: LoadComponents{QueueDepth: 10},
+			weights:    LoadWeights{},
+			expected:   0,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			require.InDelta(t, test.expected, ComputeLoadRatio(test.components, limits, test.weights), 1e-9)
+		})
+	}
+}
+
+func TestLoadTracker(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	tracker := NewLoadTracker(
+		reg,
+		LoadLimits{QueueDepth: 2, MemoryBytes: 100},
+		LoadWeights{QueueDepth: 1, Memory: 1},
+	)
+	tracker.memoryBytes = func() uint64 { return 50 }
+
+	done := tracker.Begin()
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP parca_load_ratio Weighted load of the ingestion path between 0 and 1, combining in-flight writes, append latency and memory usage relative to their configured limits.
+# TYPE parca_load_ratio gauge
+parca_load_ratio 0.5
+`), "parca_load_ratio"))
+
+	done()
+	require.InDelta(t, 0.25, tracker.Ratio(), 1e-9)
+}
+
+func TestLoadTrackerLatencyDecays(t *testing.T) {
+	t.Parallel()
+
+	tracker := NewLoadTracker(
+		prometheus.NewRegistry(),
+		LoadLimits{AppendLatency: time.Second},
+		LoadWeights{AppendLatency: 1},
+	)
+	now := time.Unix(0, 0)
+	tracker.now = func() time.Time { return now }
+
+	done := tracker.Begin()
+	now = now.Add(time.Second)
+	done()
+	require.InDelta(t, 1, tracker.Ratio(), 1e-9)
+
+	// The latency of the last burst halves every half-life while idle.
+	now = now.Add(latencyHalfLife)
+	require.InDelta(t, 0.5, tracker.Ratio(), 1e-9)
+	now = now.Add(latencyHalfLife)
+	require.InDelta(t, 0.25, tracker.Ratio(), 1e-9)
+}
+
+func TestLoadWeightsFromMap(t *testing.T) {
+	t.Parallel()
+
+	w, err := LoadWeightsFromMap(map[string]float64{LoadComponentQueue: 2, LoadComponentMemory: 1})
+	require.NoError(t, err)
+	require.Equal(t, LoadWeights{QueueDepth: 2, Memory: 1}, w)
+
+	_, err = LoadWeightsFromMap(map[string]float64{"cpu": 1})
+	require.Error(t, err)
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Reasons written samples fail for.
+const (
+	failureParse   = "parse"
+	failureInvalid = "invalid"
+	failureAppend  = "append"
+)
+
+// metrics of the samples, that is the profiles, of writes. A single write
+// may carry many series of many samples, so they are counted per sample.
+type metrics struct {
+	received    prometheus.Counter
+	failed      *prometheus.CounterVec
+	rejected    *prometheus.CounterVec
+	profileSize prometheus.Histogram
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_profilestore_received_samples_total",
+			Help: "Number of samples, that is profiles, received by writes.",
+		}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_profilestore_failed_samples_total",
+			Help: "Number of received samples that failed to be parsed, were invalid or failed to be appended.",
+		}, []string{"reason"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_profilestore_rejected_samples_total",
+			Help: "Number of received samples rejected for exceeding a limit, by the limit exceeded. They are counted as invalid failed samples as well.",
+		}, []string{"reason"}),
+		profileSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "parca_profilestore_raw_profile_size_bytes",
+			Help:    "Size of the profiles of received samples as written, before they are decompressed.",
+			Buckets: prometheus.ExponentialBuckets(1<<10, 4, 10),
+		}),
+	}
+	for _, reason := range []string{failureParse, failureInvalid, failureAppend} {
+		m.failed.WithLabelValues(reason)
+	}
+	reg.MustRegister(m.received, m.failed, m.rejected, m.profileSize)
+	return m
+}
+
+// fail counts n samples failing for the reason.
+func (m *metrics) fail(reason string, n int) {
+	m.failed.WithLabelValues(reason).Add(float64(n))
+}
+
+// reject counts n samples rejected for exceeding a limit.
+func (m *metrics) reject(reason string, n int) {
+	m.rejected.WithLabelValues(reason).Add(float64(n))
+	m.fail(failureInvalid, n)
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/polarsignals/frostdb"
+	"github.com/prometheus/prometheus/model/labels"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/originals"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+type Option func(*ProfileColumnStore)
+
+func WithLoadTracker(t *LoadTracker) Option {
+	return func(s *ProfileColumnStore) {
+		s.load = t
+	}
+}
+
+// WithExposedIngestSource stores the source a profile was ingested through
+// as the ingest_source label instead of keeping it internal.
+func WithExposedIngestSource() Option {
+	return func(s *ProfileColumnStore) {
+		s.exposeIngestSource = true
+	}
+}
+
+// WithWritePolicy only allows clients to write the series the policy grants
+// their identity.
+func WithWritePolicy(p *WritePolicy) Option {
+	return func(s *ProfileColumnStore) {
+		s.writePolicy = p
+	}
+}
+
+// WithSampleLimiter enforces the minimum interval of the limiter between the
+// samples written to each series.
+func WithSampleLimiter(l *SampleLimiter) Option {
+	return func(s *ProfileColumnStore) {
+		s.sampleLimiter = l
+	}
+}
+
+// WithRateLimiter limits the rate at which each client writes profiles to
+// the limits of the limiter.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(s *ProfileColumnStore) {
+		s.rateLimiter = l
+	}
+}
+
+// WithLenientParsing stores the samples that can be recovered from truncated
+// or malformed profiles instead of rejecting them. Their series are marked
+// with the partial label.
+func WithLenientParsing() Option {
+	return func(s *ProfileColumnStore) {
+		s.lenientParsing = true
+	}
+}
+
+// WithNormalizerOptions configures the normalizer of ingested profiles.
+func WithNormalizerOptions(opts ...parcacol.NormalizerOption) Option {
+	return func(s *ProfileColumnStore) {
+		s.normalizerOpts = append(s.normalizerOpts, opts...)
+	}
+}
+
+// WithIngesterOptions configures the ingester of profiles.
+func WithIngesterOptions(opts ...parcacol.IngesterOption) Option {
+	return func(s *ProfileColumnStore) {
+		s.ingesterOpts = append(s.ingesterOpts, opts...)
+	}
+}
+
+// WithPeerLabel sets the label of the given name to the IP of the client
+// writing a series, unless the client set the label itself. Writes of
+// trusted proxies are attributed to the client they forwarded.
+func WithPeerLabel(name string, trustedProxies []*net.IPNet) Option {
+	return func(s *ProfileColumnStore) {
+		s.peerLabeler = &peerLabeler{name: name, trustedProxies: trustedProxies}
+	}
+}
+
+// WithLowercaseLabels lowercases the names of the labels of written series,
+// and their values too if values is set, so agents that disagree on casing
+// write the same series. The value of the __name__ label is kept as is.
+func WithLowercaseLabels(values bool) Option {
+	return func(s *ProfileColumnStore) {
+		s.lowercaseLabelNames = true
+		s.lowercaseLabelValues = values
+	}
+}
+
+// WithOriginals keeps the written profiles as they were written in the
+// store, so they can be downloaded as such. Profiles that were recovered
+// partially or merged with others aren't kept. Failing to keep a profile
+// doesn't fail the write, its download falls back to reconstructing it.
+func WithOriginals(store *originals.Store) Option {
+	return func(s *ProfileColumnStore) {
+		s.originals = store
+	}
+}
+
+// WithLocationlessProfiles warns about or rejects profiles with samples of
+// which none reference a location, depending on mode being
+// LocationlessProfilesWarn or LocationlessProfilesReject. Their samples can't
+// be shown in flame graphs and only bloat the index.
+func WithLocationlessProfiles(mode string) Option {
+	return func(s *ProfileColumnStore) {
+		s.locationlessProfiles = mode
+	}
+}
+
+// WithAppendRetries makes up to attempts appends of profiles to the table,
+// waiting backoff between them, as long as they fail with errors classified
+// as transient, see WithIsRetryable.
+func WithAppendRetries(attempts int, backoff time.Duration) Option {
+	return func(s *ProfileColumnStore) {
+		s.appendAttempts = attempts
+		s.appendBackoff = backoff
+	}
+}
+
+// WithIsRetryable replaces DefaultIsRetryable as the classifier of the errors
+// of failed appends that are retried, for storage backends with their own
+// transient errors.
+func WithIsRetryable(isRetryable func(error) bool) Option {
+	return func(s *ProfileColumnStore) {
+		s.isRetryable = isRetryable
+	}
+}
+
+// WithEnricher adds the labels the enricher derives to written series. If
+// enriching a series fails it is written as is if failOpen is set, otherwise
+// the write fails.
+func WithEnricher(e Enricher, failOpen bool) Option {
+	return func(s *ProfileColumnStore) {
+		s.enricher = e
+		s.enrichFailOpen = failOpen
+	}
+}
+
+// WithRollups appends the sum of the values of each written profile to the
+// rollup table too, for range queries to read, see parcacol.WithRollups.
+// Given more than once, profiles are rolled up into each table.
+func WithRollups(table *frostdb.Table, granularity time.Duration) Option {
+	return func(s *ProfileColumnStore) {
+		s.rollups = append(s.rollups, rollup{table: table, granularity: granularity})
+	}
+}
+
+// WithDecompressionGuard rejects written profiles that decompress beyond the
+// bounds of the guard.
+func WithDecompressionGuard(g *DecompressionGuard) Option {
+	return func(s *ProfileColumnStore) {
+		s.decompressionGuard = g
+	}
+}
+
+// WithMaxProfileSize rejects writes of profiles larger than size bytes as
+// written, before they are decompressed or parsed.
+func WithMaxProfileSize(size int64) Option {
+	return func(s *ProfileColumnStore) {
+		s.maxProfileSize = size
+	}
+}
+
+// WithLimits rejects writes of profiles and series exceeding the limits.
+func WithLimits(l Limits) Option {
+	return func(s *ProfileColumnStore) {
+		s.limits = l
+	}
+}
+
+// WithWriteConcurrency writes up to n series concurrently, across all
+// writes. The series of a write are written serially in its order
+// otherwise.
+func WithWriteConcurrency(n int) Option {
+	return func(s *ProfileColumnStore) {
+		if n > 1 {
+			s.writeSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithAsyncWrites queues up to size writes for the workers to write and
+// responds to them right away, with empty counts. Writes are rejected with
+// ResourceExhausted while the queue is full. Failures of queued writes are
+// only logged and counted by the metrics.
+func WithAsyncWrites(size, workers int) Option {
+	return func(s *ProfileColumnStore) {
+		if workers < 1 {
+			workers = 1
+		}
+		s.queue = make(chan queuedWrite, size)
+		s.queueWorkers = workers
+	}
+}
+
+// WithDecoder decodes raw profiles of the format, other than pprof, with the
+// decoder, replacing the decoder of a format supported out of the box.
+func WithDecoder(format string, d Decoder) Option {
+	return func(s *ProfileColumnStore) {
+		s.decoders[format] = d
+	}
+}
+
+// WithWriteHook calls f with the context of the write and the labels of
+// every series a profile was successfully written to.
+func WithWriteHook(f func(context.Context, labels.Labels)) Option {
+	return func(s *ProfileColumnStore) {
+		s.writeHook = f
+	}
+}
+
+// Forwarder forwards the profiles written to the store to other stores, see
+// the remotewrite package.
+type Forwarder interface {
+	Forward(ctx context.Context, ls labels.Labels, sampleTypes []*profilestorepb.SampleType, sample *profilestorepb.RawSample, normalized bool)
+}
+
+// WithForwarder passes every profile written to the store to f, once it was
+// stored.
+func WithForwarder(f Forwarder) Option {
+	return func(s *ProfileColumnStore) {
+		s.forwarder = f
+	}
+}
+
+// WithTenantTables writes the profiles of tenants to their own tables,
+// including rollups and quarantined profiles, see the tenant package.
+func WithTenantTables(t *parcacol.TenantTables) Option {
+	return func(s *ProfileColumnStore) {
+		s.tenants = t
+	}
+}
+
+// WithQuarantine stores written profiles failing the soft checks of the
+// quarantine in its table instead of the table of the store.
+func WithQuarantine(q *Quarantine) Option {
+	return func(s *ProfileColumnStore) {
+		s.quarantine = q
+	}
+}
+
+// WithRelabeler relabels the series of written profiles before they are
+// stored and forwarded.
+func WithRelabeler(r *Relabeler) Option {
+	return func(s *ProfileColumnStore) {
+		s.relabeler = r
+	}
+}
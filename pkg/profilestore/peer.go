@@ -0,0 +1,127 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// forwardedForHeader is the header proxies append the address of their
+// client to, headers are part of the metadata of gRPC requests served over
+// HTTP.
+const forwardedForHeader = "x-forwarded-for"
+
+// ParseTrustedProxies parses the addresses of trusted proxies, which are
+// either CIDRs or single IPs.
+func ParseTrustedProxies(addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", addr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %w", addr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// peerLabeler sets a label to the address of the client writing profiles,
+// unless the client set it.
+type peerLabeler struct {
+	name           string
+	trustedProxies []*net.IPNet
+}
+
+func (l *peerLabeler) label(ctx context.Context, ls labels.Labels) labels.Labels {
+	if ls.Has(l.name) {
+		return ls
+	}
+	addr, ok := l.clientAddress(ctx)
+	if !ok {
+		return ls
+	}
+	return labels.NewBuilder(ls).Set(l.name, addr).Labels()
+}
+
+func (l *peerLabeler) clientAddress(ctx context.Context) (string, bool) {
+	return clientAddress(ctx, l.trustedProxies)
+}
+
+// clientAddress returns the IP of the client. Requests of trusted proxies
+// are attributed to the last address in their X-Forwarded-For header that
+// isn't a trusted proxy itself, as everything before it may be forged.
+func clientAddress(ctx context.Context, trustedProxies []*net.IPNet) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+	if !trusted(trustedProxies, ip) {
+		return ip.String(), true
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var forwarded []string
+	for _, v := range md.Get(forwardedForHeader) {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !trusted(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return ip.String(), true
+}
+
+func trusted(trustedProxies []*net.IPNet, ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	nets, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.True(t, nets[0].Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, nets[1].Contains(net.ParseIP("192.168.1.1")))
+	require.False(t, nets[1].Contains(net.ParseIP("192.168.1.2")))
+	require.True(t, nets[2].Contains(net.ParseIP("::1")))
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy"})
+	require.Error(t, err)
+}
+
+func TestClientAddress(t *testing.T) {
+	t.Parallel()
+
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	l := &peerLabeler{name: "instance", trustedProxies: trusted}
+
+	peerContext := func(addr string, forwardedFor ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 4321},
+		})
+		if len(forwardedFor) > 0 {
+			md := metadata.MD{}
+			md.Append(forwardedForHeader, forwardedFor...)
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+		return ctx
+	}
+
+	tests := map[string]struct {
+		ctx  context.Context
+		addr string
+	}{
+		"direct": {
+			ctx:  peerContext("192.168.1.1"),
+			addr: "192.168.1.1",
+		},
+		"untrusted proxy": {
+			ctx:  peerContext("192.168.1.1", "1.2.3.4"),
+			addr: "192.168.1.1",
+		},
+		"trusted proxy": {
+			ctx:  peerContext("10.0.0.1", "1.2.3.4"),
+			addr: "1.2.3.4",
+		},
+		"forged hop": {
+			ctx:  peerContext("10.0.0.1", "5.6.7.8, 1.2.3.4, 10.0.0.2"),
+			addr: "1.2.3.4",
+		},
+		"multiple headers": {
+			ctx:  peerContext("10.0.0.1", "1.2.3.4", "10.0.0.2"),
+			addr: "1.2.3.4",
+		},
+		"invalid hop": {
+			ctx:  peerContext("10.0.0.1", "unknown, 10.0.0.2"),
+			addr: "10.0.0.2",
+		},
+		"only trusted": {
+			ctx:  peerContext("10.0.0.1"),
+			addr: "10.0.0.1",
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			addr, ok := l.clientAddress(test.ctx)
+			require.True(t, ok)
+			require.Equal(t, test.addr, addr)
+		})
+	}
+
+	_, ok := l.clientAddress(context.Background())
+	require.False(t, ok)
+}
+
+func TestWriteRawPeerLabel(t *testing.T) {
+	t.Parallel()
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 4321},
+	})
+	store, querier := newTestProfileColumnStore(t, WithPeerLabel("instance", nil))
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	for _, ls := range [][]*profilestorepb.Label{
+		{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}},
+		{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "b"}, {Name: "instance", Value: "b:8080"}},
+	} {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: ls},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+		require.NoError(t, err)
+	}
+
+	vals, err := querier.Values(ctx, "instance", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"192.168.1.1", "b:8080"}, vals)
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// writeAllSeries writes the series of a write, adding the counts of their
+// samples to the response. Series are written concurrently if configured
+// to, in slots shared by all writes, otherwise serially in the order of the
+// write.
+func (s *ProfileColumnStore) writeAllSeries(ctx context.Context, w *seriesWrite, all []*profilestorepb.RawProfileSeries, resp *profilestorepb.WriteRawResponse) error {
+	if s.writeSlots == nil {
+		for _, series := range all {
+			if err := ctx.Err(); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			accepted, skipped, err := s.writeSeries(ctx, w, series)
+			if err != nil {
+				return err
+			}
+			resp.AcceptedSamples += accepted
+			resp.SkippedSamples += skipped
+		}
+		return nil
+	}
+
+	// The first failing series cancels the series still being written.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		firstErr error
+	)
+loop:
+	for _, series := range all {
+		select {
+		case s.writeSlots <- struct{}{}:
+		case <-writeCtx.Done():
+			break loop
+		}
+
+		wg.Add(1)
+		go func(series *profilestorepb.RawProfileSeries) {
+			defer wg.Done()
+			defer func() { <-s.writeSlots }()
+
+			accepted, skipped, err := s.writeSeries(writeCtx, w, series)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			resp.AcceptedSamples += accepted
+			resp.SkippedSamples += skipped
+		}(series)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return firstErr
+}
+
+// queuedWrite is a write waiting in the queue of asynchronous writes.
+type queuedWrite struct {
+	ctx context.Context
+	req *profilestorepb.WriteRawRequest
+	// end is called once the write is done, see drainer.
+	end func()
+}
+
+// enqueue queues the write, it's rejected if the queue is full.
+func (s *ProfileColumnStore) enqueue(ctx context.Context, req *profilestorepb.WriteRawRequest, end func()) (*profilestorepb.WriteRawResponse, error) {
+	select {
+	case s.queue <- queuedWrite{ctx: detachedContext{ctx}, req: req, end: end}:
+		return &profilestorepb.WriteRawResponse{}, nil
+	default:
+		end()
+		return nil, status.Error(codes.ResourceExhausted, "write queue is full")
+	}
+}
+
+// processQueue writes queued writes until the queue is closed.
+func (s *ProfileColumnStore) processQueue() {
+	for w := range s.queue {
+		if _, err := s.writeRaw(w.ctx, w.req); err != nil {
+			level.Warn(s.logger).Log("msg", "failed to write queued profiles", "source", IngestSourceFromContext(w.ctx), "err", err)
+		}
+		w.end()
+	}
+}
+
+// detachedContext keeps the values of the context of a request, like its
+// tenant and ingest source, but not its cancelation, for queued writes
+// outliving their request.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
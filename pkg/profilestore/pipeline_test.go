@@ -0,0 +1,117 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func jobSeries(t *testing.T, jobs ...string) []*profilestorepb.RawProfileSeries {
+	t.Helper()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	series := make([]*profilestorepb.RawProfileSeries, 0, len(jobs))
+	for _, job := range jobs {
+		series = append(series, &profilestorepb.RawProfileSeries{
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: job}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}, {RawProfile: content}},
+		})
+	}
+	return series
+}
+
+func TestWriteRawConcurrency(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	jobs := make([]string, 0, 8)
+	for i := 0; i < cap(jobs); i++ {
+		jobs = append(jobs, fmt.Sprintf("job-%d", i))
+	}
+
+	store, querier := newTestProfileColumnStore(t, WithWriteConcurrency(3))
+	resp, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{Series: jobSeries(t, jobs...)})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2*len(jobs)), resp.AcceptedSamples)
+
+	values, err := querier.Values(ctx, "job", nil, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	sort.Strings(values)
+	require.Equal(t, jobs, values)
+	require.Empty(t, store.writeSlots)
+
+	// The failing series fails the write.
+	series := jobSeries(t, "a", "b", "c")
+	series[1].Labels.Labels[0].Name = "invalid name"
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{Series: series})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Empty(t, store.writeSlots)
+}
+
+func TestWriteRawAsync(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, querier := newTestProfileColumnStore(t, WithAsyncWrites(4, 2))
+
+	// Queued writes outlive their request.
+	reqCtx, cancel := context.WithCancel(ctx)
+	for _, job := range []string{"a", "b", "c"} {
+		resp, err := store.WriteRaw(reqCtx, &profilestorepb.WriteRawRequest{Series: jobSeries(t, job)})
+		require.NoError(t, err)
+		require.Equal(t, &profilestorepb.WriteRawResponse{}, resp)
+	}
+	cancel()
+
+	// Draining waits for the queued writes.
+	require.NoError(t, store.Drain(ctx))
+	values, err := querier.Values(ctx, "job", nil, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	sort.Strings(values)
+	require.Equal(t, []string{"a", "b", "c"}, values)
+
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{Series: jobSeries(t, "d")})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestWriteRawAsyncQueueFull(t *testing.T) {
+	t.Parallel()
+
+	// Without workers taking writes off the queue, it fills up.
+	store := &ProfileColumnStore{queue: make(chan queuedWrite, 1)}
+	_, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{})
+	require.NoError(t, err)
+	_, err = store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// The rejected write isn't waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, store.Drain(ctx), context.DeadlineExceeded)
+	(<-store.queue).end()
+	require.NoError(t, store.Drain(context.Background()))
+}
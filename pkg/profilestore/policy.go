@@ -0,0 +1,125 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"gopkg.in/yaml.v2"
+)
+
+// WritePolicy restricts the series a client identity may write. Each
+// identity is allowed a list of selectors, a series may be written if it
+// matches any of them. Identities that are not part of the policy may not
+// write at all.
+type WritePolicy struct {
+	identities map[string][][]*labels.Matcher
+}
+
+type writePolicyConfig struct {
+	Identities map[string][]string `yaml:"identities"`
+}
+
+// ParseWritePolicy parses a write policy from YAML of the form:
+//
+//	identities:
+//	  agent-a:
+//	    - '{service="a"}'
+func ParseWritePolicy(content []byte) (*WritePolicy, error) {
+	cfg := writePolicyConfig{}
+	if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+		return nil, err
+	}
+
+	p := &WritePolicy{identities: make(map[string][][]*labels.Matcher, len(cfg.Identities))}
+	for identity, selectors := range cfg.Identities {
+		for _, selector := range selectors {
+			matchers, err := parser.ParseMetricSelector(selector)
+			if err != nil {
+				return nil, fmt.Errorf("parse selector %q of identity %q: %w", selector, identity, err)
+			}
+			p.identities[identity] = append(p.identities[identity], matchers)
+		}
+	}
+
+	return p, nil
+}
+
+// LoadWritePolicyFile parses the write policy in the given file.
+func LoadWritePolicyFile(filename string) (*WritePolicy, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := ParseWritePolicy(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing write policy file %s: %w", filename, err)
+	}
+	return p, nil
+}
+
+// Allowed returns whether the identity may write the series.
+func (p *WritePolicy) Allowed(identity string, ls labels.Labels) bool {
+	for _, matchers := range p.identities[identity] {
+		if matchesAll(matchers, ls) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAll(matchers []*labels.Matcher, ls labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(ls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the identity of the client, to be
+// used by authenticating middleware.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the client. An identity set
+// with WithIdentity takes precedence over the common name of a verified
+// client certificate.
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	if identity, ok := ctx.Value(identityKey{}).(string); ok && identity != "" {
+		return identity, true
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	identity := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	return identity, identity != ""
+}
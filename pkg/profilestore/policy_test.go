@@ -0,0 +1,142 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+const testWritePolicy = `
+identities:
+  agent-a:
+    - '{service="a"}'
+  agent-b:
+    - '{service="b", namespace=~"prod|staging"}'
+    - '{__name__="memory", service="shared"}'
+`
+
+func TestParseWritePolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseWritePolicy([]byte(`identities: {agent-a: ['{service=}']}`))
+	require.Error(t, err)
+
+	_, err = ParseWritePolicy([]byte(`unknown: true`))
+	require.Error(t, err)
+}
+
+func TestWriteRawPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseWritePolicy([]byte(testWritePolicy))
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	peerContext := func(commonName string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+			}},
+		})
+	}
+
+	tests := map[string]struct {
+		ctx    context.Context
+		labels map[string]string
+		code   codes.Code
+	}{
+		"allowed": {
+			ctx:    WithIdentity(context.Background(), "agent-a"),
+			labels: map[string]string{"service": "a"},
+			code:   codes.OK,
+		},
+		"outside allowed labels": {
+			ctx:    WithIdentity(context.Background(), "agent-a"),
+			labels: map[string]string{"service": "b"},
+			code:   codes.PermissionDenied,
+		},
+		"certificate identity allowed": {
+			ctx:    peerContext("agent-b"),
+			labels: map[string]string{"service": "b", "namespace": "prod"},
+			code:   codes.OK,
+		},
+		"certificate identity outside allowed labels": {
+			ctx:    peerContext("agent-b"),
+			labels: map[string]string{"service": "b", "namespace": "dev"},
+			code:   codes.PermissionDenied,
+		},
+		"second selector": {
+			ctx:    peerContext("agent-b"),
+			labels: map[string]string{"service": "shared"},
+			code:   codes.OK,
+		},
+		"unknown identity": {
+			ctx:    WithIdentity(context.Background(), "agent-c"),
+			labels: map[string]string{"service": "a"},
+			code:   codes.PermissionDenied,
+		},
+		"no identity": {
+			ctx:    context.Background(),
+			labels: map[string]string{"service": "a"},
+			code:   codes.PermissionDenied,
+		},
+		"scraper": {
+			ctx:    WithIngestSource(context.Background(), IngestSourceScrape),
+			labels: map[string]string{"service": "c"},
+			code:   codes.OK,
+		},
+		"self profiler": {
+			ctx:    WithIngestSource(context.Background(), IngestSourceSelf),
+			labels: map[string]string{"job": "parca"},
+			code:   codes.OK,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			api, _ := newTestProfileColumnStore(t, WithWritePolicy(policy))
+
+			ls := []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}
+			for n, v := range test.labels {
+				ls = append(ls, &profilestorepb.Label{Name: n, Value: v})
+			}
+
+			_, err := api.WriteRaw(test.ctx, &profilestorepb.WriteRawRequest{
+				Series: []*profilestorepb.RawProfileSeries{{
+					Labels:  &profilestorepb.LabelSet{Labels: ls},
+					Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+				}},
+			})
+			require.Equal(t, test.code, status.Code(err), err)
+		})
+	}
+}
@@ -14,29 +14,34 @@
 package profilestore
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/polarsignals/frostdb"
 	"github.com/polarsignals/frostdb/dynparquet"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/timestamp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
 	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
 	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/originals"
 	"github.com/parca-dev/parca/pkg/parcacol"
 )
 
@@ -44,6 +49,7 @@ type ProfileColumnStore struct {
 	profilestorepb.UnimplementedProfileStoreServiceServer
 
 	logger    log.Logger
+	metrics   *metrics
 	tracer    trace.Tracer
 	metastore metastorepb.MetastoreServiceClient
 
@@ -56,86 +62,637 @@ type ProfileColumnStore struct {
 	// reproducing situations in tests. This has huge overhead, do not enable
 	// unless you know what you're doing.
 	debugValueLog bool
+
+	load *LoadTracker
+
+	drainer drainer
+
+	// exposeIngestSource stores the ingest source of profiles as a label,
+	// otherwise it is only attached to traces and logs.
+	exposeIngestSource bool
+
+	// writePolicy restricts the series clients may write, writes of the
+	// in-process scraper are always allowed.
+	writePolicy *WritePolicy
+
+	sampleLimiter *SampleLimiter
+	rateLimiter   *RateLimiter
+
+	// lenientParsing stores whatever can be recovered from profiles that
+	// fail to decompress or parse instead of rejecting them.
+	lenientParsing bool
+
+	normalizerOpts []parcacol.NormalizerOption
+	ingesterOpts   []parcacol.IngesterOption
+
+	// rollups store the sums of the values of written profiles at their
+	// granularities, see parcacol.WithRollups.
+	rollups []rollup
+
+	// peerLabeler labels pushed series with the address of the client.
+	peerLabeler *peerLabeler
+
+	// lowercaseLabelNames and lowercaseLabelValues canonicalize the labels
+	// of written series, so series whose labels only differ in case are
+	// stored as one.
+	lowercaseLabelNames  bool
+	lowercaseLabelValues bool
+
+	// originals keeps the profiles as written, unless they were modified
+	// before being stored.
+	originals *originals.Store
+
+	// locationlessProfiles is what to do with profiles whose samples
+	// reference no locations, they are stored as is if empty.
+	locationlessProfiles string
+
+	// appendAttempts is how often appends to the table are attempted when
+	// they fail with errors isRetryable classifies as transient.
+	appendAttempts int
+	appendBackoff  time.Duration
+	isRetryable    func(error) bool
+
+	// enricher derives labels of written series, writes fail if it fails
+	// unless enrichFailOpen is set.
+	enricher       Enricher
+	enrichFailOpen bool
+
+	decompressionGuard *DecompressionGuard
+
+	// maxProfileSize is the maximum size in bytes of written profiles as
+	// written, unlimited if 0.
+	maxProfileSize int64
+
+	// limits reject writes of profiles and series exceeding them.
+	limits Limits
+
+	// writeSlots bounds the series written concurrently, series of writes
+	// are written serially if nil.
+	writeSlots chan struct{}
+
+	// queue holds the writes to write asynchronously, writes are
+	// synchronous if nil.
+	queue        chan queuedWrite
+	queueWorkers int
+	closeQueue   sync.Once
+
+	// decoders decode raw profiles by their format, other than pprof.
+	decoders map[string]Decoder
+
+	// writeHook is called with the labels of each series written to.
+	writeHook func(context.Context, labels.Labels)
+	forwarder Forwarder
+
+	quarantine *Quarantine
+
+	// relabeler relabels the series written by clients, and drops them,
+	// before any other labels are added.
+	relabeler *Relabeler
+
+	// tenants resolves the tables profiles of tenants are written to.
+	tenants *parcacol.TenantTables
 }
 
+// TraceIDMetadataKey and SpanIDMetadataKey are the gRPC metadata associating
+// the profiles of a write with the trace and span they were captured during,
+// see parcacol.Querier.QueryByTraceID. Writes through the HTTP API set them
+// with the Grpc-Metadata-Parca-Trace-Id and Grpc-Metadata-Parca-Span-Id
+// headers. The series of the write are labeled with them, unless they have
+// trace_id or span_id labels already.
+const (
+	TraceIDMetadataKey = "parca-trace-id"
+	SpanIDMetadataKey  = "parca-span-id"
+)
+
+// What to do with profiles whose samples reference no locations.
+const (
+	LocationlessProfilesWarn   = "warn"
+	LocationlessProfilesReject = "reject"
+)
+
 var _ profilestorepb.ProfileStoreServiceServer = &ProfileColumnStore{}
 
 func NewProfileColumnStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	tracer trace.Tracer,
 	metastore metastorepb.MetastoreServiceClient,
 	table *frostdb.Table,
 	schema *dynparquet.Schema,
 	debugValueLog bool,
+	opts ...Option,
 ) *ProfileColumnStore {
-	return &ProfileColumnStore{
+	s := &ProfileColumnStore{
 		logger:        logger,
+		metrics:       newMetrics(reg),
 		tracer:        tracer,
 		metastore:     metastore,
 		table:         table,
 		debugValueLog: debugValueLog,
 		schema:        schema,
+		isRetryable:   DefaultIsRetryable,
+		enricher:      NopEnricher{},
+		decoders:      defaultDecoders(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.queue != nil {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "parca_profilestore_write_queue_length",
+			Help: "Number of writes queued to be written asynchronously.",
+		}, func() float64 {
+			return float64(len(s.queue))
+		}))
+		for i := 0; i < s.queueWorkers; i++ {
+			go s.processQueue()
+		}
 	}
+
+	return s
 }
 
+// WriteRaw stores the profiles of the request. Writes stop once the context
+// is canceled, the profiles stored until then are kept, as are the profiles
+// stored before a write fails. Writes are queued and responded to with empty
+// counts right away if writes are asynchronous, see WithAsyncWrites.
 func (s *ProfileColumnStore) WriteRaw(ctx context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
+	if err := s.allowWrite(ctx, 1, req.SizeVT()); err != nil {
+		return nil, err
+	}
+	return s.writeRawRequest(ctx, req)
+}
+
+// writeRawRequest stores the profiles of a write request that is within the
+// rate limits of its client.
+func (s *ProfileColumnStore) writeRawRequest(ctx context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
+	end, ok := s.drainer.begin()
+	if !ok {
+		return nil, errDraining
+	}
+	if s.queue != nil {
+		return s.enqueue(ctx, req, end)
+	}
+	defer end()
+
+	return s.writeRaw(ctx, req)
+}
+
+// writeRaw stores the profiles of the write.
+func (s *ProfileColumnStore) writeRaw(ctx context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
 	ctx, span := s.tracer.Start(ctx, "write-raw")
 	defer span.End()
 
-	ingester := parcacol.NewIngester(
-		s.logger,
-		parcacol.NewNormalizer(s.metastore),
-		s.table,
-		s.schema,
-	)
+	if s.load != nil {
+		done := s.load.Begin()
+		defer done()
+	}
+
+	source := IngestSourceFromContext(ctx)
+	span.SetAttributes(attribute.String("ingest_source", source))
 
+	var identity string
+	authorize := s.writePolicy != nil && source == IngestSourcePush
+	if authorize {
+		var ok bool
+		identity, ok = IdentityFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "client identity is required to write profiles")
+		}
+	}
+
+	// All samples of the write are received, oversized profiles reject
+	// the whole write before any profile is parsed.
 	for _, series := range req.Series {
-		ls := make(labels.Labels, 0, len(series.Labels.Labels))
-		for _, l := range series.Labels.Labels {
-			if valid := model.LabelName(l.Name).IsValid(); !valid {
-				return nil, status.Errorf(codes.InvalidArgument, "invalid label name: %v", l.Name)
+		for _, sample := range series.Samples {
+			size := int64(len(sample.RawProfile))
+			s.metrics.received.Inc()
+			s.metrics.profileSize.Observe(float64(size))
+			if s.maxProfileSize > 0 && size > s.maxProfileSize {
+				s.metrics.reject(rejectTooLarge, 1)
+				return nil, status.Errorf(codes.InvalidArgument, "profile of %d bytes exceeds the maximum profile size of %d bytes", size, s.maxProfileSize)
 			}
+		}
+	}
 
-			ls = append(ls, labels.Label{
-				Name:  l.Name,
-				Value: l.Value,
-			})
+	ingester, err := s.ingester(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &seriesWrite{
+		ingester:    ingester,
+		span:        span,
+		source:      source,
+		identity:    identity,
+		authorize:   authorize,
+		normalized:  req.Normalized,
+		traceLabels: traceLabelsFromContext(ctx),
+	}
+	resp := &profilestorepb.WriteRawResponse{}
+	if err := s.writeAllSeries(ctx, w, req.Series, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// seriesWrite is the state of a write shared by the writes of its series.
+type seriesWrite struct {
+	ingester   *parcacol.Ingester
+	span       trace.Span
+	source     string
+	identity   string
+	authorize  bool
+	normalized bool
+	// traceLabels associate the series with the trace of the write.
+	traceLabels labels.Labels
+}
+
+// traceLabelsFromContext returns the trace_id and span_id labels of the
+// trace and span of the metadata of the context, if any.
+func traceLabelsFromContext(ctx context.Context) labels.Labels {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var ls labels.Labels
+	for _, l := range []struct{ key, name string }{
+		{TraceIDMetadataKey, parcacol.TraceIDLabel},
+		{SpanIDMetadataKey, parcacol.SpanIDLabel},
+	} {
+		if values := md.Get(l.key); len(values) > 0 && values[0] != "" {
+			ls = append(ls, labels.Label{Name: l.name, Value: values[0]})
 		}
+	}
+	return ls
+}
 
-		for _, sample := range series.Samples {
-			r, err := gzip.NewReader(bytes.NewBuffer(sample.RawProfile))
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to create gzip reader: %v", err)
+// writeSeries stores the profiles of a series of a write, returning the
+// number of its samples accepted and skipped.
+func (s *ProfileColumnStore) writeSeries(ctx context.Context, w *seriesWrite, series *profilestorepb.RawProfileSeries) (accepted, skipped uint64, err error) {
+	ls, err := s.seriesLabels(series.GetLabels().GetLabels())
+	if err == nil && s.relabeler != nil && ls.Has(labels.MetricName) {
+		var keep bool
+		if ls, keep = s.relabeler.Process(ls); !keep {
+			s.relabeler.dropped.Add(float64(len(series.Samples)))
+			return 0, uint64(len(series.Samples)), nil
+		}
+		if name := ls.Get(labels.MetricName); strings.Contains(name, ":") {
+			err = status.Errorf(codes.InvalidArgument, "invalid profile name %q of label %s after relabeling, it must not contain colons", name, labels.MetricName)
+		}
+	}
+	if err == nil && !ls.Has(labels.MetricName) {
+		err = status.Errorf(codes.InvalidArgument, "series has no %s label naming the profile", labels.MetricName)
+	}
+	if err != nil {
+		s.metrics.fail(failureInvalid, len(series.Samples))
+		return 0, 0, err
+	}
+	if reason, err := s.limits.checkSeries(ls); err != nil {
+		s.metrics.reject(reason, len(series.Samples))
+		return 0, 0, err
+	}
+	if s.peerLabeler != nil && w.source == IngestSourcePush {
+		ls = s.peerLabeler.label(ctx, ls)
+	}
+	if len(w.traceLabels) > 0 {
+		b := labels.NewBuilder(ls)
+		for _, l := range w.traceLabels {
+			if !ls.Has(l.Name) {
+				b.Set(l.Name, l.Value)
+			}
+		}
+		ls = b.Labels()
+	}
+	if w.authorize && !s.writePolicy.Allowed(w.identity, ls) {
+		return 0, 0, status.Errorf(codes.PermissionDenied, "identity %q is not allowed to write series %s", w.identity, ls.String())
+	}
+	if ls, err = s.enrich(ctx, ls); err != nil {
+		return 0, 0, err
+	}
+	// The receiving store exposes the ingest source itself.
+	forwardLabels := ls
+	if s.exposeIngestSource {
+		ls = append(ls, labels.Label{Name: ExposedIngestSourceLabel, Value: w.source})
+	}
+
+	for _, sample := range series.Samples {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, status.FromContextError(err).Err()
+		}
+
+		var decoder Decoder
+		if sample.Format != "" && sample.Format != FormatPprof {
+			var ok bool
+			if decoder, ok = s.decoders[sample.Format]; !ok {
+				s.metrics.fail(failureInvalid, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "unknown profile format %q", sample.Format)
 			}
+		}
 
-			content, err := io.ReadAll(r)
+		r, err := decompressor(sample.ContentEncoding, sample.RawProfile)
+		if err != nil {
+			s.metrics.fail(failureParse, 1)
+		}
+		if errors.Is(err, errUnknownContentEncoding) || errors.Is(err, errMalformedGzip) {
+			return 0, 0, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err != nil {
+			return 0, 0, status.Errorf(codes.Internal, "failed to create decompressor: %v", err)
+		}
+
+		partial := false
+		var content []byte
+		if s.decompressionGuard != nil && sample.ContentEncoding != ContentEncodingNone {
+			content, err = s.decompressionGuard.readAll(r, len(sample.RawProfile))
+		} else {
+			content, err = io.ReadAll(r)
+		}
+		r.Close()
+		if err != nil {
+			// Only pprof profiles can be partially recovered.
+			if !s.lenientParsing || decoder != nil || !errors.Is(err, io.ErrUnexpectedEOF) {
+				s.metrics.fail(failureParse, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "failed to decompress profile: %v", err)
+			}
+			partial = true
+		}
+
+		p := &pprofpb.Profile{}
+		if decoder != nil {
+			if p, err = decoder.Decode(content); err != nil {
+				s.metrics.fail(failureParse, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "failed to decode %s profile: %v", sample.Format, err)
+			}
+		} else if err := p.UnmarshalVT(content); err != nil {
+			if !s.lenientParsing {
+				s.metrics.fail(failureParse, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "failed to parse profile: %v", err)
+			}
+			partial = true
+		}
+		if partial {
+			recovered, err := recoverProfile(content)
 			if err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "failed to decompress profile: %v", err)
+				s.metrics.fail(failureParse, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "failed to recover partial profile: %v", err)
 			}
+			level.Warn(s.logger).Log("msg", "stored partially recovered profile", "labels", ls.String(), "samples", len(recovered.Sample))
+			w.span.SetAttributes(attribute.Bool("partial", true))
+			p = recovered
+		}
 
-			p := &pprofpb.Profile{}
-			if err := p.UnmarshalVT(content); err != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "failed to parse profile: %v", err)
+		if reason, err := s.limits.checkProfile(ls, p, time.Now()); err != nil {
+			s.metrics.reject(reason, 1)
+			return 0, 0, err
+		}
+
+		symbolized := applySymbols(p, sample.Symbols)
+		filtered := filterSampleTypes(p, series.SampleTypes)
+		if filtered && len(p.SampleType) == 0 {
+			level.Debug(s.logger).Log("msg", "no sample types of profile selected, dropping it", "labels", ls.String())
+			skipped++
+			continue
+		}
+
+		if s.locationlessProfiles != "" && !hasLocations(p) {
+			if s.locationlessProfiles == LocationlessProfilesReject {
+				s.metrics.fail(failureInvalid, 1)
+				return 0, 0, status.Errorf(codes.InvalidArgument, "profile of series %s has samples without locations", ls.String())
 			}
+			level.Warn(s.logger).Log("msg", "stored profile with samples without locations", "labels", ls.String(), "samples", len(p.Sample))
+		}
 
-			if s.debugValueLog {
-				dir := fmt.Sprintf("tmp/%s", base64.URLEncoding.EncodeToString([]byte(ls.String())))
-				err := os.MkdirAll(dir, os.ModePerm)
+		if s.quarantine != nil {
+			if reason := s.quarantine.reason(ls, p); reason != "" {
+				qls := labels.NewBuilder(ls).Set(QuarantineReasonLabel, reason).Labels()
+				qingester, err := s.quarantineIngester(ctx)
 				if err != nil {
-					level.Error(s.logger).Log("msg", "failed to create debug-value-log directory", "err", err)
-				} else {
-					err := os.WriteFile(fmt.Sprintf("%s/%d.pb.gz", dir, timestamp.FromTime(time.Now())), sample.RawProfile, 0o644)
-					if err != nil {
-						level.Error(s.logger).Log("msg", "failed to write debug-value-log", "err", err)
-					}
+					return 0, 0, err
 				}
+				if err := qingester.Ingest(ctx, qls, p, w.normalized); err != nil {
+					s.metrics.fail(failureAppend, 1)
+					return 0, 0, status.Errorf(codes.Internal, "failed to ingest quarantined profile: %v", err)
+				}
+				level.Warn(s.logger).Log("msg", "quarantined profile", "labels", ls.String(), "reason", reason)
+				skipped++
+				continue
 			}
+		}
 
-			if err := ingester.Ingest(ctx, ls, p, req.Normalized); err != nil {
-				return nil, status.Errorf(codes.Internal, "failed to ingest profile: %v", err)
+		parsed := p
+		// admitted reports to the sample limiter whether the admitted
+		// sample was written.
+		admitted := func(bool) {}
+		if s.sampleLimiter != nil {
+			var ok bool
+			if p, admitted, ok = s.sampleLimiter.Admit(ctx, ls, p); !ok {
+				skipped++
+				continue
 			}
 		}
+
+		if s.debugValueLog {
+			dir := fmt.Sprintf("tmp/%s", base64.URLEncoding.EncodeToString([]byte(ls.String())))
+			err := os.MkdirAll(dir, os.ModePerm)
+			if err != nil {
+				level.Error(s.logger).Log("msg", "failed to create debug-value-log directory", "err", err)
+			} else {
+				err := os.WriteFile(fmt.Sprintf("%s/%d.pb.gz", dir, timestamp.FromTime(time.Now())), sample.RawProfile, 0o644)
+				if err != nil {
+					level.Error(s.logger).Log("msg", "failed to write debug-value-log", "err", err)
+				}
+			}
+		}
+
+		sampleLabels := ls
+		if partial {
+			sampleLabels = labels.NewBuilder(ls).Set(PartialLabel, "true").Labels()
+		}
+
+		if err := w.ingester.Ingest(ctx, sampleLabels, p, w.normalized); err != nil {
+			admitted(false)
+			s.metrics.fail(failureAppend, 1)
+			return 0, 0, status.Errorf(codes.Internal, "failed to ingest profile: %v", err)
+		}
+		admitted(true)
+		s.written(ctx, sampleLabels)
+		accepted++
+		if s.forwarder != nil {
+			s.forward(ctx, forwardLabels, series.SampleTypes, sample, p, parsed, w.normalized)
+		}
+
+		// Partial, merged, symbolized, filtered and decoded profiles
+		// differ from the profile written.
+		if s.originals != nil && !partial && !symbolized && !filtered && decoder == nil && p == parsed {
+			original := content
+			if sample.ContentEncoding == ContentEncodingGzip || sample.ContentEncoding == "" && isGzip(sample.RawProfile) {
+				original = sample.RawProfile
+			}
+			if err := s.originals.Upload(ctx, sampleLabels, p.TimeNanos/time.Millisecond.Nanoseconds(), original); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to store original profile, it will be reconstructed on download", "labels", sampleLabels.String(), "err", err)
+			}
+		}
+	}
+
+	return accepted, skipped, nil
+}
+
+// forward forwards a written sample. Samples the sample limiter merged with
+// the samples it held back are forwarded as the profile written, so that the
+// held back samples aren't lost downstream.
+func (s *ProfileColumnStore) forward(ctx context.Context, ls labels.Labels, sampleTypes []*profilestorepb.SampleType, sample *profilestorepb.RawSample, p, parsed *pprofpb.Profile, normalized bool) {
+	if p != parsed {
+		data, err := p.MarshalVT()
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to marshal merged profile, not forwarding it", "labels", ls.String(), "err", err)
+			return
+		}
+		sample = &profilestorepb.RawSample{RawProfile: data, ContentEncoding: ContentEncodingNone}
 	}
+	s.forwarder.Forward(ctx, ls, sampleTypes, sample, normalized)
+}
 
-	return &profilestorepb.WriteRawResponse{}, nil
+// written calls the write hook, if any, with the labels of a series written
+// to.
+func (s *ProfileColumnStore) written(ctx context.Context, ls labels.Labels) {
+	if s.writeHook != nil {
+		s.writeHook(ctx, ls)
+	}
+}
+
+// ingester returns an ingester of profiles into the table of the tenant of
+// the context, retrying failed appends if configured to.
+func (s *ProfileColumnStore) ingester(ctx context.Context) (*parcacol.Ingester, error) {
+	table, err := s.tenantTable(ctx, s.table)
+	if err != nil {
+		return nil, err
+	}
+	opts := s.ingesterOpts
+	for _, r := range s.rollups {
+		rollups, err := s.tenantTable(ctx, r.table)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts[:len(opts):len(opts)], parcacol.WithRollups(rollups, r.granularity))
+	}
+	return s.newIngester(table, opts...), nil
+}
+
+// quarantineIngester returns an ingester of profiles into the quarantine
+// table of the tenant of the context, quarantined profiles aren't rolled up.
+func (s *ProfileColumnStore) quarantineIngester(ctx context.Context) (*parcacol.Ingester, error) {
+	table, err := s.tenantTable(ctx, s.quarantine.table)
+	if err != nil {
+		return nil, err
+	}
+	return s.newIngester(table, s.ingesterOpts...), nil
+}
+
+// tenantTable returns the table of the tenant of the context corresponding
+// to the table of the default tenant.
+func (s *ProfileColumnStore) tenantTable(ctx context.Context, t *frostdb.Table) (*frostdb.Table, error) {
+	if s.tenants == nil {
+		return t, nil
+	}
+	t, err := s.tenants.Table(ctx, t)
+	switch {
+	case errors.Is(err, parcacol.ErrTenantNotAllowed):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, parcacol.ErrTooManyTenants):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "failed to get table of tenant: %v", err)
+	}
+	return t, nil
+}
+
+// rollup is a table of rollups at a granularity.
+type rollup struct {
+	table       *frostdb.Table
+	granularity time.Duration
+}
+
+func (s *ProfileColumnStore) newIngester(t *frostdb.Table, opts ...parcacol.IngesterOption) *parcacol.Ingester {
+	var table parcacol.Table = t
+	if s.appendAttempts > 1 {
+		table = &retryingTable{
+			Table:       t,
+			logger:      s.logger,
+			attempts:    s.appendAttempts,
+			backoff:     s.appendBackoff,
+			isRetryable: s.isRetryable,
+		}
+	}
+	opts = append([]parcacol.IngesterOption{parcacol.WithIngesterTracer(s.tracer)}, opts...)
+	return parcacol.NewIngester(
+		s.logger,
+		parcacol.NewNormalizer(s.metastore, s.normalizerOpts...),
+		table,
+		s.schema,
+		opts...,
+	)
+}
+
+// hasLocations returns whether a profile has no samples or any of its samples
+// references a location.
+func hasLocations(p *pprofpb.Profile) bool {
+	if len(p.Sample) == 0 {
+		return true
+	}
+	for _, sample := range p.Sample {
+		if len(sample.LocationId) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// seriesLabels validates the labels of a series written by a client. Labels
+// reserved for the ingest source are dropped, the others are lowercased if
+// configured to.
+//
+// The __name__ label names the profile, it is stored as is next to the
+// sample types of the profile rather than suffixed with them, unless a
+// series name template is configured. Queries select profiles by their name
+// and sample type separated by colons, so names must not contain colons.
+func (s *ProfileColumnStore) seriesLabels(pbls []*profilestorepb.Label) (labels.Labels, error) {
+	ls := make(labels.Labels, 0, len(pbls)+1)
+	seen := make(map[string]int, len(pbls))
+	for _, l := range pbls {
+		if valid := model.LabelName(l.Name).IsValid(); !valid {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid label name: %v", l.Name)
+		}
+		if l.Name == labels.MetricName && (l.Value == "" || strings.Contains(l.Value, ":")) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid profile name %q of label %s, it must not be empty or contain colons", l.Value, labels.MetricName)
+		}
+
+		name, value := l.Name, l.Value
+		if s.lowercaseLabelNames {
+			name = strings.ToLower(name)
+		}
+		if s.lowercaseLabelValues && name != labels.MetricName {
+			value = strings.ToLower(value)
+		}
+		if name == IngestSourceLabel || (s.exposeIngestSource && name == ExposedIngestSourceLabel) {
+			continue
+		}
+
+		// Labels differing only in case are merged if their values are the
+		// same after lowercasing, there is no telling which to keep otherwise.
+		if i, ok := seen[name]; ok {
+			if !s.lowercaseLabelNames {
+				return nil, status.Errorf(codes.InvalidArgument, "duplicate label name: %v", name)
+			}
+			if ls[i].Value != value {
+				return nil, status.Errorf(codes.InvalidArgument, "label %s has conflicting values %q and %q after lowercasing", name, ls[i].Value, value)
+			}
+			continue
+		}
+		seen[name] = len(ls)
+
+		ls = append(ls, labels.Label{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return ls, nil
 }
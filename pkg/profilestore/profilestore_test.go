@@ -14,20 +14,35 @@
 package profilestore
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/apache/arrow/go/v8/arrow/memory"
 	"github.com/go-kit/log"
 	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
 	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
 	"github.com/parca-dev/parca/pkg/metastore"
 	"github.com/parca-dev/parca/pkg/metastoretest"
+	"github.com/parca-dev/parca/pkg/originals"
 	"github.com/parca-dev/parca/pkg/parcacol"
 )
 
@@ -63,6 +78,7 @@ func Test_LabelName_Invalid(t *testing.T) {
 
 	api := NewProfileColumnStore(
 		logger,
+		reg,
 		tracer,
 		metastore.NewInProcessClient(m),
 		table,
@@ -86,3 +102,591 @@ func Test_LabelName_Invalid(t *testing.T) {
 
 	require.Equal(t, st.Code(), codes.InvalidArgument)
 }
+
+func TestIngestSource(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		ctx    context.Context
+		labels []*profilestorepb.Label
+		expose bool
+		source string
+	}{
+		"push": {
+			ctx:    context.Background(),
+			expose: true,
+			source: IngestSourcePush,
+		},
+		"scrape": {
+			ctx:    WithIngestSource(context.Background(), IngestSourceScrape),
+			expose: true,
+			source: IngestSourceScrape,
+		},
+		"client cannot claim to be the scraper": {
+			ctx: context.Background(),
+			labels: []*profilestorepb.Label{
+				{Name: IngestSourceLabel, Value: IngestSourceScrape},
+				{Name: ExposedIngestSourceLabel, Value: IngestSourceScrape},
+			},
+			expose: true,
+			source: IngestSourcePush,
+		},
+		"internal": {
+			ctx:    WithIngestSource(context.Background(), IngestSourceScrape),
+			labels: []*profilestorepb.Label{{Name: IngestSourceLabel, Value: IngestSourceScrape}},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var opts []Option
+			if test.expose {
+				opts = append(opts, WithExposedIngestSource())
+			}
+			api, querier := newTestProfileColumnStore(t, opts...)
+
+			_, err := api.WriteRaw(test.ctx, &profilestorepb.WriteRawRequest{
+				Series: []*profilestorepb.RawProfileSeries{{
+					Labels: &profilestorepb.LabelSet{
+						Labels: append([]*profilestorepb.Label{
+							{Name: "__name__", Value: "memory"},
+							{Name: "job", Value: "test"},
+						}, test.labels...),
+					},
+					Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+				}},
+			})
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			names, err := querier.Labels(ctx, nil, time.Unix(0, 0), time.Now())
+			require.NoError(t, err)
+			require.Contains(t, names, "job")
+			require.NotContains(t, names, IngestSourceLabel)
+
+			if !test.expose {
+				require.NotContains(t, names, ExposedIngestSourceLabel)
+				return
+			}
+
+			vals, err := querier.Values(ctx, ExposedIngestSourceLabel, nil, time.Unix(0, 0), time.Now())
+			require.NoError(t, err)
+			require.Equal(t, []string{test.source}, vals)
+		})
+	}
+}
+
+func newTestProfileColumnStore(t *testing.T, opts ...Option) (*ProfileColumnStore, *parcacol.Querier) {
+	t.Helper()
+
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	col, err := frostdb.New(logger, reg)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, tracer))
+
+	return NewProfileColumnStore(logger, reg, tracer, m, table, schema, false, opts...),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(memory.DefaultAllocator, colDB.TableProvider()),
+			"stacktraces",
+			m,
+		)
+}
+
+func TestWriteRawLowercaseLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, ls ...*profilestorepb.Label) error {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: append([]*profilestorepb.Label{{Name: "__name__", Value: "memory"}}, ls...)},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+		return err
+	}
+
+	store, querier := newTestProfileColumnStore(t, WithLowercaseLabels(false))
+	require.NoError(t, write(store, &profilestorepb.Label{Name: "Service", Value: "API"}))
+	require.NoError(t, write(store, &profilestorepb.Label{Name: "service", Value: "api"}))
+
+	names, err := querier.Labels(ctx, nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"service"}, names)
+	vals, err := querier.Values(ctx, "service", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"API", "api"}, vals)
+
+	store, querier = newTestProfileColumnStore(t, WithLowercaseLabels(true))
+	require.NoError(t, write(store, &profilestorepb.Label{Name: "Service", Value: "API"}))
+	require.NoError(t, write(store, &profilestorepb.Label{Name: "service", Value: "api"}))
+	require.NoError(t, write(store,
+		&profilestorepb.Label{Name: "SERVICE", Value: "Api"},
+		&profilestorepb.Label{Name: "service", Value: "api"},
+	))
+
+	vals, err = querier.Values(ctx, "service", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"api"}, vals)
+	series, err := querier.QueryRange(ctx, `memory:alloc_objects:count:space:bytes`, time.Unix(0, 0), time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+
+	err = write(store,
+		&profilestorepb.Label{Name: "Service", Value: "api"},
+		&profilestorepb.Label{Name: "service", Value: "web"},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWriteRawOriginals(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bucket := objstore.NewInMemBucket()
+	store, _ := newTestProfileColumnStore(t, WithOriginals(originals.NewStore(prometheus.NewRegistry(), bucket)))
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	ls := labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}}
+
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+				{Name: "__name__", Value: "memory"},
+				{Name: "job", Value: "a"},
+			}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, bucket.Objects(), 1)
+
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(b))
+
+	original, ok, err := originals.NewStore(prometheus.NewRegistry(), bucket).Download(ctx, ls, p.TimeNanos/time.Millisecond.Nanoseconds())
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, content, original)
+}
+
+func TestWriteRawLocationlessProfiles(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(b))
+	for _, s := range p.Sample {
+		s.LocationId = nil
+	}
+	b, err = p.MarshalVT()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	locationless := buf.Bytes()
+
+	write := func(store *ProfileColumnStore, content []byte) error {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+		return err
+	}
+
+	for _, mode := range []string{"", LocationlessProfilesWarn} {
+		store, querier := newTestProfileColumnStore(t, WithLocationlessProfiles(mode))
+		require.NoError(t, write(store, locationless))
+		series, err := querier.QueryRange(ctx, `memory:alloc_objects:count:space:bytes`, time.Unix(0, 0), time.Now(), 0)
+		require.NoError(t, err)
+		require.Len(t, series, 1)
+	}
+
+	store, querier := newTestProfileColumnStore(t, WithLocationlessProfiles(LocationlessProfilesReject))
+	require.Equal(t, codes.InvalidArgument, status.Code(write(store, locationless)))
+	_, err = querier.QueryRange(ctx, `memory:alloc_objects:count:space:bytes`, time.Unix(0, 0), time.Now(), 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.NoError(t, write(store, content))
+}
+
+func TestWriteRawEnricher(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, instance string) error {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+					{Name: "__name__", Value: "memory"},
+					{Name: "instance", Value: instance},
+				}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+		return err
+	}
+
+	// The stubbed source knows the team of instance a, looking up instance b
+	// times out.
+	var lookups int64
+	source := EnricherFunc(func(ctx context.Context, ls labels.Labels) (labels.Labels, error) {
+		atomic.AddInt64(&lookups, 1)
+		switch ls.Get("instance") {
+		case "a":
+			return labels.Labels{{Name: "team", Value: "storage"}}, nil
+		case "b":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	})
+	enricher := NewCachingEnricher(source, 10, time.Minute, 10*time.Millisecond)
+	t.Cleanup(func() { enricher.Close() })
+
+	store, querier := newTestProfileColumnStore(t, WithEnricher(enricher, true))
+	require.NoError(t, write(store, "a"))
+	require.NoError(t, write(store, "a"))
+	require.Equal(t, int64(1), atomic.LoadInt64(&lookups))
+	require.NoError(t, write(store, "b"))
+	require.NoError(t, write(store, "c"))
+
+	vals, err := querier.Values(ctx, "team", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"storage"}, vals)
+	vals, err = querier.Values(ctx, "instance", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b", "c"}, vals)
+
+	store, _ = newTestProfileColumnStore(t, WithEnricher(enricher, false))
+	require.NoError(t, write(store, "a"))
+	require.Equal(t, codes.Unavailable, status.Code(write(store, "b")))
+}
+
+func TestWriteRawMaxProfileSize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	small, err := os.ReadFile("../query/testdata/profile1.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, raws ...[]byte) error {
+		samples := make([]*profilestorepb.RawSample, 0, len(raws))
+		for _, raw := range raws {
+			samples = append(samples, &profilestorepb.RawSample{RawProfile: raw})
+		}
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+				Samples: samples,
+			}},
+		})
+		return err
+	}
+
+	store, _ := newTestProfileColumnStore(t, WithMaxProfileSize(int64(len(content))))
+	require.NoError(t, write(store, content))
+
+	// The write is rejected as a whole, even the profiles within the limit
+	// aren't stored.
+	store, querier := newTestProfileColumnStore(t, WithMaxProfileSize(int64(len(content))-1))
+	err = write(store, small, content)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, err.Error(), fmt.Sprintf("profile of %d bytes exceeds the maximum profile size of %d bytes", len(content), len(content)-1))
+	types, err := querier.ProfileTypes(ctx)
+	require.NoError(t, err)
+	require.Empty(t, types)
+}
+
+func TestWriteRawMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(store *ProfileColumnStore, labelName string, raws ...[]byte) error {
+		samples := make([]*profilestorepb.RawSample, 0, len(raws))
+		for _, raw := range raws {
+			samples = append(samples, &profilestorepb.RawSample{RawProfile: raw})
+		}
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: labelName, Value: "memory"}}},
+				Samples: samples,
+			}},
+		})
+		return err
+	}
+
+	store, _ := newTestProfileColumnStore(t)
+	require.NoError(t, write(store, "__name__", content, content))
+	require.Error(t, write(store, "__name__", []byte{0x1f, 0x8b, 0x00}))
+	require.Error(t, write(store, "invalid name", content, content, content))
+
+	// Samples are counted, not writes.
+	require.Equal(t, 6.0, testutil.ToFloat64(store.metrics.received))
+	require.Equal(t, 1.0, testutil.ToFloat64(store.metrics.failed.WithLabelValues(failureParse)))
+	require.Equal(t, 3.0, testutil.ToFloat64(store.metrics.failed.WithLabelValues(failureInvalid)))
+	require.Equal(t, 0.0, testutil.ToFloat64(store.metrics.failed.WithLabelValues(failureAppend)))
+	require.Equal(t, 1, testutil.CollectAndCount(store.metrics.profileSize))
+}
+
+func TestWriteRawLabelValidation(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		labels []*profilestorepb.Label
+		opts   []Option
+		err    string
+	}{
+		"invalid name": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "pod name", Value: "a"}},
+			err:    "invalid label name: pod name",
+		},
+		"dotted name": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "k8s.pod", Value: "a"}},
+			err:    "invalid label name: k8s.pod",
+		},
+		"duplicate name": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}, {Name: "job", Value: "b"}},
+			err:    "duplicate label name: job",
+		},
+		"duplicate name after lowercasing": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}, {Name: "Job", Value: "b"}},
+			opts:   []Option{WithLowercaseLabels(false)},
+			err:    `label job has conflicting values "a" and "b" after lowercasing`,
+		},
+		"missing profile name": {
+			labels: []*profilestorepb.Label{{Name: "job", Value: "a"}},
+			err:    "series has no __name__ label naming the profile",
+		},
+		"empty profile name": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: ""}},
+			err:    "invalid profile name",
+		},
+		"profile name with colons": {
+			labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory:alloc_objects"}},
+			err:    "invalid profile name",
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store, _ := newTestProfileColumnStore(t, test.opts...)
+			_, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+				Series: []*profilestorepb.RawProfileSeries{{
+					Labels:  &profilestorepb.LabelSet{Labels: test.labels},
+					Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+				}},
+			})
+			require.Equal(t, codes.InvalidArgument, status.Code(err))
+			require.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+// The name of a profile is stored as written, its sample types are stored
+// separately rather than appended to it.
+func TestWriteRawProfileName(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	store, querier := newTestProfileColumnStore(t)
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory_alloc_objects_count"}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+		}},
+	})
+	require.NoError(t, err)
+
+	types, err := querier.ProfileTypes(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, types)
+	for _, typ := range types {
+		require.Equal(t, "memory_alloc_objects_count", typ.Name)
+	}
+}
+
+func TestWriteRawResponseCounts(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	store, _ := newTestProfileColumnStore(t)
+	resp, err := store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}, {RawProfile: content}},
+		}, {
+			Labels:      &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "filtered"}}},
+			Samples:     []*profilestorepb.RawSample{{RawProfile: content}},
+			SampleTypes: []*profilestorepb.SampleType{{Type: "cpu", Unit: "nanoseconds"}},
+		}},
+	})
+	require.NoError(t, err)
+	// Each accepted sample is stored as a profile per sample type, the
+	// counts are of the samples written.
+	require.Equal(t, uint64(2), resp.AcceptedSamples)
+	require.Equal(t, uint64(1), resp.SkippedSamples)
+}
+
+func TestWriteRawCanceled(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The client goes away after the first profile is written.
+	written := []string{}
+	store, querier := newTestProfileColumnStore(t, WithWriteHook(func(_ context.Context, ls labels.Labels) {
+		written = append(written, ls.Get("job"))
+		cancel()
+	}))
+
+	series := []*profilestorepb.RawProfileSeries{}
+	for _, job := range []string{"a", "b", "c"} {
+		series = append(series, &profilestorepb.RawProfileSeries{
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: job}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+		})
+	}
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{Series: series})
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Equal(t, []string{"a"}, written)
+
+	// The profile written before the cancellation is kept.
+	values, err := querier.Values(context.Background(), "job", nil, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, values)
+}
+
+type forwarded struct {
+	labels     labels.Labels
+	sample     *profilestorepb.RawSample
+	normalized bool
+}
+
+type recordingForwarder struct {
+	forwarded []forwarded
+}
+
+func (f *recordingForwarder) Forward(_ context.Context, ls labels.Labels, _ []*profilestorepb.SampleType, sample *profilestorepb.RawSample, normalized bool) {
+	f.forwarded = append(f.forwarded, forwarded{labels: ls, sample: sample, normalized: normalized})
+}
+
+func TestWriteRawForwarder(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	f := &recordingForwarder{}
+	store, _ := newTestProfileColumnStore(t, WithForwarder(f), WithExposedIngestSource())
+
+	// Only profiles that were stored are forwarded.
+	_, err = store.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+		}, {
+			Labels:  &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "b"}}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: []byte("not a profile")}},
+		}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.Len(t, f.forwarded, 1)
+	// The receiving store exposes the ingest source itself.
+	require.Equal(t, labels.FromStrings("__name__", "memory", "job", "a"), f.forwarded[0].labels)
+	require.Equal(t, content, f.forwarded[0].sample.RawProfile)
+	require.False(t, f.forwarded[0].normalized)
+}
+
+func TestWriteRawTraceMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		TraceIDMetadataKey, "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanIDMetadataKey, "00f067aa0ba902b7",
+	))
+	store, querier := newTestProfileColumnStore(t)
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	for _, ls := range [][]*profilestorepb.Label{
+		{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "a"}},
+		{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "b"}, {Name: "trace_id", Value: "a3ce929d0e0e47364bf92f3577b34da6"}},
+	} {
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: ls},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+		require.NoError(t, err)
+	}
+
+	vals, err := querier.Values(ctx, parcacol.TraceIDLabel, nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"4bf92f3577b34da6a3ce929d0e0e4736", "a3ce929d0e0e47364bf92f3577b34da6"}, vals)
+
+	vals, err = querier.Values(ctx, parcacol.SpanIDLabel, nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"00f067aa0ba902b7"}, vals)
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"github.com/polarsignals/frostdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+)
+
+// QuarantineReasonLabel is the label quarantined profiles are stored with,
+// set to the reason they were quarantined for.
+const QuarantineReasonLabel = "quarantine_reason"
+
+// Reasons profiles are quarantined for.
+const (
+	QuarantineReasonOversized         = "oversized"
+	QuarantineReasonHighCardinality   = "high_cardinality"
+	QuarantineReasonUnusualSampleType = "unusual_sample_type"
+)
+
+// Quarantine stores written profiles failing soft checks in a separate
+// table, rather than rejecting them, so operators can inspect them. Queries
+// exclude quarantined profiles unless they explicitly select them.
+type Quarantine struct {
+	table *frostdb.Table
+
+	maxSamples  int
+	maxLabels   int
+	sampleTypes map[string]struct{}
+
+	quarantined *prometheus.CounterVec
+}
+
+// NewQuarantine quarantines profiles of more than maxSamples samples, of
+// series of more than maxLabels labels, or with a sample type other than
+// sampleTypes into the table. Checks whose limit is 0 or empty are
+// disabled.
+func NewQuarantine(reg prometheus.Registerer, table *frostdb.Table, maxSamples, maxLabels int, sampleTypes []string) *Quarantine {
+	q := &Quarantine{
+		table:      table,
+		maxSamples: maxSamples,
+		maxLabels:  maxLabels,
+		quarantined: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_profilestore_quarantined_profiles_total",
+			Help: "Number of written profiles stored in the quarantine for failing soft checks.",
+		}, []string{"reason"}),
+	}
+	if len(sampleTypes) > 0 {
+		q.sampleTypes = make(map[string]struct{}, len(sampleTypes))
+		for _, t := range sampleTypes {
+			q.sampleTypes[t] = struct{}{}
+		}
+	}
+	reg.MustRegister(q.quarantined)
+	return q
+}
+
+// reason returns the reason the profile of the series is quarantined for,
+// or an empty string if it passes the checks.
+func (q *Quarantine) reason(ls labels.Labels, p *pprofpb.Profile) string {
+	reason := ""
+	switch {
+	case q.maxSamples > 0 && len(p.Sample) > q.maxSamples:
+		reason = QuarantineReasonOversized
+	case q.maxLabels > 0 && len(ls) > q.maxLabels:
+		reason = QuarantineReasonHighCardinality
+	case q.sampleTypes != nil && !q.knownSampleTypes(p):
+		reason = QuarantineReasonUnusualSampleType
+	default:
+		return ""
+	}
+	q.quarantined.WithLabelValues(reason).Inc()
+	return reason
+}
+
+func (q *Quarantine) knownSampleTypes(p *pprofpb.Profile) bool {
+	for _, t := range p.SampleType {
+		if t.Type < 0 || t.Type >= int64(len(p.StringTable)) {
+			return false
+		}
+		if _, ok := q.sampleTypes[p.StringTable[t.Type]]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+func TestWriteRawQuarantine(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	col, err := frostdb.New(logger, reg)
+	require.NoError(t, err)
+	colDB, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+	table, err := colDB.Table("stacktraces", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+	quarantine, err := colDB.Table("quarantine", frostdb.NewTableConfig(schema))
+	require.NoError(t, err)
+
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(t, logger, reg, tracer))
+	store := NewProfileColumnStore(logger, reg, tracer, m, table, schema, false,
+		WithQuarantine(NewQuarantine(reg, quarantine, 0, 0, []string{"alloc_objects", "alloc_space", "inuse_objects", "inuse_space"})),
+	)
+	querier := parcacol.NewQuerier(
+		tracer,
+		query.NewEngine(memory.DefaultAllocator, colDB.TableProvider()),
+		"stacktraces",
+		m,
+		parcacol.WithQuarantineTable("quarantine"),
+	)
+
+	write := func(name, file string) {
+		raw, err := os.ReadFile(file)
+		require.NoError(t, err)
+		_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+					{Name: "__name__", Value: name},
+					{Name: "job", Value: "default"},
+				}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: raw}},
+			}},
+		})
+		require.NoError(t, err)
+	}
+	// The CPU profile has an unexpected sample type and is quarantined.
+	write("memory", "../query/testdata/alloc_objects.pb.gz")
+	write("process_cpu", "../query/testdata/profile1.pb.gz")
+
+	start, end := time.Unix(0, 0), time.Unix(math.MaxInt32, 0)
+	const (
+		memoryQuery = `memory:alloc_objects:count:space:bytes{job="default"}`
+		cpuQuery    = `process_cpu:samples:count:cpu:nanoseconds:delta{job="default"}`
+	)
+
+	series, err := querier.QueryRange(ctx, memoryQuery, start, end, 0)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	_, err = querier.QueryRange(ctx, cpuQuery, start, end, 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	quarantinedCtx := parcacol.WithQuarantined(ctx)
+	series, err = querier.QueryRange(quarantinedCtx, cpuQuery, start, end, 0)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	labels := map[string]string{}
+	for _, l := range series[0].Labelset.Labels {
+		labels[l.Name] = l.Value
+	}
+	require.Equal(t, QuarantineReasonUnusualSampleType, labels[QuarantineReasonLabel])
+	_, err = querier.QueryRange(quarantinedCtx, memoryQuery, start, end, 0)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Limits of rate limiting.
+const (
+	rateLimitRequests = "requests"
+	rateLimitBytes    = "bytes"
+)
+
+// RateLimits are the rates at which each client may write profiles.
+type RateLimits struct {
+	// RequestsPerSecond is the rate of write requests, unlimited if 0.
+	RequestsPerSecond float64
+	// RequestBurst is the number of requests that may be made at once, the
+	// requests per second rounded up if 0.
+	RequestBurst int
+	// BytesPerSecond is the rate of the bytes of write requests, unlimited
+	// if 0.
+	BytesPerSecond float64
+	// ByteBurst is the number of bytes that may be written at once, the
+	// bytes per second rounded up if 0. Larger write requests are always
+	// rejected.
+	ByteBurst int
+}
+
+// RateLimiter limits the rate at which each client writes profiles with
+// WriteRaw, WriteRawStream, WriteTrace and uploads. Clients are told by their
+// identity, see IdentityFromContext, otherwise by their IP. Writes exceeding
+// the limits are rejected with ResourceExhausted and the time to retry after.
+// Only writes of network clients are limited, in-process writers like the
+// scraper, the self-profiler and the NATS consumer never are.
+type RateLimiter struct {
+	limits         RateLimits
+	trustedProxies []*net.IPNet
+
+	// now returns the current time, it is settable for testing convenience.
+	now func() time.Time
+
+	mtx       sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+	// refill is the time after which the limiters of a client are full
+	// again, so clients unseen for longer are forgotten.
+	refill time.Duration
+
+	throttled *prometheus.CounterVec
+}
+
+type clientLimiter struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+	seen     time.Time
+}
+
+// NewRateLimiter limits the writes of each client to the limits. The IPs of
+// clients behind the trusted proxies are taken from the X-Forwarded-For
+// header.
+func NewRateLimiter(reg prometheus.Registerer, limits RateLimits, trustedProxies []*net.IPNet) *RateLimiter {
+	l := &RateLimiter{
+		trustedProxies: trustedProxies,
+		now:            time.Now,
+		clients:        map[string]*clientLimiter{},
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_profilestore_throttled_requests_total",
+			Help: "Number of write requests rejected for exceeding the rate limits of their client, by the limit exceeded.",
+		}, []string{"limit"}),
+	}
+	if limits.RequestsPerSecond > 0 {
+		if limits.RequestBurst <= 0 {
+			limits.RequestBurst = int(math.Ceil(limits.RequestsPerSecond))
+		}
+		l.refill = refillTime(limits.RequestBurst, limits.RequestsPerSecond)
+	}
+	if limits.BytesPerSecond > 0 {
+		if limits.ByteBurst <= 0 {
+			limits.ByteBurst = int(math.Ceil(limits.BytesPerSecond))
+		}
+		if refill := refillTime(limits.ByteBurst, limits.BytesPerSecond); refill > l.refill {
+			l.refill = refill
+		}
+	}
+	l.limits = limits
+
+	for _, limit := range []string{rateLimitRequests, rateLimitBytes} {
+		l.throttled.WithLabelValues(limit)
+	}
+	reg.MustRegister(l.throttled)
+	return l
+}
+
+func refillTime(burst int, perSecond float64) time.Duration {
+	return time.Duration(float64(burst) / perSecond * float64(time.Second))
+}
+
+// allowWrite returns an error if the client of the context may not make the
+// write requests of the bytes now, see WithRateLimiter.
+func (s *ProfileColumnStore) allowWrite(ctx context.Context, requests, bytes int) error {
+	if s.rateLimiter == nil || !fromNetworkClient(ctx) {
+		return nil
+	}
+	return s.rateLimiter.allow(ctx, requests, bytes)
+}
+
+// fromNetworkClient returns whether the writes of the context are pushed by a
+// client over the network, gRPC requests and uploads carry the peer of their
+// client. Scraped and self-profiled writes have sources other than push, and
+// in-process writers like the NATS consumer have no peer.
+func fromNetworkClient(ctx context.Context) bool {
+	if IngestSourceFromContext(ctx) != IngestSourcePush {
+		return false
+	}
+	_, ok := peer.FromContext(ctx)
+	return ok
+}
+
+// allow returns an error if the client of the context may not make the
+// requests of the bytes now.
+func (l *RateLimiter) allow(ctx context.Context, requests, bytes int) error {
+	key := l.clientKey(ctx)
+	now := l.now()
+	c := l.client(key, now)
+
+	if c.bytes != nil && bytes > l.limits.ByteBurst {
+		l.throttled.WithLabelValues(rateLimitBytes).Inc()
+		return status.Errorf(codes.ResourceExhausted, "write of %d bytes of client %s exceeds the burst of %d bytes of the rate limit", bytes, key, l.limits.ByteBurst)
+	}
+
+	var (
+		reservations []*rate.Reservation
+		delay        time.Duration
+		limit        string
+	)
+	reserve := func(limiter *rate.Limiter, n int, name string) {
+		if limiter == nil || n == 0 {
+			return
+		}
+		r := limiter.ReserveN(now, n)
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay, limit = d, name
+		}
+	}
+	reserve(c.requests, requests, rateLimitRequests)
+	reserve(c.bytes, bytes, rateLimitBytes)
+	if delay == 0 {
+		return nil
+	}
+
+	// Rejected requests don't use up the limits.
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	l.throttled.WithLabelValues(limit).Inc()
+
+	st := status.Newf(codes.ResourceExhausted, "%s rate limit of client %s exceeded, retry in %s", limit, key, delay)
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// clientKey returns the key of the limiters of the client of the context.
+func (l *RateLimiter) clientKey(ctx context.Context) string {
+	if identity, ok := IdentityFromContext(ctx); ok {
+		return fmt.Sprintf("identity %q", identity)
+	}
+	if addr, ok := clientAddress(ctx, l.trustedProxies); ok {
+		return "ip " + addr
+	}
+	return "unknown"
+}
+
+// client returns the limiters of the client, forgetting the clients whose
+// limiters are full again every so often.
+func (l *RateLimiter) client(key string, now time.Time) *clientLimiter {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.lastSweep) > l.refill {
+		for k, c := range l.clients {
+			if now.Sub(c.seen) > l.refill {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimiter{}
+		if l.limits.RequestsPerSecond > 0 {
+			c.requests = rate.NewLimiter(rate.Limit(l.limits.RequestsPerSecond), l.limits.RequestBurst)
+		}
+		if l.limits.BytesPerSecond > 0 {
+			c.bytes = rate.NewLimiter(rate.Limit(l.limits.BytesPerSecond), l.limits.ByteBurst)
+		}
+		l.clients[key] = c
+	}
+	c.seen = now
+	return c
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestRateLimiterRequests(t *testing.T) {
+	t.Parallel()
+
+	l := NewRateLimiter(prometheus.NewRegistry(), RateLimits{RequestsPerSecond: 1, RequestBurst: 2}, nil)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	a := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 4321},
+	})
+	b := WithIdentity(a, "agent-b")
+
+	require.NoError(t, l.allow(a, 1, 0))
+	require.NoError(t, l.allow(a, 1, 0))
+	err := l.allow(a, 1, 0)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Contains(t, err.Error(), "ip 192.168.1.1")
+
+	details := status.Convert(err).Details()
+	require.Len(t, details, 1)
+	require.Equal(t, time.Second, details[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	// Clients with an identity are limited separately from their IP.
+	require.NoError(t, l.allow(b, 1, 0))
+
+	// Rejected requests don't use up the limit.
+	now = now.Add(time.Second)
+	require.NoError(t, l.allow(a, 1, 0))
+	require.Error(t, l.allow(a, 1, 0))
+	require.Equal(t, 2.0, testutil.ToFloat64(l.throttled.WithLabelValues(rateLimitRequests)))
+
+	// Clients whose limits are full again are forgotten.
+	now = now.Add(3 * time.Second)
+	require.NoError(t, l.allow(b, 1, 0))
+	require.Len(t, l.clients, 1)
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	t.Parallel()
+
+	l := NewRateLimiter(prometheus.NewRegistry(), RateLimits{RequestsPerSecond: 10, BytesPerSecond: 100}, nil)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	ctx := WithIdentity(context.Background(), "agent")
+
+	require.NoError(t, l.allow(ctx, 1, 60))
+	err := l.allow(ctx, 1, 60)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, 200*time.Millisecond, status.Convert(err).Details()[0].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+
+	// Writes larger than the burst are never allowed.
+	now = now.Add(time.Minute)
+	err = l.allow(ctx, 1, 101)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Empty(t, status.Convert(err).Details())
+	require.Equal(t, 2.0, testutil.ToFloat64(l.throttled.WithLabelValues(rateLimitBytes)))
+	require.Equal(t, 0.0, testutil.ToFloat64(l.throttled.WithLabelValues(rateLimitRequests)))
+}
+
+func TestRateLimitedWrites(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	l := NewRateLimiter(prometheus.NewRegistry(), RateLimits{RequestsPerSecond: 1}, nil)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	store, _ := newTestProfileColumnStore(t, WithRateLimiter(l))
+
+	client := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.3"), Port: 4321},
+	})
+	ctx := WithIdentity(client, "agent")
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{})
+	require.NoError(t, err)
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// In-process writers are never limited, neither are scraped and
+	// self-profiled writes, even with the peer of a client.
+	for _, ctx := range []context.Context{
+		context.Background(),
+		WithIngestSource(client, IngestSourceScrape),
+		WithIngestSource(context.Background(), IngestSourceSelf),
+	} {
+		for i := 0; i < 3; i++ {
+			_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{})
+			require.NoError(t, err)
+		}
+	}
+
+	// Uploads are limited by the IP of their client like gRPC writes.
+	upload := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/profiles/upload?__name__=memory", bytes.NewReader(content))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		store.Upload(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, upload("192.168.1.1:4321"))
+	require.Equal(t, http.StatusTooManyRequests, upload("192.168.1.1:4322"))
+	require.Equal(t, http.StatusOK, upload("192.168.1.2:4321"))
+	require.Equal(t, 2.0, testutil.ToFloat64(l.throttled.WithLabelValues(rateLimitRequests)))
+}
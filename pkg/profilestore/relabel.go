@@ -0,0 +1,69 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+// Relabeler relabels the series of written profiles before they are stored,
+// like the write_relabel_configs of Prometheus remote write. Series are
+// dropped if relabeling drops them or their __name__ label. Its rules may be
+// replaced at runtime with ApplyConfig.
+type Relabeler struct {
+	mtx  sync.RWMutex
+	cfgs []*relabel.Config
+
+	dropped prometheus.Counter
+}
+
+func NewRelabeler(reg prometheus.Registerer, cfgs []*relabel.Config) *Relabeler {
+	r := &Relabeler{
+		cfgs: cfgs,
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_profilestore_relabel_dropped_samples_total",
+			Help: "Number of received samples dropped along with their series by write relabeling.",
+		}),
+	}
+	reg.MustRegister(r.dropped)
+	return r
+}
+
+// ApplyConfig replaces the relabeling rules, writes in flight keep relabeling
+// with the rules they started with.
+func (r *Relabeler) ApplyConfig(cfgs []*relabel.Config) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.cfgs = cfgs
+}
+
+// Process returns the relabeled labels of a series and whether it is kept.
+func (r *Relabeler) Process(ls labels.Labels) (labels.Labels, bool) {
+	r.mtx.RLock()
+	cfgs := r.cfgs
+	r.mtx.RUnlock()
+
+	if len(cfgs) == 0 {
+		return ls, true
+	}
+	ls = relabel.Process(ls, cfgs...)
+	if ls == nil || !ls.Has(labels.MetricName) {
+		return nil, false
+	}
+	return ls, true
+}
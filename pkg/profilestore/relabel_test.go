@@ -0,0 +1,127 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestWriteRawRelabel(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	relabeler := NewRelabeler(prometheus.NewRegistry(), []*relabel.Config{
+		{
+			Regex:  relabel.MustNewRegexp("pod_uid"),
+			Action: relabel.LabelDrop,
+		},
+		{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        relabel.MustNewRegexp("legacy-(.*)"),
+			TargetLabel:  "job",
+			Replacement:  "$1",
+			Action:       relabel.Replace,
+		},
+		{
+			SourceLabels: model.LabelNames{"job"},
+			Regex:        relabel.MustNewRegexp("debug"),
+			Action:       relabel.Drop,
+		},
+	})
+	store, querier := newTestProfileColumnStore(t, WithRelabeler(relabeler))
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(ls ...*profilestorepb.Label) (*profilestorepb.WriteRawResponse, error) {
+		return store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:  &profilestorepb.LabelSet{Labels: ls},
+				Samples: []*profilestorepb.RawSample{{RawProfile: content}},
+			}},
+		})
+	}
+
+	resp, err := write(
+		&profilestorepb.Label{Name: "__name__", Value: "memory"},
+		&profilestorepb.Label{Name: "job", Value: "legacy-api"},
+		&profilestorepb.Label{Name: "pod_uid", Value: "7f3c"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), resp.AcceptedSamples)
+
+	resp, err = write(
+		&profilestorepb.Label{Name: "__name__", Value: "memory"},
+		&profilestorepb.Label{Name: "job", Value: "debug"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), resp.AcceptedSamples)
+	require.Equal(t, uint64(1), resp.SkippedSamples)
+	require.Equal(t, 1.0, testutil.ToFloat64(relabeler.dropped))
+
+	names, err := querier.Labels(ctx, nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.NotContains(t, names, "pod_uid")
+
+	jobs, err := querier.Values(ctx, "job", nil, time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []string{"api"}, jobs)
+
+	// Relabeling must not name profiles invalidly.
+	relabeler.ApplyConfig([]*relabel.Config{{
+		SourceLabels: model.LabelNames{"job"},
+		Regex:        relabel.MustNewRegexp("(.*)"),
+		TargetLabel:  "__name__",
+		Replacement:  "memory:$1",
+		Action:       relabel.Replace,
+	}})
+	_, err = write(
+		&profilestorepb.Label{Name: "__name__", Value: "memory"},
+		&profilestorepb.Label{Name: "job", Value: "api"},
+	)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestRelabelerProcess(t *testing.T) {
+	t.Parallel()
+
+	r := NewRelabeler(prometheus.NewRegistry(), nil)
+	ls := labels.FromStrings("__name__", "memory", "job", "api")
+
+	relabeled, keep := r.Process(ls)
+	require.True(t, keep)
+	require.Equal(t, ls, relabeled)
+
+	// Series whose name is dropped are dropped.
+	r.ApplyConfig([]*relabel.Config{{
+		Regex:  relabel.MustNewRegexp("__name__"),
+		Action: relabel.LabelDrop,
+	}})
+	_, keep = r.Process(ls)
+	require.False(t, keep)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/polarsignals/frostdb/dynparquet"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+// DefaultIsRetryable classifies every error as transient except those of
+// cancelled or timed out requests and invalid arguments, which fail the same
+// way when retried.
+func DefaultIsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if s, ok := status.FromError(err); ok && s.Code() == codes.InvalidArgument {
+		return false
+	}
+	return true
+}
+
+// retryingTable retries appends to a table that fail with errors isRetryable
+// classifies as transient.
+type retryingTable struct {
+	parcacol.Table
+
+	logger      log.Logger
+	attempts    int
+	backoff     time.Duration
+	isRetryable func(error) bool
+}
+
+func (t *retryingTable) InsertBuffer(ctx context.Context, buf *dynparquet.Buffer) (uint64, error) {
+	for attempt := 1; ; attempt++ {
+		tx, err := t.Table.InsertBuffer(ctx, buf)
+		if err == nil || attempt >= t.attempts || !t.isRetryable(err) {
+			return tx, err
+		}
+		level.Debug(t.logger).Log("msg", "retrying failed append", "attempt", attempt, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(t.backoff):
+		}
+	}
+}
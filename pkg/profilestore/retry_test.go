@@ -0,0 +1,111 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb/dynparquet"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+// failingTable fails the first appends with the errors.
+type failingTable struct {
+	parcacol.Table
+
+	errs     []error
+	attempts int
+}
+
+func (t *failingTable) InsertBuffer(ctx context.Context, buf *dynparquet.Buffer) (uint64, error) {
+	t.attempts++
+	if len(t.errs) > 0 {
+		err := t.errs[0]
+		t.errs = t.errs[1:]
+		return 0, err
+	}
+	return 1, nil
+}
+
+func TestRetryingTable(t *testing.T) {
+	t.Parallel()
+
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+	isRetryable := func(err error) bool {
+		return errors.Is(err, errTransient)
+	}
+
+	tests := map[string]struct {
+		errs     []error
+		attempts int
+		err      error
+	}{
+		"success": {
+			attempts: 1,
+		},
+		"retried": {
+			errs:     []error{errTransient, errTransient},
+			attempts: 3,
+		},
+		"too many attempts": {
+			errs:     []error{errTransient, errTransient, errTransient, errTransient},
+			attempts: 3,
+			err:      errTransient,
+		},
+		"not retryable": {
+			errs:     []error{errPermanent},
+			attempts: 1,
+			err:      errPermanent,
+		},
+		"retried until not retryable": {
+			errs:     []error{errTransient, errPermanent},
+			attempts: 2,
+			err:      errPermanent,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			failing := &failingTable{errs: test.errs}
+			table := &retryingTable{
+				Table:       failing,
+				logger:      log.NewNopLogger(),
+				attempts:    3,
+				isRetryable: isRetryable,
+			}
+			_, err := table.InsertBuffer(context.Background(), nil)
+			require.ErrorIs(t, err, test.err)
+			require.Equal(t, test.attempts, failing.attempts)
+		})
+	}
+}
+
+func TestDefaultIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, DefaultIsRetryable(errors.New("write ahead log: disk full")))
+	require.False(t, DefaultIsRetryable(context.Canceled))
+	require.False(t, DefaultIsRetryable(status.Error(codes.InvalidArgument, "invalid")))
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+const (
+	// SampleLimitModeDrop drops samples arriving within the minimum interval
+	// of the previous sample of their series.
+	SampleLimitModeDrop = "drop"
+	// SampleLimitModeMerge keeps samples arriving within the minimum interval
+	// of the previous sample of their series and merges them into the next
+	// sample of the series that is written. This is meant for delta profiles,
+	// whose values add up.
+	SampleLimitModeMerge = "merge"
+
+	// sampleLimitStaleIntervals is the number of minimum intervals after which
+	// a series without new samples is forgotten, along with samples pending
+	// to be merged.
+	sampleLimitStaleIntervals = 10
+)
+
+// SampleLimiter enforces a minimum interval between the samples written to
+// each series.
+type SampleLimiter struct {
+	minInterval time.Duration
+	mode        string
+
+	// now returns the current time, it is settable for testing convenience.
+	now func() time.Time
+
+	mtx       sync.Mutex
+	series    map[seriesKey]*limitedSeries
+	lastSweep time.Time
+
+	limited *prometheus.CounterVec
+}
+
+// seriesKey identifies a series of a tenant, tenants writing the same series
+// are limited separately.
+type seriesKey struct {
+	tenant string
+	hash   uint64
+}
+
+type limitedSeries struct {
+	// last is the timestamp of the last written sample.
+	last time.Time
+	// seen is the time the series last received a sample.
+	seen    time.Time
+	pending []*pprofpb.Profile
+}
+
+func NewSampleLimiter(reg prometheus.Registerer, minInterval time.Duration, mode string) (*SampleLimiter, error) {
+	if mode != SampleLimitModeDrop && mode != SampleLimitModeMerge {
+		return nil, fmt.Errorf("unknown sample limit mode %q", mode)
+	}
+
+	l := &SampleLimiter{
+		minInterval: minInterval,
+		mode:        mode,
+		now:         time.Now,
+		series:      map[seriesKey]*limitedSeries{},
+		limited: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parca_profilestore_series_samples_limited_total",
+				Help: "Number of samples that arrived within the minimum sample interval of their series, by whether they were dropped or merged.",
+			},
+			[]string{"action"},
+		),
+	}
+	reg.MustRegister(l.limited)
+
+	return l, nil
+}
+
+// Admit decides whether the sample of the series of the tenant of the context
+// is written. A sample is written if it is at least the minimum interval newer
+// than the last sample written to the series. In merge mode, samples that are
+// not written are held back and merged into the next sample that is, the
+// returned profile is the one to write. Admitted samples have to be finished
+// with done, reporting whether they were written. Samples that failed to be
+// written don't count as the last sample of their series, and the samples
+// merged into them are held back again.
+func (l *SampleLimiter) Admit(ctx context.Context, ls labels.Labels, p *pprofpb.Profile) (admitted *pprofpb.Profile, done func(written bool), ok bool) {
+	now := l.now()
+	ts := now
+	if p.TimeNanos > 0 {
+		ts = time.Unix(0, p.TimeNanos)
+	}
+
+	key := seriesKey{hash: labels.New(ls...).Hash()}
+	key.tenant, _ = tenant.FromContext(ctx)
+
+	l.mtx.Lock()
+	l.sweep(now)
+
+	s, ok := l.series[key]
+	if !ok {
+		s = &limitedSeries{}
+		l.series[key] = s
+	}
+	s.seen = now
+
+	if ok && ts.Sub(s.last) < l.minInterval {
+		action := "dropped"
+		if l.mode == SampleLimitModeMerge {
+			s.pending = append(s.pending, p)
+			action = "merged"
+		}
+		l.mtx.Unlock()
+		l.limited.WithLabelValues(action).Inc()
+		return nil, nil, false
+	}
+
+	// The sample is the last of the series unless it fails to be written,
+	// so samples arriving meanwhile are limited.
+	last := s.last
+	s.last = ts
+	pending := s.pending
+	s.pending = nil
+	l.mtx.Unlock()
+
+	done = func(written bool) {
+		if written {
+			return
+		}
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if l.series[key] != s {
+			// The series was forgotten meanwhile.
+			return
+		}
+		if s.last.Equal(ts) {
+			s.last = last
+		}
+		s.pending = append(pending, s.pending...)
+	}
+
+	if len(pending) == 0 {
+		return p, done, true
+	}
+
+	merged, err := mergeProfiles(append(pending[:len(pending):len(pending)], p))
+	if err != nil {
+		// Profiles of a series are expected to be compatible, if they
+		// aren't the held back samples are lost.
+		l.limited.WithLabelValues("dropped").Add(float64(len(pending)))
+		pending = nil
+		return p, done, true
+	}
+	// The merged profile is written at the time of the admitted sample.
+	merged.TimeNanos = p.TimeNanos
+	return merged, done, true
+}
+
+// sweep forgets series that haven't received samples for a while, so that
+// the memory of series that have gone away is released. Held back samples of
+// these series are dropped. It must be called with the lock held.
+func (l *SampleLimiter) sweep(now time.Time) {
+	stale := sampleLimitStaleIntervals * l.minInterval
+	if now.Sub(l.lastSweep) < stale {
+		return
+	}
+	l.lastSweep = now
+
+	for key, s := range l.series {
+		if now.Sub(s.seen) < stale {
+			continue
+		}
+		if len(s.pending) > 0 {
+			l.limited.WithLabelValues("dropped").Add(float64(len(s.pending)))
+		}
+		delete(l.series, key)
+	}
+}
+
+func mergeProfiles(ps []*pprofpb.Profile) (*pprofpb.Profile, error) {
+	profiles := make([]*profile.Profile, 0, len(ps))
+	for _, p := range ps {
+		b, err := p.MarshalVT()
+		if err != nil {
+			return nil, err
+		}
+		pp, err := profile.ParseData(b)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, pp)
+	}
+
+	merged, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := merged.WriteUncompressed(&buf); err != nil {
+		return nil, err
+	}
+
+	p := &pprofpb.Profile{}
+	if err := p.UnmarshalVT(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,307 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+func countProfile(ts time.Time, value int64) *pprofpb.Profile {
+	return &pprofpb.Profile{
+		StringTable: []string{"", "samples", "count"},
+		SampleType:  []*pprofpb.ValueType{{Type: 1, Unit: 2}},
+		Sample:      []*pprofpb.Sample{{Value: []int64{value}}},
+		TimeNanos:   ts.UnixNano(),
+	}
+}
+
+func TestSampleLimiterDrop(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeDrop)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	a := labels.FromStrings("__name__", "memory", "job", "a")
+	b := labels.FromStrings("__name__", "memory", "job", "b")
+	start := time.Unix(1000, 0)
+
+	var admitted []time.Duration
+	for i := 0; i < 15; i++ {
+		ts := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		if _, _, ok := l.Admit(ctx, a, countProfile(ts, 1)); ok {
+			admitted = append(admitted, ts.Sub(start))
+		}
+		// Another series is limited independently.
+		_, _, ok := l.Admit(ctx, b, countProfile(start.Add(time.Duration(i)*time.Second), 1))
+		require.True(t, ok)
+	}
+
+	require.Equal(t, []time.Duration{0, time.Second}, admitted)
+	require.Equal(t, 13.0, testutil.ToFloat64(l.limited.WithLabelValues("dropped")))
+
+	_, err = NewSampleLimiter(prometheus.NewRegistry(), time.Second, "sum")
+	require.Error(t, err)
+}
+
+func TestSampleLimiterMerge(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeMerge)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ls := labels.FromStrings("__name__", "memory", "job", "a")
+	start := time.Unix(1000, 0)
+
+	p, _, ok := l.Admit(ctx, ls, countProfile(start, 1))
+	require.True(t, ok)
+	require.Equal(t, int64(1), p.Sample[0].Value[0])
+
+	for i := 1; i < 4; i++ {
+		_, _, ok := l.Admit(ctx, ls, countProfile(start.Add(time.Duration(i)*100*time.Millisecond), int64(i)))
+		require.False(t, ok)
+	}
+	require.Equal(t, 3.0, testutil.ToFloat64(l.limited.WithLabelValues("merged")))
+
+	// The held back samples are merged into the next admitted one.
+	next := start.Add(time.Second)
+	p, _, ok = l.Admit(ctx, ls, countProfile(next, 10))
+	require.True(t, ok)
+	require.Len(t, p.Sample, 1)
+	require.Equal(t, int64(1+2+3+10), p.Sample[0].Value[0])
+	require.Equal(t, next.UnixNano(), p.TimeNanos)
+}
+
+func TestSampleLimiterFailedWrite(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeMerge)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	ls := labels.FromStrings("__name__", "memory", "job", "a")
+	start := time.Unix(1000, 0)
+
+	_, done, ok := l.Admit(ctx, ls, countProfile(start, 1))
+	require.True(t, ok)
+	done(true)
+	_, _, ok = l.Admit(ctx, ls, countProfile(start.Add(100*time.Millisecond), 2))
+	require.False(t, ok)
+
+	// A sample failing to be written neither limits its retry nor loses the
+	// samples held back.
+	next := start.Add(time.Second)
+	p, done, ok := l.Admit(ctx, ls, countProfile(next, 10))
+	require.True(t, ok)
+	require.Equal(t, int64(2+10), p.Sample[0].Value[0])
+	done(false)
+
+	p, done, ok = l.Admit(ctx, ls, countProfile(next, 10))
+	require.True(t, ok)
+	require.Equal(t, int64(2+10), p.Sample[0].Value[0])
+	done(true)
+
+	_, _, ok = l.Admit(ctx, ls, countProfile(next.Add(100*time.Millisecond), 1))
+	require.False(t, ok)
+}
+
+func TestSampleLimiterTenants(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeDrop)
+	require.NoError(t, err)
+
+	ls := labels.FromStrings("__name__", "memory", "job", "a")
+	now := time.Unix(1000, 0)
+
+	// Tenants writing the same series are limited separately.
+	for _, id := range []string{"a", "b"} {
+		_, _, ok := l.Admit(tenant.WithTenant(context.Background(), id), ls, countProfile(now, 1))
+		require.True(t, ok, id)
+	}
+	_, _, ok := l.Admit(tenant.WithTenant(context.Background(), "a"), ls, countProfile(now.Add(100*time.Millisecond), 1))
+	require.False(t, ok)
+}
+
+func TestSampleLimiterForgetsStaleSeries(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeMerge)
+	require.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	a := labels.FromStrings("job", "a")
+	_, _, ok := l.Admit(ctx, a, countProfile(now, 1))
+	require.True(t, ok)
+	_, _, ok = l.Admit(ctx, a, countProfile(now.Add(100*time.Millisecond), 1))
+	require.False(t, ok)
+
+	now = now.Add(sampleLimitStaleIntervals * time.Second)
+	_, _, ok = l.Admit(ctx, labels.FromStrings("job", "b"), countProfile(now, 1))
+	require.True(t, ok)
+
+	require.Len(t, l.series, 1)
+	require.Equal(t, 1.0, testutil.ToFloat64(l.limited.WithLabelValues("dropped")))
+}
+
+func TestWriteRawSampleLimit(t *testing.T) {
+	t.Parallel()
+
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	rawProfile := func(ts time.Time) []byte {
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(content))
+		p.TimeNanos = ts.UnixNano()
+
+		b, err := p.MarshalVT()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		_, err = w.Write(b)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeDrop)
+	require.NoError(t, err)
+	// Series aren't forgotten however long the writes take.
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	api, querier := newTestProfileColumnStore(t, WithSampleLimiter(l))
+
+	start := time.Now().Truncate(time.Second).Add(-time.Minute)
+	series := func(job string, ts time.Time) *profilestorepb.RawProfileSeries {
+		return &profilestorepb.RawProfileSeries{
+			Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+				{Name: "__name__", Value: "memory"},
+				{Name: "job", Value: job},
+			}},
+			Samples: []*profilestorepb.RawSample{{RawProfile: rawProfile(ts)}},
+		}
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		_, err := api.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{
+				// Every 100ms, only the first sample is kept.
+				series("fast", start.Add(time.Duration(i)*100*time.Millisecond)),
+				// Every 2s, all samples are kept.
+				series("slow", start.Add(time.Duration(i)*2*time.Second)),
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	res, err := querier.QueryRange(ctx, "memory:alloc_objects:count:space:bytes", time.Unix(0, 0), time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+
+	samples := map[string]int{}
+	for _, s := range res {
+		for _, l := range s.Labelset.Labels {
+			if l.Name == "job" {
+				samples[l.Value] = len(s.Samples)
+			}
+		}
+	}
+	require.Equal(t, map[string]int{"fast": 1, "slow": 5}, samples)
+}
+
+func TestWriteRawSampleLimitForwarder(t *testing.T) {
+	t.Parallel()
+
+	compressed, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	total := func(p *pprofpb.Profile) int64 {
+		var total int64
+		for _, s := range p.Sample {
+			total += s.Value[0]
+		}
+		return total
+	}
+	rawProfile := func(ts time.Time) (*pprofpb.Profile, []byte) {
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(content))
+		p.TimeNanos = ts.UnixNano()
+
+		b, err := p.MarshalVT()
+		require.NoError(t, err)
+		return p, b
+	}
+
+	l, err := NewSampleLimiter(prometheus.NewRegistry(), time.Second, SampleLimitModeMerge)
+	require.NoError(t, err)
+	f := &recordingForwarder{}
+	api, _ := newTestProfileColumnStore(t, WithSampleLimiter(l), WithForwarder(f))
+
+	start := time.Now().Truncate(time.Second).Add(-time.Minute)
+	var raw [][]byte
+	for _, ts := range []time.Time{start, start.Add(100 * time.Millisecond), start.Add(time.Second)} {
+		_, b := rawProfile(ts)
+		raw = append(raw, b)
+		_, err := api.WriteRaw(context.Background(), &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+					{Name: "__name__", Value: "memory"},
+				}},
+				Samples: []*profilestorepb.RawSample{{RawProfile: b, ContentEncoding: ContentEncodingNone}},
+			}},
+		})
+		require.NoError(t, err)
+	}
+
+	// The sample held back is forwarded merged into the next one written.
+	require.Len(t, f.forwarded, 2)
+	require.Equal(t, raw[0], f.forwarded[0].sample.RawProfile)
+
+	single, _ := rawProfile(start)
+	merged := &pprofpb.Profile{}
+	require.Equal(t, ContentEncodingNone, f.forwarded[1].sample.ContentEncoding)
+	require.NoError(t, merged.UnmarshalVT(f.forwarded[1].sample.RawProfile))
+	require.Equal(t, start.Add(time.Second).UnixNano(), merged.TimeNanos)
+	require.Equal(t, 2*total(single), total(merged))
+}
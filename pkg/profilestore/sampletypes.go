@@ -0,0 +1,63 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// filterSampleTypes removes the sample types of the profile that aren't
+// among the given ones, along with their values, so they aren't stored. It
+// returns whether any sample type was removed.
+func filterSampleTypes(p *pprofpb.Profile, sampleTypes []*profilestorepb.SampleType) bool {
+	if len(sampleTypes) == 0 {
+		return false
+	}
+
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(p.StringTable)) {
+			return ""
+		}
+		return p.StringTable[i]
+	}
+	keep := make([]int, 0, len(p.SampleType))
+	for i, t := range p.SampleType {
+		for _, st := range sampleTypes {
+			if str(t.Type) == st.Type && str(t.Unit) == st.Unit {
+				keep = append(keep, i)
+				break
+			}
+		}
+	}
+	if len(keep) == len(p.SampleType) {
+		return false
+	}
+
+	types := make([]*pprofpb.ValueType, 0, len(keep))
+	for _, i := range keep {
+		types = append(types, p.SampleType[i])
+	}
+	p.SampleType = types
+	for _, s := range p.Sample {
+		values := make([]int64, 0, len(keep))
+		for _, i := range keep {
+			if i < len(s.Value) {
+				values = append(values, s.Value[i])
+			}
+		}
+		s.Value = values
+	}
+	return true
+}
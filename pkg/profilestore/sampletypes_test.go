@@ -0,0 +1,87 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestFilterSampleTypes(t *testing.T) {
+	t.Parallel()
+
+	p := &pprofpb.Profile{
+		SampleType:  []*pprofpb.ValueType{{Type: 1, Unit: 2}, {Type: 3, Unit: 4}},
+		Sample:      []*pprofpb.Sample{{Value: []int64{1, 2}}, {Value: []int64{3, 4}}},
+		StringTable: []string{"", "alloc_objects", "count", "alloc_space", "bytes"},
+	}
+
+	require.False(t, filterSampleTypes(p, nil))
+	require.False(t, filterSampleTypes(p, []*profilestorepb.SampleType{
+		{Type: "alloc_objects", Unit: "count"},
+		{Type: "alloc_space", Unit: "bytes"},
+	}))
+	require.Len(t, p.SampleType, 2)
+
+	require.True(t, filterSampleTypes(p, []*profilestorepb.SampleType{{Type: "alloc_space", Unit: "bytes"}}))
+	require.Equal(t, []*pprofpb.ValueType{{Type: 3, Unit: 4}}, p.SampleType)
+	require.Equal(t, []int64{2}, p.Sample[0].Value)
+	require.Equal(t, []int64{4}, p.Sample[1].Value)
+}
+
+func TestWriteRawSampleTypes(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	write := func(sampleTypes ...*profilestorepb.SampleType) []string {
+		ctx := context.Background()
+		store, querier := newTestProfileColumnStore(t)
+		_, err := store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels:      &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+				Samples:     []*profilestorepb.RawSample{{RawProfile: content}},
+				SampleTypes: sampleTypes,
+			}},
+		})
+		require.NoError(t, err)
+
+		types, err := querier.ProfileTypes(ctx)
+		require.NoError(t, err)
+		stored := []string{}
+		for _, t := range types {
+			stored = append(stored, t.SampleType+":"+t.SampleUnit)
+		}
+		return stored
+	}
+
+	require.ElementsMatch(t, []string{"alloc_objects:count", "alloc_space:bytes", "inuse_objects:count", "inuse_space:bytes"}, write())
+	require.ElementsMatch(t, []string{"alloc_space:bytes", "inuse_space:bytes"}, write(
+		&profilestorepb.SampleType{Type: "alloc_space", Unit: "bytes"},
+		&profilestorepb.SampleType{Type: "inuse_space", Unit: "bytes"},
+	))
+
+	// Both the type and the unit have to match, case sensitively.
+	require.Empty(t, write(
+		&profilestorepb.SampleType{Type: "Alloc_Space", Unit: "bytes"},
+		&profilestorepb.SampleType{Type: "inuse_space", Unit: "count"},
+	))
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import "context"
+
+const (
+	// IngestSourceLabel is the internal label holding the way a profile was
+	// ingested. Values sent by clients are always replaced.
+	IngestSourceLabel = "__ingest_source__"
+	// ExposedIngestSourceLabel is the name the ingest source is stored as
+	// when it is exposed.
+	ExposedIngestSourceLabel = "ingest_source"
+
+	IngestSourcePush   = "push"
+	IngestSourceScrape = "scrape"
+	// IngestSourceSelf marks the profiles the server takes of itself.
+	IngestSourceSelf = "self"
+)
+
+type ingestSourceKey struct{}
+
+// WithIngestSource returns a context marking writes made with it as coming
+// from the given source. Context values don't cross the wire, so only
+// in-process writers, like the scraper, can set a source other than push.
+func WithIngestSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, ingestSourceKey{}, source)
+}
+
+// IngestSourceFromContext returns the ingest source of the context, writes
+// without a source are pushed profiles.
+func IngestSourceFromContext(ctx context.Context) string {
+	if source, ok := ctx.Value(ingestSourceKey{}).(string); ok && source != "" {
+		return source
+	}
+	return IngestSourcePush
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// WriteRawStream stores a raw profile received in chunks. The chunks are
+// received one at a time, so gRPC flow control keeps clients from sending
+// faster than they are received. Once the profile exceeds the maximum
+// profile size the stream is aborted, rather than the whole profile being
+// received first. The profile is then written like a single sample of
+// WriteRaw.
+func (s *ProfileColumnStore) WriteRawStream(stream profilestorepb.ProfileStoreService_WriteRawStreamServer) error {
+	ctx := stream.Context()
+	if err := s.allowWrite(ctx, 1, 0); err != nil {
+		return err
+	}
+
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "no profile received")
+	}
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	req := first
+	for {
+		// The bytes of each chunk count towards the rate limit as they
+		// arrive.
+		if err := s.allowWrite(ctx, 0, req.SizeVT()); err != nil {
+			return err
+		}
+		buf.Write(req.Chunk)
+		if s.maxProfileSize > 0 && int64(buf.Len()) > s.maxProfileSize {
+			s.metrics.received.Inc()
+			s.metrics.reject(rejectTooLarge, 1)
+			return status.Errorf(codes.InvalidArgument, "profile of more than %d bytes exceeds the maximum profile size of %d bytes", buf.Len(), s.maxProfileSize)
+		}
+
+		req, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.writeRawRequest(ctx, &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: first.Labels,
+			Samples: []*profilestorepb.RawSample{{
+				RawProfile:      buf.Bytes(),
+				ContentEncoding: first.ContentEncoding,
+				Format:          first.Format,
+			}},
+			SampleTypes: first.SampleTypes,
+		}},
+		Normalized: first.Normalized,
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
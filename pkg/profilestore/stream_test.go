@@ -0,0 +1,113 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+type fakeWriteRawStreamServer struct {
+	grpc.ServerStream
+
+	reqs []*profilestorepb.WriteRawStreamRequest
+	resp *profilestorepb.WriteRawResponse
+}
+
+func (s *fakeWriteRawStreamServer) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeWriteRawStreamServer) Recv() (*profilestorepb.WriteRawStreamRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *fakeWriteRawStreamServer) SendAndClose(resp *profilestorepb.WriteRawResponse) error {
+	s.resp = resp
+	return nil
+}
+
+// rawStream splits the profile into small chunks, the options are only set
+// on the first.
+func rawStream(b []byte, first *profilestorepb.WriteRawStreamRequest) *fakeWriteRawStreamServer {
+	s := &fakeWriteRawStreamServer{reqs: []*profilestorepb.WriteRawStreamRequest{first}}
+	for len(b) > 0 {
+		n := 100
+		if n > len(b) {
+			n = len(b)
+		}
+		s.reqs = append(s.reqs, &profilestorepb.WriteRawStreamRequest{Chunk: b[:n]})
+		b = b[n:]
+	}
+	return s
+}
+
+func TestWriteRawStream(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	store, querier := newTestProfileColumnStore(t)
+	stream := rawStream(content, &profilestorepb.WriteRawStreamRequest{
+		Labels:      &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+		SampleTypes: []*profilestorepb.SampleType{{Type: "alloc_space", Unit: "bytes"}},
+	})
+	require.NoError(t, store.WriteRawStream(stream))
+	require.Equal(t, uint64(1), stream.resp.AcceptedSamples)
+
+	types, err := querier.ProfileTypes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, types, 1)
+	require.Equal(t, "alloc_space", types[0].SampleType)
+
+	err = store.WriteRawStream(&fakeWriteRawStreamServer{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Invalid labels are rejected like by WriteRaw.
+	err = store.WriteRawStream(rawStream(content, &profilestorepb.WriteRawStreamRequest{}))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWriteRawStreamMaxProfileSize(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	store, _ := newTestProfileColumnStore(t, WithMaxProfileSize(150))
+	stream := rawStream(content, &profilestorepb.WriteRawStreamRequest{
+		Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{{Name: "__name__", Value: "memory"}}},
+	})
+	err = store.WriteRawStream(stream)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, err.Error(), "exceeds the maximum profile size")
+	// The stream is aborted once the profile is too large, the remaining
+	// chunks aren't received anymore.
+	require.NotEmpty(t, stream.reqs)
+}
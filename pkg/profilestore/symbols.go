@@ -0,0 +1,87 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// applySymbols adds lines of the functions of the symbols to the locations of
+// the profile that have none, so they are stored symbolized. Locations whose
+// address has no symbol are left as they are. It returns whether any
+// location was symbolized.
+func applySymbols(p *pprofpb.Profile, symbols []*profilestorepb.AddressSymbol) bool {
+	if len(symbols) == 0 {
+		return false
+	}
+
+	byAddress := make(map[uint64][]*profilestorepb.AddressSymbol, len(symbols))
+	for _, s := range symbols {
+		byAddress[s.Address] = append(byAddress[s.Address], s)
+	}
+
+	if len(p.StringTable) == 0 {
+		p.StringTable = []string{""}
+	}
+	stringIndex := make(map[string]int64, len(p.StringTable))
+	for i, s := range p.StringTable {
+		if _, ok := stringIndex[s]; !ok {
+			stringIndex[s] = int64(i)
+		}
+	}
+	str := func(s string) int64 {
+		if i, ok := stringIndex[s]; ok {
+			return i
+		}
+		p.StringTable = append(p.StringTable, s)
+		stringIndex[s] = int64(len(p.StringTable) - 1)
+		return stringIndex[s]
+	}
+
+	type functionKey struct {
+		name, file string
+	}
+	functions := map[functionKey]uint64{}
+	function := func(s *profilestorepb.AddressSymbol) uint64 {
+		key := functionKey{s.FunctionName, s.FileName}
+		if id, ok := functions[key]; ok {
+			return id
+		}
+		id := uint64(len(p.Function) + 1)
+		p.Function = append(p.Function, &pprofpb.Function{
+			Id:         id,
+			Name:       str(s.FunctionName),
+			SystemName: str(s.FunctionName),
+			Filename:   str(s.FileName),
+		})
+		functions[key] = id
+		return id
+	}
+
+	symbolized := false
+	for _, l := range p.Location {
+		if len(l.Line) > 0 {
+			continue
+		}
+		for _, s := range byAddress[l.Address] {
+			l.Line = append(l.Line, &pprofpb.Line{
+				FunctionId: function(s),
+				Line:       s.Line,
+			})
+			symbolized = true
+		}
+	}
+	return symbolized
+}
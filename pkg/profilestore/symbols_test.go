@@ -0,0 +1,94 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+func TestWriteRawSymbols(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, querier := newTestProfileColumnStore(t)
+
+	// An unsymbolized profile of a single stack of three addresses, as
+	// agents symbolizing profiles themselves send it.
+	p := &pprofpb.Profile{
+		SampleType: []*pprofpb.ValueType{{Type: 1, Unit: 2}},
+		PeriodType: &pprofpb.ValueType{Type: 3, Unit: 4},
+		Period:     1,
+		Sample:     []*pprofpb.Sample{{LocationId: []uint64{1, 2, 3}, Value: []int64{10}}},
+		Mapping:    []*pprofpb.Mapping{{Id: 1, MemoryStart: 0x1000, MemoryLimit: 0x10000, Filename: 5}},
+		Location: []*pprofpb.Location{
+			{Id: 1, MappingId: 1, Address: 0x1100},
+			{Id: 2, MappingId: 1, Address: 0x1200},
+			{Id: 3, MappingId: 1, Address: 0x1300},
+		},
+		StringTable: []string{"", "samples", "count", "cpu", "nanoseconds", "/bin/app"},
+		TimeNanos:   time.Now().UnixNano(),
+	}
+	raw, err := p.MarshalVT()
+	require.NoError(t, err)
+
+	// The map is partial, 0x1300 stays unsymbolized, and 0x1100 has an
+	// inlined function.
+	_, err = store.WriteRaw(ctx, &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+				{Name: "__name__", Value: "process_cpu"},
+			}},
+			Samples: []*profilestorepb.RawSample{{
+				RawProfile:      raw,
+				ContentEncoding: ContentEncodingNone,
+				Symbols: []*profilestorepb.AddressSymbol{
+					{Address: 0x1100, FunctionName: "inlined", FileName: "app.go", Line: 12},
+					{Address: 0x1100, FunctionName: "work", FileName: "app.go", Line: 20},
+					{Address: 0x1200, FunctionName: "main", FileName: "main.go", Line: 7},
+				},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+
+	merged, err := querier.QueryMerge(ctx, "process_cpu:samples:count:cpu:nanoseconds", time.Unix(0, 0), time.Unix(math.MaxInt32, 0))
+	require.NoError(t, err)
+	require.Len(t, merged.Samples, 1)
+
+	type line struct {
+		function, file string
+		line           int64
+	}
+	var lines [][]line
+	for _, l := range merged.Samples[0].Locations {
+		var ls []line
+		for _, ll := range l.Lines {
+			ls = append(ls, line{ll.Function.Name, ll.Function.Filename, ll.Line})
+		}
+		lines = append(lines, ls)
+	}
+	require.Equal(t, [][]line{
+		{{"inlined", "app.go", 12}, {"work", "app.go", 20}},
+		{{"main", "main.go", 7}},
+		nil,
+	}, lines)
+}
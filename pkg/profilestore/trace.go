@@ -0,0 +1,200 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/gotrace"
+)
+
+// Names of the profiles derived from Go execution traces.
+const (
+	TraceCPUProfileName        = "go_trace_cpu"
+	TraceGCProfileName         = "go_trace_gc"
+	TraceGoroutinesProfileName = "go_trace_goroutines"
+)
+
+// defaultTraceCPUProfileRate is the rate runtime/pprof samples at unless
+// configured otherwise.
+const defaultTraceCPUProfileRate = 100
+
+// WriteTrace stores the profiles derived from a Go execution trace. The trace
+// is parsed while its chunks are received, so it's never held in memory as a
+// whole, and is aborted once it exceeds the maximum profile size. The derived
+// profiles are then written like the series of a WriteRaw request.
+func (s *ProfileColumnStore) WriteTrace(stream profilestorepb.ProfileStoreService_WriteTraceServer) error {
+	ctx, span := s.tracer.Start(stream.Context(), "write-trace")
+	defer span.End()
+
+	if err := s.allowWrite(ctx, 1, 0); err != nil {
+		return err
+	}
+
+	end, ok := s.drainer.begin()
+	if !ok {
+		return errDraining
+	}
+	defer end()
+
+	// Clients without an identity are rejected before their trace is
+	// received.
+	if s.writePolicy != nil && IngestSourceFromContext(ctx) == IngestSourcePush {
+		if _, ok := IdentityFromContext(ctx); !ok {
+			return status.Error(codes.PermissionDenied, "client identity is required to write profiles")
+		}
+	}
+
+	first, err := stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return status.Error(codes.InvalidArgument, "no trace received")
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.allowWrite(ctx, 0, first.SizeVT()); err != nil {
+		return err
+	}
+
+	for _, l := range first.GetLabels().GetLabels() {
+		if l.Name == labels.MetricName {
+			return status.Errorf(codes.InvalidArgument, "the %s label is set to the names of the derived profiles", labels.MetricName)
+		}
+	}
+
+	rate := first.CpuProfileRate
+	if rate == 0 {
+		rate = defaultTraceCPUProfileRate
+	}
+	if rate < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid CPU profile rate: %d", rate)
+	}
+
+	r := &traceChunkReader{ctx: ctx, store: s, stream: stream, chunk: first.Chunk, read: int64(len(first.Chunk))}
+	if err := r.checkSize(); err != nil {
+		return err
+	}
+	t, err := gotrace.Parse(r)
+	if r.err != nil {
+		return r.err
+	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse trace: %v", err)
+	}
+
+	type namedProfile struct {
+		name string
+		p    *pprofpb.Profile
+	}
+	var profiles []namedProfile
+	if p := t.CPUProfile(time.Second / time.Duration(rate)); p != nil {
+		profiles = append(profiles, namedProfile{TraceCPUProfileName, p})
+	}
+	if first.RuntimeMetrics {
+		profiles = append(profiles,
+			namedProfile{TraceGCProfileName, t.GCProfile()},
+			namedProfile{TraceGoroutinesProfileName, t.GoroutineCreationProfile()},
+		)
+	}
+	if len(profiles) == 0 {
+		return status.Error(codes.InvalidArgument, "trace contains no CPU samples, the CPU profiler has to run while tracing")
+	}
+
+	// Traces carry no wall clock time, the derived profiles are stored as
+	// taken now.
+	now := time.Now().UnixNano()
+	req := &profilestorepb.WriteRawRequest{}
+	for _, np := range profiles {
+		np.p.TimeNanos = now
+		content, err := np.p.MarshalVT()
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to encode %s profile: %v", np.name, err)
+		}
+
+		ls := make([]*profilestorepb.Label, 0, len(first.GetLabels().GetLabels())+1)
+		ls = append(ls, &profilestorepb.Label{Name: labels.MetricName, Value: np.name})
+		ls = append(ls, first.GetLabels().GetLabels()...)
+		req.Series = append(req.Series, &profilestorepb.RawProfileSeries{
+			Labels: &profilestorepb.LabelSet{Labels: ls},
+			Samples: []*profilestorepb.RawSample{{
+				RawProfile:      content,
+				ContentEncoding: ContentEncodingNone,
+			}},
+		})
+	}
+	if _, err := s.writeRaw(ctx, req); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&profilestorepb.WriteTraceResponse{})
+}
+
+// traceChunkReader reads the chunks of a trace from a stream, the bytes of
+// each chunk count towards the rate limit of the client as they arrive.
+type traceChunkReader struct {
+	ctx    context.Context
+	store  *ProfileColumnStore
+	stream profilestorepb.ProfileStoreService_WriteTraceServer
+	chunk  []byte
+	// read is the number of bytes of the trace received.
+	read int64
+	// err is the error receiving the trace, rather than parsing it.
+	err error
+}
+
+func (r *traceChunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				r.err = err
+			}
+			return 0, err
+		}
+		if err := r.store.allowWrite(r.ctx, 0, req.SizeVT()); err != nil {
+			r.err = err
+			return 0, err
+		}
+		r.chunk = req.Chunk
+		r.read += int64(len(req.Chunk))
+		if err := r.checkSize(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// checkSize returns an error once the trace exceeds the maximum profile size.
+func (r *traceChunkReader) checkSize() error {
+	max := r.store.maxProfileSize
+	if max <= 0 || r.read <= max {
+		return nil
+	}
+	r.store.metrics.received.Inc()
+	r.store.metrics.reject(rejectTooLarge, 1)
+	r.err = status.Errorf(codes.InvalidArgument, "trace of more than %d bytes exceeds the maximum profile size of %d bytes", r.read, max)
+	return r.err
+}
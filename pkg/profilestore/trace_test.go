@@ -0,0 +1,174 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+type fakeWriteTraceServer struct {
+	grpc.ServerStream
+
+	reqs []*profilestorepb.WriteTraceRequest
+	resp *profilestorepb.WriteTraceResponse
+}
+
+func (s *fakeWriteTraceServer) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeWriteTraceServer) Recv() (*profilestorepb.WriteTraceRequest, error) {
+	if len(s.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *fakeWriteTraceServer) SendAndClose(resp *profilestorepb.WriteTraceResponse) error {
+	s.resp = resp
+	return nil
+}
+
+// traceStream splits the trace into small chunks, the options are only set
+// on the first.
+func traceStream(t *testing.T, first *profilestorepb.WriteTraceRequest) *fakeWriteTraceServer {
+	t.Helper()
+
+	b, err := os.ReadFile("../gotrace/testdata/go1.19.trace")
+	require.NoError(t, err)
+
+	s := &fakeWriteTraceServer{reqs: []*profilestorepb.WriteTraceRequest{first}}
+	for len(b) > 0 {
+		n := 100
+		if n > len(b) {
+			n = len(b)
+		}
+		s.reqs = append(s.reqs, &profilestorepb.WriteTraceRequest{Chunk: b[:n]})
+		b = b[n:]
+	}
+	return s
+}
+
+func TestWriteTrace(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, querier := newTestProfileColumnStore(t)
+
+	stream := traceStream(t, &profilestorepb.WriteTraceRequest{
+		Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+			{Name: "job", Value: "test"},
+		}},
+		RuntimeMetrics: true,
+	})
+	require.NoError(t, store.WriteTrace(stream))
+	require.NotNil(t, stream.resp)
+
+	types, err := querier.ProfileTypes(ctx)
+	require.NoError(t, err)
+	names := map[string]struct{}{}
+	for _, typ := range types {
+		names[typ.Name] = struct{}{}
+	}
+	require.Equal(t, map[string]struct{}{
+		TraceCPUProfileName:        {},
+		TraceGCProfileName:         {},
+		TraceGoroutinesProfileName: {},
+	}, names)
+
+	res, err := querier.QueryRange(ctx, `go_trace_cpu:samples:count:cpu:nanoseconds:delta{job="test"}`, time.Unix(0, 0), time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Len(t, res[0].Samples, 1)
+	require.Greater(t, res[0].Samples[0].Value, int64(0))
+
+	res, err = querier.QueryRange(ctx, `go_trace_gc:gc_cycles:count:gc_cycles:count:delta{job="test"}`, time.Unix(0, 0), time.Now(), 0)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, int64(1), res[0].Samples[0].Value)
+}
+
+func TestWriteTraceInvalid(t *testing.T) {
+	t.Parallel()
+
+	store, _ := newTestProfileColumnStore(t)
+
+	err := store.WriteTrace(&fakeWriteTraceServer{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = store.WriteTrace(traceStream(t, &profilestorepb.WriteTraceRequest{
+		Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+			{Name: "__name__", Value: "cpu"},
+		}},
+	}))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = store.WriteTrace(&fakeWriteTraceServer{reqs: []*profilestorepb.WriteTraceRequest{{
+		Chunk: []byte("go 1.19 trace\x00\x00\x00\x01"),
+	}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWriteTraceChecks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	relabeler := NewRelabeler(prometheus.NewRegistry(), []*relabel.Config{{
+		SourceLabels: model.LabelNames{labels.MetricName},
+		Regex:        relabel.MustNewRegexp(TraceGoroutinesProfileName),
+		Action:       relabel.Drop,
+	}})
+	store, querier := newTestProfileColumnStore(t, WithRelabeler(relabeler))
+
+	// The derived series are relabeled like the series of WriteRaw.
+	require.NoError(t, store.WriteTrace(traceStream(t, &profilestorepb.WriteTraceRequest{
+		Labels: &profilestorepb.LabelSet{Labels: []*profilestorepb.Label{
+			{Name: "job", Value: "test"},
+		}},
+		RuntimeMetrics: true,
+	})))
+	types, err := querier.ProfileTypes(ctx)
+	require.NoError(t, err)
+	names := map[string]struct{}{}
+	for _, typ := range types {
+		names[typ.Name] = struct{}{}
+	}
+	require.Equal(t, map[string]struct{}{
+		TraceCPUProfileName: {},
+		TraceGCProfileName:  {},
+	}, names)
+
+	// Traces exceeding the maximum profile size are aborted.
+	store, _ = newTestProfileColumnStore(t, WithMaxProfileSize(1000))
+	err = store.WriteTrace(traceStream(t, &profilestorepb.WriteTraceRequest{}))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, err.Error(), "exceeds the maximum profile size")
+}
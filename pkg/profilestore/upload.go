@@ -0,0 +1,115 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// Upload is an HTTP handler writing the pprof profile of the request body,
+// for profiles to be written without a gRPC client:
+//
+//	curl --data-binary @cpu.pb.gz 'http://localhost:7070/api/profiles/upload?__name__=cpu&instance=dev'
+//
+// The query parameters are the labels of the series and the Content-Encoding
+// header is the content encoding of the profile, like in a WriteRaw request.
+// The response is the WriteRawResponse as JSON. Uploads count towards the
+// rate limits of their client like WriteRaw requests.
+func (s *ProfileColumnStore) Upload(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if s.maxProfileSize > 0 {
+		// Reading a byte more than allowed is enough for WriteRaw to
+		// reject the profile.
+		body = io.LimitReader(r.Body, s.maxProfileSize+1)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read profile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	values := r.URL.Query()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ls := make([]*profilestorepb.Label, 0, len(values))
+	for _, name := range names {
+		// Names given more than once are rejected as duplicate labels.
+		for _, value := range values[name] {
+			ls = append(ls, &profilestorepb.Label{Name: name, Value: value})
+		}
+	}
+
+	resp, err := s.WriteRaw(uploadContext(r), &profilestorepb.WriteRawRequest{
+		Series: []*profilestorepb.RawProfileSeries{{
+			Labels: &profilestorepb.LabelSet{Labels: ls},
+			Samples: []*profilestorepb.RawSample{{
+				RawProfile:      content,
+				ContentEncoding: r.Header.Get("Content-Encoding"),
+			}},
+		}},
+	})
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), runtime.HTTPStatusFromCode(status.Code(err)))
+		return
+	}
+
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(b); err != nil {
+		level.Warn(s.logger).Log("msg", "failed to write upload response", "err", err)
+	}
+}
+
+// uploadContext returns the context of an upload with the peer and the
+// forwarded addresses of the request, so the client of an upload is told like
+// the client of a gRPC request.
+func uploadContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		p := &peer.Peer{Addr: addr}
+		if r.TLS != nil {
+			p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+		}
+		ctx = peer.NewContext(ctx, p)
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		md.Append(forwardedForHeader, forwarded...)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return ctx
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profilestore
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpload(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+
+	store, querier := newTestProfileColumnStore(t, WithMaxProfileSize(int64(len(content))))
+	upload := func(query string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		store.Upload(w, httptest.NewRequest(http.MethodPost, "/api/profiles/upload?"+query, bytes.NewReader(body)))
+		return w
+	}
+
+	w := upload("__name__=memory&instance=dev", content)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.JSONEq(t, `{"acceptedSamples": "1"}`, w.Body.String())
+
+	types, err := querier.ProfileTypes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, types, 4)
+
+	w = upload("__name__=memory", []byte("not a profile"))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "failed to parse profile")
+
+	w = upload("instance=dev", content)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "series has no __name__ label")
+
+	w = upload("__name__=memory&instance=a&instance=b", content)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "duplicate label name")
+
+	w = upload("__name__=memory", append(content, 0))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "exceeds the maximum profile size")
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-kit/log/level"
+)
+
+// ActiveQuery is a query that is being executed.
+type ActiveQuery struct {
+	ID       uint64 `json:"id"`
+	Selector string `json:"selector"`
+	Elapsed  string `json:"elapsed"`
+}
+
+type activeQuery struct {
+	selector string
+	start    time.Time
+	cancel   context.CancelFunc
+}
+
+// activeQueries tracks the queries being executed so they can be listed and
+// cancelled.
+type activeQueries struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	nextID  uint64
+	queries map[uint64]*activeQuery
+}
+
+func newActiveQueries() *activeQueries {
+	return &activeQueries{
+		now:     time.Now,
+		queries: map[uint64]*activeQuery{},
+	}
+}
+
+// start tracks a query of the selectors until the returned function is
+// called. Cancelling the query cancels the returned context.
+func (a *activeQueries) start(ctx context.Context, selectors ...string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	a.mtx.Lock()
+	a.nextID++
+	id := a.nextID
+	a.queries[id] = &activeQuery{
+		selector: strings.Join(selectors, "; "),
+		start:    a.now(),
+		cancel:   cancel,
+	}
+	a.mtx.Unlock()
+
+	return ctx, func() {
+		a.mtx.Lock()
+		delete(a.queries, id)
+		a.mtx.Unlock()
+		cancel()
+	}
+}
+
+// list returns the queries being executed, oldest first.
+func (a *activeQueries) list() []ActiveQuery {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	now := a.now()
+	res := make([]ActiveQuery, 0, len(a.queries))
+	for id, q := range a.queries {
+		res = append(res, ActiveQuery{
+			ID:       id,
+			Selector: q.selector,
+			Elapsed:  now.Sub(q.start).String(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// cancel cancels the query of the id, returning false if no such query is
+// being executed.
+func (a *activeQueries) cancel(id uint64) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	q, ok := a.queries[id]
+	if !ok {
+		return false
+	}
+	q.cancel()
+	return true
+}
+
+// AdminHandler is an HTTP handler to list the queries being executed with
+// GET /queries and to cancel one with POST /queries/{id}/cancel.
+func (q *ColumnQueryAPI) AdminHandler() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/queries", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(q.active.list()); err != nil {
+			level.Warn(q.logger).Log("msg", "failed to write active queries", "err", err)
+		}
+	})
+	r.Post("/queries/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid query id", http.StatusBadRequest)
+			return
+		}
+		if !q.active.cancel(id) {
+			http.Error(w, "query not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return r
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// blockingQuerier blocks range queries until they are cancelled.
+type blockingQuerier struct {
+	Querier
+}
+
+func (q blockingQuerier) QueryRange(ctx context.Context, query string, startTime, endTime time.Time, limit uint32) ([]*pb.MetricsSeries, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAdminHandlerCancelQuery(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		blockingQuerier{},
+	)
+	admin := api.AdminHandler()
+
+	const query = `memory:alloc_objects:count:space:bytes{job="a"}`
+	errc := make(chan error, 1)
+	go func() {
+		_, err := api.QueryRange(context.Background(), &pb.QueryRangeRequest{
+			Query: query,
+			Start: timestamppb.New(time.Unix(0, 0)),
+			End:   timestamppb.New(time.Unix(60, 0)),
+		})
+		errc <- err
+	}()
+
+	var active []ActiveQuery
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries", nil))
+		return rec.Code == http.StatusOK &&
+			json.Unmarshal(rec.Body.Bytes(), &active) == nil &&
+			len(active) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(1), active[0].ID)
+	require.Equal(t, query, active[0].Selector)
+	_, err := time.ParseDuration(active[0].Elapsed)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/queries/2/cancel", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/queries/1/cancel", nil))
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	select {
+	case err := <-errc:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled query didn't terminate")
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/queries", nil))
+	require.JSONEq(t, "[]", rec.Body.String())
+}
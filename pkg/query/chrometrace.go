@@ -0,0 +1,290 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// DefaultMaxChromeTraceEvents is the maximum number of events a Chrome trace
+// export contains unless a lower limit is requested.
+const DefaultMaxChromeTraceEvents = 100_000
+
+// ChromeTrace is a profile in the JSON Object Format of the Chrome Trace
+// Event Format, as understood by chrome://tracing and Perfetto.
+type ChromeTrace struct {
+	TraceEvents     []ChromeTraceEvent `json:"traceEvents"`
+	DisplayTimeUnit string             `json:"displayTimeUnit,omitempty"`
+	OtherData       map[string]string  `json:"otherData,omitempty"`
+}
+
+// ChromeTraceEvent is a complete ("X") event. Timestamps and durations are in
+// microseconds.
+type ChromeTraceEvent struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"cat,omitempty"`
+	Phase     string            `json:"ph"`
+	Timestamp float64           `json:"ts"`
+	Duration  float64           `json:"dur"`
+	PID       int               `json:"pid"`
+	TID       int               `json:"tid"`
+	Args      map[string]string `json:"args,omitempty"`
+}
+
+type chromeTraceNode struct {
+	name     string
+	value    int64
+	start    int64
+	children map[string]*chromeTraceNode
+	ordered  []*chromeTraceNode
+}
+
+func (n *chromeTraceNode) child(name string) *chromeTraceNode {
+	if c, ok := n.children[name]; ok {
+		return c
+	}
+	c := &chromeTraceNode{name: name, children: map[string]*chromeTraceNode{}}
+	n.children[name] = c
+	n.ordered = append(n.ordered, c)
+	return c
+}
+
+// layout sorts the children of the node by name, like a flame graph, and
+// places them next to each other starting at the node's start.
+func (n *chromeTraceNode) layout() {
+	sort.Slice(n.ordered, func(i, j int) bool {
+		return n.ordered[i].name < n.ordered[j].name
+	})
+
+	start := n.start
+	for _, c := range n.ordered {
+		c.start = start
+		start += c.value
+		c.layout()
+	}
+}
+
+// chromeTraceHeap orders nodes by descending value, so that truncated traces
+// keep the biggest frames.
+type chromeTraceHeap []*chromeTraceNode
+
+func (h chromeTraceHeap) Len() int            { return len(h) }
+func (h chromeTraceHeap) Less(i, j int) bool  { return h[i].value > h[j].value }
+func (h chromeTraceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *chromeTraceHeap) Push(x interface{}) { *h = append(*h, x.(*chromeTraceNode)) }
+func (h *chromeTraceHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// GenerateChromeTrace converts a profile into a Chrome trace. The stacks of
+// the profile are merged into a tree and each frame becomes a duration event
+// with the cumulative value of the frame as its duration, which renders as a
+// flame chart. Values of profiles measured in nanoseconds are converted to
+// microseconds, any other unit is mapped to one microsecond per unit.
+//
+// At most maxEvents events are returned, preferring the frames with the
+// highest values. Since a frame's value is never lower than the value of its
+// children, a truncated trace never contains a frame without its callers.
+func GenerateChromeTrace(ctx context.Context, p *profile.Profile, maxEvents int) (*ChromeTrace, error) {
+	if maxEvents <= 0 {
+		return nil, errors.New("maximum number of events must be positive")
+	}
+
+	root := &chromeTraceNode{children: map[string]*chromeTraceNode{}}
+	for _, s := range p.Samples {
+		if s.Value <= 0 {
+			continue
+		}
+
+		root.value += s.Value
+		n := root
+		// Locations are ordered from the leaf to the root.
+		for i := len(s.Locations) - 1; i >= 0; i-- {
+			for _, name := range chromeTraceFrameNames(s.Locations[i]) {
+				n = n.child(name)
+				n.value += s.Value
+			}
+		}
+	}
+	root.layout()
+
+	scale := 1.0
+	if p.Meta.SampleType.Unit == "nanoseconds" {
+		scale = 1.0 / float64(time.Microsecond)
+	}
+
+	trace := &ChromeTrace{
+		TraceEvents:     []ChromeTraceEvent{},
+		DisplayTimeUnit: "ms",
+		OtherData: map[string]string{
+			"sample_type": p.Meta.SampleType.Type,
+			"sample_unit": p.Meta.SampleType.Unit,
+		},
+	}
+
+	h := &chromeTraceHeap{}
+	for _, c := range root.ordered {
+		heap.Push(h, c)
+	}
+	for h.Len() > 0 {
+		if len(trace.TraceEvents) == maxEvents {
+			trace.OtherData["truncated"] = "true"
+			break
+		}
+
+		n := heap.Pop(h).(*chromeTraceNode)
+		trace.TraceEvents = append(trace.TraceEvents, ChromeTraceEvent{
+			Name:      n.name,
+			Category:  p.Meta.Name,
+			Phase:     "X",
+			Timestamp: float64(n.start) * scale,
+			Duration:  float64(n.value) * scale,
+			PID:       1,
+			TID:       1,
+			Args:      map[string]string{"value": strconv.FormatInt(n.value, 10)},
+		})
+		for _, c := range n.ordered {
+			heap.Push(h, c)
+		}
+	}
+
+	// Events are expected to be sorted by their timestamp.
+	sort.SliceStable(trace.TraceEvents, func(i, j int) bool {
+		return trace.TraceEvents[i].Timestamp < trace.TraceEvents[j].Timestamp
+	})
+
+	return trace, nil
+}
+
+// chromeTraceFrameNames returns the names of the frames of a location, from
+// the outermost to the innermost inlined function.
+func chromeTraceFrameNames(l *profile.Location) []string {
+	if len(l.Lines) == 0 {
+		return []string{fmt.Sprintf("0x%x", l.Address)}
+	}
+
+	names := make([]string, 0, len(l.Lines))
+	for i := len(l.Lines) - 1; i >= 0; i-- {
+		name := l.Lines[i].Function.GetName()
+		if name == "" {
+			name = fmt.Sprintf("0x%x", l.Address)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// selectHTTPProfile selects the profile of an HTTP export. The profile is
+// selected with the query parameter and either a single time or a start and
+// end to merge profiles over. Times are RFC3339 formatted. On failure the
+// HTTP status code to respond with is returned.
+func (q *ColumnQueryAPI) selectHTTPProfile(ctx context.Context, values url.Values) (*profile.Profile, int, error) {
+	query := values.Get("query")
+	if query == "" {
+		return nil, http.StatusBadRequest, errors.New("query is required")
+	}
+
+	parseTime := func(name string) (time.Time, error) {
+		t, err := time.Parse(time.RFC3339Nano, values.Get(name))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return t, nil
+	}
+
+	var (
+		p   *profile.Profile
+		err error
+	)
+	switch {
+	case values.Has("time"):
+		var ts time.Time
+		if ts, err = parseTime("time"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		p, err = q.querier.QuerySingle(ctx, query, ts)
+	case values.Has("start") && values.Has("end"):
+		var start, end time.Time
+		if start, err = parseTime("start"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if end, err = parseTime("end"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		p, err = q.querier.QueryMerge(ctx, query, start, end)
+	default:
+		return nil, http.StatusBadRequest, errors.New("either time or start and end are required")
+	}
+	if err != nil {
+		return nil, runtime.HTTPStatusFromCode(status.Code(err)), err
+	}
+
+	return p, http.StatusOK, nil
+}
+
+// ChromeTrace is an HTTP handler exporting a queried profile as a Chrome
+// trace. The profile is selected like for selectHTTPProfile. The number of
+// events can be lowered with max_events.
+func (q *ColumnQueryAPI) ChromeTrace(w http.ResponseWriter, r *http.Request) {
+	ctx, span := q.tracer.Start(r.Context(), "ChromeTrace")
+	defer span.End()
+
+	values := r.URL.Query()
+	maxEvents := DefaultMaxChromeTraceEvents
+	if v := values.Get("max_events"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid max_events %q", v), http.StatusBadRequest)
+			return
+		}
+		if n < maxEvents {
+			maxEvents = n
+		}
+	}
+
+	p, code, err := q.selectHTTPProfile(ctx, values)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	trace, err := GenerateChromeTrace(ctx, p, maxEvents)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(trace); err != nil {
+		level.Warn(q.logger).Log("msg", "failed to write chrome trace", "err", err)
+	}
+}
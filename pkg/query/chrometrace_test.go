@@ -0,0 +1,179 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+func chromeTraceTestProfile() *profile.Profile {
+	loc := func(names ...string) *profile.Location {
+		l := &profile.Location{}
+		for _, n := range names {
+			l.Lines = append(l.Lines, profile.LocationLine{Function: &metastorepb.Function{Name: n}})
+		}
+		return l
+	}
+
+	var (
+		main = loc("main")
+		a    = loc("a")
+		// b is inlined into c.
+		bc = loc("b", "c")
+	)
+
+	return &profile.Profile{
+		Meta: profile.Meta{
+			Name:       "process_cpu",
+			SampleType: profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		},
+		Samples: []*profile.SymbolizedSample{
+			{Locations: []*profile.Location{a, main}, Value: 3000},
+			{Locations: []*profile.Location{bc, main}, Value: 1000},
+			{Locations: []*profile.Location{main}, Value: 2000},
+			{Locations: []*profile.Location{{Address: 0x1234}}, Value: 500},
+		},
+	}
+}
+
+func TestGenerateChromeTrace(t *testing.T) {
+	t.Parallel()
+
+	tr, err := GenerateChromeTrace(context.Background(), chromeTraceTestProfile(), DefaultMaxChromeTraceEvents)
+	require.NoError(t, err)
+
+	b, err := json.Marshal(tr)
+	require.NoError(t, err)
+	require.True(t, json.Valid(b))
+
+	// 0x1234, main, a, c and the inlined b.
+	require.Len(t, tr.TraceEvents, 5)
+	require.NotContains(t, tr.OtherData, "truncated")
+
+	events := map[string]ChromeTraceEvent{}
+	for _, e := range tr.TraceEvents {
+		require.Equal(t, "X", e.Phase)
+		events[e.Name] = e
+	}
+
+	require.Equal(t, 0.0, events["0x1234"].Timestamp)
+	require.Equal(t, 0.5, events["0x1234"].Duration)
+	require.Equal(t, 0.5, events["main"].Timestamp)
+	require.Equal(t, 6.0, events["main"].Duration)
+	require.Equal(t, 0.5, events["a"].Timestamp)
+	require.Equal(t, 3.0, events["a"].Duration)
+	require.Equal(t, 3.5, events["c"].Timestamp)
+	require.Equal(t, 1.0, events["c"].Duration)
+	require.Equal(t, 3.5, events["b"].Timestamp)
+	require.Equal(t, 1.0, events["b"].Duration)
+}
+
+func TestGenerateChromeTraceTruncated(t *testing.T) {
+	t.Parallel()
+
+	tr, err := GenerateChromeTrace(context.Background(), chromeTraceTestProfile(), 2)
+	require.NoError(t, err)
+
+	require.Len(t, tr.TraceEvents, 2)
+	require.Equal(t, "true", tr.OtherData["truncated"])
+	require.Equal(t, "main", tr.TraceEvents[0].Name)
+	require.Equal(t, "a", tr.TraceEvents[1].Name)
+
+	_, err = GenerateChromeTrace(context.Background(), chromeTraceTestProfile(), 0)
+	require.Error(t, err)
+}
+
+type fakeChromeTraceQuerier struct {
+	Querier
+	p *profile.Profile
+}
+
+func (q fakeChromeTraceQuerier) QuerySingle(ctx context.Context, query string, time time.Time) (*profile.Profile, error) {
+	return q.p, nil
+}
+
+func (q fakeChromeTraceQuerier) QueryMerge(ctx context.Context, query string, start, end time.Time) (*profile.Profile, error) {
+	return q.p, nil
+}
+
+func TestChromeTraceHandler(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		fakeChromeTraceQuerier{p: chromeTraceTestProfile()},
+	)
+
+	tests := map[string]struct {
+		url    string
+		code   int
+		events int
+	}{
+		"single": {
+			url:    "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta&time=2022-01-01T00:00:00Z",
+			code:   http.StatusOK,
+			events: 5,
+		},
+		"merge": {
+			url:    "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta&start=2022-01-01T00:00:00Z&end=2022-01-02T00:00:00Z&max_events=3",
+			code:   http.StatusOK,
+			events: 3,
+		},
+		"missing query": {
+			url:  "/?time=2022-01-01T00:00:00Z",
+			code: http.StatusBadRequest,
+		},
+		"missing time": {
+			url:  "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta",
+			code: http.StatusBadRequest,
+		},
+		"invalid max events": {
+			url:  "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta&time=2022-01-01T00:00:00Z&max_events=-1",
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			api.ChromeTrace(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+			require.Equal(t, test.code, rec.Code, rec.Body.String())
+			if test.code != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			tr := &ChromeTrace{}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), tr))
+			require.Len(t, tr.TraceEvents, test.events)
+		})
+	}
+}
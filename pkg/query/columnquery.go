@@ -24,9 +24,12 @@ import (
 	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
 	sharepb "github.com/parca-dev/parca/gen/proto/go/share"
+	"github.com/parca-dev/parca/pkg/originals"
+	"github.com/parca-dev/parca/pkg/parcacol"
 	"github.com/parca-dev/parca/pkg/profile"
 )
 
@@ -37,6 +40,7 @@ type Querier interface {
 	ProfileTypes(ctx context.Context) ([]*pb.ProfileType, error)
 	QuerySingle(ctx context.Context, query string, time time.Time) (*profile.Profile, error)
 	QueryMerge(ctx context.Context, query string, start, end time.Time) (*profile.Profile, error)
+	QueryByTraceID(ctx context.Context, query, traceID, spanID string, start, end time.Time) (*profile.Profile, error)
 }
 
 // ColumnQueryAPI is the read api interface for parca
@@ -48,6 +52,116 @@ type ColumnQueryAPI struct {
 	tracer      trace.Tracer
 	shareClient sharepb.ShareClient
 	querier     Querier
+
+	maxMergeSpan      time.Duration
+	mergeSpanExceeded string
+	maxSeries         int
+	requiredLabels    map[string]struct{}
+	maxRegexSize      int
+	originals         *originals.Store
+	notifier          *WriteNotifier
+	replicaLabel      string
+	cpuBudget         *cpuBudget
+	quarantine        bool
+
+	active *activeQueries
+}
+
+// What to do about merges of samples spanning more than the maximum merge
+// span.
+const (
+	MergeSpanExceededWarn  = "warn"
+	MergeSpanExceededError = "error"
+)
+
+type Option func(*ColumnQueryAPI)
+
+// WithMaxMergeSpan limits the time between the first and the last sample of
+// a merge. Exceeding it either adds a warning to the response or fails the
+// query, depending on exceeded being MergeSpanExceededWarn or
+// MergeSpanExceededError.
+func WithMaxMergeSpan(span time.Duration, exceeded string) Option {
+	return func(q *ColumnQueryAPI) {
+		q.maxMergeSpan = span
+		q.mergeSpanExceeded = exceeded
+	}
+}
+
+// WithMaxSeriesPerQuery limits the number of series a range query may
+// return, queries matching more fail.
+func WithMaxSeriesPerQuery(max int) Option {
+	return func(q *ColumnQueryAPI) {
+		q.maxSeries = max
+	}
+}
+
+// WithRequiredLabels rejects queries whose selectors select none of the
+// labels, so that no query can select the profiles of every series.
+func WithRequiredLabels(names ...string) Option {
+	return func(q *ColumnQueryAPI) {
+		if len(names) == 0 {
+			return
+		}
+		q.requiredLabels = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			q.requiredLabels[name] = struct{}{}
+		}
+	}
+}
+
+// WithMaxRegexSize rejects queries with a regex matcher whose regex compiles
+// to more than size instructions, bounding the cost of matching it.
+func WithMaxRegexSize(size int) Option {
+	return func(q *ColumnQueryAPI) {
+		q.maxRegexSize = size
+	}
+}
+
+// WithOriginals downloads single profiles as they were written if they are
+// in the store, rather than reconstructing them from their samples.
+func WithOriginals(store *originals.Store) Option {
+	return func(q *ColumnQueryAPI) {
+		q.originals = store
+	}
+}
+
+// WithWriteNotifier enables live queries, updated when the notifier is told
+// of a write to a series they select.
+func WithWriteNotifier(n *WriteNotifier) Option {
+	return func(q *ColumnQueryAPI) {
+		q.notifier = n
+	}
+}
+
+// WithReplicaLabel deduplicates the series of range queries only differing
+// by the replica label, like the series of redundant agents profiling the
+// same process, instead of returning them separately.
+func WithReplicaLabel(name string) Option {
+	return func(q *ColumnQueryAPI) {
+		q.replicaLabel = name
+	}
+}
+
+// WithCPUBudget aborts queries consuming more than the budget of CPU time,
+// checked every interval, so a single query can't starve others on shared
+// cores. Each query is charged its own CPU time only, see cpuBudget. The
+// budget is not enforced on platforms without per-thread CPU accounting.
+func WithCPUBudget(budget, interval time.Duration) Option {
+	return func(q *ColumnQueryAPI) {
+		q.cpuBudget = &cpuBudget{
+			budget:        budget,
+			interval:      interval,
+			threadCPUTime: threadCPUTime,
+		}
+	}
+}
+
+// WithQuarantine allows queries to select the profiles stored in the
+// quarantine of the profile store instead of the regular ones.
+func WithQuarantine() Option {
+	return func(q *ColumnQueryAPI) {
+		q.quarantine = true
+	}
 }
 
 func NewColumnQueryAPI(
@@ -55,13 +169,29 @@ func NewColumnQueryAPI(
 	tracer trace.Tracer,
 	shareClient sharepb.ShareClient,
 	querier Querier,
+	opts ...Option,
 ) *ColumnQueryAPI {
-	return &ColumnQueryAPI{
-		logger:      logger,
-		tracer:      tracer,
-		shareClient: shareClient,
-		querier:     querier,
+	q := &ColumnQueryAPI{
+		logger:            logger,
+		tracer:            tracer,
+		shareClient:       shareClient,
+		querier:           querier,
+		mergeSpanExceeded: MergeSpanExceededWarn,
+		active:            newActiveQueries(),
 	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// optionalTime returns the time of a timestamp, or the zero time if it isn't
+// set.
+func optionalTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
 }
 
 // Labels issues a labels request against the storage.
@@ -78,7 +208,7 @@ func (q *ColumnQueryAPI) Labels(ctx context.Context, req *pb.LabelsRequest) (*pb
 
 // Values issues a values request against the storage.
 func (q *ColumnQueryAPI) Values(ctx context.Context, req *pb.ValuesRequest) (*pb.ValuesResponse, error) {
-	vals, err := q.querier.Values(ctx, req.LabelName, req.Match, req.Start.AsTime(), req.End.AsTime())
+	vals, err := q.querier.Values(ctx, req.LabelName, req.Match, optionalTime(req.Start), optionalTime(req.End))
 	if err != nil {
 		return nil, err
 	}
@@ -93,17 +223,66 @@ func (q *ColumnQueryAPI) QueryRange(ctx context.Context, req *pb.QueryRangeReque
 	if err := req.Validate(); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := q.checkSelector(req.Query); err != nil {
+		return nil, err
+	}
+	ctx, done := q.active.start(ctx, req.Query)
+	defer done()
+	if req.PrimarySampleType {
+		ctx = parcacol.WithPrimarySampleType(ctx)
+	}
+	ctx, err := q.withQuarantined(ctx, req.Quarantined)
+	if err != nil {
+		return nil, err
+	}
+	start, end := req.Start.AsTime(), req.End.AsTime()
+	if req.Step != nil {
+		if n := stepBuckets(start, end, req.Step.AsDuration()); n > maxStepBuckets {
+			return nil, status.Errorf(codes.InvalidArgument, "query has %d buckets, more than the maximum of %d, increase the step", n, maxStepBuckets)
+		}
+		ctx = parcacol.WithStep(ctx, req.Step.AsDuration())
+	}
 
-	res, err := q.querier.QueryRange(ctx, req.Query, req.Start.AsTime(), req.End.AsTime(), req.Limit)
+	var res []*pb.MetricsSeries
+	err = q.cpuBudget.run(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = q.querier.QueryRange(ctx, req.Query, start, end, req.Limit)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	if q.replicaLabel != "" {
+		// The replicas are deduplicated per bucket with a step, as they
+		// are unlikely to profile at the same time.
+		if req.Step != nil {
+			bucketSeries(res, start, end, req.Step.AsDuration(), false)
+		}
+		res = deduplicateReplicas(res, q.replicaLabel)
+	}
+	if q.maxSeries > 0 && len(res) > q.maxSeries {
+		return nil, status.Errorf(codes.ResourceExhausted, "query matches %d series, more than the maximum of %d, narrow down the selector", len(res), q.maxSeries)
+	}
+	if req.Step != nil {
+		bucketSeries(res, start, end, req.Step.AsDuration(), req.FillZeros)
+	}
 
 	return &pb.QueryRangeResponse{
 		Series: res,
 	}, nil
 }
 
+// withQuarantined makes the query select quarantined profiles if requested.
+func (q *ColumnQueryAPI) withQuarantined(ctx context.Context, quarantined bool) (context.Context, error) {
+	if !quarantined {
+		return ctx, nil
+	}
+	if !q.quarantine {
+		return nil, status.Error(codes.FailedPrecondition, "the quarantine is disabled")
+	}
+	return parcacol.WithQuarantined(ctx), nil
+}
+
 // Types returns the available types of profiles.
 func (q *ColumnQueryAPI) ProfileTypes(ctx context.Context, req *pb.ProfileTypesRequest) (*pb.ProfileTypesResponse, error) {
 	types, err := q.querier.ProfileTypes(ctx)
@@ -118,13 +297,58 @@ func (q *ColumnQueryAPI) ProfileTypes(ctx context.Context, req *pb.ProfileTypesR
 
 // Query issues a instant query against the storage.
 func (q *ColumnQueryAPI) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	ctx, span := q.tracer.Start(ctx, "Query")
+	defer span.End()
+	span.SetAttributes(attribute.String("mode", req.Mode.String()))
+
+	var resp *pb.QueryResponse
+	err := q.cpuBudget.run(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = q.query(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+func (q *ColumnQueryAPI) query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
 	if err := req.Validate(); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if err := q.checkSelectors(req); err != nil {
+		return nil, err
+	}
+	filter, err := q.newStackFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, done := q.active.start(ctx, requestSelectors(req)...)
+	defer done()
+	if req.PrimarySampleType {
+		ctx = parcacol.WithPrimarySampleType(ctx)
+	}
+	ctx, err = q.withQuarantined(ctx, req.Quarantined)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, err := q.checkMergeSpans(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if q.originals != nil && downloadsOriginal(req) {
+		if b, ok := q.originalProfile(ctx, req.GetSingle()); ok {
+			return &pb.QueryResponse{
+				Report:   &pb.QueryResponse_Pprof{Pprof: b},
+				Warnings: warnings,
+			}, nil
+		}
+	}
 
 	var (
-		p   *profile.Profile
-		err error
+		p       *profile.Profile
+		changes *pb.FunctionChanges
+		sources []*profile.Profile
 	)
 
 	switch req.Mode {
@@ -133,7 +357,12 @@ func (q *ColumnQueryAPI) Query(ctx context.Context, req *pb.QueryRequest) (*pb.Q
 	case pb.QueryRequest_MODE_MERGE:
 		p, err = q.selectMerge(ctx, req.GetMerge())
 	case pb.QueryRequest_MODE_DIFF:
-		p, err = q.selectDiff(ctx, req.GetDiff())
+		p, changes, err = q.selectDiff(ctx, req.GetDiff(), req.GetPercentOnly())
+	case pb.QueryRequest_MODE_TRACE:
+		p, err = q.selectTrace(ctx, req.GetTrace())
+	case pb.QueryRequest_MODE_UNION:
+		sources, err = q.selectUnion(ctx, req.GetUnion(), req.GetPercentOnly())
+		p = unionProfile(sources)
 	default:
 		return nil, status.Error(codes.InvalidArgument, "unknown query mode")
 	}
@@ -142,7 +371,53 @@ func (q *ColumnQueryAPI) Query(ctx context.Context, req *pb.QueryRequest) (*pb.Q
 		return nil, err
 	}
 
-	return q.renderReport(ctx, p, req.GetReportType())
+	// The sides of a diff and the profiles of a union are made relative
+	// when they are selected.
+	if req.GetPercentOnly() && req.Mode != pb.QueryRequest_MODE_DIFF && req.Mode != pb.QueryRequest_MODE_UNION {
+		p = PercentProfile(p)
+	}
+
+	// Stacks are filtered after the values are made relative, so that they
+	// stay relative to the whole profile, like they do in pprof.
+	if filter != nil {
+		p = filter.filterProfile(p)
+		for i, source := range sources {
+			sources[i] = filter.filterProfile(source)
+		}
+	}
+
+	var resp *pb.QueryResponse
+	if req.Mode == pb.QueryRequest_MODE_UNION && req.GetReportType() == pb.QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED {
+		// Other reports of a union are reports of its plain merge, as only
+		// flame graphs keep the sources of their nodes.
+		fg, err := GenerateUnionFlamegraph(ctx, q.tracer, sources)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to generate flamegraph: %v", err.Error())
+		}
+		resp = &pb.QueryResponse{
+			Report: &pb.QueryResponse_Flamegraph{Flamegraph: fg},
+		}
+	} else {
+		resp, err = q.renderReport(ctx, p, req.GetReportType())
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp.FunctionChanges = changes
+	resp.Warnings = warnings
+
+	if subtree := req.GetSubtree(); subtree != nil {
+		resp.Report = &pb.QueryResponse_Flamegraph{
+			Flamegraph: SubtreeFlamegraph(resp.GetFlamegraph(), subtree),
+		}
+	}
+	if req.GetLeavesOnly() {
+		resp.Report = &pb.QueryResponse_Top{
+			Top: LeavesTopTable(resp.GetTop()),
+		}
+	}
+
+	return resp, nil
 }
 
 func (q *ColumnQueryAPI) renderReport(ctx context.Context, p *profile.Profile, typ pb.QueryRequest_ReportType) (*pb.QueryResponse, error) {
@@ -224,26 +499,127 @@ func (q *ColumnQueryAPI) selectMerge(ctx context.Context, m *pb.MergeProfile) (*
 	return p, nil
 }
 
-func (q *ColumnQueryAPI) selectDiff(ctx context.Context, d *pb.DiffProfile) (*profile.Profile, error) {
+// checkMergeSpans checks the time spanned by the samples of the merges of a
+// query against the maximum merge span. Samples arriving late can make a
+// merge mix states of unrelated points in time.
+func (q *ColumnQueryAPI) checkMergeSpans(ctx context.Context, req *pb.QueryRequest) ([]string, error) {
+	if q.maxMergeSpan <= 0 {
+		return nil, nil
+	}
+
+	var merges []*pb.MergeProfile
+	switch req.Mode {
+	case pb.QueryRequest_MODE_MERGE:
+		merges = append(merges, req.GetMerge())
+	case pb.QueryRequest_MODE_UNION:
+		merges = append(merges, req.GetUnion().GetMerges()...)
+	case pb.QueryRequest_MODE_DIFF:
+		for _, s := range []*pb.ProfileDiffSelection{req.GetDiff().GetA(), req.GetDiff().GetB()} {
+			if s.GetMode() == pb.ProfileDiffSelection_MODE_MERGE {
+				merges = append(merges, s.GetMerge())
+			}
+		}
+	}
+
+	var warnings []string
+	for _, m := range merges {
+		span, err := q.mergeSpan(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if span <= q.maxMergeSpan {
+			continue
+		}
+
+		msg := fmt.Sprintf("merged samples of %s span %s, more than the maximum merge span of %s", m.GetQuery(), span, q.maxMergeSpan)
+		if q.mergeSpanExceeded == MergeSpanExceededError {
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		warnings = append(warnings, msg)
+	}
+
+	return warnings, nil
+}
+
+// mergeSpan returns the time between the first and the last sample of a
+// merge.
+func (q *ColumnQueryAPI) mergeSpan(ctx context.Context, m *pb.MergeProfile) (time.Duration, error) {
+	series, err := q.querier.QueryRange(ctx, m.GetQuery(), m.GetStart().AsTime(), m.GetEnd().AsTime(), 0)
+	if status.Code(err) == codes.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var first, last time.Time
+	for _, s := range series {
+		for _, sample := range s.Samples {
+			ts := sample.Timestamp.AsTime()
+			if first.IsZero() || ts.Before(first) {
+				first = ts
+			}
+			if ts.After(last) {
+				last = ts
+			}
+		}
+	}
+
+	return last.Sub(first), nil
+}
+
+func (q *ColumnQueryAPI) selectTrace(ctx context.Context, t *pb.TraceProfile) (*profile.Profile, error) {
+	p, err := q.querier.QueryByTraceID(
+		ctx,
+		t.Query,
+		t.TraceId,
+		t.SpanId,
+		t.Start.AsTime(),
+		t.End.AsTime(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// selectDiff returns the diff of the selected profiles and, if requested, the
+// functions that appeared and disappeared between them. With percentOnly both
+// profiles are relative to their own totals before they are compared.
+func (q *ColumnQueryAPI) selectDiff(ctx context.Context, d *pb.DiffProfile, percentOnly bool) (*profile.Profile, *pb.FunctionChanges, error) {
 	ctx, span := q.tracer.Start(ctx, "diffRequest")
 	defer span.End()
 
 	if d == nil {
-		return nil, status.Error(codes.InvalidArgument, "requested diff mode, but did not provide parameters for diff")
+		return nil, nil, status.Error(codes.InvalidArgument, "requested diff mode, but did not provide parameters for diff")
 	}
 
 	base, err := q.selectProfileForDiff(ctx, d.A)
 	if err != nil {
-		return nil, fmt.Errorf("reading base profile: %w", err)
+		return nil, nil, fmt.Errorf("reading base profile: %w", err)
 	}
 
 	compare, err := q.selectProfileForDiff(ctx, d.B)
 	if err != nil {
-		return nil, fmt.Errorf("reading compared profile: %w", err)
+		return nil, nil, fmt.Errorf("reading compared profile: %w", err)
+	}
+
+	if percentOnly {
+		base = PercentProfile(base)
+		compare = PercentProfile(compare)
+	}
+
+	var changes *pb.FunctionChanges
+	if d.FunctionChanges {
+		changes = GenerateFunctionChanges(ctx, base, compare)
 	}
 
 	// TODO: This is cheating a bit. This should be done with a sub-query in the columnstore.
 	diff := &profile.Profile{}
+	if percentOnly {
+		diff.Meta.SampleType.Unit = BasisPointsUnit
+	}
 
 	// TODO: Use parcacol.Sample for comparing these
 	for i := range compare.Samples {
@@ -265,7 +641,7 @@ func (q *ColumnQueryAPI) selectDiff(ctx context.Context, d *pb.DiffProfile) (*pr
 		})
 	}
 
-	return diff, nil
+	return diff, changes, nil
 }
 
 func (q *ColumnQueryAPI) selectProfileForDiff(ctx context.Context, s *pb.ProfileDiffSelection) (*profile.Profile, error) {
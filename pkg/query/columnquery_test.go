@@ -19,6 +19,7 @@ import (
 	"context"
 	"crypto/tls"
 	"io"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -37,6 +38,7 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
@@ -216,6 +218,365 @@ func TestColumnQueryAPIQueryRange(t *testing.T) {
 	require.Equal(t, 10, len(res.Series[0].Samples))
 }
 
+func TestColumnQueryAPIQueryRangeStep(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	for _, sec := range []int64{10, 20, 25, 50} {
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(fileContent))
+		p.TimeNanos = time.Unix(sec, 0).UnixNano()
+
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: "default",
+		}}, p, false)
+		require.NoError(t, err)
+	}
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			m,
+		),
+	)
+
+	queryRange := func(step time.Duration, fillZeros bool) (*pb.QueryRangeResponse, error) {
+		req := &pb.QueryRangeRequest{
+			Query:     `memory:alloc_objects:count:space:bytes{job="default"}`,
+			Start:     timestamppb.New(time.Unix(0, 0)),
+			End:       timestamppb.New(time.Unix(60, 0)),
+			FillZeros: fillZeros,
+		}
+		if step != 0 {
+			req.Step = durationpb.New(step)
+		}
+		return api.QueryRange(ctx, req)
+	}
+
+	res, err := queryRange(0, false)
+	require.NoError(t, err)
+	require.Len(t, res.Series[0].Samples, 4)
+	v := res.Series[0].Samples[0].Value
+	require.NotZero(t, v)
+
+	type point struct {
+		sec   int64
+		value int64
+	}
+	points := func(res *pb.QueryRangeResponse) []point {
+		var ps []point
+		for _, s := range res.Series[0].Samples {
+			ps = append(ps, point{s.Timestamp.AsTime().Unix(), s.Value})
+		}
+		return ps
+	}
+
+	res, err = queryRange(10*time.Second, false)
+	require.NoError(t, err)
+	require.Equal(t, []point{{10, v}, {20, 2 * v}, {50, v}}, points(res))
+
+	res, err = queryRange(10*time.Second, true)
+	require.NoError(t, err)
+	require.Equal(t, []point{{0, 0}, {10, v}, {20, 2 * v}, {30, 0}, {40, 0}, {50, v}}, points(res))
+
+	res, err = queryRange(time.Minute, false)
+	require.NoError(t, err)
+	require.Equal(t, []point{{0, 4 * v}}, points(res))
+
+	_, err = queryRange(time.Millisecond, false)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = queryRange(-time.Second, false)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestColumnQueryAPIQueryRangeRollups(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	tables := map[string]*columnstore.Table{}
+	for _, name := range []string{"stacktraces", "rollups", "rollups_2m", "empty"} {
+		tables[name], err = colDB.Table(
+			name,
+			columnstore.NewTableConfig(schema),
+		)
+		require.NoError(t, err)
+	}
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, tables["stacktraces"], schema,
+		parcacol.WithRollups(tables["rollups"], time.Minute),
+		parcacol.WithRollups(tables["rollups_2m"], 2*time.Minute),
+	)
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	for _, w := range []struct {
+		job string
+		sec int64
+	}{
+		{job: "a", sec: 30},
+		{job: "a", sec: 60},
+		{job: "a", sec: 90},
+		{job: "b", sec: 100},
+		{job: "a", sec: 150},
+		{job: "b", sec: 179},
+		{job: "a", sec: 250},
+	} {
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(fileContent))
+		p.TimeNanos = time.Unix(w.sec, 0).UnixNano()
+
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: w.job,
+		}}, p, false)
+		require.NoError(t, err)
+	}
+
+	newAPI := func(table string, opts ...parcacol.QuerierOption) *ColumnQueryAPI {
+		return NewColumnQueryAPI(
+			logger,
+			tracer,
+			getShareServerConn(t),
+			parcacol.NewQuerier(
+				tracer,
+				query.NewEngine(
+					memory.DefaultAllocator,
+					colDB.TableProvider(),
+				),
+				table,
+				m,
+				opts...,
+			),
+		)
+	}
+	type point struct {
+		sec   int64
+		value int64
+	}
+	queryRange := func(api *ColumnQueryAPI, start, end int64, step time.Duration) (map[string][]point, error) {
+		res, err := api.QueryRange(ctx, &pb.QueryRangeRequest{
+			Query:     `memory:alloc_objects:count:space:bytes`,
+			Start:     timestamppb.New(time.Unix(start, 0)),
+			End:       timestamppb.New(time.Unix(end, 0)),
+			Step:      durationpb.New(step),
+			FillZeros: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		series := map[string][]point{}
+		for _, s := range res.Series {
+			var ps []point
+			for _, sample := range s.Samples {
+				ps = append(ps, point{sample.Timestamp.AsTime().Unix(), sample.Value})
+			}
+			series[s.Labelset.String()] = ps
+		}
+		return series, nil
+	}
+
+	// The querier of the empty table can only answer queries from rollups.
+	raw := newAPI("stacktraces")
+	rollups := newAPI("empty", parcacol.WithRollupTable("rollups", time.Minute))
+	for _, q := range []struct {
+		start, end int64
+		step       time.Duration
+	}{
+		{start: 0, end: 240, step: time.Minute},
+		{start: 0, end: 240, step: 2 * time.Minute},
+		{start: 60, end: 180, step: time.Minute},
+		{start: 0, end: 300, step: 5 * time.Minute},
+	} {
+		want, err := queryRange(raw, q.start, q.end, q.step)
+		require.NoError(t, err)
+		got, err := queryRange(rollups, q.start, q.end, q.step)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	// Queries whose buckets don't consist of whole rollups read samples.
+	for _, q := range []struct {
+		start, end int64
+		step       time.Duration
+	}{
+		{start: 1, end: 241, step: time.Minute},
+		{start: 0, end: 210, step: time.Minute},
+		{start: 0, end: 240, step: 30 * time.Second},
+	} {
+		_, err := queryRange(rollups, q.start, q.end, q.step)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	}
+
+	// Queries read the rollups of the coarsest granularity their buckets
+	// consist of whole rollups of, the empty table stands in for the
+	// rollups of the finer one here.
+	coarsest := newAPI("stacktraces",
+		parcacol.WithRollupTable("empty", time.Minute),
+		parcacol.WithRollupTable("rollups_2m", 2*time.Minute),
+	)
+	want, err := queryRange(raw, 0, 240, 2*time.Minute)
+	require.NoError(t, err)
+	got, err := queryRange(coarsest, 0, 240, 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	_, err = queryRange(coarsest, 60, 180, time.Minute)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestColumnQueryAPIQueryRangeMaxSeries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")))
+
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+	for _, instance := range []string{"a", "b", "c"} {
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "instance",
+			Value: instance,
+		}, {
+			Name:  "job",
+			Value: "default",
+		}}, p, false)
+		require.NoError(t, err)
+	}
+
+	newAPI := func(opts ...Option) *ColumnQueryAPI {
+		return NewColumnQueryAPI(
+			logger,
+			tracer,
+			getShareServerConn(t),
+			parcacol.NewQuerier(
+				tracer,
+				query.NewEngine(
+					memory.DefaultAllocator,
+					colDB.TableProvider(),
+				),
+				"stacktraces",
+				m,
+			),
+			opts...,
+		)
+	}
+	req := &pb.QueryRangeRequest{
+		Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+		Start: timestamppb.New(timestamp.Time(0)),
+		End:   timestamppb.New(timestamp.Time(9223372036854775807)),
+	}
+
+	res, err := newAPI(WithMaxSeriesPerQuery(3)).QueryRange(ctx, req)
+	require.NoError(t, err)
+	require.Len(t, res.Series, 3)
+
+	_, err = newAPI(WithMaxSeriesPerQuery(2)).QueryRange(ctx, req)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// A narrower selector stays within the limit.
+	res, err = newAPI(WithMaxSeriesPerQuery(2)).QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: `memory:alloc_objects:count:space:bytes{job="default",instance="a"}`,
+		Start: req.Start,
+		End:   req.End,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Series, 1)
+}
+
 func TestColumnQueryAPIQuerySingle(t *testing.T) {
 	t.Parallel()
 
@@ -239,30 +600,602 @@ func TestColumnQueryAPIQuerySingle(t *testing.T) {
 		columnstore.NewTableConfig(schema),
 	)
 	require.NoError(t, err)
-	m := metastoretest.NewTestMetastore(
+	m := metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	)
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	p := &pprofpb.Profile{}
+	err = p.UnmarshalVT(fileContent)
+	require.NoError(t, err)
+
+	metastore := metastore.NewInProcessClient(m)
+	normalizer := parcacol.NewNormalizer(metastore)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	err = ingester.Ingest(ctx, labels.Labels{{
+		Name:  "__name__",
+		Value: "memory",
+	}, {
+		Name:  "job",
+		Value: "default",
+	}}, p, false)
+	require.NoError(t, err)
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			metastore,
+		),
+	)
+	ts := timestamppb.New(timestamp.Time(p.TimeNanos / time.Millisecond.Nanoseconds()))
+	res, err := api.Query(ctx, &pb.QueryRequest{
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Time:  ts,
+			},
+		},
+	})
+	require.NoError(t, err)
+	fg := res.Report.(*pb.QueryResponse_Flamegraph).Flamegraph
+	require.Equal(t, int32(33), fg.Height)
+
+	// The subtree of a function is re-based to its cumulative value.
+	child := fg.Root.Children[0]
+	res, err = api.Query(ctx, &pb.QueryRequest{
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Time:  ts,
+			},
+		},
+		Subtree: &pb.Subtree{FunctionName: child.Meta.Function.Name},
+	})
+	require.NoError(t, err)
+	subtree := res.Report.(*pb.QueryResponse_Flamegraph).Flamegraph
+	require.Equal(t, subtree.Root.Cumulative, subtree.Total)
+	require.GreaterOrEqual(t, subtree.Total, child.Cumulative)
+	require.Less(t, subtree.Total, fg.Total)
+	require.Len(t, subtree.Root.Children, 1)
+	require.Equal(t, child.Meta.Function.Name, subtree.Root.Children[0].Meta.Function.Name)
+
+	_, err = api.Query(ctx, &pb.QueryRequest{
+		ReportType: pb.QueryRequest_REPORT_TYPE_TOP,
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Time:  ts,
+			},
+		},
+		Subtree: &pb.Subtree{FunctionName: child.Meta.Function.Name},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err = api.Query(ctx, &pb.QueryRequest{
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Time:  ts,
+			},
+		},
+		PercentOnly: true,
+	})
+	require.NoError(t, err)
+	percent := res.Report.(*pb.QueryResponse_Flamegraph).Flamegraph
+	require.Equal(t, BasisPointsUnit, percent.Unit)
+	require.Equal(t, int64(100*100), percent.Total)
+	require.InDelta(t, child.Cumulative*100*100/fg.Total, percent.Root.Children[0].Cumulative, 1)
+
+	res, err = api.Query(ctx, &pb.QueryRequest{
+		ReportType: pb.QueryRequest_REPORT_TYPE_PPROF,
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Time:  ts,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	testProf := &pprofpb.Profile{}
+	err = testProf.UnmarshalVT(MustDecompressGzip(t, res.Report.(*pb.QueryResponse_Pprof).Pprof))
+	require.NoError(t, err)
+}
+
+func TestColumnQueryAPIQueryTrace(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	)
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	p := &pprofpb.Profile{}
+	err = p.UnmarshalVT(fileContent)
+	require.NoError(t, err)
+
+	// Associate the first two samples with a trace.
+	p.StringTable = append(p.StringTable, parcacol.TraceIDLabel, "4bf92f3577b34da6a3ce929d0e0e4736")
+	traceIDKey, traceIDValue := int64(len(p.StringTable)-2), int64(len(p.StringTable)-1)
+	var traceValue int64
+	for _, s := range p.Sample[:2] {
+		s.Label = append(s.Label, &pprofpb.Label{Key: traceIDKey, Str: traceIDValue})
+		traceValue += s.Value[0]
+	}
+	// Associate the first sample with a span of the trace.
+	p.StringTable = append(p.StringTable, parcacol.SpanIDLabel, "00f067aa0ba902b7")
+	spanIDKey, spanIDValue := int64(len(p.StringTable)-2), int64(len(p.StringTable)-1)
+	p.Sample[0].Label = append(p.Sample[0].Label, &pprofpb.Label{Key: spanIDKey, Str: spanIDValue})
+	spanValue := p.Sample[0].Value[0]
+
+	metastore := metastore.NewInProcessClient(m)
+	normalizer := parcacol.NewNormalizer(metastore)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	err = ingester.Ingest(ctx, labels.Labels{{
+		Name:  "__name__",
+		Value: "memory",
+	}, {
+		Name:  "job",
+		Value: "default",
+	}}, p, false)
+	require.NoError(t, err)
+
+	// Associate a whole profile with a trace.
+	var profileValue int64
+	for _, s := range p.Sample {
+		profileValue += s.Value[0]
+	}
+	err = ingester.Ingest(ctx, labels.Labels{{
+		Name:  "__name__",
+		Value: "memory",
+	}, {
+		Name:  "job",
+		Value: "default",
+	}, {
+		Name:  parcacol.TraceIDLabel,
+		Value: "a3ce929d0e0e47364bf92f3577b34da6",
+	}}, p, false)
+	require.NoError(t, err)
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			metastore,
+		),
+	)
+
+	traceRequest := func(traceID, spanID string) *pb.QueryRequest {
+		return &pb.QueryRequest{
+			Mode:       pb.QueryRequest_MODE_TRACE,
+			ReportType: pb.QueryRequest_REPORT_TYPE_PPROF,
+			Options: &pb.QueryRequest_Trace{
+				Trace: &pb.TraceProfile{
+					Query:   `memory:alloc_objects:count:space:bytes{job="default"}`,
+					TraceId: traceID,
+					SpanId:  spanID,
+					Start:   timestamppb.New(timestamp.Time(0)),
+					End:     timestamppb.New(timestamp.Time(math.MaxInt64)),
+				},
+			},
+		}
+	}
+
+	traceTotal := func(traceID, spanID string) (int, int64) {
+		res, err := api.Query(ctx, traceRequest(traceID, spanID))
+		require.NoError(t, err)
+
+		testProf := &pprofpb.Profile{}
+		err = testProf.UnmarshalVT(MustDecompressGzip(t, res.Report.(*pb.QueryResponse_Pprof).Pprof))
+		require.NoError(t, err)
+
+		var total int64
+		for _, s := range testProf.Sample {
+			total += s.Value[0]
+		}
+		return len(testProf.Sample), total
+	}
+
+	samples, total := traceTotal("4bf92f3577b34da6a3ce929d0e0e4736", "")
+	require.NotZero(t, samples)
+	require.LessOrEqual(t, samples, 2)
+	require.Equal(t, traceValue, total)
+
+	samples, total = traceTotal("4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	require.Equal(t, 1, samples)
+	require.Equal(t, spanValue, total)
+
+	_, total = traceTotal("a3ce929d0e0e47364bf92f3577b34da6", "")
+	require.Equal(t, profileValue, total)
+
+	_, err = api.Query(ctx, traceRequest("00000000000000000000000000000000", ""))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = api.Query(ctx, traceRequest("4bf92f3577b34da6a3ce929d0e0e4736", "0000000000000000"))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = api.Query(ctx, traceRequest("", ""))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestColumnQueryAPIQueryFgprof(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	)
+
+	fileContent := MustReadAllGzip(t, "testdata/fgprof.pb.gz")
+	p := &pprofpb.Profile{}
+	err = p.UnmarshalVT(fileContent)
+	require.NoError(t, err)
+	p.TimeNanos = time.Now().UnixNano()
+
+	metastore := metastore.NewInProcessClient(m)
+	normalizer := parcacol.NewNormalizer(metastore)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+	err = ingester.Ingest(ctx, labels.Labels{{
+		Name:  "__name__",
+		Value: "fgprof",
+	}, {
+		Name:  "job",
+		Value: "default",
+	}}, p, false)
+	require.NoError(t, err)
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			metastore,
+		),
+	)
+	res, err := api.QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: `fgprof:samples:count::`,
+		Start: timestamppb.New(timestamp.Time(0)),
+		End:   timestamppb.New(timestamp.Time(9223372036854775807)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Series))
+	require.Equal(t, 1, len(res.Series[0].Labelset.Labels))
+	require.Equal(t, 1, len(res.Series[0].Samples))
+}
+
+func TestColumnQueryAPIQueryMergeSpan(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(fileContent))
+
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	// The second sample arrived an hour after the first.
+	for _, ts := range []time.Time{time.Unix(60, 0), time.Unix(60*60+60, 0)} {
+		p.TimeNanos = ts.UnixNano()
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: "default",
+		}}, p, false)
+		require.NoError(t, err)
+	}
+
+	newAPI := func(opts ...Option) *ColumnQueryAPI {
+		return NewColumnQueryAPI(
+			logger,
+			tracer,
+			getShareServerConn(t),
+			parcacol.NewQuerier(
+				tracer,
+				query.NewEngine(
+					memory.DefaultAllocator,
+					colDB.TableProvider(),
+				),
+				"stacktraces",
+				m,
+			),
+			opts...,
+		)
+	}
+	req := &pb.QueryRequest{
+		Mode: pb.QueryRequest_MODE_MERGE,
+		Options: &pb.QueryRequest_Merge{
+			Merge: &pb.MergeProfile{
+				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+				Start: timestamppb.New(time.Unix(0, 0)),
+				End:   timestamppb.New(time.Unix(2*60*60, 0)),
+			},
+		},
+	}
+
+	res, err := newAPI().Query(ctx, req)
+	require.NoError(t, err)
+	require.Empty(t, res.Warnings)
+
+	res, err = newAPI(WithMaxMergeSpan(2*time.Hour, MergeSpanExceededWarn)).Query(ctx, req)
+	require.NoError(t, err)
+	require.Empty(t, res.Warnings)
+
+	res, err = newAPI(WithMaxMergeSpan(time.Minute, MergeSpanExceededWarn)).Query(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, res.GetFlamegraph())
+	require.Equal(t, []string{
+		`merged samples of memory:alloc_objects:count:space:bytes{job="default"} span 1h0m0s, more than the maximum merge span of 1m0s`,
+	}, res.Warnings)
+
+	_, err = newAPI(WithMaxMergeSpan(time.Minute, MergeSpanExceededError)).Query(ctx, req)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestColumnQueryAPIQueryMergeAggregation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
 		t,
 		logger,
 		reg,
 		tracer,
+	))
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	// The profiles of job a overlap with the one of job b at 120s, so the
+	// values at 60s, 120s and 180s are 1, 3+1 and 2 times the ones of the
+	// profile.
+	for _, w := range []struct {
+		job    string
+		ts     int64
+		factor int64
+	}{
+		{job: "a", ts: 60, factor: 1},
+		{job: "a", ts: 120, factor: 3},
+		{job: "b", ts: 120, factor: 1},
+		{job: "a", ts: 180, factor: 2},
+	} {
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(fileContent))
+		p.TimeNanos = time.Unix(w.ts, 0).UnixNano()
+		for _, s := range p.Sample {
+			for i := range s.Value {
+				s.Value[i] *= w.factor
+			}
+		}
+		require.NoError(t, ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: w.job,
+		}}, p, false))
+	}
+
+	total := func(aggregations map[string]parcacol.Aggregation, selector string) int64 {
+		api := NewColumnQueryAPI(
+			logger,
+			tracer,
+			getShareServerConn(t),
+			parcacol.NewQuerier(
+				tracer,
+				query.NewEngine(
+					memory.DefaultAllocator,
+					colDB.TableProvider(),
+				),
+				"stacktraces",
+				m,
+				parcacol.WithSampleTypeAggregations(aggregations),
+			),
+		)
+		res, err := api.Query(ctx, &pb.QueryRequest{
+			Mode: pb.QueryRequest_MODE_MERGE,
+			Options: &pb.QueryRequest_Merge{
+				Merge: &pb.MergeProfile{
+					Query: selector,
+					Start: timestamppb.New(time.Unix(0, 0)),
+					End:   timestamppb.New(time.Unix(240, 0)),
+				},
+			},
+		})
+		require.NoError(t, err)
+		return res.GetFlamegraph().Total
+	}
+
+	const (
+		inuse = "memory:inuse_space:bytes:space:bytes"
+		alloc = "memory:alloc_space:bytes:space:bytes"
+	)
+	aggregations := map[string]parcacol.Aggregation{
+		"inuse_space": parcacol.AggregationMax,
+	}
+
+	// Values are summed by default.
+	sum := total(nil, inuse)
+	require.Greater(t, sum, int64(0))
+	require.Zero(t, sum%7)
+	unit := sum / 7
+	// Other sample types are still summed.
+	require.Equal(t, total(nil, alloc), total(aggregations, alloc))
+
+	require.Equal(t, 4*unit, total(aggregations, inuse))
+	aggregations["inuse_space"] = parcacol.AggregationLast
+	require.Equal(t, 2*unit, total(aggregations, inuse))
+	aggregations["inuse_space"] = parcacol.AggregationSum
+	require.Equal(t, 7*unit, total(aggregations, inuse))
+}
+
+func TestColumnQueryAPIQueryUnion(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
 	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
 
 	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
 	p := &pprofpb.Profile{}
-	err = p.UnmarshalVT(fileContent)
-	require.NoError(t, err)
+	require.NoError(t, p.UnmarshalVT(fileContent))
 
-	metastore := metastore.NewInProcessClient(m)
-	normalizer := parcacol.NewNormalizer(metastore)
+	normalizer := parcacol.NewNormalizer(m)
 	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
 
-	err = ingester.Ingest(ctx, labels.Labels{{
-		Name:  "__name__",
-		Value: "memory",
-	}, {
-		Name:  "job",
-		Value: "default",
-	}}, p, false)
-	require.NoError(t, err)
+	p.TimeNanos = time.Unix(60, 0).UnixNano()
+	for _, job := range []string{"a", "b"} {
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: job,
+		}}, p, false)
+		require.NoError(t, err)
+	}
 
 	api := NewColumnQueryAPI(
 		logger,
@@ -275,38 +1208,67 @@ func TestColumnQueryAPIQuerySingle(t *testing.T) {
 				colDB.TableProvider(),
 			),
 			"stacktraces",
-			metastore,
+			m,
 		),
 	)
-	ts := timestamppb.New(timestamp.Time(p.TimeNanos / time.Millisecond.Nanoseconds()))
+
+	union := func(queries ...string) *pb.QueryRequest_Union {
+		u := &pb.UnionProfile{}
+		for _, q := range queries {
+			u.Merges = append(u.Merges, &pb.MergeProfile{
+				Query: q,
+				Start: timestamppb.New(time.Unix(0, 0)),
+				End:   timestamppb.New(time.Unix(120, 0)),
+			})
+		}
+		return &pb.QueryRequest_Union{Union: u}
+	}
+
 	res, err := api.Query(ctx, &pb.QueryRequest{
-		Options: &pb.QueryRequest_Single{
-			Single: &pb.SingleProfile{
-				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
-				Time:  ts,
-			},
-		},
+		Mode: pb.QueryRequest_MODE_UNION,
+		Options: union(
+			`memory:alloc_objects:count:space:bytes{job="a"}`,
+			`memory:alloc_objects:count:space:bytes{job="b"}`,
+		),
 	})
 	require.NoError(t, err)
-	require.Equal(t, int32(33), res.Report.(*pb.QueryResponse_Flamegraph).Flamegraph.Height)
 
-	res, err = api.Query(ctx, &pb.QueryRequest{
-		ReportType: pb.QueryRequest_REPORT_TYPE_PPROF,
-		Options: &pb.QueryRequest_Single{
-			Single: &pb.SingleProfile{
-				Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
-				Time:  ts,
-			},
-		},
+	fg := res.GetFlamegraph()
+	merged, err := api.Query(ctx, &pb.QueryRequest{
+		Mode: pb.QueryRequest_MODE_MERGE,
+		Options: &pb.QueryRequest_Merge{Merge: &pb.MergeProfile{
+			Query: `memory:alloc_objects:count:space:bytes`,
+			Start: timestamppb.New(time.Unix(0, 0)),
+			End:   timestamppb.New(time.Unix(120, 0)),
+		}},
 	})
 	require.NoError(t, err)
+	require.Equal(t, merged.GetFlamegraph().Total, fg.Total)
+	require.Equal(t, len(merged.GetFlamegraph().Root.Children), len(fg.Root.Children))
+	for _, n := range fg.Root.Children {
+		require.Equal(t, []int32{0, 1}, n.Sources)
+	}
 
-	testProf := &pprofpb.Profile{}
-	err = testProf.UnmarshalVT(MustDecompressGzip(t, res.Report.(*pb.QueryResponse_Pprof).Pprof))
+	res, err = api.Query(ctx, &pb.QueryRequest{
+		Mode:        pb.QueryRequest_MODE_UNION,
+		PercentOnly: true,
+		Options: union(
+			`memory:alloc_objects:count:space:bytes{job="a"}`,
+			`memory:alloc_objects:count:space:bytes{job="b"}`,
+		),
+	})
 	require.NoError(t, err)
+	require.Equal(t, int64(10000), res.GetFlamegraph().Total)
+	require.Equal(t, BasisPointsUnit, res.GetFlamegraph().Unit)
+
+	_, err = api.Query(ctx, &pb.QueryRequest{
+		Mode:    pb.QueryRequest_MODE_UNION,
+		Options: union(`memory:alloc_objects:count:space:bytes{job="a"}`),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
 }
 
-func TestColumnQueryAPIQueryFgprof(t *testing.T) {
+func TestColumnQueryAPIQueryPrimarySampleType(t *testing.T) {
 	t.Parallel()
 
 	ctx := context.Background()
@@ -329,30 +1291,37 @@ func TestColumnQueryAPIQueryFgprof(t *testing.T) {
 		columnstore.NewTableConfig(schema),
 	)
 	require.NoError(t, err)
-	m := metastoretest.NewTestMetastore(
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
 		t,
 		logger,
 		reg,
 		tracer,
-	)
-
-	fileContent := MustReadAllGzip(t, "testdata/fgprof.pb.gz")
-	p := &pprofpb.Profile{}
-	err = p.UnmarshalVT(fileContent)
-	require.NoError(t, err)
-	p.TimeNanos = time.Now().UnixNano()
+	))
 
-	metastore := metastore.NewInProcessClient(m)
-	normalizer := parcacol.NewNormalizer(metastore)
+	normalizer := parcacol.NewNormalizer(m)
 	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
-	err = ingester.Ingest(ctx, labels.Labels{{
-		Name:  "__name__",
-		Value: "fgprof",
-	}, {
-		Name:  "job",
-		Value: "default",
-	}}, p, false)
-	require.NoError(t, err)
+
+	// The profile of job a has no default sample type, so its primary is
+	// the last, inuse_space. The profile of job b declares alloc_objects.
+	for _, job := range []string{"a", "b"} {
+		fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+		p := &pprofpb.Profile{}
+		require.NoError(t, p.UnmarshalVT(fileContent))
+		p.TimeNanos = time.Unix(60, 0).UnixNano()
+		p.DefaultSampleType = 0
+		if job == "b" {
+			p.DefaultSampleType = p.SampleType[0].Type
+		}
+
+		err = ingester.Ingest(ctx, labels.Labels{{
+			Name:  "__name__",
+			Value: "memory",
+		}, {
+			Name:  "job",
+			Value: job,
+		}}, p, false)
+		require.NoError(t, err)
+	}
 
 	api := NewColumnQueryAPI(
 		logger,
@@ -365,18 +1334,57 @@ func TestColumnQueryAPIQueryFgprof(t *testing.T) {
 				colDB.TableProvider(),
 			),
 			"stacktraces",
-			metastore,
+			m,
 		),
 	)
-	res, err := api.QueryRange(ctx, &pb.QueryRangeRequest{
-		Query: `fgprof:samples:count::`,
-		Start: timestamppb.New(timestamp.Time(0)),
-		End:   timestamppb.New(timestamp.Time(9223372036854775807)),
-	})
-	require.NoError(t, err)
-	require.Equal(t, 1, len(res.Series))
-	require.Equal(t, 1, len(res.Series[0].Labelset.Labels))
-	require.Equal(t, 1, len(res.Series[0].Samples))
+
+	merge := func(query string, primary bool) (*pb.QueryResponse, error) {
+		return api.Query(ctx, &pb.QueryRequest{
+			Mode: pb.QueryRequest_MODE_MERGE,
+			Options: &pb.QueryRequest_Merge{Merge: &pb.MergeProfile{
+				Query: query,
+				Start: timestamppb.New(time.Unix(0, 0)),
+				End:   timestamppb.New(time.Unix(120, 0)),
+			}},
+			PrimarySampleType: primary,
+		})
+	}
+
+	for _, tc := range []struct {
+		job     string
+		primary string
+	}{
+		{job: "a", primary: `memory:inuse_space:bytes:space:bytes{job="a"}`},
+		{job: "b", primary: `memory:alloc_objects:count:space:bytes{job="b"}`},
+	} {
+		expected, err := merge(tc.primary, false)
+		require.NoError(t, err)
+		require.NotZero(t, expected.GetFlamegraph().Total)
+
+		res, err := merge(`memory:alloc_space:bytes:space:bytes{job="`+tc.job+`"}`, true)
+		require.NoError(t, err)
+		require.Equal(t, expected.GetFlamegraph().Total, res.GetFlamegraph().Total)
+		require.Equal(t, expected.GetFlamegraph().Unit, res.GetFlamegraph().Unit)
+
+		expectedRange, err := api.QueryRange(ctx, &pb.QueryRangeRequest{
+			Query: tc.primary,
+			Start: timestamppb.New(time.Unix(0, 0)),
+			End:   timestamppb.New(time.Unix(120, 0)),
+		})
+		require.NoError(t, err)
+		resRange, err := api.QueryRange(ctx, &pb.QueryRangeRequest{
+			Query:             `memory:alloc_space:bytes:space:bytes{job="` + tc.job + `"}`,
+			Start:             timestamppb.New(time.Unix(0, 0)),
+			End:               timestamppb.New(time.Unix(120, 0)),
+			PrimarySampleType: true,
+		})
+		require.NoError(t, err)
+		require.Equal(t, expectedRange.Series[0].Samples[0].Value, resRange.Series[0].Samples[0].Value)
+	}
+
+	// The values of different primary sample types can't be merged.
+	_, err = merge(`memory:alloc_space:bytes:space:bytes`, true)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
 }
 
 func TestColumnQueryAPIQueryDiff(t *testing.T) {
@@ -565,12 +1573,14 @@ func TestColumnQueryAPIQueryDiff(t *testing.T) {
 	require.Equal(t, 1, len(fg.Root.Children))
 	require.Equal(t, int64(2), fg.Root.Children[0].Cumulative)
 	require.Equal(t, int64(1), fg.Root.Children[0].Diff)
+	require.Nil(t, res.FunctionChanges)
 
 	res, err = api.Query(ctx, &pb.QueryRequest{
 		Mode:       pb.QueryRequest_MODE_DIFF,
 		ReportType: *pb.QueryRequest_REPORT_TYPE_TOP.Enum(),
 		Options: &pb.QueryRequest_Diff{
 			Diff: &pb.DiffProfile{
+				FunctionChanges: true,
 				A: &pb.ProfileDiffSelection{
 					Mode: pb.ProfileDiffSelection_MODE_SINGLE_UNSPECIFIED,
 					Options: &pb.ProfileDiffSelection_Single{
@@ -599,6 +1609,12 @@ func TestColumnQueryAPIQueryDiff(t *testing.T) {
 	require.Equal(t, int64(2), topList[0].Cumulative)
 	require.Equal(t, int64(1), topList[0].Diff)
 
+	// Both profiles contain testFunc, so nothing appeared or disappeared.
+	require.NotNil(t, res.FunctionChanges)
+	require.Empty(t, res.FunctionChanges.Added)
+	require.Empty(t, res.FunctionChanges.Removed)
+	require.Equal(t, "count", res.FunctionChanges.Unit)
+
 	res, err = api.Query(ctx, &pb.QueryRequest{
 		Mode:       pb.QueryRequest_MODE_DIFF,
 		ReportType: *pb.QueryRequest_REPORT_TYPE_PPROF.Enum(),
@@ -855,3 +1871,114 @@ func TestColumnQueryAPILabelValues(t *testing.T) {
 		"default",
 	}, res.LabelValues)
 }
+
+func TestColumnQueryAPILabelValuesMatch(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	)
+
+	fileContent := MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(fileContent))
+
+	metastore := metastore.NewInProcessClient(m)
+	normalizer := parcacol.NewNormalizer(metastore)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+	for _, ls := range []labels.Labels{
+		{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}},
+		{{Name: "__name__", Value: "memory"}, {Name: "instance", Value: "a"}, {Name: "job", Value: "other"}},
+	} {
+		require.NoError(t, ingester.Ingest(ctx, ls, p, false))
+	}
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			metastore,
+		),
+	)
+
+	ts := timestamp.Time(p.TimeNanos / time.Millisecond.Nanoseconds())
+	tests := map[string]struct {
+		req    *pb.ValuesRequest
+		values []string
+	}{
+		"label matcher": {
+			req:    &pb.ValuesRequest{LabelName: "job", Match: []string{`{instance="a"}`}},
+			values: []string{"other"},
+		},
+		"profile type": {
+			req:    &pb.ValuesRequest{LabelName: "job", Match: []string{`memory:alloc_objects:count:space:bytes{job="default"}`}},
+			values: []string{"default"},
+		},
+		"any selector": {
+			req:    &pb.ValuesRequest{LabelName: "job", Match: []string{`{job="default"}`, `{instance="a"}`}},
+			values: []string{"default", "other"},
+		},
+		"missing label": {
+			req:    &pb.ValuesRequest{LabelName: "instance"},
+			values: []string{"a"},
+		},
+		"time range": {
+			req: &pb.ValuesRequest{
+				LabelName: "job",
+				Start:     timestamppb.New(ts.Add(time.Minute)),
+			},
+			values: []string{},
+		},
+		"open end": {
+			req: &pb.ValuesRequest{
+				LabelName: "job",
+				Start:     timestamppb.New(ts.Add(-time.Minute)),
+			},
+			values: []string{"default", "other"},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := api.Values(ctx, test.req)
+			require.NoError(t, err)
+			require.Equal(t, test.values, res.LabelValues)
+		})
+	}
+
+	_, err = api.Values(ctx, &pb.ValuesRequest{LabelName: "job", Match: []string{`{job=}`}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cpuBudget aborts queries consuming more CPU time than the budget. Go
+// doesn't account CPU time per goroutine, so a query runs on an OS thread
+// locked to it, which runs nothing else meanwhile. The CPU time of the thread,
+// sampled every interval, is then the CPU time of the query alone, rather than
+// of ingestion or other queries. Queries are evaluated in the goroutine
+// calling the querier, including the scans and merges of the column store.
+type cpuBudget struct {
+	budget   time.Duration
+	interval time.Duration
+	// threadCPUTime returns the CPU time the thread consumed.
+	threadCPUTime func(tid int) (time.Duration, error)
+}
+
+// run runs the query on a thread of its own, cancelling its context once the
+// thread consumed more CPU time than the budget. Queries aborted for their
+// budget fail with ResourceExhausted.
+func (b *cpuBudget) run(ctx context.Context, query func(context.Context) error) error {
+	if b == nil {
+		return query(ctx)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	tid := threadID()
+	start, err := b.threadCPUTime(tid)
+	if err != nil {
+		// The budget can't be enforced on this platform.
+		return query(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var over int32
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			now, err := b.threadCPUTime(tid)
+			if err != nil {
+				return
+			}
+			if now-start > b.budget {
+				atomic.StoreInt32(&over, 1)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err = query(ctx)
+	close(done)
+	<-stopped
+	if atomic.LoadInt32(&over) == 1 {
+		return status.Errorf(codes.ResourceExhausted, "query consumed more than the CPU time budget of %s", b.budget)
+	}
+	return err
+}
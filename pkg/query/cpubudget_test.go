@@ -0,0 +1,149 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// spin burns CPU time until the context is done.
+func spin(ctx context.Context) {
+	x := uint64(1)
+	for ctx.Err() == nil {
+		for i := 0; i < 1000; i++ {
+			x = x*6364136223846793005 + 1442695040888963407
+		}
+	}
+	_ = x
+}
+
+// spinningQuerier burns CPU time in merges and range queries until they are
+// cancelled.
+type spinningQuerier struct {
+	Querier
+}
+
+func (q spinningQuerier) QueryMerge(ctx context.Context, query string, start, end time.Time) (*profile.Profile, error) {
+	spin(ctx)
+	return nil, ctx.Err()
+}
+
+func (q spinningQuerier) QueryRange(ctx context.Context, query string, startTime, endTime time.Time, limit uint32) ([]*pb.MetricsSeries, error) {
+	spin(ctx)
+	return nil, ctx.Err()
+}
+
+// idleQuerier merges without consuming CPU time.
+type idleQuerier struct {
+	Querier
+	d time.Duration
+}
+
+func (q idleQuerier) QueryMerge(ctx context.Context, query string, start, end time.Time) (*profile.Profile, error) {
+	select {
+	case <-time.After(q.d):
+		return &profile.Profile{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var mergeRequest = &pb.QueryRequest{
+	Mode: pb.QueryRequest_MODE_MERGE,
+	Options: &pb.QueryRequest_Merge{
+		Merge: &pb.MergeProfile{
+			Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+			Start: timestamppb.New(time.Unix(0, 0)),
+			End:   timestamppb.New(time.Unix(60, 0)),
+		},
+	},
+}
+
+func TestColumnQueryAPIQueryCPUBudget(t *testing.T) {
+	t.Parallel()
+
+	if _, err := threadCPUTime(threadID()); err != nil {
+		t.Skip(err)
+	}
+
+	const budget = 100 * time.Millisecond
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		spinningQuerier{},
+		WithCPUBudget(budget, 10*time.Millisecond),
+	)
+
+	start := time.Now()
+	_, err := api.Query(context.Background(), mergeRequest)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// The query consumed the budget on a thread of its own.
+	require.GreaterOrEqual(t, time.Since(start), budget)
+
+	_, err = api.QueryRange(context.Background(), &pb.QueryRangeRequest{
+		Query: `memory:alloc_objects:count:space:bytes{job="default"}`,
+		Start: timestamppb.New(time.Unix(0, 0)),
+		End:   timestamppb.New(time.Unix(60, 0)),
+	})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestCPUBudgetChargesQueryOnly(t *testing.T) {
+	t.Parallel()
+
+	if _, err := threadCPUTime(threadID()); err != nil {
+		t.Skip(err)
+	}
+
+	// Other goroutines burn CPU time while the query runs, like ingestion
+	// and other queries would.
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spin(ctx)
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		idleQuerier{d: 300 * time.Millisecond},
+		WithCPUBudget(50*time.Millisecond, 10*time.Millisecond),
+	)
+	_, err := api.Query(context.Background(), mergeRequest)
+	require.NoError(t, err)
+}
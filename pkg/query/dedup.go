@@ -0,0 +1,157 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// deduplicateReplicas merges the series only differing by the replica label,
+// written by redundant agents profiling the same process, into one series
+// without the label. Rather than adding the samples of the replicas up, it
+// takes the samples of the first replica by label value, and only falls back
+// to the samples of the others across the gaps of that replica, see
+// mergeReplicas. Replicas don't need to profile at the same time.
+func deduplicateReplicas(series []*pb.MetricsSeries, replicaLabel string) []*pb.MetricsSeries {
+	type group struct {
+		labels   []*profilestorepb.Label
+		replicas []*pb.MetricsSeries
+		names    []string
+	}
+	var (
+		order  []string
+		groups = map[string]*group{}
+	)
+	for _, s := range series {
+		var (
+			ls      []*profilestorepb.Label
+			replica string
+			key     strings.Builder
+		)
+		for _, l := range s.Labelset.GetLabels() {
+			if l.Name == replicaLabel {
+				replica = l.Value
+				continue
+			}
+			ls = append(ls, l)
+			key.WriteString(l.Name)
+			key.WriteByte(0)
+			key.WriteString(l.Value)
+			key.WriteByte(0)
+		}
+
+		g, ok := groups[key.String()]
+		if !ok {
+			g = &group{labels: ls}
+			groups[key.String()] = g
+			order = append(order, key.String())
+		}
+		g.replicas = append(g.replicas, s)
+		g.names = append(g.names, replica)
+	}
+
+	res := make([]*pb.MetricsSeries, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		sort.Sort(replicasByName{g.replicas, g.names})
+
+		res = append(res, &pb.MetricsSeries{
+			Labelset:   &profilestorepb.LabelSet{Labels: g.labels},
+			Samples:    mergeReplicas(g.replicas),
+			PeriodType: g.replicas[0].PeriodType,
+			SampleType: g.replicas[0].SampleType,
+		})
+	}
+	return res
+}
+
+// mergeReplicas merges the samples of the replicas, given in the order they
+// are preferred in, into samples of a single series. The interval of the
+// replicas is the median time between their consecutive samples. Samples of
+// other replicas up to half an interval after the last sample taken are
+// duplicates of it, and are skipped. Otherwise the next sample is taken from
+// the first replica that has one within one and a half intervals, that is
+// without a gap, or from whichever replica has the earliest if all of them
+// have a gap.
+func mergeReplicas(replicas []*pb.MetricsSeries) []*pb.MetricsSample {
+	samples := make([][]*pb.MetricsSample, len(replicas))
+	var intervals []time.Duration
+	for i, r := range replicas {
+		samples[i] = append([]*pb.MetricsSample{}, r.Samples...)
+		sort.SliceStable(samples[i], func(a, b int) bool {
+			return samples[i][a].Timestamp.AsTime().Before(samples[i][b].Timestamp.AsTime())
+		})
+		for j := 1; j < len(samples[i]); j++ {
+			intervals = append(intervals, samples[i][j].Timestamp.AsTime().Sub(samples[i][j-1].Timestamp.AsTime()))
+		}
+	}
+	var interval time.Duration
+	if len(intervals) > 0 {
+		sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+		interval = intervals[len(intervals)/2]
+	}
+
+	var (
+		res  []*pb.MetricsSample
+		last time.Time
+	)
+	for {
+		// Skip the duplicates of the last sample taken.
+		next := -1
+		for i := range samples {
+			for len(samples[i]) > 0 && len(res) > 0 && !samples[i][0].Timestamp.AsTime().After(last.Add(interval/2)) {
+				samples[i] = samples[i][1:]
+			}
+			if len(samples[i]) == 0 {
+				continue
+			}
+			if next == -1 || samples[i][0].Timestamp.AsTime().Before(samples[next][0].Timestamp.AsTime()) {
+				next = i
+			}
+		}
+		if next == -1 {
+			return res
+		}
+		if len(res) > 0 {
+			for i := range samples {
+				if len(samples[i]) > 0 && !samples[i][0].Timestamp.AsTime().After(last.Add(interval*3/2)) {
+					next = i
+					break
+				}
+			}
+		}
+
+		res = append(res, samples[next][0])
+		last = samples[next][0].Timestamp.AsTime()
+		samples[next] = samples[next][1:]
+	}
+}
+
+// replicasByName sorts the series of replicas by their replica label value.
+type replicasByName struct {
+	series []*pb.MetricsSeries
+	names  []string
+}
+
+func (r replicasByName) Len() int           { return len(r.series) }
+func (r replicasByName) Less(i, j int) bool { return r.names[i] < r.names[j] }
+func (r replicasByName) Swap(i, j int) {
+	r.series[i], r.series[j] = r.series[j], r.series[i]
+	r.names[i], r.names[j] = r.names[j], r.names[i]
+}
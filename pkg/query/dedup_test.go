@@ -0,0 +1,149 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// seriesQuerier answers range queries with fixed series.
+type seriesQuerier struct {
+	Querier
+	series func() []*pb.MetricsSeries
+}
+
+func (q seriesQuerier) QueryRange(ctx context.Context, query string, startTime, endTime time.Time, limit uint32) ([]*pb.MetricsSeries, error) {
+	return q.series(), nil
+}
+
+func testSeries(samples map[time.Duration]int64, ls ...string) *pb.MetricsSeries {
+	s := &pb.MetricsSeries{Labelset: &profilestorepb.LabelSet{}}
+	for i := 0; i < len(ls); i += 2 {
+		s.Labelset.Labels = append(s.Labelset.Labels, &profilestorepb.Label{Name: ls[i], Value: ls[i+1]})
+	}
+	for t, v := range samples {
+		s.Samples = append(s.Samples, &pb.MetricsSample{Timestamp: timestamppb.New(time.Unix(0, 0).Add(t)), Value: v})
+	}
+	return s
+}
+
+func seriesValues(s *pb.MetricsSeries) map[time.Duration]int64 {
+	values := map[time.Duration]int64{}
+	for i, sample := range s.Samples {
+		if i > 0 && !s.Samples[i-1].Timestamp.AsTime().Before(sample.Timestamp.AsTime()) {
+			panic("samples are not sorted")
+		}
+		values[sample.Timestamp.AsTime().Sub(time.Unix(0, 0))] = sample.Value
+	}
+	return values
+}
+
+func TestColumnQueryAPIQueryRangeDeduplicateReplicas(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		seriesQuerier{series: func() []*pb.MetricsSeries {
+			return []*pb.MetricsSeries{
+				// Replica a misses the sample of the third bucket.
+				testSeries(map[time.Duration]int64{time.Second: 1, 11 * time.Second: 2, 31 * time.Second: 4}, "job", "default", "replica", "a"),
+				testSeries(map[time.Duration]int64{3 * time.Second: 3, 13 * time.Second: 4, 23 * time.Second: 5, 33 * time.Second: 6}, "job", "default", "replica", "b"),
+				testSeries(map[time.Duration]int64{time.Second: 7}, "job", "other", "replica", "a"),
+			}
+		}},
+		WithReplicaLabel("replica"),
+	)
+
+	res, err := api.QueryRange(context.Background(), &pb.QueryRangeRequest{
+		Query: `memory:alloc_objects:count:space:bytes{job=~".+"}`,
+		Start: timestamppb.New(time.Unix(0, 0)),
+		End:   timestamppb.New(time.Unix(40, 0)),
+		Step:  durationpb.New(10 * time.Second),
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Series, 2)
+
+	require.Equal(t, []*profilestorepb.Label{{Name: "job", Value: "default"}}, res.Series[0].Labelset.Labels)
+	require.Equal(t, map[time.Duration]int64{
+		0:                1,
+		10 * time.Second: 2,
+		20 * time.Second: 5,
+		30 * time.Second: 4,
+	}, seriesValues(res.Series[0]))
+
+	require.Equal(t, []*profilestorepb.Label{{Name: "job", Value: "other"}}, res.Series[1].Labelset.Labels)
+	require.Equal(t, map[time.Duration]int64{0: 7}, seriesValues(res.Series[1]))
+}
+
+func TestDeduplicateReplicas(t *testing.T) {
+	t.Parallel()
+
+	// Replicas are preferred by label value, regardless of their order.
+	res := deduplicateReplicas([]*pb.MetricsSeries{
+		testSeries(map[time.Duration]int64{time.Second: 10, 2 * time.Second: 20, 3 * time.Second: 30}, "job", "default", "replica", "b"),
+		testSeries(map[time.Duration]int64{time.Second: 1, 3 * time.Second: 3, 4 * time.Second: 4}, "job", "default", "replica", "a"),
+		testSeries(map[time.Duration]int64{time.Second: 100}, "job", "default"),
+	}, "replica")
+	require.Len(t, res, 1)
+	require.Equal(t, []*profilestorepb.Label{{Name: "job", Value: "default"}}, res[0].Labelset.Labels)
+	require.Equal(t, map[time.Duration]int64{
+		time.Second:     100,
+		2 * time.Second: 20,
+		3 * time.Second: 3,
+		4 * time.Second: 4,
+	}, seriesValues(res[0]))
+}
+
+func TestDeduplicateReplicasWithoutStep(t *testing.T) {
+	t.Parallel()
+
+	// The replicas profile at different times, replica a misses two
+	// samples.
+	a, b := map[time.Duration]int64{}, map[time.Duration]int64{}
+	for i := time.Duration(0); i < 8; i++ {
+		if i != 5 && i != 6 {
+			a[i*10*time.Second] = 1
+		}
+		b[i*10*time.Second+5*time.Second] = 2
+	}
+	res := deduplicateReplicas([]*pb.MetricsSeries{
+		testSeries(a, "job", "default", "replica", "a"),
+		testSeries(b, "job", "default", "replica", "b"),
+	}, "replica")
+	require.Len(t, res, 1)
+	// The samples are taken from replica a, and from b only across its gap,
+	// rather than interleaving both.
+	require.Equal(t, map[time.Duration]int64{
+		0:                1,
+		10 * time.Second: 1,
+		20 * time.Second: 1,
+		30 * time.Second: 1,
+		40 * time.Second: 1,
+		55 * time.Second: 2,
+		70 * time.Second: 1,
+	}, seriesValues(res[0]))
+}
@@ -145,6 +145,7 @@ func aggregateByFunction(fg *querypb.Flamegraph) *querypb.Flamegraph {
 				Meta:       node.Meta,
 				Cumulative: node.Cumulative,
 				Diff:       node.Diff,
+				Sources:    node.Sources,
 			}
 			mergeChildren(node, compareByName, equalsByName)
 			peekNodes := stack.Peek().nodes
@@ -195,6 +196,7 @@ func mergeChildren(node *querypb.FlamegraphNode, compare, equals func(a, b *quer
 			cumulative += next.Cumulative
 			current.Cumulative += next.Cumulative
 			current.Diff += next.Diff
+			current.Sources = mergeSources(current.Sources, next.Sources)
 			current.Children = append(current.Children, next.Children...)
 			// Delete merged child
 			node.Children = append(node.Children[:j], node.Children[j+1:]...)
@@ -0,0 +1,107 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"sort"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// GenerateFunctionChanges returns the functions that are present in compare
+// but not in base as added, and the ones present in base but not in compare
+// as removed. Functions are identified by their name, like in the top table,
+// and locations without function information are ignored.
+func GenerateFunctionChanges(ctx context.Context, base, compare *profile.Profile) *pb.FunctionChanges {
+	baseFunctions := functionValues(base)
+	compareFunctions := functionValues(compare)
+
+	changes := &pb.FunctionChanges{
+		Added:   []*pb.FunctionChange{},
+		Removed: []*pb.FunctionChange{},
+		Unit:    compare.Meta.SampleType.Unit,
+	}
+	if changes.Unit == "" {
+		changes.Unit = base.Meta.SampleType.Unit
+	}
+
+	for name, c := range compareFunctions {
+		if _, ok := baseFunctions[name]; !ok {
+			changes.Added = append(changes.Added, c)
+		}
+	}
+	for name, c := range baseFunctions {
+		if _, ok := compareFunctions[name]; !ok {
+			changes.Removed = append(changes.Removed, c)
+		}
+	}
+
+	sortFunctionChanges(changes.Added)
+	sortFunctionChanges(changes.Removed)
+
+	return changes
+}
+
+// functionValues returns the cumulative and flat values of every function of
+// the profile by name.
+func functionValues(p *profile.Profile) map[string]*pb.FunctionChange {
+	functions := map[string]*pb.FunctionChange{}
+	seen := map[string]struct{}{}
+
+	for _, s := range p.Samples {
+		// Recursive functions appear multiple times in a stack but only count
+		// once towards the cumulative value.
+		for k := range seen {
+			delete(seen, k)
+		}
+
+		for i, l := range s.Locations {
+			for j, line := range l.Lines {
+				name := line.Function.GetName()
+				if name == "" {
+					continue
+				}
+
+				c, ok := functions[name]
+				if !ok {
+					c = &pb.FunctionChange{Function: line.Function}
+					functions[name] = c
+				}
+
+				// The first line of the first location is the innermost
+				// function of the stack.
+				if i == 0 && j == 0 {
+					c.Flat += s.Value
+				}
+				if _, ok := seen[name]; !ok {
+					seen[name] = struct{}{}
+					c.Cumulative += s.Value
+				}
+			}
+		}
+	}
+
+	return functions
+}
+
+func sortFunctionChanges(changes []*pb.FunctionChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Cumulative == changes[j].Cumulative {
+			return changes[i].Function.GetName() < changes[j].Function.GetName()
+		}
+		return changes[i].Cumulative > changes[j].Cumulative
+	})
+}
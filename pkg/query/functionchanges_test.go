@@ -0,0 +1,95 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+func TestGenerateFunctionChanges(t *testing.T) {
+	t.Parallel()
+
+	loc := func(names ...string) *profile.Location {
+		l := &profile.Location{}
+		for _, n := range names {
+			l.Lines = append(l.Lines, profile.LocationLine{Function: &metastorepb.Function{Name: n}})
+		}
+		return l
+	}
+
+	var (
+		main     = loc("main")
+		parse    = loc("parse")
+		oldCache = loc("oldCache")
+		// newCache is inlined into lookup.
+		newCacheLookup = loc("newCache", "lookup")
+		walk           = loc("walk")
+		unsymbolized   = &profile.Location{Address: 0x1234}
+	)
+
+	meta := profile.Meta{SampleType: profile.ValueType{Type: "cpu", Unit: "nanoseconds"}}
+	base := &profile.Profile{
+		Meta: meta,
+		Samples: []*profile.SymbolizedSample{
+			{Locations: []*profile.Location{parse, main}, Value: 10},
+			{Locations: []*profile.Location{oldCache, main}, Value: 5},
+			{Locations: []*profile.Location{unsymbolized, main}, Value: 1},
+		},
+	}
+	compare := &profile.Profile{
+		Meta: meta,
+		Samples: []*profile.SymbolizedSample{
+			{Locations: []*profile.Location{parse, main}, Value: 8},
+			{Locations: []*profile.Location{newCacheLookup, main}, Value: 3},
+			// walk is recursive and only counts once per stack.
+			{Locations: []*profile.Location{walk, walk, main}, Value: 4},
+			{Locations: []*profile.Location{unsymbolized, main}, Value: 2},
+		},
+	}
+
+	changes := GenerateFunctionChanges(context.Background(), base, compare)
+	require.Equal(t, "nanoseconds", changes.Unit)
+
+	name := func(c []*pb.FunctionChange) []string {
+		names := make([]string, 0, len(c))
+		for _, f := range c {
+			names = append(names, f.Function.Name)
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"walk", "lookup", "newCache"}, name(changes.Added))
+	require.Equal(t, int64(4), changes.Added[0].Cumulative)
+	require.Equal(t, int64(4), changes.Added[0].Flat)
+	require.Equal(t, int64(3), changes.Added[1].Cumulative)
+	require.Equal(t, int64(0), changes.Added[1].Flat)
+	require.Equal(t, int64(3), changes.Added[2].Cumulative)
+	require.Equal(t, int64(3), changes.Added[2].Flat)
+
+	require.Equal(t, []string{"oldCache"}, name(changes.Removed))
+	require.Equal(t, int64(5), changes.Removed[0].Cumulative)
+	require.Equal(t, int64(5), changes.Removed[0].Flat)
+
+	// Diffing a profile against itself yields no changes.
+	changes = GenerateFunctionChanges(context.Background(), base, base)
+	require.Empty(t, changes.Added)
+	require.Empty(t, changes.Removed)
+}
@@ -0,0 +1,289 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// What to do when series of a live query are written faster than its client
+// receives the updated results.
+const (
+	// LiveBackpressureDrop coalesces the writes into a single update, so a
+	// slow client only misses intermediate results.
+	LiveBackpressureDrop = "drop"
+	// LiveBackpressureBuffer queues an update for each write, and ends the
+	// stream of a client falling behind by more than the buffer size.
+	LiveBackpressureBuffer = "buffer"
+)
+
+// DefaultLiveQueryWindow is the time range live queries select if they don't
+// set a window.
+const DefaultLiveQueryWindow = 15 * time.Minute
+
+// WriteNotifier notifies live queries of the series being written by the
+// tenant making them.
+type WriteNotifier struct {
+	backpressure string
+	bufferSize   int
+
+	mtx sync.Mutex
+	// subs are the subscriptions by tenant, the default tenant has the
+	// empty ID.
+	subs map[string]map[*liveSubscription]struct{}
+}
+
+// liveSubscription is a live query waiting for writes of the series it
+// selects.
+type liveSubscription struct {
+	tenant   string
+	matchers []*labels.Matcher
+
+	updates chan struct{}
+	// overflowed is closed when the client fell behind by more than the
+	// buffered updates.
+	overflowed chan struct{}
+}
+
+// NewWriteNotifier returns a notifier handling slow clients according to the
+// backpressure mode, LiveBackpressureDrop or LiveBackpressureBuffer, in which
+// up to bufferSize updates are queued per client.
+func NewWriteNotifier(backpressure string, bufferSize int) (*WriteNotifier, error) {
+	if backpressure != LiveBackpressureDrop && backpressure != LiveBackpressureBuffer {
+		return nil, fmt.Errorf("unknown live query backpressure mode %q", backpressure)
+	}
+	if backpressure == LiveBackpressureDrop || bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &WriteNotifier{
+		backpressure: backpressure,
+		bufferSize:   bufferSize,
+		subs:         map[string]map[*liveSubscription]struct{}{},
+	}, nil
+}
+
+// Written notifies the live queries of the tenant of the context selecting
+// the series of the labels that it was written to.
+func (n *WriteNotifier) Written(ctx context.Context, ls labels.Labels) {
+	id, _ := tenant.FromContext(ctx)
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	for s := range n.subs[id] {
+		if !s.matches(ls) {
+			continue
+		}
+		select {
+		case s.updates <- struct{}{}:
+		default:
+			// An update is pending already when dropping.
+			if n.backpressure == LiveBackpressureBuffer {
+				close(s.overflowed)
+				n.remove(s)
+			}
+		}
+	}
+}
+
+// subscribe subscribes the tenant of the context to the writes of the series
+// selected by the query.
+func (n *WriteNotifier) subscribe(ctx context.Context, query string) (*liveSubscription, error) {
+	matchers, err := parser.ParseMetricSelector(query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse query")
+	}
+
+	id, _ := tenant.FromContext(ctx)
+	s := &liveSubscription{
+		tenant:     id,
+		updates:    make(chan struct{}, n.bufferSize),
+		overflowed: make(chan struct{}),
+	}
+	for _, m := range matchers {
+		if m.Name == labels.MetricName {
+			// Series are named by the name of the profile type only.
+			m, err = labels.NewMatcher(m.Type, m.Name, strings.SplitN(m.Value, ":", 2)[0])
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, "failed to parse query")
+			}
+		}
+		s.matchers = append(s.matchers, m)
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	subs, ok := n.subs[id]
+	if !ok {
+		subs = map[*liveSubscription]struct{}{}
+		n.subs[id] = subs
+	}
+	subs[s] = struct{}{}
+	return s, nil
+}
+
+func (n *WriteNotifier) unsubscribe(s *liveSubscription) {
+	n.mtx.Lock()
+	n.remove(s)
+	n.mtx.Unlock()
+}
+
+// remove removes the subscription, n.mtx must be held.
+func (n *WriteNotifier) remove(s *liveSubscription) {
+	delete(n.subs[s.tenant], s)
+	if len(n.subs[s.tenant]) == 0 {
+		delete(n.subs, s.tenant)
+	}
+}
+
+func (s *liveSubscription) matches(ls labels.Labels) bool {
+	for _, m := range s.matchers {
+		if !m.Matches(ls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// LiveQueryRange is an HTTP handler streaming the result of a range query
+// as server-sent events. The result is sent once when subscribing and again
+// whenever a series selected by the query is written. The query selects the
+// samples of the window up to the time of each update, it is set by the
+// query, window and optionally step parameters.
+//
+// A stream ends when the client disconnects, or with an error event if the
+// client falls behind in buffer mode.
+func (q *ColumnQueryAPI) LiveQueryRange(w http.ResponseWriter, r *http.Request) {
+	if q.notifier == nil {
+		http.Error(w, "live queries are disabled", http.StatusNotFound)
+		return
+	}
+
+	values := r.URL.Query()
+	query := values.Get("query")
+	if query == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if err := q.checkSelector(query); err != nil {
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		return
+	}
+	window := DefaultLiveQueryWindow
+	if v := values.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid window %q", v), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	var step *durationpb.Duration
+	if v := values.Get("step"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("invalid step %q", v), http.StatusBadRequest)
+			return
+		}
+		step = durationpb.New(d)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before the first query, so no write is missed in between.
+	sub, err := q.notifier.subscribe(r.Context(), query)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		return
+	}
+	defer q.notifier.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	send := func() bool {
+		now := time.Now()
+		res, err := q.QueryRange(ctx, &pb.QueryRangeRequest{
+			Query: query,
+			Start: timestamppb.New(now.Add(-window)),
+			End:   timestamppb.New(now),
+			Step:  step,
+		})
+		if status.Code(err) == codes.NotFound {
+			res, err = &pb.QueryRangeResponse{}, nil
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				writeEvent(w, flusher, "error", status.Convert(err).Message())
+			}
+			return false
+		}
+		b, err := protojson.Marshal(res)
+		if err != nil {
+			writeEvent(w, flusher, "error", err.Error())
+			return false
+		}
+		return writeEvent(w, flusher, "update", string(b))
+	}
+
+	if !send() {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.overflowed:
+			writeEvent(w, flusher, "error", "client fell behind the updates")
+			return
+		case <-sub.updates:
+			if !send() {
+				level.Debug(q.logger).Log("msg", "live query ended", "query", query)
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a server-sent event, returning whether it succeeded.
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event, data string) bool {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return false
+	}
+	flusher.Flush()
+	return true
+}
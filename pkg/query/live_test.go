@@ -0,0 +1,249 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/go-kit/log"
+	columnstore "github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+	"github.com/parca-dev/parca/pkg/parcacol"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// readEvent reads the next server-sent event of a stream.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestLiveQueryRange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	)
+
+	metastore := metastore.NewInProcessClient(m)
+	normalizer := parcacol.NewNormalizer(metastore)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	notifier, err := NewWriteNotifier(LiveBackpressureDrop, 0)
+	require.NoError(t, err)
+
+	api := NewColumnQueryAPI(
+		logger,
+		tracer,
+		getShareServerConn(t),
+		parcacol.NewQuerier(
+			tracer,
+			query.NewEngine(
+				memory.DefaultAllocator,
+				colDB.TableProvider(),
+			),
+			"stacktraces",
+			metastore,
+		),
+		WithWriteNotifier(notifier),
+	)
+	srv := httptest.NewServer(http.HandlerFunc(api.LiveQueryRange))
+	t.Cleanup(srv.Close)
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, srv.URL+"?window=1h&query="+`memory:alloc_objects:count:space:bytes{job="default"}`, nil)
+	require.NoError(t, err)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+	event, data := readEvent(t, r)
+	require.Equal(t, "update", event)
+	res := &pb.QueryRangeResponse{}
+	require.NoError(t, protojson.Unmarshal([]byte(data), res))
+	require.Empty(t, res.Series)
+
+	// Writes of series the query doesn't select push no update.
+	notifier.Written(ctx, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "other"}})
+
+	fileContent, err := os.ReadFile("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustDecompressGzip(t, fileContent)))
+	p.TimeNanos = time.Now().Add(-time.Minute).UnixNano()
+	ls := labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}}
+	require.NoError(t, ingester.Ingest(ctx, ls, p, false))
+	notifier.Written(ctx, ls)
+
+	event, data = readEvent(t, r)
+	require.Equal(t, "update", event)
+	res = &pb.QueryRangeResponse{}
+	require.NoError(t, protojson.Unmarshal([]byte(data), res))
+	require.Len(t, res.Series, 1)
+	require.Len(t, res.Series[0].Samples, 1)
+
+	// Disconnecting tears the subscription down.
+	cancel()
+	require.Eventually(t, func() bool {
+		notifier.mtx.Lock()
+		defer notifier.mtx.Unlock()
+		return len(notifier.subs) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWriteNotifierBackpressure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ls := labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}}
+
+	drop, err := NewWriteNotifier(LiveBackpressureDrop, 4)
+	require.NoError(t, err)
+	sub, err := drop.subscribe(ctx, `memory:alloc_objects:count:space:bytes{job="default"}`)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		drop.Written(ctx, ls)
+	}
+	// Writes are coalesced into a single pending update.
+	require.Len(t, sub.updates, 1)
+	select {
+	case <-sub.overflowed:
+		t.Fatal("dropping updates must not end the subscription")
+	default:
+	}
+
+	buffer, err := NewWriteNotifier(LiveBackpressureBuffer, 4)
+	require.NoError(t, err)
+	sub, err = buffer.subscribe(ctx, `memory:alloc_objects:count:space:bytes{job="default"}`)
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		buffer.Written(ctx, ls)
+	}
+	require.Len(t, sub.updates, 4)
+	select {
+	case <-sub.overflowed:
+		t.Fatal("subscription overflowed within its buffer")
+	default:
+	}
+	buffer.Written(ctx, ls)
+	<-sub.overflowed
+	require.Empty(t, buffer.subs)
+
+	_, err = NewWriteNotifier("block", 4)
+	require.Error(t, err)
+}
+
+func TestWriteNotifierMatchers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tenantCtx := tenant.WithTenant(ctx, "team-a")
+	notifier, err := NewWriteNotifier(LiveBackpressureDrop, 0)
+	require.NoError(t, err)
+
+	pending := func(sub *liveSubscription) bool {
+		select {
+		case <-sub.updates:
+			return true
+		default:
+			return false
+		}
+	}
+
+	regex, err := notifier.subscribe(ctx, `{__name__=~"parca_agent.*", job="default"}`)
+	require.NoError(t, err)
+	notifier.Written(ctx, labels.Labels{{Name: "__name__", Value: "parca_agent_cpu"}, {Name: "job", Value: "default"}})
+	require.True(t, pending(regex))
+	notifier.Written(ctx, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}})
+	require.False(t, pending(regex))
+
+	// Subscribers are only notified of the writes of their own tenant.
+	ls := labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: "default"}}
+	query := `memory:alloc_objects:count:space:bytes{job="default"}`
+	def, err := notifier.subscribe(ctx, query)
+	require.NoError(t, err)
+	other, err := notifier.subscribe(tenantCtx, query)
+	require.NoError(t, err)
+
+	notifier.Written(tenantCtx, ls)
+	require.False(t, pending(def))
+	require.True(t, pending(other))
+	notifier.Written(ctx, ls)
+	require.True(t, pending(def))
+	require.False(t, pending(other))
+
+	notifier.unsubscribe(other)
+	notifier.mtx.Lock()
+	defer notifier.mtx.Unlock()
+	require.NotContains(t, notifier.subs, "team-a")
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+// downloadsOriginal returns whether the query asks for a single profile as
+// is, which is then the profile as written if it was kept.
+func downloadsOriginal(req *pb.QueryRequest) bool {
+	return req.Mode == pb.QueryRequest_MODE_SINGLE_UNSPECIFIED &&
+		req.GetReportType() == pb.QueryRequest_REPORT_TYPE_PPROF &&
+		req.GetSubtree() == nil &&
+		!req.GetPercentOnly() &&
+		!req.GetPrimarySampleType() &&
+		req.GetFocus() == "" &&
+		req.GetIgnore() == "" &&
+		req.GetShowFrom() == ""
+}
+
+// originalProfile returns the profile as written of the single series the
+// query selects at its time. It returns false if the query selects more
+// than one series or the profile wasn't kept, failures are only logged as
+// the profile can still be reconstructed.
+func (q *ColumnQueryAPI) originalProfile(ctx context.Context, s *pb.SingleProfile) ([]byte, bool) {
+	ctx, span := q.tracer.Start(ctx, "originalProfile")
+	defer span.End()
+
+	meta, _, err := parcacol.QueryToFilterExprs(s.Query)
+	if err != nil {
+		return nil, false
+	}
+
+	// Range queries exclude their bounds.
+	t := s.Time.AsTime()
+	series, err := q.querier.QueryRange(ctx, s.Query, t.Add(-time.Millisecond), t.Add(time.Millisecond), 0)
+	if err != nil || len(series) != 1 {
+		return nil, false
+	}
+
+	ls := labels.Labels{{Name: labels.MetricName, Value: meta.Name}}
+	for _, l := range series[0].Labelset.Labels {
+		ls = append(ls, labels.Label{Name: l.Name, Value: l.Value})
+	}
+
+	b, ok, err := q.originals.Download(ctx, ls, timestamp.FromTime(t))
+	if err != nil {
+		level.Warn(q.logger).Log("msg", "failed to download original profile, reconstructing it", "err", err)
+		return nil, false
+	}
+	return b, ok
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/go-kit/log"
+	columnstore "github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/metastoretest"
+	"github.com/parca-dev/parca/pkg/originals"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+type unavailableBucket struct {
+	objstore.Bucket
+}
+
+func (b unavailableBucket) Get(context.Context, string) (io.ReadCloser, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestColumnQueryAPIQueryOriginal(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+	col, err := columnstore.New(
+		logger,
+		reg,
+	)
+	require.NoError(t, err)
+	colDB, err := col.DB(context.Background(), "parca")
+	require.NoError(t, err)
+
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+
+	table, err := colDB.Table(
+		"stacktraces",
+		columnstore.NewTableConfig(schema),
+	)
+	require.NoError(t, err)
+	m := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	normalizer := parcacol.NewNormalizer(m)
+	ingester := parcacol.NewIngester(logger, normalizer, table, schema)
+
+	bucket := objstore.NewInMemBucket()
+	store := originals.NewStore(prometheus.NewRegistry(), bucket)
+
+	// Job a is kept, job b is not.
+	original := []byte("original profile")
+	p := &pprofpb.Profile{}
+	require.NoError(t, p.UnmarshalVT(MustReadAllGzip(t, "testdata/alloc_objects.pb.gz")))
+	p.TimeNanos = time.Unix(60, 0).UnixNano()
+	for _, job := range []string{"a", "b"} {
+		ls := labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: job}}
+		require.NoError(t, ingester.Ingest(ctx, ls, p, false))
+		if job == "a" {
+			require.NoError(t, store.Upload(ctx, ls, 60_000, original))
+		}
+	}
+
+	newAPI := func(store *originals.Store) *ColumnQueryAPI {
+		return NewColumnQueryAPI(
+			logger,
+			tracer,
+			getShareServerConn(t),
+			parcacol.NewQuerier(
+				tracer,
+				query.NewEngine(
+					memory.DefaultAllocator,
+					colDB.TableProvider(),
+				),
+				"stacktraces",
+				m,
+			),
+			WithOriginals(store),
+		)
+	}
+	download := func(api *ColumnQueryAPI, query string) []byte {
+		res, err := api.Query(ctx, &pb.QueryRequest{
+			Mode: pb.QueryRequest_MODE_SINGLE_UNSPECIFIED,
+			Options: &pb.QueryRequest_Single{Single: &pb.SingleProfile{
+				Query: query,
+				Time:  timestamppb.New(time.Unix(60, 0)),
+			}},
+			ReportType: pb.QueryRequest_REPORT_TYPE_PPROF,
+		})
+		require.NoError(t, err)
+		return res.GetPprof()
+	}
+
+	api := newAPI(store)
+	got := download(api, `memory:alloc_objects:count:space:bytes{job="a"}`)
+	require.Equal(t, original, MustDecompressGzip(t, got))
+
+	// Profiles that weren't kept, or of several series, are reconstructed.
+	reconstructed := download(api, `memory:alloc_objects:count:space:bytes{job="b"}`)
+	rp := &pprofpb.Profile{}
+	require.NoError(t, rp.UnmarshalVT(MustDecompressGzip(t, reconstructed)))
+	require.NotEmpty(t, rp.Sample)
+	merged := download(api, `memory:alloc_objects:count:space:bytes`)
+	require.NoError(t, rp.UnmarshalVT(MustDecompressGzip(t, merged)))
+	require.NotEmpty(t, rp.Sample)
+
+	// Profiles are reconstructed if the object store fails.
+	api = newAPI(originals.NewStore(prometheus.NewRegistry(), unavailableBucket{bucket}))
+	reconstructed = download(api, `memory:alloc_objects:count:space:bytes{job="a"}`)
+	require.NoError(t, rp.UnmarshalVT(MustDecompressGzip(t, reconstructed)))
+	require.NotEmpty(t, rp.Sample)
+}
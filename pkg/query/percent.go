@@ -0,0 +1,106 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"math"
+	"sort"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// BasisPointsUnit is the unit of the values of profiles relative to their
+// total, a basis point is a hundredth of a percent.
+const BasisPointsUnit = "basis_points"
+
+// basisPointsTotal is the total of a profile in basis points.
+const basisPointsTotal = 100 * 100
+
+// PercentProfile returns the profile with the value of every sample relative
+// to the total of the profile, in basis points. Values are rounded such that
+// they still add up to the total, which a profile of many small samples
+// wouldn't if every value was rounded on its own. All values of a profile
+// with a total of zero are zero.
+func PercentProfile(p *profile.Profile) *profile.Profile {
+	var total int64
+	for _, s := range p.Samples {
+		total += s.Value
+	}
+
+	res := &profile.Profile{
+		Samples: make([]*profile.SymbolizedSample, 0, len(p.Samples)),
+		Meta:    p.Meta,
+	}
+	res.Meta.SampleType.Unit = BasisPointsUnit
+
+	values := make([]int64, len(p.Samples))
+	diffValues := make([]int64, len(p.Samples))
+	for i, s := range p.Samples {
+		values[i] = s.Value
+		diffValues[i] = s.DiffValue
+	}
+	values = basisPoints(values, total)
+	diffValues = basisPoints(diffValues, total)
+
+	for i, s := range p.Samples {
+		sample := *s
+		sample.Value = values[i]
+		sample.DiffValue = diffValues[i]
+		res.Samples = append(res.Samples, &sample)
+	}
+
+	return res
+}
+
+// basisPoints returns the values relative to the total in basis points. The
+// values are rounded down and the basis points lost to rounding are handed
+// out to the values that lost the most, so the result adds up to the sum of
+// the values, rounded.
+func basisPoints(values []int64, total int64) []int64 {
+	res := make([]int64, len(values))
+	if total == 0 {
+		return res
+	}
+
+	var (
+		exact   float64
+		rounded int64
+	)
+	remainders := make([]float64, len(values))
+	for i, v := range values {
+		bp := float64(v) * basisPointsTotal / float64(total)
+		res[i] = int64(math.Floor(bp))
+		remainders[i] = bp - float64(res[i])
+		exact += bp
+		rounded += res[i]
+	}
+
+	missing := int(int64(math.Round(exact)) - rounded)
+	if missing <= 0 {
+		return res
+	}
+
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return remainders[idx[i]] > remainders[idx[j]]
+	})
+	for _, i := range idx[:missing] {
+		res[i]++
+	}
+
+	return res
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+func TestPercentProfile(t *testing.T) {
+	t.Parallel()
+
+	p := &profile.Profile{
+		Meta: profile.Meta{SampleType: profile.ValueType{Type: "alloc_space", Unit: "bytes"}},
+		Samples: []*profile.SymbolizedSample{
+			{Value: 1},
+			{Value: 1},
+			{Value: 1},
+		},
+	}
+
+	res := PercentProfile(p)
+	require.Equal(t, BasisPointsUnit, res.Meta.SampleType.Unit)
+	require.Equal(t, "alloc_space", res.Meta.SampleType.Type)
+
+	// The basis point lost to rounding goes to one of the samples.
+	var total int64
+	for _, s := range res.Samples {
+		require.InDelta(t, 3333, s.Value, 1)
+		total += s.Value
+	}
+	require.Equal(t, int64(100*100), total)
+
+	// The original profile is left as it is.
+	require.Equal(t, "bytes", p.Meta.SampleType.Unit)
+	require.Equal(t, int64(1), p.Samples[0].Value)
+}
+
+func TestPercentProfileZeroTotal(t *testing.T) {
+	t.Parallel()
+
+	res := PercentProfile(&profile.Profile{
+		Samples: []*profile.SymbolizedSample{
+			{Value: 5},
+			{Value: -5},
+			{Value: 0},
+		},
+	})
+	require.Len(t, res.Samples, 3)
+	for _, s := range res.Samples {
+		require.Equal(t, int64(0), s.Value)
+	}
+
+	res = PercentProfile(&profile.Profile{})
+	require.Empty(t, res.Samples)
+}
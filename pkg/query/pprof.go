@@ -15,7 +15,9 @@ package query
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/go-kit/log/level"
 	"github.com/google/pprof/profile"
 
 	parcaprofile "github.com/parca-dev/parca/pkg/profile"
@@ -195,3 +197,29 @@ func GenerateFlatPprof(ctx context.Context, ip *parcaprofile.Profile) (*profile.
 
 	return p, nil
 }
+
+// PprofDownload is an HTTP handler exporting a queried profile as a gzip
+// compressed pprof profile, to be opened with go tool pprof and other pprof
+// compatible tools. The profile is selected like for selectHTTPProfile.
+func (q *ColumnQueryAPI) PprofDownload(w http.ResponseWriter, r *http.Request) {
+	ctx, span := q.tracer.Start(r.Context(), "PprofDownload")
+	defer span.End()
+
+	p, code, err := q.selectHTTPProfile(ctx, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	pp, err := GenerateFlatPprof(ctx, p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile.pb.gz"`)
+	if err := pp.Write(w); err != nil {
+		level.Warn(q.logger).Log("msg", "failed to write pprof profile", "err", err)
+	}
+}
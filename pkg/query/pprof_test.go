@@ -16,6 +16,8 @@ package query
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -188,3 +190,27 @@ func TestGeneratePprofNilMapping(t *testing.T) {
 	require.NoError(t, f.Close())
 	require.NoError(t, resProf.CheckValid())
 }
+
+func TestPprofDownload(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(
+		log.NewNopLogger(),
+		trace.NewNoopTracerProvider().Tracer(""),
+		nil,
+		fakeChromeTraceQuerier{p: chromeTraceTestProfile()},
+	)
+
+	rec := httptest.NewRecorder()
+	api.PprofDownload(rec, httptest.NewRequest(http.MethodGet, "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta&start=2022-01-01T00:00:00Z&end=2022-01-02T00:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+
+	p, err := profile.Parse(rec.Body)
+	require.NoError(t, err)
+	require.Equal(t, len(chromeTraceTestProfile().Samples), len(p.Sample))
+
+	rec = httptest.NewRecorder()
+	api.PprofDownload(rec, httptest.NewRequest(http.MethodGet, "/?query=process_cpu:cpu:nanoseconds:cpu:nanoseconds:delta", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
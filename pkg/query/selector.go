@@ -0,0 +1,143 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// checkSelector rejects selectors with regexes more complex than allowed and
+// selectors that don't select any of the required labels.
+func (q *ColumnQueryAPI) checkSelector(query string) error {
+	if len(q.requiredLabels) == 0 && q.maxRegexSize == 0 {
+		return nil
+	}
+
+	matchers, err := parser.ParseMetricSelector(query)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to parse query %q: %v", query, err)
+	}
+
+	if q.maxRegexSize > 0 {
+		for _, m := range matchers {
+			if err := q.checkRegex(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(q.requiredLabels) == 0 {
+		return nil
+	}
+	return q.checkRequiredLabels(query, matchers)
+}
+
+// checkRegex rejects regex matchers whose regex compiles to more
+// instructions than the maximum regex size. Regexes are matched in time
+// linear in the size of their program, so this bounds the cost of matching
+// the label values of every stored series.
+func (q *ColumnQueryAPI) checkRegex(m *labels.Matcher) error {
+	if m.Type != labels.MatchRegexp && m.Type != labels.MatchNotRegexp {
+		return nil
+	}
+
+	// Matchers are anchored, like labels.NewFastRegexMatcher does.
+	size, err := regexSize("^(?:" + m.Value + ")$")
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid regex of label %s: %v", m.Name, err)
+	}
+	if size > q.maxRegexSize {
+		return status.Errorf(codes.InvalidArgument, "regex of label %s is too complex, it compiles to %d instructions, more than the maximum of %d", m.Name, size, q.maxRegexSize)
+	}
+	return nil
+}
+
+// regexSize returns the number of instructions the regex compiles to.
+func regexSize(expr string) (int, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+	return len(prog.Inst), nil
+}
+
+// checkRequiredLabels rejects selectors that don't select any of the
+// required labels. A label is only selected by a matcher that doesn't match
+// the empty value, as any other matcher also matches series without the
+// label.
+func (q *ColumnQueryAPI) checkRequiredLabels(query string, matchers []*labels.Matcher) error {
+	for _, m := range matchers {
+		if _, ok := q.requiredLabels[m.Name]; ok && !m.Matches("") {
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(q.requiredLabels))
+	for name := range q.requiredLabels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return status.Errorf(codes.InvalidArgument, "query %q must select at least one of the labels %s", query, strings.Join(names, ", "))
+}
+
+// checkSelectors checks the selectors of every profile a query request
+// selects.
+func (q *ColumnQueryAPI) checkSelectors(req *pb.QueryRequest) error {
+	for _, query := range requestSelectors(req) {
+		if err := q.checkSelector(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestSelectors returns the selectors of every profile a query request
+// selects.
+func requestSelectors(req *pb.QueryRequest) []string {
+	var queries []string
+	switch req.Mode {
+	case pb.QueryRequest_MODE_SINGLE_UNSPECIFIED:
+		queries = append(queries, req.GetSingle().GetQuery())
+	case pb.QueryRequest_MODE_MERGE:
+		queries = append(queries, req.GetMerge().GetQuery())
+	case pb.QueryRequest_MODE_TRACE:
+		queries = append(queries, req.GetTrace().GetQuery())
+	case pb.QueryRequest_MODE_DIFF:
+		for _, s := range []*pb.ProfileDiffSelection{req.GetDiff().GetA(), req.GetDiff().GetB()} {
+			if s.GetMode() == pb.ProfileDiffSelection_MODE_MERGE {
+				queries = append(queries, s.GetMerge().GetQuery())
+			} else {
+				queries = append(queries, s.GetSingle().GetQuery())
+			}
+		}
+	case pb.QueryRequest_MODE_UNION:
+		for _, m := range req.GetUnion().GetMerges() {
+			queries = append(queries, m.GetQuery())
+		}
+	}
+	return queries
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+func TestCheckSelector(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil, WithRequiredLabels("service", "namespace"))
+
+	for _, query := range []string{
+		`memory:alloc_objects:count:space:bytes{service="a"}`,
+		`memory:alloc_objects:count:space:bytes{job="a", namespace=~"prod|dev"}`,
+		`memory:alloc_objects:count:space:bytes{service!=""}`,
+	} {
+		require.NoError(t, api.checkSelector(query), query)
+	}
+
+	for _, query := range []string{
+		`memory:alloc_objects:count:space:bytes`,
+		`memory:alloc_objects:count:space:bytes{job="a"}`,
+		`memory:alloc_objects:count:space:bytes{service=~".*"}`,
+		`memory:alloc_objects:count:space:bytes{service!="a"}`,
+		`memory:alloc_objects:count:space:bytes{service=`,
+	} {
+		require.Equal(t, codes.InvalidArgument, status.Code(api.checkSelector(query)), query)
+	}
+
+	// Without required labels any selector is allowed.
+	api = NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil, WithRequiredLabels())
+	require.NoError(t, api.checkSelector(`memory:alloc_objects:count:space:bytes`))
+}
+
+func TestCheckSelectorRegexSize(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil, WithMaxRegexSize(100))
+
+	for _, query := range []string{
+		`memory:alloc_objects:count:space:bytes{job="a"}`,
+		`memory:alloc_objects:count:space:bytes{namespace=~"prod|dev"}`,
+		`memory:alloc_objects:count:space:bytes{pod!~"api-[a-z0-9]{5}"}`,
+	} {
+		require.NoError(t, api.checkSelector(query), query)
+	}
+
+	for _, query := range []string{
+		`memory:alloc_objects:count:space:bytes{job=~"(a{50}){20}"}`,
+		`memory:alloc_objects:count:space:bytes{job="a", pod!~"((a|b){10}){10}"}`,
+	} {
+		require.Equal(t, codes.InvalidArgument, status.Code(api.checkSelector(query)), query)
+	}
+
+	// Regexes that backtrack catastrophically elsewhere are small and
+	// matched in linear time.
+	query := `memory:alloc_objects:count:space:bytes{job=~"(a+)+b"}`
+	require.NoError(t, api.checkSelector(query))
+	matchers, err := parser.ParseMetricSelector(query)
+	require.NoError(t, err)
+	done := make(chan bool)
+	go func() { done <- matchers[0].Matches(strings.Repeat("a", 1e5) + "c") }()
+	select {
+	case matched := <-done:
+		require.False(t, matched)
+	case <-time.After(10 * time.Second):
+		t.Fatal("matching the regex didn't finish")
+	}
+}
+
+func TestColumnQueryAPIQueryRequiredLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	api := NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil, WithRequiredLabels("service"))
+
+	single := func(query string) *pb.ProfileDiffSelection {
+		return &pb.ProfileDiffSelection{
+			Options: &pb.ProfileDiffSelection_Single{Single: &pb.SingleProfile{
+				Query: query,
+				Time:  timestamppb.New(time.Unix(1, 0)),
+			}},
+		}
+	}
+
+	// Every side of a diff has to select a required label.
+	_, err := api.Query(ctx, &pb.QueryRequest{
+		Mode: pb.QueryRequest_MODE_DIFF,
+		Options: &pb.QueryRequest_Diff{Diff: &pb.DiffProfile{
+			A: single(`memory:alloc_objects:count:space:bytes{service="a"}`),
+			B: single(`memory:alloc_objects:count:space:bytes{job="a"}`),
+		}},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = api.QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: `memory:alloc_objects:count:space:bytes`,
+		Start: timestamppb.New(time.Unix(0, 0)),
+		End:   timestamppb.New(time.Unix(1, 0)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.NoError(t, api.checkSelectors(&pb.QueryRequest{
+		Mode: pb.QueryRequest_MODE_DIFF,
+		Options: &pb.QueryRequest_Diff{Diff: &pb.DiffProfile{
+			A: single(`memory:alloc_objects:count:space:bytes{service="a"}`),
+			B: single(`memory:alloc_objects:count:space:bytes{service="b"}`),
+		}},
+	}))
+}
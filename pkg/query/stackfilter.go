@@ -0,0 +1,137 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// stackFilter filters the stacks of a profile by the names of their
+// functions, like the focus, ignore and show_from options of pprof. Regexes
+// match anywhere in the name unless they are anchored.
+type stackFilter struct {
+	focus    *regexp.Regexp
+	ignore   *regexp.Regexp
+	showFrom *regexp.Regexp
+}
+
+// newStackFilter returns the filter of the stacks the request asks for, nil
+// if it doesn't filter any.
+func (q *ColumnQueryAPI) newStackFilter(req *pb.QueryRequest) (*stackFilter, error) {
+	if req.GetFocus() == "" && req.GetIgnore() == "" && req.GetShowFrom() == "" {
+		return nil, nil
+	}
+
+	f := &stackFilter{}
+	var err error
+	if f.focus, err = q.compileStackRegex("focus", req.GetFocus()); err != nil {
+		return nil, err
+	}
+	if f.ignore, err = q.compileStackRegex("ignore", req.GetIgnore()); err != nil {
+		return nil, err
+	}
+	if f.showFrom, err = q.compileStackRegex("show_from", req.GetShowFrom()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// compileStackRegex compiles the regex of a filter, nil if it is empty. Like
+// the regexes of selectors, it must not be more complex than allowed, as it
+// is matched against the functions of every stack.
+func (q *ColumnQueryAPI) compileStackRegex(name, expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s regex: %v", name, err)
+	}
+	if q.maxRegexSize > 0 {
+		if size, err := regexSize(expr); err == nil && size > q.maxRegexSize {
+			return nil, status.Errorf(codes.InvalidArgument, "%s regex is too complex, it compiles to %d instructions, more than the maximum of %d", name, size, q.maxRegexSize)
+		}
+	}
+	return re, nil
+}
+
+// filterProfile returns the profile with only the stacks the filter keeps.
+// The values of the kept samples are unchanged, so that they stay relative to
+// the total of the whole profile.
+func (f *stackFilter) filterProfile(p *profile.Profile) *profile.Profile {
+	if f == nil {
+		return p
+	}
+
+	res := &profile.Profile{
+		Meta:    p.Meta,
+		Samples: make([]*profile.SymbolizedSample, 0, len(p.Samples)),
+	}
+	for _, s := range p.Samples {
+		if s, ok := f.filterSample(s); ok {
+			res.Samples = append(res.Samples, s)
+		}
+	}
+	return res
+}
+
+// filterSample returns the sample with its stack cut at show_from, or false
+// if the stack is dropped. Locations are ordered from the leaf to the root.
+func (f *stackFilter) filterSample(s *profile.SymbolizedSample) (*profile.SymbolizedSample, bool) {
+	if f.focus != nil && !matchesAnyLocation(f.focus, s.Locations) {
+		return nil, false
+	}
+	if f.ignore != nil && matchesAnyLocation(f.ignore, s.Locations) {
+		return nil, false
+	}
+	if f.showFrom == nil {
+		return s, true
+	}
+
+	// Inlined functions share the location of their caller, so the stack is
+	// cut after the whole location of the outermost match.
+	for i := len(s.Locations) - 1; i >= 0; i-- {
+		if matchesLocation(f.showFrom, s.Locations[i]) {
+			cut := *s
+			cut.Locations = s.Locations[:i+1]
+			return &cut, true
+		}
+	}
+	return nil, false
+}
+
+func matchesAnyLocation(re *regexp.Regexp, locations []*profile.Location) bool {
+	for _, l := range locations {
+		if matchesLocation(re, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesLocation(re *regexp.Regexp, l *profile.Location) bool {
+	for _, line := range l.Lines {
+		if line.Function != nil && re.MatchString(line.Function.Name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// stackFilterTestSample returns a sample of the stack of functions given
+// from the root to the leaf, one location each.
+func stackFilterTestSample(value int64, functions ...string) *profile.SymbolizedSample {
+	s := &profile.SymbolizedSample{Value: value}
+	for i := len(functions) - 1; i >= 0; i-- {
+		s.Locations = append(s.Locations, &profile.Location{
+			ID:    functions[i],
+			Lines: []profile.LocationLine{{Function: &metastorepb.Function{Name: functions[i]}}},
+		})
+	}
+	return s
+}
+
+func stackFilterTestStacks(p *profile.Profile) map[string]int64 {
+	stacks := map[string]int64{}
+	for _, s := range p.Samples {
+		stack := ""
+		for i := len(s.Locations) - 1; i >= 0; i-- {
+			if stack != "" {
+				stack += ";"
+			}
+			stack += s.Locations[i].Lines[0].Function.Name
+		}
+		stacks[stack] += s.Value
+	}
+	return stacks
+}
+
+func TestStackFilter(t *testing.T) {
+	t.Parallel()
+
+	p := &profile.Profile{
+		Samples: []*profile.SymbolizedSample{
+			stackFilterTestSample(1, "main", "http.Serve", "handler", "json.Marshal"),
+			stackFilterTestSample(2, "main", "http.Serve", "handler", "db.Query"),
+			stackFilterTestSample(3, "main", "gc"),
+			stackFilterTestSample(4, "main", "handler", "handler", "db.Query"),
+		},
+	}
+
+	tests := map[string]struct {
+		req      *pb.QueryRequest
+		expected map[string]int64
+	}{
+		"focus": {
+			req: &pb.QueryRequest{Focus: "^db\\."},
+			expected: map[string]int64{
+				"main;http.Serve;handler;db.Query": 2,
+				"main;handler;handler;db.Query":    4,
+			},
+		},
+		"ignore": {
+			req: &pb.QueryRequest{Ignore: "http|gc"},
+			expected: map[string]int64{
+				"main;handler;handler;db.Query": 4,
+			},
+		},
+		"focusAndIgnore": {
+			req: &pb.QueryRequest{Focus: "handler", Ignore: "Marshal"},
+			expected: map[string]int64{
+				"main;http.Serve;handler;db.Query": 2,
+				"main;handler;handler;db.Query":    4,
+			},
+		},
+		"showFrom": {
+			// Recursive stacks are cut at the outermost call.
+			req: &pb.QueryRequest{ShowFrom: "^handler$"},
+			expected: map[string]int64{
+				"handler;json.Marshal":     1,
+				"handler;db.Query":         2,
+				"handler;handler;db.Query": 4,
+			},
+		},
+	}
+	api := NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil)
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := api.newStackFilter(test.req)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, stackFilterTestStacks(f.filterProfile(p)))
+		})
+	}
+
+	// The profile itself is left as it is.
+	require.Len(t, p.Samples, 4)
+	require.Len(t, p.Samples[0].Locations, 4)
+}
+
+func TestNewStackFilter(t *testing.T) {
+	t.Parallel()
+
+	api := NewColumnQueryAPI(log.NewNopLogger(), trace.NewNoopTracerProvider().Tracer(""), nil, nil, WithMaxRegexSize(100))
+
+	f, err := api.newStackFilter(&pb.QueryRequest{})
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	// A nil filter keeps every stack.
+	p := &profile.Profile{Samples: []*profile.SymbolizedSample{stackFilterTestSample(1, "main")}}
+	require.Same(t, p, f.filterProfile(p))
+
+	for _, req := range []*pb.QueryRequest{
+		{Focus: "("},
+		{Ignore: "[a-"},
+		{ShowFrom: "(a{50}){20}"},
+	} {
+		_, err := api.newStackFilter(req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+	}
+}
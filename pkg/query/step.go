@@ -0,0 +1,69 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// maxStepBuckets is the maximum number of buckets of a range query with a
+// step, like Prometheus limits the points of a series.
+const maxStepBuckets = 11000
+
+// stepBuckets returns the number of buckets of the step between start and
+// end, the last bucket may be cut short by end.
+func stepBuckets(start, end time.Time, step time.Duration) int64 {
+	span := end.Sub(start)
+	n := int64(span / step)
+	if span%step != 0 {
+		n++
+	}
+	return n
+}
+
+// bucketSeries sums the values of the samples of each series in buckets of
+// the step aligned to start. Buckets without samples are left out unless
+// fillZeros is set, then they have a value of zero.
+func bucketSeries(series []*pb.MetricsSeries, start, end time.Time, step time.Duration, fillZeros bool) {
+	n := stepBuckets(start, end, step)
+	for _, s := range series {
+		values := make([]int64, n)
+		seen := make([]bool, n)
+		for _, sample := range s.Samples {
+			t := sample.Timestamp.AsTime()
+			if t.Before(start) || !t.Before(end) {
+				continue
+			}
+			i := int64(t.Sub(start) / step)
+			values[i] += sample.Value
+			seen[i] = true
+		}
+
+		samples := make([]*pb.MetricsSample, 0, len(s.Samples))
+		for i := int64(0); i < n; i++ {
+			if !seen[i] && !fillZeros {
+				continue
+			}
+			samples = append(samples, &pb.MetricsSample{
+				Timestamp: timestamppb.New(start.Add(time.Duration(i) * step)),
+				Value:     values[i],
+			})
+		}
+		s.Samples = samples
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// SubtreeFlamegraph re-roots the flame graph at the outermost occurrences of
+// the function of the subtree. Recursive calls of the function stay part of
+// the subtree of their outermost occurrence, so no value is counted twice.
+// The total of the returned flame graph is the cumulative value of the
+// subtrees, so that their values are relative to the function.
+func SubtreeFlamegraph(fg *pb.Flamegraph, subtree *pb.Subtree) *pb.Flamegraph {
+	var roots []*pb.FlamegraphNode
+	var find func(nodes []*pb.FlamegraphNode)
+	find = func(nodes []*pb.FlamegraphNode) {
+		for _, n := range nodes {
+			if n.Meta.GetFunction().GetName() == subtree.FunctionName {
+				roots = append(roots, n)
+				continue
+			}
+			find(n.Children)
+		}
+	}
+	find(fg.Root.GetChildren())
+
+	res := &pb.Flamegraph{
+		Root: &pb.FlamegraphRootNode{
+			Children: roots,
+		},
+		Unit: fg.Unit,
+	}
+	for _, n := range roots {
+		res.Root.Cumulative += n.Cumulative
+		res.Root.Diff += n.Diff
+	}
+	res.Total = res.Root.Cumulative
+	res.Height = flamegraphHeight(roots) + 1 // add one for the root
+
+	if subtree.Mode == pb.Subtree_MODE_MERGE_UNSPECIFIED {
+		res = aggregateByFunction(res)
+	}
+
+	return res
+}
+
+func flamegraphHeight(nodes []*pb.FlamegraphNode) int32 {
+	var height int32
+	for _, n := range nodes {
+		if h := flamegraphHeight(n.Children) + 1; h > height {
+			height = h
+		}
+	}
+	return height
+}
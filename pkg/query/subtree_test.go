@@ -0,0 +1,158 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+func subtreeTestNode(name string, cumulative int64, children ...*pb.FlamegraphNode) *pb.FlamegraphNode {
+	return &pb.FlamegraphNode{
+		Meta: &pb.FlamegraphNodeMeta{
+			Function: &metastorepb.Function{Name: name},
+			Location: &metastorepb.Location{},
+		},
+		Cumulative: cumulative,
+		Children:   children,
+	}
+}
+
+// subtreeTestFlamegraph has f called from two different stacks, once
+// recursively.
+func subtreeTestFlamegraph() *pb.Flamegraph {
+	return &pb.Flamegraph{
+		Total:  10,
+		Unit:   "count",
+		Height: 6,
+		Root: &pb.FlamegraphRootNode{
+			Cumulative: 10,
+			Children: []*pb.FlamegraphNode{
+				subtreeTestNode("main", 10,
+					subtreeTestNode("a", 6,
+						subtreeTestNode("f", 6,
+							subtreeTestNode("x", 4),
+							subtreeTestNode("f", 2,
+								subtreeTestNode("y", 2),
+							),
+						),
+					),
+					subtreeTestNode("b", 4,
+						subtreeTestNode("c", 4,
+							subtreeTestNode("f", 4,
+								subtreeTestNode("x", 1),
+								subtreeTestNode("z", 3),
+							),
+						),
+					),
+				),
+			},
+		},
+	}
+}
+
+func TestSubtreeFlamegraph(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		subtree  *pb.Subtree
+		expected *pb.Flamegraph
+	}{
+		"merge": {
+			subtree: &pb.Subtree{FunctionName: "f"},
+			expected: &pb.Flamegraph{
+				Total:  10,
+				Unit:   "count",
+				Height: 4,
+				Root: &pb.FlamegraphRootNode{
+					Cumulative: 10,
+					Children: []*pb.FlamegraphNode{
+						subtreeTestNode("f", 10,
+							subtreeTestNode("f", 2,
+								subtreeTestNode("y", 2),
+							),
+							subtreeTestNode("x", 5),
+							subtreeTestNode("z", 3),
+						),
+					},
+				},
+			},
+		},
+		"list": {
+			subtree: &pb.Subtree{FunctionName: "f", Mode: pb.Subtree_MODE_LIST},
+			expected: &pb.Flamegraph{
+				Total:  10,
+				Unit:   "count",
+				Height: 4,
+				Root: &pb.FlamegraphRootNode{
+					Cumulative: 10,
+					Children: []*pb.FlamegraphNode{
+						subtreeTestNode("f", 6,
+							subtreeTestNode("x", 4),
+							subtreeTestNode("f", 2,
+								subtreeTestNode("y", 2),
+							),
+						),
+						subtreeTestNode("f", 4,
+							subtreeTestNode("x", 1),
+							subtreeTestNode("z", 3),
+						),
+					},
+				},
+			},
+		},
+		"values relative to the function": {
+			subtree: &pb.Subtree{FunctionName: "c"},
+			expected: &pb.Flamegraph{
+				Total:  4,
+				Unit:   "count",
+				Height: 4,
+				Root: &pb.FlamegraphRootNode{
+					Cumulative: 4,
+					Children: []*pb.FlamegraphNode{
+						subtreeTestNode("c", 4,
+							subtreeTestNode("f", 4,
+								subtreeTestNode("x", 1),
+								subtreeTestNode("z", 3),
+							),
+						),
+					},
+				},
+			},
+		},
+		"unknown function": {
+			subtree: &pb.Subtree{FunctionName: "unknown"},
+			expected: &pb.Flamegraph{
+				Unit:   "count",
+				Height: 1,
+				Root:   &pb.FlamegraphRootNode{},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			fg := SubtreeFlamegraph(subtreeTestFlamegraph(), test.subtree)
+			require.True(t, proto.Equal(test.expected, fg), "expected %v, got %v", test.expected, fg)
+		})
+	}
+}
@@ -164,3 +164,22 @@ func aggregateTopByFunction(top *pb.Top) *pb.Top {
 		Unit:     top.GetUnit(),
 	}
 }
+
+// LeavesTopTable returns the nodes of a top table with a flat value, the
+// functions at the leaves of stacks, ranked by their flat value across all
+// stacks.
+func LeavesTopTable(top *pb.Top) *pb.Top {
+	list := make([]*pb.TopNode, 0, len(top.GetList()))
+	for _, n := range top.GetList() {
+		if n.Flat != 0 {
+			list = append(list, n)
+		}
+	}
+
+	return &pb.Top{
+		List:     list,
+		Reported: int32(len(list)),
+		Total:    top.GetTotal(),
+		Unit:     top.GetUnit(),
+	}
+}
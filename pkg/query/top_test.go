@@ -170,6 +170,83 @@ func TestGenerateTopTableAggregateFlat(t *testing.T) {
 	require.Equal(t, int64(0), top.List[3].Flat)
 }
 
+func TestLeavesTopTable(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	metastore := metastore.NewInProcessClient(metastoretest.NewTestMetastore(
+		t,
+		logger,
+		reg,
+		tracer,
+	))
+
+	lres, err := metastore.GetOrCreateLocations(ctx, &metastorepb.GetOrCreateLocationsRequest{
+		Locations: []*metastorepb.Location{{
+			Address: 0x1,
+		}, {
+			Address: 0x2,
+		}, {
+			Address: 0x3,
+		}, {
+			Address: 0x4,
+		}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(lres.Locations))
+	l1 := lres.Locations[0]
+	l2 := lres.Locations[1]
+	l3 := lres.Locations[2]
+	l4 := lres.Locations[3]
+
+	// 0x1 is the leaf of two stacks and called by 0x4 in another, 0x3 is
+	// never a leaf.
+	sres, err := metastore.GetOrCreateStacktraces(ctx, &metastorepb.GetOrCreateStacktracesRequest{
+		Stacktraces: []*metastorepb.Stacktrace{{
+			LocationIds: []string{l1.Id, l2.Id},
+		}, {
+			LocationIds: []string{l1.Id, l3.Id},
+		}, {
+			LocationIds: []string{l2.Id, l3.Id},
+		}, {
+			LocationIds: []string{l4.Id, l1.Id},
+		}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(sres.Stacktraces))
+
+	samples := make([]*profile.NormalizedSample, 0, len(sres.Stacktraces))
+	for i, st := range sres.Stacktraces {
+		samples = append(samples, &profile.NormalizedSample{
+			StacktraceID: st.Id,
+			Value:        1 << i,
+		})
+	}
+	p, err := parcacol.NewArrowToProfileConverter(tracer, metastore).SymbolizeNormalizedProfile(ctx, &profile.NormalizedProfile{
+		Samples: samples,
+	})
+	require.NoError(t, err)
+
+	top, err := GenerateTopTable(ctx, p)
+	require.NoError(t, err)
+	leaves := LeavesTopTable(top)
+
+	require.Equal(t, int32(3), leaves.Reported)
+	require.Equal(t, top.Total, leaves.Total)
+	require.Equal(t, 3, len(leaves.List))
+	require.Equal(t, uint64(0x4), leaves.List[0].Meta.Location.Address)
+	require.Equal(t, int64(8), leaves.List[0].Flat)
+	require.Equal(t, uint64(0x2), leaves.List[1].Meta.Location.Address)
+	require.Equal(t, int64(4), leaves.List[1].Flat)
+	require.Equal(t, uint64(0x1), leaves.List[2].Meta.Location.Address)
+	require.Equal(t, int64(3), leaves.List[2].Flat)
+	require.Equal(t, int64(11), leaves.List[2].Cumulative)
+}
+
 func TestGenerateDiffTopTable(t *testing.T) {
 	t.Parallel()
 
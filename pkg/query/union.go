@@ -0,0 +1,195 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+// selectUnion returns the profiles of the merges of a union, in the order of
+// the merges. With percentOnly the values are relative to the total of all
+// profiles, so the profiles stay comparable with each other.
+func (q *ColumnQueryAPI) selectUnion(ctx context.Context, u *pb.UnionProfile, percentOnly bool) ([]*profile.Profile, error) {
+	ctx, span := q.tracer.Start(ctx, "unionRequest")
+	defer span.End()
+
+	profiles := make([]*profile.Profile, 0, len(u.GetMerges()))
+	for i, m := range u.GetMerges() {
+		p, err := q.selectMerge(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("reading profile of merge %d: %w", i, err)
+		}
+		if i > 0 && p.Meta.SampleType != profiles[0].Meta.SampleType {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"merges of a union must be of the same sample type, %s is %s:%s but %s is %s:%s",
+				u.Merges[0].GetQuery(), profiles[0].Meta.SampleType.Type, profiles[0].Meta.SampleType.Unit,
+				m.GetQuery(), p.Meta.SampleType.Type, p.Meta.SampleType.Unit,
+			)
+		}
+		profiles = append(profiles, p)
+	}
+
+	if !percentOnly {
+		return profiles, nil
+	}
+
+	// The samples of all profiles are made relative at once, so their
+	// basis points add up to the total of the union.
+	percent := PercentProfile(unionProfile(profiles))
+	res := make([]*profile.Profile, 0, len(profiles))
+	for _, p := range profiles {
+		meta := p.Meta
+		meta.SampleType.Unit = BasisPointsUnit
+		res = append(res, &profile.Profile{
+			Samples: percent.Samples[:len(p.Samples)],
+			Meta:    meta,
+		})
+		percent.Samples = percent.Samples[len(p.Samples):]
+	}
+	return res, nil
+}
+
+// unionProfile returns a profile of the samples of all profiles, which is
+// their plain merge.
+func unionProfile(profiles []*profile.Profile) *profile.Profile {
+	res := &profile.Profile{}
+	for i, p := range profiles {
+		if i == 0 {
+			res.Meta = p.Meta
+		}
+		res.Samples = append(res.Samples, p.Samples...)
+	}
+	return res
+}
+
+// GenerateUnionFlamegraph returns the flame graph of the merge of the
+// profiles, every node of which carries the indexes of the profiles that
+// contain it as its sources.
+func GenerateUnionFlamegraph(ctx context.Context, tracer trace.Tracer, profiles []*profile.Profile) (*pb.Flamegraph, error) {
+	ctx, span := tracer.Start(ctx, "GenerateUnionFlamegraph")
+	defer span.End()
+
+	res := &pb.Flamegraph{Root: &pb.FlamegraphRootNode{}}
+	for i, p := range profiles {
+		fg, err := GenerateFlamegraphFlat(ctx, tracer, p)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Unit = fg.Unit
+		res.Total += fg.Total
+		if fg.Height > res.Height {
+			res.Height = fg.Height
+		}
+		res.Root.Cumulative += fg.Root.Cumulative
+		res.Root.Diff += fg.Root.Diff
+		res.Root.Children = unionNodes(res.Root.Children, fg.Root.Children, int32(i))
+	}
+
+	return res, nil
+}
+
+// unionNodeKey identifies the nodes that are merged in the union, they are
+// aggregated by function like the nodes of any flame graph.
+type unionNodeKey struct {
+	function string
+	address  uint64
+}
+
+func newUnionNodeKey(n *pb.FlamegraphNode) unionNodeKey {
+	if f := n.GetMeta().GetFunction(); f != nil {
+		return unionNodeKey{function: f.Name}
+	}
+	return unionNodeKey{address: n.GetMeta().GetLocation().GetAddress()}
+}
+
+// unionNodes merges the nodes of a source into the nodes of the union so far,
+// the merged nodes are sorted like the nodes aggregated by function.
+func unionNodes(union, nodes []*pb.FlamegraphNode, source int32) []*pb.FlamegraphNode {
+	if len(nodes) == 0 {
+		return union
+	}
+
+	index := make(map[unionNodeKey]*pb.FlamegraphNode, len(union))
+	for _, n := range union {
+		index[newUnionNodeKey(n)] = n
+	}
+
+	for _, n := range nodes {
+		existing, ok := index[newUnionNodeKey(n)]
+		if !ok {
+			setSources(n, source)
+			union = append(union, n)
+			continue
+		}
+
+		existing.Meta.Line = nil
+		if existing.Meta.Mapping != nil && n.Meta.Mapping != nil && existing.Meta.Mapping.Id != n.Meta.Mapping.Id {
+			existing.Meta.Mapping = &metastorepb.Mapping{}
+		}
+		existing.Cumulative += n.Cumulative
+		existing.Diff += n.Diff
+		existing.Sources = mergeSources(existing.Sources, []int32{source})
+		existing.Children = unionNodes(existing.Children, n.Children, source)
+	}
+
+	sort.SliceStable(union, func(i, j int) bool {
+		return compareByName(union[i], union[j])
+	})
+	return union
+}
+
+func setSources(n *pb.FlamegraphNode, source int32) {
+	n.Sources = []int32{source}
+	for _, c := range n.Children {
+		setSources(c, source)
+	}
+}
+
+// mergeSources returns the union of two ascending lists of sources.
+func mergeSources(a, b []int32) []int32 {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+
+	res := make([]int32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			res = append(res, a[i])
+			i++
+		case i == len(a) || b[j] < a[i]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+
+	metastorepb "github.com/parca-dev/parca/gen/proto/go/parca/metastore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profile"
+)
+
+func TestGenerateUnionFlamegraph(t *testing.T) {
+	t.Parallel()
+
+	location := func(name string) *profile.Location {
+		return &profile.Location{
+			ID:    name,
+			Lines: []profile.LocationLine{{Function: &metastorepb.Function{Id: name, Name: name}}},
+		}
+	}
+	main, a, b, c := location("main"), location("a"), location("b"), location("c")
+	sample := func(value int64, locations ...*profile.Location) *profile.SymbolizedSample {
+		return &profile.SymbolizedSample{Locations: locations, Value: value}
+	}
+	meta := profile.Meta{SampleType: profile.ValueType{Type: "samples", Unit: "count"}}
+
+	fg, err := GenerateUnionFlamegraph(context.Background(), trace.NewNoopTracerProvider().Tracer(""), []*profile.Profile{{
+		Meta:    meta,
+		Samples: []*profile.SymbolizedSample{sample(3, a, main), sample(1, b, main)},
+	}, {
+		Meta:    meta,
+		Samples: []*profile.SymbolizedSample{sample(2, a, main), sample(4, c, main)},
+	}})
+	require.NoError(t, err)
+
+	require.Equal(t, int64(10), fg.Total)
+	require.Equal(t, int64(10), fg.Root.Cumulative)
+	require.Equal(t, int32(3), fg.Height)
+	require.Equal(t, "count", fg.Unit)
+
+	type node struct {
+		name       string
+		cumulative int64
+		sources    []int32
+	}
+	nodes := func(children []*pb.FlamegraphNode) []node {
+		var res []node
+		for _, n := range children {
+			res = append(res, node{n.Meta.Function.Name, n.Cumulative, n.Sources})
+		}
+		return res
+	}
+
+	require.Equal(t, []node{{"main", 10, []int32{0, 1}}}, nodes(fg.Root.Children))
+	require.Equal(t, []node{
+		{"a", 5, []int32{0, 1}},
+		{"b", 1, []int32{0}},
+		{"c", 4, []int32{1}},
+	}, nodes(fg.Root.Children[0].Children))
+
+	// Sources are kept when subtrees are aggregated.
+	sub := SubtreeFlamegraph(fg, &pb.Subtree{FunctionName: "a"})
+	require.Equal(t, []node{{"a", 5, []int32{0, 1}}}, nodes(sub.Root.Children))
+}
+
+func TestMergeSources(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, []int32{0, 1, 2, 3}, mergeSources([]int32{0, 2}, []int32{1, 2, 3}))
+	require.Equal(t, []int32{1}, mergeSources(nil, []int32{1}))
+	require.Equal(t, []int32{1}, mergeSources([]int32{1}, nil))
+}
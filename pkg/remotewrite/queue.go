@@ -0,0 +1,283 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/config"
+)
+
+// Results of forwarded profiles.
+const (
+	resultSent      = "sent"
+	resultFailed    = "failed"
+	resultDropped   = "dropped"
+	resultRelabeled = "relabeled"
+)
+
+type metrics struct {
+	profiles    *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+	queueLength *prometheus.GaugeVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		profiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_remote_write_profiles_total",
+			Help: "Total number of profiles forwarded to remote write endpoints by result, which is sent, failed, dropped while the queue was full, or relabeled when dropped by relabeling.",
+		}, []string{"remote_name", "result"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_remote_write_retries_total",
+			Help: "Total number of failed requests to remote write endpoints that were retried.",
+		}, []string{"remote_name"}),
+		queueLength: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "parca_remote_write_queue_length",
+			Help: "Number of profiles buffered to be sent to remote write endpoints.",
+		}, []string{"remote_name"}),
+	}
+	reg.MustRegister(m.profiles, m.retries, m.queueLength)
+	return m
+}
+
+// profile is a profile written to the store to be forwarded.
+type profile struct {
+	tenant      string
+	labels      labels.Labels
+	sampleTypes []*profilestorepb.SampleType
+	sample      *profilestorepb.RawSample
+	normalized  bool
+}
+
+// queue buffers the profiles forwarded to an endpoint and sends them in
+// batches.
+type queue struct {
+	logger       log.Logger
+	metrics      *metrics
+	name         string
+	cfg          config.QueueConfig
+	relabel      []*relabel.Config
+	timeout      time.Duration
+	headers      map[string]string
+	tenantHeader string
+
+	client profilestorepb.ProfileStoreServiceClient
+	closer io.Closer
+
+	profiles chan profile
+	// ctx is canceled to abort sending once the queue is stopped and the
+	// context of stopping it is done.
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newQueue(logger log.Logger, m *metrics, cfg *config.RemoteWriteConfig, client profilestorepb.ProfileStoreServiceClient, closer io.Closer, tenantHeader string) *queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &queue{
+		logger:       log.With(logger, "remote_name", cfg.Name),
+		metrics:      m,
+		name:         cfg.Name,
+		cfg:          cfg.QueueConfig,
+		relabel:      cfg.WriteRelabelConfigs,
+		timeout:      time.Duration(cfg.RemoteTimeout),
+		headers:      cfg.Headers,
+		tenantHeader: tenantHeader,
+		client:       client,
+		closer:       closer,
+		profiles:     make(chan profile, cfg.QueueConfig.Capacity),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+}
+
+// enqueue buffers the profile, after relabeling its series. It's dropped if
+// the buffer is full.
+func (q *queue) enqueue(p profile) {
+	if len(q.relabel) > 0 {
+		if p.labels = relabel.Process(p.labels, q.relabel...); p.labels == nil {
+			q.metrics.profiles.WithLabelValues(q.name, resultRelabeled).Inc()
+			return
+		}
+	}
+
+	select {
+	case q.profiles <- p:
+		q.metrics.queueLength.WithLabelValues(q.name).Inc()
+	default:
+		q.metrics.profiles.WithLabelValues(q.name, resultDropped).Inc()
+	}
+}
+
+// run sends the buffered profiles until the queue is stopped, and then the
+// rest of them.
+func (q *queue) run() {
+	defer close(q.done)
+	defer q.close()
+
+	deadline := time.NewTimer(time.Duration(q.cfg.BatchSendDeadline))
+	defer deadline.Stop()
+
+	batch := make([]profile, 0, q.cfg.MaxSamplesPerSend)
+	flush := func() {
+		if len(batch) > 0 {
+			q.send(batch)
+			batch = batch[:0]
+		}
+		if !deadline.Stop() {
+			select {
+			case <-deadline.C:
+			default:
+			}
+		}
+		deadline.Reset(time.Duration(q.cfg.BatchSendDeadline))
+	}
+
+	for {
+		select {
+		case p, ok := <-q.profiles:
+			if !ok {
+				flush()
+				return
+			}
+			q.metrics.queueLength.WithLabelValues(q.name).Dec()
+			batch = append(batch, p)
+			if len(batch) >= q.cfg.MaxSamplesPerSend {
+				flush()
+			}
+		case <-deadline.C:
+			deadline.Reset(time.Duration(q.cfg.BatchSendDeadline))
+			if len(batch) > 0 {
+				q.send(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// stop stops queueing profiles and waits for the buffered ones to be sent
+// until the context is done, the rest is dropped.
+func (q *queue) stop(ctx context.Context) {
+	close(q.profiles)
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		q.cancel()
+		<-q.done
+	}
+}
+
+func (q *queue) close() {
+	q.cancel()
+	if q.closer != nil {
+		if err := q.closer.Close(); err != nil {
+			level.Debug(q.logger).Log("msg", "failed to close remote write client", "err", err)
+		}
+	}
+}
+
+// send sends the batch, with a request per tenant and normalization of the
+// profiles.
+func (q *queue) send(batch []profile) {
+	type requestKey struct {
+		tenant     string
+		normalized bool
+	}
+	reqs := map[requestKey]*profilestorepb.WriteRawRequest{}
+	keys := []requestKey{}
+	for _, p := range batch {
+		k := requestKey{tenant: p.tenant, normalized: p.normalized}
+		req, ok := reqs[k]
+		if !ok {
+			req = &profilestorepb.WriteRawRequest{Normalized: p.normalized}
+			reqs[k] = req
+			keys = append(keys, k)
+		}
+		pbls := make([]*profilestorepb.Label, 0, len(p.labels))
+		for _, l := range p.labels {
+			pbls = append(pbls, &profilestorepb.Label{Name: l.Name, Value: l.Value})
+		}
+		req.Series = append(req.Series, &profilestorepb.RawProfileSeries{
+			Labels:      &profilestorepb.LabelSet{Labels: pbls},
+			Samples:     []*profilestorepb.RawSample{p.sample},
+			SampleTypes: p.sampleTypes,
+		})
+	}
+
+	for _, k := range keys {
+		req := reqs[k]
+		result := resultSent
+		if err := q.sendWithRetries(k.tenant, req); err != nil {
+			level.Warn(q.logger).Log("msg", "failed to forward profiles", "profiles", len(req.Series), "err", err)
+			result = resultFailed
+		}
+		q.metrics.profiles.WithLabelValues(q.name, result).Add(float64(len(req.Series)))
+	}
+}
+
+func (q *queue) sendWithRetries(tenant string, req *profilestorepb.WriteRawRequest) error {
+	md := metadata.New(q.headers)
+	md.Set(ForwardedHeader, "true")
+	if q.tenantHeader != "" && tenant != "" {
+		md.Set(q.tenantHeader, tenant)
+	}
+	ctx := metadata.NewOutgoingContext(q.ctx, md)
+
+	backoff := time.Duration(q.cfg.MinBackoff)
+	for attempt := 0; ; attempt++ {
+		reqCtx, cancel := context.WithTimeout(ctx, q.timeout)
+		_, err := q.client.WriteRaw(reqCtx, req)
+		cancel()
+		if err == nil || attempt >= q.cfg.MaxRetries || !retryable(err) {
+			return err
+		}
+		q.metrics.retries.WithLabelValues(q.name).Inc()
+		level.Debug(q.logger).Log("msg", "retrying failed request", "attempt", attempt+1, "err", err)
+
+		select {
+		case <-q.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Duration(q.cfg.MaxBackoff) {
+			backoff = time.Duration(q.cfg.MaxBackoff)
+		}
+	}
+}
+
+// retryable reports whether a failed request may succeed when retried.
+// Requests the endpoint rejected, like invalid or unauthorized ones, fail the
+// same way again.
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
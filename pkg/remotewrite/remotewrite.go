@@ -0,0 +1,263 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotewrite forwards the profiles written to a profile store to
+// other Parca-compatible endpoints, like remote write of Prometheus does for
+// samples. Every endpoint has a queue of its own, so a slow or unavailable
+// endpoint doesn't hold up writes or the other endpoints.
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	commonconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v2"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/config"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// ForwardedHeader is the gRPC metadata marking writes as forwarded by
+// another instance. Forwarded writes aren't forwarded again, so instances
+// can forward to each other without writes going round in circles.
+const ForwardedHeader = "parca-forwarded"
+
+// Dialer creates the client of an endpoint, and what closes it.
+type Dialer func(cfg *config.RemoteWriteConfig) (profilestorepb.ProfileStoreServiceClient, io.Closer, error)
+
+type Option func(*Manager)
+
+// WithTenantHeader sends the tenant of forwarded profiles as the given gRPC
+// metadata, the tenant header of the endpoints.
+func WithTenantHeader(header string) Option {
+	return func(m *Manager) {
+		m.tenantHeader = strings.ToLower(header)
+	}
+}
+
+// WithDialer replaces how the clients of endpoints are created, which is
+// dialing their address with gRPC.
+func WithDialer(d Dialer) Option {
+	return func(m *Manager) {
+		m.dial = d
+	}
+}
+
+// Manager forwards profiles to the endpoints of the applied remote write
+// configs.
+type Manager struct {
+	logger       log.Logger
+	metrics      *metrics
+	dial         Dialer
+	tenantHeader string
+
+	mtx    sync.RWMutex
+	queues map[string]*queue
+	// configs are the YAML encoded configs of the queues, queues of
+	// unchanged configs are kept when a config is applied.
+	configs map[string]string
+}
+
+func NewManager(logger log.Logger, reg prometheus.Registerer, opts ...Option) *Manager {
+	m := &Manager{
+		logger:  log.With(logger, "component", "remote_write"),
+		metrics: newMetrics(reg),
+		dial:    Dial,
+		queues:  map[string]*queue{},
+		configs: map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// ApplyConfig forwards profiles to the endpoints of the given configs from
+// now on. The queues of removed or changed endpoints are stopped, they send
+// the profiles already buffered in the background.
+func (m *Manager) ApplyConfig(cfgs []*config.RemoteWriteConfig) error {
+	r, err := m.PrepareConfig(cfgs)
+	if err != nil {
+		return err
+	}
+	r.Apply()
+	return nil
+}
+
+// PrepareConfig creates the clients of the new endpoints of the given
+// configs, without forwarding to them until the reload is applied. Prepared
+// configs mustn't be interleaved.
+func (m *Manager) PrepareConfig(cfgs []*config.RemoteWriteConfig) (config.Reload, error) {
+	queues := make(map[string]*queue, len(cfgs))
+	configs := make(map[string]string, len(cfgs))
+
+	m.mtx.RLock()
+	for _, cfg := range cfgs {
+		b, err := yaml.Marshal(cfg)
+		if err != nil {
+			m.mtx.RUnlock()
+			return config.Reload{}, fmt.Errorf("failed to encode remote write config %q: %w", cfg.Name, err)
+		}
+		// Secrets are encoded redacted.
+		c := string(b) + string(cfg.BearerToken)
+		configs[cfg.Name] = c
+		if q, ok := m.queues[cfg.Name]; ok && m.configs[cfg.Name] == c {
+			queues[cfg.Name] = q
+		}
+	}
+	m.mtx.RUnlock()
+
+	var created []*queue
+	for _, cfg := range cfgs {
+		if _, ok := queues[cfg.Name]; ok {
+			continue
+		}
+		client, closer, err := m.dial(cfg)
+		if err != nil {
+			for _, q := range created {
+				q.close()
+			}
+			return config.Reload{}, fmt.Errorf("failed to create client of remote write %q: %w", cfg.Name, err)
+		}
+		q := newQueue(m.logger, m.metrics, cfg, client, closer, m.tenantHeader)
+		queues[cfg.Name] = q
+		created = append(created, q)
+	}
+
+	return config.Reload{
+		Apply: func() {
+			m.mtx.Lock()
+			old := m.queues
+			m.queues, m.configs = queues, configs
+			m.mtx.Unlock()
+
+			for _, q := range created {
+				go q.run()
+			}
+			for name, q := range old {
+				if queues[name] != q {
+					go q.stop(context.Background())
+				}
+			}
+		},
+		Discard: func() {
+			for _, q := range created {
+				q.close()
+			}
+		},
+	}, nil
+}
+
+// Forward queues the profile of a series written to the store to be sent to
+// every endpoint. Profiles written by forwarding writes aren't forwarded.
+func (m *Manager) Forward(ctx context.Context, ls labels.Labels, sampleTypes []*profilestorepb.SampleType, sample *profilestorepb.RawSample, normalized bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(ForwardedHeader)) > 0 {
+		return
+	}
+	p := profile{
+		labels:      ls,
+		sampleTypes: sampleTypes,
+		sample:      sample,
+		normalized:  normalized,
+	}
+	p.tenant, _ = tenant.FromContext(ctx)
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	for _, q := range m.queues {
+		q.enqueue(p)
+	}
+}
+
+// Close stops forwarding profiles and sends the profiles buffered, until the
+// context is done.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mtx.Lock()
+	queues := m.queues
+	m.queues, m.configs = map[string]*queue{}, map[string]string{}
+	m.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, q := range queues {
+		wg.Add(1)
+		go func(q *queue) {
+			defer wg.Done()
+			q.stop(ctx)
+		}(q)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Dial creates a gRPC client of the endpoint of the config.
+func Dial(cfg *config.RemoteWriteConfig) (profilestorepb.ProfileStoreServiceClient, io.Closer, error) {
+	opts := []grpc.DialOption{}
+	if cfg.Insecure {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		tlsConfig, err := commonconfig.NewTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+
+	token := string(cfg.BearerToken)
+	if cfg.BearerTokenFile != "" {
+		b, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bearer token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+		if token == "" {
+			return nil, nil, errors.New("bearer token file is empty")
+		}
+	}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: token, insecure: cfg.Insecure}))
+	}
+
+	conn, err := grpc.Dial(cfg.Address, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return profilestorepb.NewProfileStoreServiceClient(conn), conn, nil
+}
+
+type bearerToken struct {
+	token    string
+	insecure bool
+}
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return !t.insecure
+}
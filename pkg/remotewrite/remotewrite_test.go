@@ -0,0 +1,265 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/config"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+type request struct {
+	md  metadata.MD
+	req *profilestorepb.WriteRawRequest
+}
+
+// fakeClient records the requests it receives, failing the first ones with
+// the given errors, nil errors succeed.
+type fakeClient struct {
+	profilestorepb.ProfileStoreServiceClient
+
+	mtx      sync.Mutex
+	errs     []error
+	requests []request
+	closed   bool
+}
+
+func (c *fakeClient) WriteRaw(ctx context.Context, req *profilestorepb.WriteRawRequest, _ ...grpc.CallOption) (*profilestorepb.WriteRawResponse, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.requests = append(c.requests, request{md: md, req: req})
+	return &profilestorepb.WriteRawResponse{}, nil
+}
+
+func (c *fakeClient) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *fakeClient) received() []request {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return append([]request(nil), c.requests...)
+}
+
+// fakeDialer dials fake clients, by the name of the config.
+type fakeDialer struct {
+	mtx     sync.Mutex
+	clients map[string]*fakeClient
+	dials   int
+}
+
+func (d *fakeDialer) dial(cfg *config.RemoteWriteConfig) (profilestorepb.ProfileStoreServiceClient, io.Closer, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.dials++
+	c := &fakeClient{}
+	d.clients[cfg.Name] = c
+	return c, c, nil
+}
+
+func (d *fakeDialer) client(name string) *fakeClient {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.clients[name]
+}
+
+func remoteWriteConfig(name string) *config.RemoteWriteConfig {
+	q := config.DefaultQueueConfig
+	q.BatchSendDeadline = model.Duration(time.Hour)
+	q.MinBackoff = model.Duration(time.Millisecond)
+	q.MaxBackoff = model.Duration(time.Millisecond)
+	return &config.RemoteWriteConfig{
+		Name:          name,
+		Address:       name + ":7070",
+		RemoteTimeout: model.Duration(time.Second),
+		QueueConfig:   q,
+	}
+}
+
+func sample(b string) *profilestorepb.RawSample {
+	return &profilestorepb.RawSample{RawProfile: []byte(b)}
+}
+
+func TestManagerForward(t *testing.T) {
+	t.Parallel()
+
+	d := &fakeDialer{clients: map[string]*fakeClient{}}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), WithDialer(d.dial), WithTenantHeader("X-Scope-OrgID"))
+
+	central := remoteWriteConfig("central")
+	central.Headers = map[string]string{"x-edge": "eu-1"}
+	central.WriteRelabelConfigs = []*relabel.Config{{
+		SourceLabels: model.LabelNames{"job"},
+		Regex:        relabel.MustNewRegexp("debug"),
+		Action:       relabel.Drop,
+	}}
+	require.NoError(t, m.ApplyConfig([]*config.RemoteWriteConfig{central, remoteWriteConfig("replica")}))
+
+	ctx := context.Background()
+	m.Forward(ctx, labels.FromStrings("__name__", "cpu", "job", "api"), nil, sample("a"), false)
+	m.Forward(ctx, labels.FromStrings("__name__", "cpu", "job", "debug"), nil, sample("b"), false)
+	m.Forward(tenant.WithTenant(ctx, "team-a"), labels.FromStrings("__name__", "cpu", "job", "api"), nil, sample("c"), false)
+	m.Forward(ctx, labels.FromStrings("__name__", "cpu", "job", "api"), nil, sample("d"), true)
+
+	// Writes forwarded by other instances aren't forwarded again.
+	forwarded := metadata.NewIncomingContext(ctx, metadata.Pairs(ForwardedHeader, "true"))
+	m.Forward(forwarded, labels.FromStrings("__name__", "cpu", "job", "api"), nil, sample("e"), false)
+
+	require.NoError(t, m.Close(ctx))
+
+	profiles := func(reqs []request) []string {
+		var ps []string
+		for _, r := range reqs {
+			require.Equal(t, []string{"true"}, r.md.Get(ForwardedHeader))
+			for _, s := range r.req.Series {
+				ps = append(ps, string(s.Samples[0].RawProfile))
+			}
+		}
+		return ps
+	}
+
+	reqs := d.client("central").received()
+	require.ElementsMatch(t, []string{"a", "c", "d"}, profiles(reqs))
+	for _, r := range reqs {
+		require.Equal(t, []string{"eu-1"}, r.md.Get("x-edge"))
+		switch string(r.req.Series[0].Samples[0].RawProfile) {
+		case "c":
+			require.Equal(t, []string{"team-a"}, r.md.Get("x-scope-orgid"))
+		case "d":
+			require.True(t, r.req.Normalized)
+		default:
+			require.Empty(t, r.md.Get("x-scope-orgid"))
+			require.False(t, r.req.Normalized)
+		}
+	}
+	require.ElementsMatch(t, []string{"a", "b", "c", "d"}, profiles(d.client("replica").received()))
+
+	require.Equal(t, 3.0, testutil.ToFloat64(m.metrics.profiles.WithLabelValues("central", resultSent)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.metrics.profiles.WithLabelValues("central", resultRelabeled)))
+	require.True(t, d.client("central").closed)
+}
+
+func TestManagerApplyConfig(t *testing.T) {
+	t.Parallel()
+
+	d := &fakeDialer{clients: map[string]*fakeClient{}}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), WithDialer(d.dial))
+	defer m.Close(context.Background())
+
+	a, b := remoteWriteConfig("a"), remoteWriteConfig("b")
+	require.NoError(t, m.ApplyConfig([]*config.RemoteWriteConfig{a, b}))
+	require.Equal(t, 2, d.dials)
+
+	// Unchanged endpoints keep their queue, removed ones send what they
+	// buffered.
+	m.Forward(context.Background(), labels.FromStrings("__name__", "cpu"), nil, sample("a"), false)
+	oldB := d.client("b")
+	b = remoteWriteConfig("b")
+	b.Headers = map[string]string{"x-edge": "eu-1"}
+	require.NoError(t, m.ApplyConfig([]*config.RemoteWriteConfig{remoteWriteConfig("a"), b}))
+	require.Equal(t, 3, d.dials)
+
+	require.Eventually(t, func() bool {
+		return len(oldB.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Discarded configs close the clients they created and leave the
+	// endpoints forwarded to unchanged.
+	r, err := m.PrepareConfig([]*config.RemoteWriteConfig{remoteWriteConfig("c")})
+	require.NoError(t, err)
+	c := d.client("c")
+	r.Discard()
+	c.mtx.Lock()
+	require.True(t, c.closed)
+	c.mtx.Unlock()
+	m.mtx.RLock()
+	require.Len(t, m.queues, 2)
+	m.mtx.RUnlock()
+}
+
+func TestQueueRetries(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(prometheus.NewRegistry())
+	c := &fakeClient{errs: []error{
+		status.Error(codes.Unavailable, "connection refused"),
+		status.Error(codes.ResourceExhausted, "write queue is full"),
+		nil,
+		// Rejected requests aren't retried.
+		status.Error(codes.InvalidArgument, "invalid profile"),
+	}}
+	q := newQueue(log.NewNopLogger(), m, remoteWriteConfig("central"), c, nil, "")
+
+	q.send([]profile{{labels: labels.FromStrings("__name__", "cpu"), sample: sample("a")}})
+	q.send([]profile{{labels: labels.FromStrings("__name__", "cpu"), sample: sample("b")}})
+	q.send([]profile{{labels: labels.FromStrings("__name__", "cpu"), sample: sample("c")}})
+
+	reqs := c.received()
+	require.Len(t, reqs, 2)
+	require.Equal(t, "a", string(reqs[0].req.Series[0].Samples[0].RawProfile))
+	require.Equal(t, "c", string(reqs[1].req.Series[0].Samples[0].RawProfile))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.retries.WithLabelValues("central")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.profiles.WithLabelValues("central", resultSent)))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.profiles.WithLabelValues("central", resultFailed)))
+}
+
+func TestQueueFull(t *testing.T) {
+	t.Parallel()
+
+	m := newMetrics(prometheus.NewRegistry())
+	cfg := remoteWriteConfig("central")
+	cfg.QueueConfig.Capacity = 1
+	q := newQueue(log.NewNopLogger(), m, cfg, &fakeClient{}, nil, "")
+
+	// The queue isn't running, so the first profile fills it up.
+	q.enqueue(profile{labels: labels.FromStrings("__name__", "cpu"), sample: sample("a")})
+	q.enqueue(profile{labels: labels.FromStrings("__name__", "cpu"), sample: sample("b")})
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.queueLength.WithLabelValues("central")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.profiles.WithLabelValues("central", resultDropped)))
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retention deletes the blocks of profile data persisted to object
+// storage once they are older than the retention time or exceed the
+// retention size.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thanos-io/objstore"
+
+	"github.com/parca-dev/parca/pkg/runutil"
+)
+
+// Reasons for deleting blocks.
+const (
+	reasonTime = "time"
+	reasonSize = "size"
+)
+
+// Retention deletes blocks from a bucket laid out like frostdb persists
+// them, as <database>/<table>/<block ULID>/.
+//
+// A block holds the rows inserted from the time of its ULID until it was
+// rotated, which is when the next block of the table was created. A block is
+// deleted once the next block of its table was created before the cutoff.
+// If the blocks are larger than the retention size, the oldest blocks are
+// deleted until they aren't. The newest persisted block of a table is never
+// deleted.
+type Retention struct {
+	logger log.Logger
+	bucket objstore.Bucket
+
+	mtx       sync.Mutex
+	retention time.Duration
+	size      int64
+
+	deletedBlocks *prometheus.CounterVec
+	deletedBytes  *prometheus.CounterVec
+}
+
+func New(logger log.Logger, reg prometheus.Registerer, bucket objstore.Bucket) *Retention {
+	r := &Retention{
+		logger: logger,
+		bucket: bucket,
+		deletedBlocks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_retention_deleted_blocks_total",
+			Help: "Total number of blocks of profile data deleted by reason, which is time or size.",
+		}, []string{"reason"}),
+		deletedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_retention_deleted_bytes_total",
+			Help: "Total number of bytes of the blocks of profile data deleted by reason, which is time or size.",
+		}, []string{"reason"}),
+	}
+	reg.MustRegister(r.deletedBlocks, r.deletedBytes)
+	return r
+}
+
+// ApplyConfig sets the retention time and size, a value of 0 means no limit.
+func (r *Retention) ApplyConfig(retention time.Duration, size int64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.retention = retention
+	r.size = size
+}
+
+// Run applies the retention every interval until the context is canceled.
+func (r *Retention) Run(ctx context.Context, interval time.Duration) error {
+	return runutil.Repeat(interval, ctx.Done(), func() error {
+		if err := r.Apply(ctx, time.Now()); err != nil {
+			// Try again on the next cycle.
+			level.Error(r.logger).Log("msg", "failed to apply retention", "err", err)
+		}
+		return nil
+	})
+}
+
+type block struct {
+	dir  string
+	id   ulid.ULID
+	size int64
+	// next is the time the next block of the table was created at, 0 for
+	// the newest block.
+	next uint64
+}
+
+// Apply deletes the blocks exceeding the retention at now.
+func (r *Retention) Apply(ctx context.Context, now time.Time) error {
+	r.mtx.Lock()
+	retention, size := r.retention, r.size
+	r.mtx.Unlock()
+
+	if retention <= 0 && size <= 0 {
+		return nil
+	}
+
+	blocks, err := r.blocks(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Blocks are deleted oldest first, so that the oldest blocks of all
+	// tables are deleted to get below the retention size.
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].id.Compare(blocks[j].id) < 0
+	})
+	var total int64
+	for _, b := range blocks {
+		total += b.size
+	}
+
+	cutoff := ulid.Timestamp(now.Add(-retention))
+	for _, b := range blocks {
+		if b.next == 0 {
+			continue
+		}
+
+		var reason string
+		switch {
+		case retention > 0 && b.next < cutoff:
+			reason = reasonTime
+		case size > 0 && total > size:
+			reason = reasonSize
+		default:
+			continue
+		}
+
+		if err := r.deleteDir(ctx, b.dir); err != nil {
+			return fmt.Errorf("delete block %s: %w", b.dir, err)
+		}
+		total -= b.size
+		r.deletedBlocks.WithLabelValues(reason).Inc()
+		r.deletedBytes.WithLabelValues(reason).Add(float64(b.size))
+		level.Debug(r.logger).Log("msg", "deleted block", "block", b.dir, "reason", reason)
+	}
+	return nil
+}
+
+// blocks returns the blocks of all tables of all databases.
+func (r *Retention) blocks(ctx context.Context) ([]block, error) {
+	blocks := []block{}
+	err := r.bucket.Iter(ctx, "", func(db string) error {
+		return r.bucket.Iter(ctx, db, func(table string) error {
+			tableBlocks, err := r.tableBlocks(ctx, table)
+			if err != nil {
+				return fmt.Errorf("list blocks of %s: %w", table, err)
+			}
+			blocks = append(blocks, tableBlocks...)
+			return nil
+		})
+	})
+	return blocks, err
+}
+
+func (r *Retention) tableBlocks(ctx context.Context, table string) ([]block, error) {
+	blocks := []block{}
+	if err := r.bucket.Iter(ctx, table, func(dir string) error {
+		id, err := ulid.Parse(path.Base(dir))
+		if err != nil {
+			// Not a block.
+			return nil
+		}
+		size, err := r.dirSize(ctx, dir)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block{dir: dir, id: id, size: size})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].id.Compare(blocks[j].id) < 0
+	})
+	for i := 0; i+1 < len(blocks); i++ {
+		blocks[i].next = blocks[i+1].id.Time()
+	}
+	return blocks, nil
+}
+
+func (r *Retention) dirSize(ctx context.Context, dir string) (int64, error) {
+	var size int64
+	err := r.bucket.Iter(ctx, dir, func(name string) error {
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			n, err := r.dirSize(ctx, name)
+			size += n
+			return err
+		}
+		attrs, err := r.bucket.Attributes(ctx, name)
+		if err != nil {
+			return err
+		}
+		size += attrs.Size
+		return nil
+	})
+	return size, err
+}
+
+func (r *Retention) deleteDir(ctx context.Context, dir string) error {
+	return r.bucket.Iter(ctx, dir, func(name string) error {
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			return r.deleteDir(ctx, name)
+		}
+		return r.bucket.Delete(ctx, name)
+	})
+}
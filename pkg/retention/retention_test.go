@@ -0,0 +1,125 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retention
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+)
+
+func TestRetentionApplyTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	bucket := objstore.NewInMemBucket()
+	blocks := objstore.NewPrefixedBucket(bucket, "blocks")
+
+	upload := func(table string, age time.Duration) string {
+		id := ulid.MustNew(ulid.Timestamp(now.Add(-age)), nil)
+		dir := path.Join("parca", table, id.String())
+		require.NoError(t, blocks.Upload(ctx, path.Join(dir, "data.parquet"), bytes.NewReader([]byte("data"))))
+		return dir
+	}
+
+	oldest := upload("stacktraces", 3*time.Hour)
+	old := upload("stacktraces", 2*time.Hour)
+	recent := upload("stacktraces", time.Hour)
+	newest := upload("stacktraces", 10*time.Minute)
+	// The only block of a table can still receive rows and is kept.
+	only := upload("rollups", 3*time.Hour)
+
+	reg := prometheus.NewRegistry()
+	r := New(log.NewNopLogger(), reg, blocks)
+	r.ApplyConfig(90*time.Minute, 0)
+	require.NoError(t, r.Apply(ctx, now))
+
+	exists := func(dir string) bool {
+		ok, err := blocks.Exists(ctx, path.Join(dir, "data.parquet"))
+		require.NoError(t, err)
+		return ok
+	}
+	require.False(t, exists(oldest))
+	// Rows were inserted into it until the recent block was created, which
+	// is within the retention.
+	require.True(t, exists(old))
+	require.True(t, exists(recent))
+	require.True(t, exists(newest))
+	require.True(t, exists(only))
+	require.Equal(t, 1.0, testutil.ToFloat64(r.deletedBlocks.WithLabelValues(reasonTime)))
+	require.Equal(t, 4.0, testutil.ToFloat64(r.deletedBytes.WithLabelValues(reasonTime)))
+
+	require.NoError(t, r.Apply(ctx, now.Add(time.Hour)))
+	require.False(t, exists(old))
+	require.True(t, exists(recent))
+	require.True(t, exists(newest))
+	require.True(t, exists(only))
+	require.Equal(t, 2.0, testutil.ToFloat64(r.deletedBlocks.WithLabelValues(reasonTime)))
+}
+
+func TestRetentionApplySize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	blocks := objstore.NewInMemBucket()
+
+	upload := func(table string, age time.Duration) string {
+		id := ulid.MustNew(ulid.Timestamp(now.Add(-age)), nil)
+		dir := path.Join("parca", table, id.String())
+		require.NoError(t, blocks.Upload(ctx, path.Join(dir, "data.parquet"), bytes.NewReader(make([]byte, 100))))
+		return dir
+	}
+
+	a1 := upload("stacktraces", 3*time.Hour)
+	b1 := upload("rollups", 150*time.Minute)
+	a2 := upload("stacktraces", 2*time.Hour)
+	a3 := upload("stacktraces", time.Hour)
+	b2 := upload("rollups", 30*time.Minute)
+
+	r := New(log.NewNopLogger(), prometheus.NewRegistry(), blocks)
+	r.ApplyConfig(0, 250)
+	require.NoError(t, r.Apply(ctx, now))
+
+	exists := func(dir string) bool {
+		ok, err := blocks.Exists(ctx, path.Join(dir, "data.parquet"))
+		require.NoError(t, err)
+		return ok
+	}
+	// The oldest blocks of all tables are deleted until the size is below
+	// the retention size.
+	require.False(t, exists(a1))
+	require.False(t, exists(b1))
+	require.False(t, exists(a2))
+	require.True(t, exists(a3))
+	require.True(t, exists(b2))
+	require.Equal(t, 3.0, testutil.ToFloat64(r.deletedBlocks.WithLabelValues(reasonSize)))
+	require.Equal(t, 300.0, testutil.ToFloat64(r.deletedBytes.WithLabelValues(reasonSize)))
+
+	// The newest block of each table is kept regardless.
+	r.ApplyConfig(0, 1)
+	require.NoError(t, r.Apply(ctx, now))
+	require.True(t, exists(a3))
+	require.True(t, exists(b2))
+}
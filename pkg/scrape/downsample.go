@@ -0,0 +1,107 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrape
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// RemainderFunctionName is the name of the function of the synthetic stack
+// that holds the values of the samples dropped from scraped profiles.
+const RemainderFunctionName = "[other stacks]"
+
+// limitProfileSamples reduces a profile to at most max samples. The samples
+// with the highest values are kept and the values of all others are added up
+// in a single sample of a synthetic stack, so the totals of the profile are
+// preserved. Profiles within the limit are returned as they are.
+func limitProfileSamples(b []byte, max int) ([]byte, bool, error) {
+	p, err := profile.ParseData(b)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(p.Sample) <= max {
+		return b, false, nil
+	}
+
+	idx := defaultSampleIndex(p)
+	sort.SliceStable(p.Sample, func(i, j int) bool {
+		return abs(p.Sample[i].Value[idx]) > abs(p.Sample[j].Value[idx])
+	})
+
+	var maxFunctionID, maxLocationID uint64
+	for _, fn := range p.Function {
+		if fn.ID > maxFunctionID {
+			maxFunctionID = fn.ID
+		}
+	}
+	for _, loc := range p.Location {
+		if loc.ID > maxLocationID {
+			maxLocationID = loc.ID
+		}
+	}
+
+	fn := &profile.Function{
+		ID:         maxFunctionID + 1,
+		Name:       RemainderFunctionName,
+		SystemName: RemainderFunctionName,
+	}
+	loc := &profile.Location{
+		ID:   maxLocationID + 1,
+		Line: []profile.Line{{Function: fn}},
+	}
+	remainder := &profile.Sample{
+		Location: []*profile.Location{loc},
+		Value:    make([]int64, len(p.SampleType)),
+	}
+	for _, s := range p.Sample[max-1:] {
+		for i, v := range s.Value {
+			remainder.Value[i] += v
+		}
+	}
+
+	p.Function = append(p.Function, fn)
+	p.Location = append(p.Location, loc)
+	p.Sample = append(p.Sample[:max-1], remainder)
+
+	// Compacting drops the locations and functions only the removed samples
+	// referenced.
+	p = p.Compact()
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// defaultSampleIndex returns the index of the sample type samples are ranked
+// by, which like in pprof is the last unless the profile says otherwise.
+func defaultSampleIndex(p *profile.Profile) int {
+	for i, st := range p.SampleType {
+		if st.Type == p.DefaultSampleType {
+			return i
+		}
+	}
+	return len(p.SampleType) - 1
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
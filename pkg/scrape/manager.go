@@ -29,6 +29,20 @@ import (
 	"github.com/parca-dev/parca/pkg/config"
 )
 
+// ManagerOption configures a Manager.
+type ManagerOption func(*Manager)
+
+// WithMaxConcurrentScrapes bounds the number of scrapes of all scrape pools
+// running at the same time, unbounded if n is 0. The max_concurrent_scrapes
+// of scrape configs bound the scrapes of their job further.
+func WithMaxConcurrentScrapes(n int) ManagerOption {
+	return func(m *Manager) {
+		if n > 0 {
+			m.scrapeSem = make(chan struct{}, n)
+		}
+	}
+}
+
 // NewManager is the Manager constructor.
 func NewManager(
 	logger log.Logger,
@@ -36,6 +50,7 @@ func NewManager(
 	store profilepb.ProfileStoreServiceServer,
 	scrapeConfigs []*config.ScrapeConfig,
 	externalLabels labels.Labels,
+	opts ...ManagerOption,
 ) *Manager {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -74,6 +89,11 @@ func NewManager(
 				Name: "parca_target_scrape_pool_sync_total",
 				Help: "Total number of syncs that were executed on a scrape pool.",
 			}, []string{"scrape_job"}),
+		targetScrapesFailed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "parca_target_scrapes_failed_total",
+				Help: "Total number of scrapes that failed, including scrapes that timed out.",
+			}, []string{"scrape_job"}),
 		targetScrapeSampleLimit: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "parca_target_scrapes_exceeded_sample_limit_total",
@@ -95,12 +115,16 @@ func NewManager(
 				Help: "Total number of samples rejected due to timestamp falling outside of the time bounds",
 			}),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
 
 	reg.MustRegister(
 		m.targetIntervalLength,
 		m.targetReloadIntervalLength,
 		m.targetSyncIntervalLength,
 		m.targetScrapePoolSyncsCounter,
+		m.targetScrapesFailed,
 		m.targetScrapeSampleLimit,
 		m.targetScrapeSampleDuplicate,
 		m.targetScrapeSampleOutOfOrder,
@@ -127,6 +151,10 @@ type Manager struct {
 
 	externalLabels labels.Labels
 
+	// scrapeSem bounds the number of concurrent scrapes of all scrape
+	// pools, it is nil if the number of concurrent scrapes is unbounded.
+	scrapeSem chan struct{}
+
 	mtxScrape     sync.Mutex // Guards the fields below.
 	scrapeConfigs map[string]*config.ScrapeConfig
 	scrapePools   map[string]*scrapePool
@@ -138,6 +166,7 @@ type Manager struct {
 	targetReloadIntervalLength    *prometheus.SummaryVec
 	targetSyncIntervalLength      *prometheus.SummaryVec
 	targetScrapePoolSyncsCounter  *prometheus.CounterVec
+	targetScrapesFailed           *prometheus.CounterVec
 	targetScrapeSampleLimit       prometheus.Counter
 	targetScrapeSampleDuplicate   prometheus.Counter
 	targetScrapeSampleOutOfOrder  prometheus.Counter
@@ -195,11 +224,12 @@ func (m *Manager) reload() {
 				level.Error(m.logger).Log("msg", "error reloading target set", "err", "invalid config id:"+setName)
 				return
 			}
-			sp = newScrapePool(scrapeConfig, m.store, log.With(m.logger, "scrape_pool", setName), m.externalLabels, &scrapePoolMetrics{
+			sp = newScrapePool(scrapeConfig, m.store, log.With(m.logger, "scrape_pool", setName), m.externalLabels, m.scrapeSem, &scrapePoolMetrics{
 				targetIntervalLength:          m.targetIntervalLength,
 				targetReloadIntervalLength:    m.targetReloadIntervalLength,
 				targetSyncIntervalLength:      m.targetSyncIntervalLength,
 				targetScrapePoolSyncsCounter:  m.targetScrapePoolSyncsCounter,
+				targetScrapesFailed:           m.targetScrapesFailed,
 				targetScrapeSampleLimit:       m.targetScrapeSampleLimit,
 				targetScrapeSampleDuplicate:   m.targetScrapeSampleDuplicate,
 				targetScrapeSampleOutOfOrder:  m.targetScrapeSampleOutOfOrder,
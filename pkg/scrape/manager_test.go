@@ -0,0 +1,101 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/stretchr/testify/require"
+
+	"github.com/parca-dev/parca/pkg/config"
+)
+
+func TestManagerFileDiscovery(t *testing.T) {
+	t.Parallel()
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		_, _ = w.Write([]byte("pprof"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	sdFile := filepath.Join(t.TempDir(), "targets.json")
+	writeTargets := func(content string) {
+		tmp := sdFile + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o644))
+		require.NoError(t, os.Rename(tmp, sdFile))
+	}
+	writeTargets(`[]`)
+
+	cfg := testScrapeConfig(t, fmt.Sprintf(`  file_sd_configs:
+  - files: [%q]
+  relabel_configs:
+  - source_labels: [team]
+    target_label: owner
+`, sdFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	discoveryManager := discovery.NewManager(ctx, log.NewNopLogger())
+	require.NoError(t, discoveryManager.ApplyConfig(map[string]discovery.Configs{
+		cfg.JobName: cfg.ServiceDiscoveryConfigs,
+	}))
+	go func() { _ = discoveryManager.Run() }()
+
+	store := &recordingStore{}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), store, nil, nil)
+	require.NoError(t, m.ApplyConfig([]*config.ScrapeConfig{cfg}))
+	go func() { _ = m.Run(discoveryManager.SyncCh()) }()
+	defer m.Stop()
+
+	writeTargets(fmt.Sprintf(`[{"targets": [%q], "labels": {"team": "a"}}]`, u.Host))
+
+	require.Eventually(t, func() bool {
+		return len(store.Requests()) > 0
+	}, 30*time.Second, 100*time.Millisecond)
+
+	ls := map[string]string{}
+	for _, l := range store.Requests()[0].Series[0].Labels.Labels {
+		ls[l.Name] = l.Value
+	}
+	require.Equal(t, "a", ls["owner"])
+
+	writeTargets(`[]`)
+
+	require.Eventually(t, func() bool {
+		return len(m.TargetsActive()[cfg.JobName]) == 0
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// Once the target is gone its scrape loop must have been stopped.
+	before := atomic.LoadInt64(&hits)
+	time.Sleep(2 * time.Second)
+	require.Equal(t, before, atomic.LoadInt64(&hits))
+}
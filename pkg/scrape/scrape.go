@@ -35,6 +35,7 @@ import (
 
 	profilepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
 	"github.com/parca-dev/parca/pkg/config"
+	"github.com/parca-dev/parca/pkg/profilestore"
 )
 
 // scrapePool manages scrapes for sets of targets.
@@ -52,6 +53,12 @@ type scrapePool struct {
 	droppedTargets []*Target
 	loops          map[uint64]loop
 	cancel         context.CancelFunc
+	// scrapeSem bounds the number of concurrent scrapes of the pool, it is
+	// nil if the number of concurrent scrapes is unbounded.
+	scrapeSem chan struct{}
+	// managerSem bounds the number of concurrent scrapes of all pools of
+	// the manager, it is nil if unbounded.
+	managerSem chan struct{}
 
 	// Constructor for new scrape loops. This is settable for testing convenience.
 	newLoop func(*Target, scraper) loop
@@ -62,6 +69,7 @@ type scrapePoolMetrics struct {
 	targetReloadIntervalLength    *prometheus.SummaryVec
 	targetSyncIntervalLength      *prometheus.SummaryVec
 	targetScrapePoolSyncsCounter  *prometheus.CounterVec
+	targetScrapesFailed           *prometheus.CounterVec
 	targetScrapeSampleLimit       prometheus.Counter
 	targetScrapeSampleDuplicate   prometheus.Counter
 	targetScrapeSampleOutOfOrder  prometheus.Counter
@@ -73,6 +81,7 @@ func newScrapePool(
 	store profilepb.ProfileStoreServiceServer,
 	logger log.Logger,
 	externalLabels labels.Labels,
+	managerSem chan struct{},
 	metrics *scrapePoolMetrics,
 ) *scrapePool {
 	if logger == nil {
@@ -97,6 +106,8 @@ func newScrapePool(
 		loops:         map[uint64]loop{},
 		logger:        logger,
 		metrics:       metrics,
+		scrapeSem:     newScrapeSemaphore(cfg),
+		managerSem:    managerSem,
 	}
 	sp.newLoop = func(t *Target, s scraper) loop {
 		return newScrapeLoop(
@@ -106,6 +117,9 @@ func newScrapePool(
 			log.With(logger, "target", t),
 			externalLabels,
 			sp.metrics.targetIntervalLength,
+			sp.metrics.targetScrapesFailed.WithLabelValues(cfg.JobName),
+			scrapeSemaphores(sp.scrapeSem, sp.managerSem),
+			sp.config.MaxProfileSamples,
 			buffers,
 			store,
 		)
@@ -114,6 +128,25 @@ func newScrapePool(
 	return sp
 }
 
+// scrapeSemaphores returns the semaphores that are set, in the order to
+// acquire them in.
+func scrapeSemaphores(sems ...chan struct{}) []chan struct{} {
+	var set []chan struct{}
+	for _, sem := range sems {
+		if sem != nil {
+			set = append(set, sem)
+		}
+	}
+	return set
+}
+
+func newScrapeSemaphore(cfg *config.ScrapeConfig) chan struct{} {
+	if cfg.MaxConcurrentScrapes <= 0 {
+		return nil
+	}
+	return make(chan struct{}, cfg.MaxConcurrentScrapes)
+}
+
 func (sp *scrapePool) ActiveTargets() []*Target {
 	sp.mtx.Lock()
 	defer sp.mtx.Unlock()
@@ -169,33 +202,38 @@ func (sp *scrapePool) reload(cfg *config.ScrapeConfig) {
 	}
 	sp.config = cfg
 	sp.client = client
+	sp.scrapeSem = newScrapeSemaphore(cfg)
 
 	var (
-		wg       sync.WaitGroup
-		interval = time.Duration(sp.config.ScrapeInterval)
-		timeout  = time.Duration(sp.config.ScrapeTimeout)
+		wg              sync.WaitGroup
+		defaultInterval = time.Duration(sp.config.ScrapeInterval)
+		defaultTimeout  = time.Duration(sp.config.ScrapeTimeout)
 	)
 
 	for fp, oldLoop := range sp.loops {
+		t := sp.activeTargets[fp]
+		interval, timeout, err := t.intervalAndTimeout(defaultInterval, defaultTimeout)
+		if err != nil {
+			level.Error(sp.logger).Log("msg", "invalid target scrape interval or timeout, using defaults", "target", t, "err", err)
+		}
 		var (
-			t       = sp.activeTargets[fp]
 			s       = &targetScraper{Target: t, logger: sp.logger, client: sp.client, timeout: timeout}
 			newLoop = sp.newLoop(t, s)
 		)
 		wg.Add(1)
 
-		go func(oldLoop, newLoop loop) {
+		go func(oldLoop, newLoop loop, interval, timeout time.Duration) {
 			oldLoop.stop()
 			wg.Done()
 
 			go newLoop.run(interval, timeout, nil)
-		}(oldLoop, newLoop)
+		}(oldLoop, newLoop, interval, timeout)
 
 		sp.loops[fp] = newLoop
 	}
 
 	wg.Wait()
-	sp.metrics.targetReloadIntervalLength.WithLabelValues(interval.String()).Observe(
+	sp.metrics.targetReloadIntervalLength.WithLabelValues(defaultInterval.String()).Observe(
 		time.Since(start).Seconds(),
 	)
 }
@@ -240,9 +278,9 @@ func (sp *scrapePool) sync(targets []*Target) {
 	defer sp.mtx.Unlock()
 
 	var (
-		uniqueTargets = map[uint64]struct{}{}
-		interval      = time.Duration(sp.config.ScrapeInterval)
-		timeout       = time.Duration(sp.config.ScrapeTimeout)
+		uniqueTargets   = map[uint64]struct{}{}
+		defaultInterval = time.Duration(sp.config.ScrapeInterval)
+		defaultTimeout  = time.Duration(sp.config.ScrapeTimeout)
 	)
 
 	for _, t := range targets {
@@ -251,6 +289,11 @@ func (sp *scrapePool) sync(targets []*Target) {
 		uniqueTargets[hash] = struct{}{}
 
 		if _, ok := sp.activeTargets[hash]; !ok {
+			interval, timeout, err := t.intervalAndTimeout(defaultInterval, defaultTimeout)
+			if err != nil {
+				level.Error(sp.logger).Log("msg", "invalid target scrape interval or timeout, using defaults", "target", t, "err", err)
+			}
+
 			s := &targetScraper{Target: t, client: sp.client, timeout: timeout, logger: sp.logger}
 			l := sp.newLoop(t, s)
 
@@ -357,6 +400,12 @@ type scrapeLoop struct {
 	scraper        scraper
 	l              log.Logger
 	intervalLength *prometheus.SummaryVec
+	scrapesFailed  prometheus.Counter
+	// scrapeSems are acquired for each scrape, the semaphore of the pool
+	// before the semaphore of the manager, so scrapes waiting for a slot of
+	// their pool don't hold slots of the manager.
+	scrapeSems     []chan struct{}
+	maxSamples     int
 	lastScrapeSize int
 	externalLabels labels.Labels
 
@@ -375,6 +424,9 @@ func newScrapeLoop(ctx context.Context,
 	l log.Logger,
 	externalLabels labels.Labels,
 	targetIntervalLength *prometheus.SummaryVec,
+	targetScrapesFailed prometheus.Counter,
+	scrapeSems []chan struct{},
+	maxSamples int,
 	buffers *pool.Pool,
 	store profilepb.ProfileStoreServiceServer,
 ) *scrapeLoop {
@@ -393,6 +445,9 @@ func newScrapeLoop(ctx context.Context,
 		l:              l,
 		externalLabels: externalLabels,
 		intervalLength: targetIntervalLength,
+		scrapesFailed:  targetScrapesFailed,
+		scrapeSems:     scrapeSems,
+		maxSamples:     maxSamples,
 		ctx:            ctx,
 	}
 	sl.scrapeCtx, sl.cancel = context.WithCancel(ctx)
@@ -400,6 +455,27 @@ func newScrapeLoop(ctx context.Context,
 	return sl
 }
 
+// acquire acquires the slots of all semaphores of the loop, it returns false
+// without holding any slot if the loop is stopped meanwhile.
+func (sl *scrapeLoop) acquire() bool {
+	for i, sem := range sl.scrapeSems {
+		select {
+		case sem <- struct{}{}:
+		case <-sl.scrapeCtx.Done():
+			sl.release(i)
+			return false
+		}
+	}
+	return true
+}
+
+// release releases the slots of the first n semaphores of the loop.
+func (sl *scrapeLoop) release(n int) {
+	for _, sem := range sl.scrapeSems[:n] {
+		<-sem
+	}
+}
+
 func (sl *scrapeLoop) run(interval, timeout time.Duration, errc chan<- error) {
 	select {
 	case <-time.After(sl.scraper.offset(interval)):
@@ -445,10 +521,19 @@ mainLoop:
 			}
 		}
 
+		// Wait for free slots if the number of concurrent scrapes is
+		// bounded, the timeout only applies to the scrape itself.
+		if !sl.acquire() {
+			sl.buffers.Put(b)
+			break mainLoop
+		}
+
 		scrapeCtx, cancel := context.WithTimeout(sl.ctx, timeout)
 		scrapeErr := sl.scraper.scrape(scrapeCtx, buf, profileType)
 		cancel()
 
+		sl.release(len(sl.scrapeSems))
+
 		if scrapeErr == nil {
 			b = buf.Bytes()
 			// NOTE: There were issues with misbehaving clients in the past
@@ -458,6 +543,19 @@ mainLoop:
 				sl.lastScrapeSize = len(b)
 			}
 
+			raw := b
+			if sl.maxSamples > 0 {
+				limited, ok, err := limitProfileSamples(b, sl.maxSamples)
+				switch {
+				case err != nil:
+					// The profile store rejects what can't be parsed
+					// anyway, it's stored as is.
+					level.Debug(sl.l).Log("msg", "failed to parse scraped profile to limit its samples", "err", err)
+				case ok:
+					raw = limited
+				}
+			}
+
 			tl := sl.target.Labels()
 			tl = append(tl, labels.Label{Name: "__name__", Value: profileType})
 			for _, l := range sl.externalLabels {
@@ -480,14 +578,14 @@ mainLoop:
 				})
 			}
 
-			_, err := sl.store.WriteRaw(sl.ctx, &profilepb.WriteRawRequest{
+			_, err := sl.store.WriteRaw(profilestore.WithIngestSource(sl.ctx, profilestore.IngestSourceScrape), &profilepb.WriteRawRequest{
 				Tenant: "",
 				Series: []*profilepb.RawProfileSeries{
 					{
 						Labels: protolbls,
 						Samples: []*profilepb.RawSample{
 							{
-								RawProfile: buf.Bytes(),
+								RawProfile: raw,
 							},
 						},
 					},
@@ -502,24 +600,31 @@ mainLoop:
 				}
 			}
 
+			sl.target.mtx.Lock()
 			sl.target.health = HealthGood
 			sl.target.lastScrapeDuration = time.Since(start)
 			sl.target.lastError = nil
+			sl.target.mtx.Unlock()
 		} else {
 			level.Debug(sl.l).Log("msg", "Scrape failed", "err", scrapeErr.Error())
+			sl.scrapesFailed.Inc()
 			if errc != nil {
 				errc <- scrapeErr
 			}
 
+			sl.target.mtx.Lock()
 			sl.target.health = HealthBad
 			sl.target.lastScrapeDuration = time.Since(start)
 			sl.target.lastError = scrapeErr
+			sl.target.mtx.Unlock()
 		}
 
 		sl.buffers.Put(b)
 		last = start
 
+		sl.target.mtx.Lock()
 		sl.target.lastScrape = last
+		sl.target.mtx.Unlock()
 
 		select {
 		case <-sl.ctx.Done():
@@ -0,0 +1,512 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/stretchr/testify/require"
+
+	profilepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/config"
+	"github.com/parca-dev/parca/pkg/profilestore"
+)
+
+// recordingStore is a ProfileStoreServiceServer that keeps every request it
+// receives in memory.
+type recordingStore struct {
+	profilepb.UnimplementedProfileStoreServiceServer
+
+	mtx      sync.Mutex
+	requests []*profilepb.WriteRawRequest
+	sources  []string
+}
+
+func (s *recordingStore) WriteRaw(ctx context.Context, req *profilepb.WriteRawRequest) (*profilepb.WriteRawResponse, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.requests = append(s.requests, req)
+	s.sources = append(s.sources, profilestore.IngestSourceFromContext(ctx))
+	return &profilepb.WriteRawResponse{}, nil
+}
+
+func (s *recordingStore) Requests() []*profilepb.WriteRawRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*profilepb.WriteRawRequest{}, s.requests...)
+}
+
+func testScrapeConfig(t *testing.T, extra string) *config.ScrapeConfig {
+	t.Helper()
+
+	cfg, err := config.Load(fmt.Sprintf(`
+scrape_configs:
+- job_name: test
+  scrape_interval: 1s
+  profiling_config:
+    pprof_config:
+      memory:
+        enabled: true
+      block:
+        enabled: false
+      goroutine:
+        enabled: false
+      mutex:
+        enabled: false
+      process_cpu:
+        enabled: false
+%s`, extra))
+	require.NoError(t, err)
+	return cfg.ScrapeConfigs[0]
+}
+
+func testScrapePoolMetrics(reg prometheus.Registerer) *scrapePoolMetrics {
+	m := NewManager(log.NewNopLogger(), reg, nil, nil, nil)
+	return &scrapePoolMetrics{
+		targetIntervalLength:          m.targetIntervalLength,
+		targetReloadIntervalLength:    m.targetReloadIntervalLength,
+		targetSyncIntervalLength:      m.targetSyncIntervalLength,
+		targetScrapePoolSyncsCounter:  m.targetScrapePoolSyncsCounter,
+		targetScrapesFailed:           m.targetScrapesFailed,
+		targetScrapeSampleLimit:       m.targetScrapeSampleLimit,
+		targetScrapeSampleDuplicate:   m.targetScrapeSampleDuplicate,
+		targetScrapeSampleOutOfOrder:  m.targetScrapeSampleOutOfOrder,
+		targetScrapeSampleOutOfBounds: m.targetScrapeSampleOutOfBounds,
+	}
+}
+
+func targetGroup(t *testing.T, serverURL string) *targetgroup.Group {
+	t.Helper()
+
+	u, err := url.Parse(serverURL)
+	require.NoError(t, err)
+
+	return &targetgroup.Group{
+		Source:  "test",
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(u.Host)}},
+	}
+}
+
+func TestScrapePoolStoresScrapedProfiles(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/pprof/allocs" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("pprof"))
+	}))
+	defer srv.Close()
+
+	store := &recordingStore{}
+	sp := newScrapePool(
+		testScrapeConfig(t, ""),
+		store,
+		log.NewNopLogger(),
+		nil,
+		nil,
+		testScrapePoolMetrics(prometheus.NewRegistry()),
+	)
+	defer sp.stop()
+
+	sp.Sync([]*targetgroup.Group{targetGroup(t, srv.URL)})
+
+	require.Eventually(t, func() bool {
+		return len(store.Requests()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	req := store.Requests()[0]
+	require.Len(t, req.Series, 1)
+	require.Equal(t, []byte("pprof"), req.Series[0].Samples[0].RawProfile)
+
+	ls := map[string]string{}
+	for _, l := range req.Series[0].Labels.Labels {
+		ls[l.Name] = l.Value
+	}
+	require.Equal(t, "memory", ls[model.MetricNameLabel])
+	require.Equal(t, "test", ls[model.JobLabel])
+
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	require.Equal(t, profilestore.IngestSourceScrape, store.sources[0])
+}
+
+func TestScrapePoolCountsFailures(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	store := &recordingStore{}
+	metrics := testScrapePoolMetrics(prometheus.NewRegistry())
+	sp := newScrapePool(
+		testScrapeConfig(t, ""),
+		store,
+		log.NewNopLogger(),
+		nil,
+		nil,
+		metrics,
+	)
+	defer sp.stop()
+
+	sp.Sync([]*targetgroup.Group{targetGroup(t, srv.URL)})
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.targetScrapesFailed.WithLabelValues("test")) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, store.Requests())
+}
+
+func TestScrapePoolTimesOutSlowTargets(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	store := &recordingStore{}
+	metrics := testScrapePoolMetrics(prometheus.NewRegistry())
+	sp := newScrapePool(
+		testScrapeConfig(t, "  scrape_timeout: 100ms\n"),
+		store,
+		log.NewNopLogger(),
+		nil,
+		nil,
+		metrics,
+	)
+	defer sp.stop()
+
+	sp.Sync([]*targetgroup.Group{targetGroup(t, srv.URL)})
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.targetScrapesFailed.WithLabelValues("test")) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Empty(t, store.Requests())
+
+	targets := sp.ActiveTargets()
+	require.Len(t, targets, 1)
+	require.Eventually(t, func() bool {
+		return targets[0].Health() == HealthBad
+	}, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, targets[0].LastError(), context.DeadlineExceeded)
+}
+
+func TestScrapePoolBoundsConcurrentScrapes(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx            sync.Mutex
+		inflight, peak int
+		scrapes        int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mtx.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mtx.Lock()
+		inflight--
+		scrapes++
+		mtx.Unlock()
+
+		_, _ = w.Write([]byte("pprof"))
+	}))
+	defer srv.Close()
+
+	store := &recordingStore{}
+	sp := newScrapePool(
+		testScrapeConfig(t, "  max_concurrent_scrapes: 2\n"),
+		store,
+		log.NewNopLogger(),
+		nil,
+		nil,
+		testScrapePoolMetrics(prometheus.NewRegistry()),
+	)
+	defer sp.stop()
+
+	tg := targetGroup(t, srv.URL)
+	tg.Targets = nil
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		tg.Targets = append(tg.Targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(u.Host),
+			"replica":          model.LabelValue(fmt.Sprint(i)),
+		})
+	}
+	sp.Sync([]*targetgroup.Group{tg})
+	require.Len(t, sp.ActiveTargets(), 6)
+
+	require.Eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return scrapes >= 6
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.LessOrEqual(t, peak, 2)
+}
+
+func TestScrapePoolsShareManagerConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mtx            sync.Mutex
+		inflight, peak int
+		scrapes        int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mtx.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mtx.Lock()
+		inflight--
+		scrapes++
+		mtx.Unlock()
+
+		_, _ = w.Write([]byte("pprof"))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	// Each pool allows more scrapes than the manager does in total.
+	managerSem := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		sp := newScrapePool(
+			testScrapeConfig(t, "  max_concurrent_scrapes: 3\n"),
+			&recordingStore{},
+			log.NewNopLogger(),
+			nil,
+			managerSem,
+			testScrapePoolMetrics(prometheus.NewRegistry()),
+		)
+		defer sp.stop()
+
+		tg := targetGroup(t, srv.URL)
+		tg.Targets = nil
+		for j := 0; j < 3; j++ {
+			tg.Targets = append(tg.Targets, model.LabelSet{
+				model.AddressLabel: model.LabelValue(u.Host),
+				"replica":          model.LabelValue(fmt.Sprint(j)),
+			})
+		}
+		sp.Sync([]*targetgroup.Group{tg})
+	}
+
+	require.Eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return scrapes >= 6
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.LessOrEqual(t, peak, 2)
+}
+
+// largeProfile returns a profile of n samples of distinct stacks, the value
+// of the i-th sample is i+1.
+func largeProfile(t *testing.T, n int) []byte {
+	t.Helper()
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+		PeriodType: &profile.ValueType{Type: "space", Unit: "bytes"},
+		Period:     1,
+	}
+	for i := 0; i < n; i++ {
+		fn := &profile.Function{ID: uint64(i + 1), Name: fmt.Sprintf("func%d", i)}
+		loc := &profile.Location{ID: uint64(i + 1), Address: uint64(0x1000 + i), Line: []profile.Line{{Function: fn}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{loc},
+			Value:    []int64{int64(i + 1), int64(i+1) * 10},
+		})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf))
+	return buf.Bytes()
+}
+
+func profileTotals(p *profile.Profile) []int64 {
+	totals := make([]int64, len(p.SampleType))
+	for _, s := range p.Sample {
+		for i, v := range s.Value {
+			totals[i] += v
+		}
+	}
+	return totals
+}
+
+func TestScrapePoolLimitsProfileSamples(t *testing.T) {
+	t.Parallel()
+
+	b := largeProfile(t, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	store := &recordingStore{}
+	sp := newScrapePool(
+		testScrapeConfig(t, "  max_profile_samples: 10\n"),
+		store,
+		log.NewNopLogger(),
+		nil,
+		nil,
+		testScrapePoolMetrics(prometheus.NewRegistry()),
+	)
+	defer sp.stop()
+
+	sp.Sync([]*targetgroup.Group{targetGroup(t, srv.URL)})
+
+	require.Eventually(t, func() bool {
+		return len(store.Requests()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	original, err := profile.ParseData(b)
+	require.NoError(t, err)
+	limited, err := profile.ParseData(store.Requests()[0].Series[0].Samples[0].RawProfile)
+	require.NoError(t, err)
+
+	require.Len(t, limited.Sample, 10)
+	require.Equal(t, profileTotals(original), profileTotals(limited))
+
+	// The nine samples with the highest values are kept, the others make up
+	// the remainder.
+	for i, s := range limited.Sample[:9] {
+		require.Equal(t, fmt.Sprintf("func%d", 99-i), s.Location[0].Line[0].Function.Name)
+	}
+	remainder := limited.Sample[9]
+	require.Equal(t, RemainderFunctionName, remainder.Location[0].Line[0].Function.Name)
+	require.Equal(t, []int64{91 * 92 / 2, 91 * 92 / 2 * 10}, remainder.Value)
+	require.Len(t, limited.Function, 10)
+}
+
+func TestLimitProfileSamplesWithinLimit(t *testing.T) {
+	t.Parallel()
+
+	b := largeProfile(t, 10)
+	limited, ok, err := limitProfileSamples(b, 10)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, b, limited)
+
+	_, _, err = limitProfileSamples([]byte("pprof"), 10)
+	require.Error(t, err)
+}
+
+func TestTargetIntervalAndTimeoutOverrides(t *testing.T) {
+	t.Parallel()
+
+	cfg := testScrapeConfig(t, "  scrape_timeout: 500ms\n")
+
+	tests := map[string]struct {
+		labels   model.LabelSet
+		interval time.Duration
+		timeout  time.Duration
+		err      bool
+	}{
+		"defaults": {
+			labels:   model.LabelSet{},
+			interval: time.Second,
+			timeout:  500 * time.Millisecond,
+		},
+		"override": {
+			labels: model.LabelSet{
+				model.ScrapeIntervalLabel: "30s",
+				model.ScrapeTimeoutLabel:  "20s",
+			},
+			interval: 30 * time.Second,
+			timeout:  20 * time.Second,
+		},
+		"timeout greater than interval": {
+			labels: model.LabelSet{
+				model.ScrapeTimeoutLabel: "2s",
+			},
+			err: true,
+		},
+		"invalid interval": {
+			labels: model.LabelSet{
+				model.ScrapeIntervalLabel: "never",
+			},
+			err: true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			lset := test.labels.Clone()
+			lset[model.AddressLabel] = "localhost:7070"
+
+			targets, err := targetsFromGroup(&targetgroup.Group{Targets: []model.LabelSet{lset}}, cfg)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, targets, 1)
+
+			interval, timeout, err := targets[0].intervalAndTimeout(0, 0)
+			require.NoError(t, err)
+			require.Equal(t, test.interval, interval)
+			require.Equal(t, test.timeout, timeout)
+		})
+	}
+}
@@ -96,6 +96,29 @@ func (t *Target) offset(interval time.Duration) time.Duration {
 	return time.Duration(next)
 }
 
+// intervalAndTimeout returns the scrape interval and timeout of the target,
+// falling back to the given defaults if the target doesn't set them.
+func (t *Target) intervalAndTimeout(defaultInterval, defaultTimeout time.Duration) (time.Duration, time.Duration, error) {
+	interval, timeout := defaultInterval, defaultTimeout
+
+	if v := t.labels.Get(model.ScrapeIntervalLabel); v != "" {
+		d, err := model.ParseDuration(v)
+		if err != nil {
+			return defaultInterval, defaultTimeout, fmt.Errorf("error parsing interval label %q: %w", v, err)
+		}
+		interval = time.Duration(d)
+	}
+	if v := t.labels.Get(model.ScrapeTimeoutLabel); v != "" {
+		d, err := model.ParseDuration(v)
+		if err != nil {
+			return defaultInterval, defaultTimeout, fmt.Errorf("error parsing timeout label %q: %w", v, err)
+		}
+		timeout = time.Duration(d)
+	}
+
+	return interval, timeout, nil
+}
+
 // Params returns a copy of the set of all public params of the target.
 func (t *Target) Params() url.Values {
 	q := make(url.Values, len(t.params))
@@ -245,6 +268,8 @@ func populateLabels(lset labels.Labels, cfg *config.ScrapeConfig) (res, orig lab
 	// Copy labels into the labelset for the target if they are not set already.
 	scrapeLabels := []labels.Label{
 		{Name: model.JobLabel, Value: cfg.JobName},
+		{Name: model.ScrapeIntervalLabel, Value: cfg.ScrapeInterval.String()},
+		{Name: model.ScrapeTimeoutLabel, Value: cfg.ScrapeTimeout.String()},
 		{Name: model.SchemeLabel, Value: cfg.Scheme},
 	}
 	lb := labels.NewBuilder(lset)
@@ -309,6 +334,26 @@ func populateLabels(lset labels.Labels, cfg *config.ScrapeConfig) (res, orig lab
 		return nil, nil, err
 	}
 
+	// Targets may override the scrape interval and timeout of their config
+	// through relabeling, make sure the result is still usable.
+	interval, err := model.ParseDuration(lset.Get(model.ScrapeIntervalLabel))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing scrape interval: %w", err)
+	}
+	if interval == 0 {
+		return nil, nil, errors.New("scrape interval cannot be 0")
+	}
+	timeout, err := model.ParseDuration(lset.Get(model.ScrapeTimeoutLabel))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing scrape timeout: %w", err)
+	}
+	if timeout == 0 {
+		return nil, nil, errors.New("scrape timeout cannot be 0")
+	}
+	if timeout > interval {
+		return nil, nil, fmt.Errorf("scrape timeout cannot be greater than scrape interval (%q > %q)", timeout, interval)
+	}
+
 	// Meta labels are deleted after relabelling. Other internal labels propagate to
 	// the target which decides whether they will be part of their label set.
 	for _, l := range lset {
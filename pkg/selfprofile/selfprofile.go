@@ -0,0 +1,139 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selfprofile profiles the running process with runtime/pprof and
+// writes the profiles to its own profile store.
+package selfprofile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime/pprof"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profilestore"
+)
+
+// Job is the value of the job label of the profiles of the process.
+const Job = "parca"
+
+// The names of the profiles are the ones the profiles of the process get
+// when they are scraped, so that queries of either work the same way.
+const (
+	processCPU = "process_cpu"
+	memory     = "memory"
+	goroutine  = "goroutine"
+)
+
+// Profiler continuously profiles the CPU of the process, and takes heap and
+// goroutine profiles at the end of every interval. The CPU profile covers the
+// whole interval, so the CPU profile of the process can't be taken by other
+// means, like its pprof endpoint, while it runs.
+type Profiler struct {
+	logger   log.Logger
+	store    profilestorepb.ProfileStoreServiceServer
+	interval time.Duration
+
+	profiles *prometheus.CounterVec
+}
+
+func NewProfiler(logger log.Logger, reg prometheus.Registerer, store profilestorepb.ProfileStoreServiceServer, interval time.Duration) *Profiler {
+	p := &Profiler{
+		logger:   log.With(logger, "component", "self_profiler"),
+		store:    store,
+		interval: interval,
+		profiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_self_profiling_profiles_total",
+			Help: "Total number of profiles of the process taken by profile name and result, which is stored or failed.",
+		}, []string{"profile", "result"}),
+	}
+	reg.MustRegister(p.profiles)
+
+	return p
+}
+
+// Run profiles the process until the context is canceled.
+func (p *Profiler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		var cpu bytes.Buffer
+		cpuErr := pprof.StartCPUProfile(&cpu)
+
+		select {
+		case <-ctx.Done():
+			if cpuErr == nil {
+				pprof.StopCPUProfile()
+			}
+			return nil
+		case <-ticker.C:
+		}
+
+		if cpuErr == nil {
+			pprof.StopCPUProfile()
+		}
+		p.write(ctx, processCPU, cpu.Bytes(), cpuErr)
+
+		for _, name := range []string{memory, goroutine} {
+			b, err := lookup(name)
+			p.write(ctx, name, b, err)
+		}
+	}
+}
+
+// lookup returns the current profile of the given name, encoded as pprof.
+func lookup(name string) ([]byte, error) {
+	runtimeName := name
+	if name == memory {
+		runtimeName = "heap"
+	}
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup(runtimeName).WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// write stores the profile of the given name, unless it couldn't be taken.
+func (p *Profiler) write(ctx context.Context, name string, b []byte, err error) {
+	if err == nil {
+		_, err = p.store.WriteRaw(profilestore.WithIngestSource(ctx, profilestore.IngestSourceSelf), &profilestorepb.WriteRawRequest{
+			Series: []*profilestorepb.RawProfileSeries{{
+				Labels: &profilestorepb.LabelSet{
+					Labels: []*profilestorepb.Label{
+						{Name: "__name__", Value: name},
+						{Name: "job", Value: Job},
+					},
+				},
+				Samples: []*profilestorepb.RawSample{{RawProfile: b}},
+			}},
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to write profile: %w", err)
+		}
+	}
+	if err != nil {
+		p.profiles.WithLabelValues(name, "failed").Inc()
+		level.Warn(p.logger).Log("msg", "failed to profile the process", "profile", name, "err", err)
+		return
+	}
+	p.profiles.WithLabelValues(name, "stored").Inc()
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selfprofile
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	"github.com/parca-dev/parca/pkg/profilestore"
+)
+
+type recordingStore struct {
+	profilestorepb.UnimplementedProfileStoreServiceServer
+
+	mtx      sync.Mutex
+	requests []*profilestorepb.WriteRawRequest
+	sources  []string
+}
+
+func (s *recordingStore) WriteRaw(ctx context.Context, req *profilestorepb.WriteRawRequest) (*profilestorepb.WriteRawResponse, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.requests = append(s.requests, req)
+	s.sources = append(s.sources, profilestore.IngestSourceFromContext(ctx))
+	return &profilestorepb.WriteRawResponse{}, nil
+}
+
+func (s *recordingStore) written() ([]*profilestorepb.WriteRawRequest, []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return append([]*profilestorepb.WriteRawRequest(nil), s.requests...), append([]string(nil), s.sources...)
+}
+
+func TestProfiler(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	store := &recordingStore{}
+	p := NewProfiler(log.NewNopLogger(), reg, store, 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		requests, _ := store.written()
+		return len(requests) >= 3
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	requests, sources := store.written()
+	names := map[string]struct{}{}
+	for i, req := range requests[:3] {
+		require.Equal(t, profilestore.IngestSourceSelf, sources[i])
+		require.Len(t, req.Series, 1)
+
+		labels := map[string]string{}
+		for _, l := range req.Series[0].Labels.Labels {
+			labels[l.Name] = l.Value
+		}
+		require.Equal(t, Job, labels["job"])
+		names[labels["__name__"]] = struct{}{}
+
+		_, err := profile.Parse(bytes.NewReader(req.Series[0].Samples[0].RawProfile))
+		require.NoError(t, err)
+	}
+	require.Equal(t, map[string]struct{}{processCPU: {}, memory: {}, goroutine: {}}, names)
+	require.GreaterOrEqual(t, testutil.ToFloat64(p.profiles.WithLabelValues(processCPU, "stored")), 1.0)
+	require.Zero(t, testutil.ToFloat64(p.profiles.WithLabelValues(processCPU, "failed")))
+
+	// The CPU profile is stopped, so it can be taken again.
+	var buf bytes.Buffer
+	require.NoError(t, pprof.StartCPUProfile(&buf))
+	pprof.StopCPUProfile()
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	bearerPrefix = "bearer "
+	basicPrefix  = "basic "
+)
+
+// authenticator rejects requests whose authorization header, or metadata for
+// gRPC, doesn't carry a valid bearer token or basic auth credentials.
+type authenticator struct {
+	token []byte
+	// users maps the usernames of basic auth to the bcrypt hashes of their
+	// passwords.
+	users map[string][]byte
+	// exempt are the gRPC methods and HTTP paths that don't require
+	// credentials.
+	exempt map[string]struct{}
+
+	// verified caches the basic auth credentials that matched, as bcrypt is
+	// deliberately slow to compute. Only matching credentials are cached, so
+	// it is bounded by the number of users.
+	mtx      sync.Mutex
+	verified map[[sha256.Size]byte]struct{}
+}
+
+// enabled returns whether any credentials are accepted, and so required.
+func (a *authenticator) enabled() bool {
+	return a.token != nil || len(a.users) > 0
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func (a *authenticator) valid(authorization string) bool {
+	switch {
+	case a.token != nil && hasPrefixFold(authorization, bearerPrefix):
+		return subtle.ConstantTimeCompare([]byte(authorization[len(bearerPrefix):]), a.token) == 1
+	case len(a.users) > 0 && hasPrefixFold(authorization, basicPrefix):
+		b, err := base64.StdEncoding.DecodeString(authorization[len(basicPrefix):])
+		if err != nil {
+			return false
+		}
+		user, password, ok := strings.Cut(string(b), ":")
+		if !ok {
+			return false
+		}
+		return a.validBasic(user, password)
+	default:
+		return false
+	}
+}
+
+func (a *authenticator) validBasic(user, password string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + password))
+	a.mtx.Lock()
+	_, ok = a.verified[key]
+	a.mtx.Unlock()
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	a.mtx.Lock()
+	if a.verified == nil {
+		a.verified = map[[sha256.Size]byte]struct{}{}
+	}
+	a.verified[key] = struct{}{}
+	a.mtx.Unlock()
+	return true
+}
+
+func (a *authenticator) authorize(ctx context.Context, fullMethod string) error {
+	// Health checks are left open for probes.
+	if strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	if _, ok := a.exempt[fullMethod]; ok {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if a.valid(authorization) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid credentials")
+}
+
+func (a *authenticator) unaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *authenticator) streamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// handler authorizes the HTTP requests of h, which covers the handlers of the
+// gateway that aren't backed by gRPC. The gateway forwards the authorization
+// header of the requests it proxies to gRPC as metadata.
+func (a *authenticator) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := a.exempt[r.URL.Path]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !a.valid(r.Header.Get("Authorization")) {
+			if a.token != nil {
+				w.Header().Add("WWW-Authenticate", "Bearer")
+			}
+			if len(a.users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="Parca"`)
+			}
+			http.Error(w, "missing or invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBearerTokenAuthInterceptor(t *testing.T) {
+	t.Parallel()
+
+	a := &authenticator{token: []byte("secret")}
+	interceptor := a.unaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(method string, md metadata.MD) error {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	const method = "/parca.query.v1alpha1.QueryService/Query"
+	require.NoError(t, call(method, metadata.Pairs("authorization", "Bearer secret")))
+	require.NoError(t, call(method, metadata.Pairs("authorization", "bearer secret")))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, nil)))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", "Bearer wrong"))))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", "secret"))))
+
+	// Probes don't carry the token.
+	require.NoError(t, call("/grpc.health.v1.Health/Check", nil))
+}
+
+func TestBearerTokenAuthHandler(t *testing.T) {
+	t.Parallel()
+
+	a := &authenticator{token: []byte("secret")}
+	h := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/query_range/live", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, serve("Bearer secret").Code)
+	w := serve("")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	require.Equal(t, http.StatusUnauthorized, serve("Bearer wrong").Code)
+}
+
+func TestBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	s := NewServer(nil, "", WithBasicAuth(map[string]string{"agent": string(hash)}), WithAuthExemptions(
+		"/parca.query.v1alpha1.QueryService/Labels",
+		"/api/profiles/upload",
+	))
+	a := s.auth
+	require.True(t, a.enabled())
+
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	interceptor := a.unaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(method string, md metadata.MD) error {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	const method = "/parca.profilestore.v1alpha1.ProfileStoreService/WriteRaw"
+	require.NoError(t, call(method, metadata.Pairs("authorization", basic("agent", "secret"))))
+	// Cached after the first match.
+	require.NoError(t, call(method, metadata.Pairs("authorization", basic("agent", "secret"))))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", basic("agent", "wrong")))))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", basic("other", "secret")))))
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", "Basic !!!"))))
+	// Bearer tokens aren't accepted without one being configured.
+	require.Equal(t, codes.Unauthenticated, status.Code(call(method, metadata.Pairs("authorization", "Bearer "))))
+	require.NoError(t, call("/parca.query.v1alpha1.QueryService/Labels", nil))
+
+	h := a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(path, authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, serve("/api/query_range/live", basic("agent", "secret")).Code)
+	w := serve("/api/query_range/live", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, `Basic realm="Parca"`, w.Header().Get("WWW-Authenticate"))
+	require.Equal(t, http.StatusOK, serve("/api/profiles/upload", "").Code)
+}
+
+func TestAuthExemptionsOnly(t *testing.T) {
+	t.Parallel()
+
+	// Exemptions without credentials don't enable authentication.
+	s := NewServer(nil, "", WithAuthExemptions("/api/profiles/upload"))
+	require.False(t, s.auth.enabled())
+}
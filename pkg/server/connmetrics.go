@@ -0,0 +1,99 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/stats"
+)
+
+// connMetrics is a gRPC stats handler tracking the connections of the server
+// and the bytes sent and received over them.
+//
+// gRPC only reports connections it accepts itself. Served through the HTTP
+// server, as the gRPC server is, the connections are reported by the HTTP
+// server calling connState instead.
+type connMetrics struct {
+	active   prometheus.Gauge
+	accepted prometheus.Counter
+	received prometheus.Counter
+	sent     prometheus.Counter
+}
+
+func newConnMetrics(reg prometheus.Registerer) *connMetrics {
+	m := &connMetrics{
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "parca_server_connections_active",
+			Help: "Number of currently open connections to the server.",
+		}),
+		accepted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_server_connections_accepted_total",
+			Help: "Total number of connections accepted by the server.",
+		}),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_grpc_server_received_bytes_total",
+			Help: "Total number of bytes of gRPC messages received, as sent on the wire.",
+		}),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "parca_grpc_server_sent_bytes_total",
+			Help: "Total number of bytes of gRPC messages sent, as sent on the wire.",
+		}),
+	}
+	reg.MustRegister(m.active, m.accepted, m.received, m.sent)
+
+	return m
+}
+
+func (m *connMetrics) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (m *connMetrics) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch s := s.(type) {
+	case *stats.InPayload:
+		m.received.Add(float64(s.WireLength))
+	case *stats.OutPayload:
+		m.sent.Add(float64(s.WireLength))
+	}
+}
+
+func (m *connMetrics) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (m *connMetrics) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		m.accepted.Inc()
+		m.active.Inc()
+	case *stats.ConnEnd:
+		m.active.Dec()
+	}
+}
+
+// connState tracks the connections of an HTTP server, it is meant to be set
+// as its ConnState hook.
+func (m *connMetrics) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.accepted.Inc()
+		m.active.Inc()
+	case http.StateHijacked, http.StateClosed:
+		m.active.Dec()
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestConnMetricsGRPC(t *testing.T) {
+	t.Parallel()
+
+	m := newConnMetrics(prometheus.NewRegistry())
+
+	srv := grpc.NewServer(grpc.StatsHandler(m))
+	grpc_health.RegisterHealthServer(srv, health.NewServer())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conns []*grpc.ClientConn
+	for i := 0; i < 2; i++ {
+		conn, err := grpc.DialContext(ctx, l.Addr().String(),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithBlock(),
+		)
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.active) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(2), testutil.ToFloat64(m.accepted))
+
+	_, err = grpc_health.NewHealthClient(conns[0]).Check(ctx, &grpc_health.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Greater(t, testutil.ToFloat64(m.sent), float64(0))
+
+	require.NoError(t, conns[0].Close())
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.active) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conns[1].Close())
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.active) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(2), testutil.ToFloat64(m.accepted))
+}
+
+func TestConnMetricsHTTP(t *testing.T) {
+	t.Parallel()
+
+	m := newConnMetrics(prometheus.NewRegistry())
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = m.connState
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.active) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.active) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, float64(1), testutil.ToFloat64(m.accepted))
+}
@@ -43,6 +43,16 @@ func (wrw *wrapResponseWriter) Write(b []byte) (int, error) {
 	return wrw.ResponseWriter.Write(b)
 }
 
+// Flush lets streamed responses be flushed, unless they are not found.
+func (wrw *wrapResponseWriter) Flush() {
+	if wrw.notFound() {
+		return
+	}
+	if f, ok := wrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (wrw *wrapResponseWriter) notFound() bool {
 	return wrw.code == http.StatusNotFound
 }
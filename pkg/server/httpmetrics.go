@@ -0,0 +1,148 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/metadata"
+)
+
+// uiRoute is the route of requests that no route matched, they are served
+// by the UI.
+const uiRoute = "ui"
+
+// httpInstrumentation instruments the requests served over plain HTTP, which
+// are requests of the gateway, the UI and the debug endpoints, but not gRPC
+// or gRPC-Web requests.
+type httpInstrumentation struct {
+	logger   log.Logger
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newHTTPInstrumentation(reg prometheus.Registerer, logger log.Logger) *httpInstrumentation {
+	i := &httpInstrumentation{
+		logger: logger,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "parca_http_requests_total",
+			Help: "Total number of HTTP requests by route and status code.",
+		}, []string{"route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "parca_http_request_duration_seconds",
+			Help:    "Duration of HTTP requests by route and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "code"}),
+	}
+	reg.MustRegister(i.requests, i.duration)
+
+	return i
+}
+
+type httpRouteKey struct{}
+
+// httpRoute is filled in with the path pattern of gateway requests by the
+// gateway, which knows it only once it routed the request.
+type httpRoute struct {
+	gatewayPattern string
+}
+
+// annotateGatewayRoute is a metadata annotator of the gateway recording the
+// path pattern of the request.
+func (i *httpInstrumentation) annotateGatewayRoute(ctx context.Context, r *http.Request) metadata.MD {
+	route, ok := r.Context().Value(httpRouteKey{}).(*httpRoute)
+	if !ok {
+		return nil
+	}
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		route.gatewayPattern = pattern
+	}
+	return nil
+}
+
+// handler instruments the requests served by next. The route of a request
+// is the path pattern of the gateway or the router that matched it.
+func (i *httpInstrumentation) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// The router only exposes the pattern it matched if the route
+		// context is created ahead of it.
+		route := &httpRoute{}
+		rctx := chi.NewRouteContext()
+		ctx := context.WithValue(r.Context(), httpRouteKey{}, route)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+
+		sw := &statusResponseWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		name := route.gatewayPattern
+		if name == "" {
+			name = rctx.RoutePattern()
+		}
+		if name == "" {
+			name = uiRoute
+		}
+		code := strconv.Itoa(sw.code)
+		duration := time.Since(start)
+
+		i.requests.WithLabelValues(name, code).Inc()
+		i.duration.WithLabelValues(name, code).Observe(duration.Seconds())
+		level.Debug(i.logger).Log(
+			"msg", "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", name,
+			"code", code,
+			"duration", duration,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// statusResponseWriter records the status code of a response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streamed responses of the gateway be flushed.
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
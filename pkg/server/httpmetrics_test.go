@@ -0,0 +1,61 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-kit/log"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPInstrumentation(t *testing.T) {
+	t.Parallel()
+
+	inst := newHTTPInstrumentation(prometheus.NewRegistry(), log.NewNopLogger())
+
+	// Routed like the handlers of the server, with the gateway mounted on
+	// the router and the UI as fallback.
+	gateway := runtime.NewServeMux(runtime.WithMetadata(inst.annotateGatewayRoute))
+	require.NoError(t, gateway.HandlePath(http.MethodGet, "/api/profiles/{id}", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+		// Generated gateway handlers annotate the context like this.
+		_, err := runtime.AnnotateContext(r.Context(), gateway, r, "/parca.Test/Get", runtime.WithHTTPPathPattern("/api/profiles/{id}"))
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	router := chi.NewRouter()
+	router.Mount("/api", gateway)
+	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+	ui := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	srv := httptest.NewServer(inst.handler(fallbackNotFound(router, ui)))
+	t.Cleanup(srv.Close)
+
+	for _, path := range []string{"/metrics", "/metrics", "/api/profiles/1", "/api/profiles/2", "/targets"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, 2.0, testutil.ToFloat64(inst.requests.WithLabelValues("/metrics", "200")))
+	require.Equal(t, 2.0, testutil.ToFloat64(inst.requests.WithLabelValues("/api/profiles/{id}", "418")))
+	require.Equal(t, 1.0, testutil.ToFloat64(inst.requests.WithLabelValues(uiRoute, "200")))
+	require.Equal(t, 3, testutil.CollectAndCount(inst.duration))
+}
@@ -0,0 +1,51 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// normalizePathPrefix returns the path prefix with a leading and without a
+// trailing slash, so that it can be prepended to the paths of the handlers.
+// It is empty if the root is served.
+func normalizePathPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	prefix = path.Clean("/" + prefix)
+	if prefix == "/" {
+		return ""
+	}
+	return prefix
+}
+
+// withPathPrefix serves the handler both at the root and under the path
+// prefix, so that a reverse proxy can forward requests without stripping the
+// prefix, while probes and scrapes can still reach the server directly.
+func withPathPrefix(prefix string, handler http.Handler) http.Handler {
+	if prefix == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(prefix, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix+"/") {
+			stripped.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
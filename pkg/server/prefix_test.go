@@ -0,0 +1,98 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePathPrefix(t *testing.T) {
+	t.Parallel()
+
+	for prefix, expected := range map[string]string{
+		"":         "",
+		"/":        "",
+		"parca":    "/parca",
+		"/parca":   "/parca",
+		"/parca/":  "/parca",
+		"/a//b/":   "/a/b",
+		"/a/b/../": "/a",
+	} {
+		require.Equal(t, expected, normalizePathPrefix(prefix), prefix)
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	t.Parallel()
+
+	router := chi.NewRouter()
+	router.HandleFunc("/api/*", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "api "+r.URL.Path)
+	})
+	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "metrics")
+	})
+
+	s := &Server{}
+	ui, err := s.uiHandler(fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<script>window.PATH_PREFIX = '/PATH_PREFIX_VAR';</script>`)},
+		"main.js":    &fstest.MapFile{Data: []byte("js")},
+	}, "/parca")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(fallbackNotFound(withPathPrefix("/parca", router), ui))
+	t.Cleanup(srv.Close)
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	for _, tc := range []struct {
+		path     string
+		code     int
+		body     string
+		location string
+	}{
+		{path: "/parca/api/v1/query", code: http.StatusOK, body: "api /api/v1/query"},
+		{path: "/api/v1/query", code: http.StatusOK, body: "api /api/v1/query"},
+		{path: "/metrics", code: http.StatusOK, body: "metrics"},
+		{path: "/parca/", code: http.StatusOK, body: "<script>window.PATH_PREFIX = '/parca';</script>"},
+		{path: "/parca/targets", code: http.StatusOK, body: "<script>window.PATH_PREFIX = '/parca';</script>"},
+		{path: "/parca/main.js", code: http.StatusOK, body: "js"},
+		{path: "/main.js", code: http.StatusNotFound},
+		{path: "/", code: http.StatusFound, location: "/parca/"},
+	} {
+		resp, err := client.Get(srv.URL + tc.path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		require.Equal(t, tc.code, resp.StatusCode, tc.path)
+		if tc.body != "" {
+			require.Equal(t, tc.body, string(body), tc.path)
+		}
+		if tc.location != "" {
+			require.Equal(t, tc.location, resp.Header.Get("Location"), tc.path)
+		}
+	}
+}
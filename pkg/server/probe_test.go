@@ -0,0 +1,76 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	// The probes stay open with authentication.
+	s := NewServer(prometheus.NewRegistry(), "test-version", WithBearerToken("secret"), WithShutdownDelay(time.Minute))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(context.Background(), log.NewNopLogger(), addr, nil, "")
+	}()
+
+	get := func(path string) int {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Eventually(t, func() bool {
+		return get("/readyz") == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusOK, get("/healthz"))
+	require.Equal(t, http.StatusOK, get("/-/healthy"))
+	require.Equal(t, http.StatusOK, get("/-/ready"))
+
+	// Readiness fails while the server keeps serving during the shutdown
+	// delay.
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return get("/readyz") == http.StatusServiceUnavailable
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, http.StatusOK, get("/healthz"))
+	require.Equal(t, http.StatusServiceUnavailable, get("/-/ready"))
+	require.Equal(t, http.StatusOK, get("/-/healthy"))
+
+	// The delay is cut short by the context of the shutdown.
+	cancel()
+	<-shutdown
+	require.ErrorIs(t, <-done, http.ErrServerClosed)
+}
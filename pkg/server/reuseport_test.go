@@ -0,0 +1,44 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReusePortControl(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	lc := net.ListenConfig{Control: reusePortControl}
+
+	first, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { first.Close() })
+
+	second, err := lc.Listen(ctx, "tcp", first.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { second.Close() })
+	require.Equal(t, first.Addr().String(), second.Addr().String())
+
+	// Without the option the port stays exclusive.
+	_, err = net.Listen("tcp", first.Addr().String())
+	require.Error(t, err)
+}
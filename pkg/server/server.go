@@ -16,10 +16,14 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/textproto"
 	"strings"
 	"text/template"
 	"time"
@@ -43,6 +47,7 @@ import (
 	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
@@ -76,21 +81,162 @@ var MapAllowedLevels = map[string][]string{
 type Server struct {
 	http.Server
 	grpcProbe *prober.GRPCProbe
+	httpProbe *prober.HTTPProbe
 	reg       *prometheus.Registry
 	version   string
+
+	connMetrics  *connMetrics
+	reusePort    bool
+	adminHandler http.Handler
+	reloader     http.Handler
+	certs        *CertReloader
+	clientCAs    *x509.CertPool
+	tlsMin       uint16
+	auth         *authenticator
+	tenants      *tenantExtractor
+
+	shutdownDelay time.Duration
+}
+
+type Option func(*Server)
+
+// WithConnectionMetrics enables metrics about the open connections of the
+// server and the bytes of the gRPC messages sent and received.
+func WithConnectionMetrics() Option {
+	return func(s *Server) {
+		s.connMetrics = newConnMetrics(s.reg)
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the listener of the server, so a new
+// process can bind the same port before the old one exits on restarts.
+func WithReusePort() Option {
+	return func(s *Server) {
+		s.reusePort = true
+	}
+}
+
+// WithAdminHandler serves the handler under /admin, for operators to inspect
+// and manage the running server.
+func WithAdminHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.adminHandler = h
+	}
 }
 
-func NewServer(reg *prometheus.Registry, version string) *Server {
-	return &Server{
+// WithReloadHandler serves the handler reloading the configuration under
+// /-/reload, requiring the same credentials as the API.
+func WithReloadHandler(h http.Handler) Option {
+	return func(s *Server) {
+		s.reloader = h
+	}
+}
+
+// WithTLS serves TLS with the certificates of the reloader, rather than
+// plaintext.
+func WithTLS(certs *CertReloader) Option {
+	return func(s *Server) {
+		s.certs = certs
+	}
+}
+
+// WithClientCAs requires TLS clients to present a certificate signed by one
+// of the CAs. The HTTP API gateway presents the serving certificate to the
+// gRPC server, so it has to be signed by one of them as well. It has no effect
+// without WithTLS.
+func WithClientCAs(pool *x509.CertPool) Option {
+	return func(s *Server) {
+		s.clientCAs = pool
+	}
+}
+
+// WithTLSMinVersion sets the minimum TLS version served, TLS 1.2 by default.
+func WithTLSMinVersion(version uint16) Option {
+	return func(s *Server) {
+		s.tlsMin = version
+	}
+}
+
+// WithBearerToken requires gRPC requests and the HTTP requests of the API and
+// admin handlers to carry the token as bearer token in their authorization
+// header. Health checks, metrics and the UI stay open.
+func WithBearerToken(token string) Option {
+	return func(s *Server) {
+		s.authenticator().token = []byte(token)
+	}
+}
+
+// WithBasicAuth requires the same requests as WithBearerToken to carry the
+// credentials of one of the users as basic auth, the users mapping usernames
+// to the bcrypt hashes of their passwords. Either are accepted if both are
+// configured.
+func WithBasicAuth(users map[string]string) Option {
+	return func(s *Server) {
+		a := s.authenticator()
+		a.users = make(map[string][]byte, len(users))
+		for user, hash := range users {
+			a.users[user] = []byte(hash)
+		}
+	}
+}
+
+// WithAuthExemptions exempts gRPC methods, given by their full name, and
+// paths of the HTTP API from requiring credentials.
+func WithAuthExemptions(methods ...string) Option {
+	return func(s *Server) {
+		a := s.authenticator()
+		if a.exempt == nil {
+			a.exempt = make(map[string]struct{}, len(methods))
+		}
+		for _, m := range methods {
+			a.exempt[m] = struct{}{}
+		}
+	}
+}
+
+// WithTenantHeader takes the tenant of requests from the header, or the
+// metadata of the same name for gRPC, see the tenant package. Requests
+// without it are made by the default tenant.
+func WithTenantHeader(header string) Option {
+	return func(s *Server) {
+		s.tenants = &tenantExtractor{header: textproto.CanonicalMIMEHeaderKey(header)}
+	}
+}
+
+func (s *Server) authenticator() *authenticator {
+	if s.auth == nil {
+		s.auth = &authenticator{}
+	}
+	return s.auth
+}
+
+// WithShutdownDelay keeps serving for the delay after shutdown starts, while
+// readiness checks already fail, so load balancers stop routing requests to
+// the server before it stops accepting them.
+func WithShutdownDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.shutdownDelay = d
+	}
+}
+
+func NewServer(reg *prometheus.Registry, version string, opts ...Option) *Server {
+	s := &Server{
 		grpcProbe: prober.NewGRPC(),
+		httpProbe: prober.NewHTTP(),
 		reg:       reg,
 		version:   version,
+		tlsMin:    tls.VersionTLS12,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 // ListenAndServe starts the http grpc gateway server.
 func (s *Server) ListenAndServe(ctx context.Context, logger log.Logger, port string, allowedCORSOrigins []string, pathPrefix string, registerables ...Registerable) error {
 	level.Info(logger).Log("msg", "starting server", "addr", port)
+	pathPrefix = normalizePathPrefix(pathPrefix)
 	logLevel := "ERROR"
 
 	logOpts := []grpc_logging.Option{
@@ -111,29 +257,60 @@ func (s *Server) ListenAndServe(ctx context.Context, logger log.Logger, port str
 		grpc_prometheus.WithHistogramBuckets([]float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120}),
 	)
 
-	// Start grpc server with API server registered
-	srv := grpc.NewServer(
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		otelgrpc.StreamServerInterceptor(),
+		met.StreamServerInterceptor(),
+		grpc_logging.StreamServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		otelgrpc.UnaryServerInterceptor(),
+		met.UnaryServerInterceptor(),
+		grpc_logging.UnaryServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
+	}
+	if s.auth != nil && s.auth.enabled() {
+		streamInterceptors = append(streamInterceptors, s.auth.streamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, s.auth.unaryServerInterceptor())
+	}
+	if s.tenants != nil {
+		streamInterceptors = append(streamInterceptors, s.tenants.streamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, s.tenants.unaryServerInterceptor())
+	}
+
+	serverOpts := []grpc.ServerOption{
 		// It is increased to 32MB to account for large protobuf messages (debug information uploads and downloads).
 		grpc.MaxSendMsgSize(debuginfo.MaxMsgSize),
 		grpc.MaxRecvMsgSize(debuginfo.MaxMsgSize),
-		grpc.StreamInterceptor(
-			grpc_middleware.ChainStreamServer(
-				otelgrpc.StreamServerInterceptor(),
-				met.StreamServerInterceptor(),
-				grpc_logging.StreamServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
-			)),
-		grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				otelgrpc.UnaryServerInterceptor(),
-				met.UnaryServerInterceptor(),
-				grpc_logging.UnaryServerInterceptor(kit.InterceptorLogger(logger), logOpts...),
-			),
-		),
-	)
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
+	}
+	if s.connMetrics != nil {
+		serverOpts = append(serverOpts, grpc.StatsHandler(s.connMetrics))
+	}
+
+	// Start grpc server with API server registered
+	srv := grpc.NewServer(serverOpts...)
 
 	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if s.certs != nil {
+		// The gateway dials the server itself, whose certificate isn't
+		// necessarily valid for the address it listens on.
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: true,
+		}
+		if s.clientCAs != nil {
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return s.certs.GetCertificate(nil)
+			}
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	}
 
-	grpcWebMux := runtime.NewServeMux()
+	httpInst := newHTTPInstrumentation(s.reg, logger)
+	muxOpts := []runtime.ServeMuxOption{runtime.WithMetadata(httpInst.annotateGatewayRoute)}
+	if s.tenants != nil {
+		muxOpts = append(muxOpts, runtime.WithIncomingHeaderMatcher(s.tenants.headerMatcher))
+	}
+	grpcWebMux := runtime.NewServeMux(muxOpts...)
 	for _, r := range registerables {
 		if err := r.Register(ctx, srv, grpcWebMux, port, opts); err != nil {
 			return err
@@ -142,9 +319,35 @@ func (s *Server) ListenAndServe(ctx context.Context, logger log.Logger, port str
 	reflection.Register(srv)
 	grpc_health.RegisterHealthServer(srv, s.grpcProbe.HealthServer())
 
+	var apiHandler, adminHandler, reloadHandler http.Handler = grpcWebMux, s.adminHandler, s.reloader
+	if s.tenants != nil {
+		apiHandler = s.tenants.handler(apiHandler)
+	}
+	if s.auth != nil && s.auth.enabled() {
+		apiHandler = s.auth.handler(apiHandler)
+		if adminHandler != nil {
+			adminHandler = s.auth.handler(adminHandler)
+		}
+		if reloadHandler != nil {
+			reloadHandler = s.auth.handler(reloadHandler)
+		}
+	}
+
 	internalMux := chi.NewRouter()
-	internalMux.Mount("/api", grpcWebMux)
+	internalMux.Mount("/api", apiHandler)
+	if adminHandler != nil {
+		internalMux.Mount("/admin", adminHandler)
+	}
+	if reloadHandler != nil {
+		internalMux.Handle("/-/reload", reloadHandler)
+	}
 
+	// The probes are served outside of /api, so they stay open with
+	// authentication.
+	internalMux.HandleFunc("/healthz", s.httpProbe.HealthyHandler(logger))
+	internalMux.HandleFunc("/readyz", s.httpProbe.ReadyHandler(logger))
+	internalMux.HandleFunc("/-/healthy", s.httpProbe.HealthyHandler(logger))
+	internalMux.HandleFunc("/-/ready", s.httpProbe.ReadyHandler(logger))
 	internalMux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		promhttp.HandlerFor(s.reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
 	})
@@ -176,12 +379,15 @@ func (s *Server) ListenAndServe(ctx context.Context, logger log.Logger, port str
 		Addr: port,
 		Handler: grpcHandlerFunc(
 			srv,
-			fallbackNotFound(internalMux, uiHandler),
+			httpInst.handler(fallbackNotFound(withPathPrefix(pathPrefix, internalMux), uiHandler)),
 			allowedCORSOrigins,
 		),
 		ReadTimeout:  5 * time.Second, // TODO make config option
 		WriteTimeout: time.Minute,     // TODO make config option
 	}
+	if s.connMetrics != nil {
+		s.Server.ConnState = s.connMetrics.connState
+	}
 
 	met.InitializeMetrics(srv)
 	s.reg.MustRegister(met)
@@ -192,14 +398,51 @@ func (s *Server) ListenAndServe(ctx context.Context, logger log.Logger, port str
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
+	lc := net.ListenConfig{}
+	if s.reusePort {
+		lc.Control = reusePortControl
+	}
+	ln, err := lc.Listen(ctx, "tcp", port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", port, err)
+	}
+
 	s.grpcProbe.Ready()
 	s.grpcProbe.Healthy()
-	return s.Server.ListenAndServe()
+	s.httpProbe.Ready()
+	s.httpProbe.Healthy()
+	if s.certs == nil {
+		return s.Server.Serve(ln)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.certs.Run(ctx)
+
+	s.Server.TLSConfig = &tls.Config{
+		GetCertificate: s.certs.GetCertificate,
+		MinVersion:     s.tlsMin,
+	}
+	if s.clientCAs != nil {
+		s.Server.TLSConfig.ClientCAs = s.clientCAs
+		s.Server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return s.Server.ServeTLS(ln, "", "")
 }
 
 // Shutdown the server.
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.grpcProbe.NotReady(nil)
+	s.httpProbe.NotReady(nil)
+
+	if s.shutdownDelay > 0 {
+		t := time.NewTimer(s.shutdownDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
 	return s.Server.Shutdown(ctx)
 }
 
@@ -275,6 +518,18 @@ func (s *Server) uiHandler(uiFS fs.FS, pathPrefix string) (*http.ServeMux, error
 		return nil, err
 	}
 
+	// Redirect to the UI when the root is visited directly rather than
+	// through the reverse proxy.
+	if pathPrefix != "" {
+		uiHandler.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, pathPrefix+"/", http.StatusFound)
+		})
+	}
+
 	return &uiHandler, nil
 }
 
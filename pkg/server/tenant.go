@@ -0,0 +1,115 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// tenantExtractor takes the tenant of requests from a header, or metadata
+// for gRPC. Requests without the header are made by the default tenant.
+type tenantExtractor struct {
+	// header is the canonical form of the header.
+	header string
+}
+
+// tenant returns the tenant of the values of the header, an empty string for
+// the default tenant.
+func (e *tenantExtractor) tenant(values []string) (string, error) {
+	switch len(values) {
+	case 0:
+		return "", nil
+	case 1:
+		if err := tenant.Validate(values[0]); err != nil {
+			return "", err
+		}
+		return values[0], nil
+	default:
+		return "", errMultipleTenants
+	}
+}
+
+var errMultipleTenants = errors.New("requests must be made by a single tenant")
+
+func (e *tenantExtractor) fromMetadata(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id, err := e.tenant(md.Get(e.header))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if id == "" {
+		return ctx, nil
+	}
+	return tenant.WithTenant(ctx, id), nil
+}
+
+func (e *tenantExtractor) unaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := e.fromMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (e *tenantExtractor) streamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := e.fromMetadata(ss.Context())
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+// handler sets the tenant of the HTTP requests of h, which covers the
+// handlers of the gateway that aren't backed by gRPC. The gateway forwards
+// the header of the requests it proxies to gRPC as metadata.
+func (e *tenantExtractor) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := e.tenant(r.Header.Values(e.header))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id != "" {
+			r = r.WithContext(tenant.WithTenant(r.Context(), id))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// headerMatcher forwards the header to gRPC, in addition to the headers
+// forwarded by default.
+func (e *tenantExtractor) headerMatcher(key string) (string, bool) {
+	if textproto.CanonicalMIMEHeaderKey(key) == e.header {
+		return key, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
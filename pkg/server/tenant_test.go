@@ -0,0 +1,90 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+func TestTenantInterceptor(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(nil, "", WithTenantHeader("x-scope-orgid"))
+	interceptor := s.tenants.unaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		id, _ := tenant.FromContext(ctx)
+		return id, nil
+	}
+	call := func(md metadata.MD) (interface{}, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		return interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/parca.query.v1alpha1.QueryService/Query"}, handler)
+	}
+
+	id, err := call(metadata.Pairs("X-Scope-OrgID", "team-a"))
+	require.NoError(t, err)
+	require.Equal(t, "team-a", id)
+
+	id, err = call(nil)
+	require.NoError(t, err)
+	require.Equal(t, "", id)
+
+	_, err = call(metadata.Pairs("x-scope-orgid", "../team-a"))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = call(metadata.Pairs("x-scope-orgid", "team-a", "x-scope-orgid", "team-b"))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTenantHandler(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(nil, "", WithTenantHeader("X-Scope-OrgID"))
+	h := s.tenants.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := tenant.FromContext(r.Context())
+		_, _ = w.Write([]byte(id))
+	}))
+	serve := func(ids ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/profiles/pprof", nil)
+		for _, id := range ids {
+			r.Header.Add("X-Scope-OrgID", id)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("team-a")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "team-a", w.Body.String())
+	require.Equal(t, "", serve().Body.String())
+	require.Equal(t, http.StatusBadRequest, serve("team a").Code)
+	require.Equal(t, http.StatusBadRequest, serve("team-a", "team-b").Code)
+
+	// The gateway forwards the header to gRPC.
+	key, ok := s.tenants.headerMatcher("x-scope-orgid")
+	require.True(t, ok)
+	require.Equal(t, "x-scope-orgid", key)
+	_, ok = s.tenants.headerMatcher("X-Other")
+	require.False(t, ok)
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// CertReloader serves the certificate of a pair of certificate and key
+// files, reloading it when the files change, so that certificates can be
+// rotated without restarting the server.
+type CertReloader struct {
+	logger   log.Logger
+	certFile string
+	keyFile  string
+	watcher  *fsnotify.Watcher
+
+	mtx  sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertReloader loads the certificate of the files and starts watching
+// them for changes.
+func NewCertReloader(logger log.Logger, certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish certificate watcher: %w", err)
+	}
+	// The directories are watched rather than the files, as rotations
+	// often replace the files, like the symlinks of mounted Kubernetes
+	// secrets, which ends watches of the files.
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to start watching %s: %w", dir, err)
+		}
+	}
+	r.watcher = watcher
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s and key %s: %w", r.certFile, r.keyFile, err)
+	}
+	r.mtx.Lock()
+	r.cert = &cert
+	r.mtx.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.cert, nil
+}
+
+// Run reloads the certificate whenever the directories of the files change
+// until the context is done. The previous certificate is kept if the files
+// fail to load, like while they are halfway written.
+func (r *CertReloader) Run(ctx context.Context) error {
+	defer r.watcher.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-r.watcher.Events:
+			if !ok {
+				return nil
+			}
+			if err := r.reload(); err != nil {
+				level.Warn(r.logger).Log("msg", "failed to reload TLS certificate, keeping the previous one", "err", err)
+				continue
+			}
+			level.Debug(r.logger).Log("msg", "reloaded TLS certificate")
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			level.Error(r.logger).Log("msg", "error encountered while watching TLS certificate", "err", err)
+		}
+	}
+}
+
+// LoadClientCAs loads the PEM encoded CA certificates of the file, to verify
+// client certificates with.
+func LoadClientCAs(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpc_health "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// writeCert writes a self-signed certificate of the common name and its key
+// to the files.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func commonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	_, err := NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	require.Error(t, err)
+
+	writeCert(t, certFile, keyFile, "first")
+	r, err := NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	require.NoError(t, err)
+	require.Equal(t, "first", commonName(t, r))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, r.Run(ctx))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Files that fail to load, like halfway written ones, keep the
+	// previous certificate.
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	require.Equal(t, "first", commonName(t, r))
+
+	writeCert(t, certFile, keyFile, "second")
+	require.Eventually(t, func() bool {
+		return commonName(t, r) == "second"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert(t, certFile, keyFile, "parca")
+	certs, err := NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(prometheus.NewRegistry(), "test-version", WithTLS(certs))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(ctx, log.NewNopLogger(), addr, nil, "")
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.ErrorIs(t, <-done, http.ErrServerClosed)
+	})
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = client.Get("https://" + addr + "/metrics")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "parca", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// gRPC is served over TLS too.
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = grpc_health.NewHealthClient(conn).Check(ctx, &grpc_health.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// Plaintext is refused.
+	resp, err = http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestListenAndServeMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert(t, certFile, keyFile, "parca")
+	clientCertFile, clientKeyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeCert(t, clientCertFile, clientKeyFile, "agent")
+	certs, err := NewCertReloader(log.NewNopLogger(), certFile, keyFile)
+	require.NoError(t, err)
+
+	// The certificates are self-signed, so they are their own CAs.
+	caFile := filepath.Join(dir, "ca.crt")
+	serverPEM, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	clientPEM, err := os.ReadFile(clientCertFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(caFile, append(serverPEM, clientPEM...), 0o600))
+	pool, err := LoadClientCAs(caFile)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewServer(prometheus.NewRegistry(), "test-version", WithTLS(certs), WithClientCAs(pool), WithTLSMinVersion(tls.VersionTLS13))
+	gatewayOpts := make(chan []grpc.DialOption, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe(ctx, log.NewNopLogger(), addr, nil, "", RegisterableFunc(func(_ context.Context, _ *grpc.Server, _ *runtime.ServeMux, _ string, opts []grpc.DialOption) error {
+			gatewayOpts <- opts
+			return nil
+		}))
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, s.Shutdown(context.Background()))
+		require.ErrorIs(t, <-done, http.ErrServerClosed)
+	})
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+		},
+	}}
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = client.Get("https://" + addr + "/metrics")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+	})))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = grpc_health.NewHealthClient(conn).Check(ctx, &grpc_health.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// The gateway presents the serving certificate.
+	conn, err = grpc.DialContext(ctx, addr, <-gatewayOpts...)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = grpc_health.NewHealthClient(conn).Check(ctx, &grpc_health.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// Clients without a certificate are refused.
+	client = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	_, err = client.Get("https://" + addr + "/metrics")
+	require.Error(t, err)
+
+	// TLS versions below the minimum are refused.
+	client = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+			MaxVersion:         tls.VersionTLS12,
+		},
+	}}
+	_, err = client.Get("https://" + addr + "/metrics")
+	require.Error(t, err)
+}
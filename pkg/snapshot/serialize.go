@@ -0,0 +1,73 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/dynparquet"
+	"github.com/segmentio/parquet-go"
+)
+
+const serializeBatchSize = 1024
+
+// blockRowGroups returns the row groups of the block.
+func blockRowGroups(block *frostdb.TableBlock) ([]dynparquet.DynamicRowGroup, error) {
+	var rowGroups []dynparquet.DynamicRowGroup
+	err := block.RowGroupIterator(context.Background(), math.MaxUint64, nil, &frostdb.AlwaysTrueFilter{}, func(rg dynparquet.DynamicRowGroup) bool {
+		rowGroups = append(rowGroups, rg)
+		return true
+	})
+	return rowGroups, err
+}
+
+// serialize writes the rows of the row groups as parquet, which the rows can
+// be inserted into a table of the same schema from. TableBlock.Serialize
+// isn't used, as it drops rows of blocks whose last row groups exceed its
+// batch size.
+func serialize(w io.Writer, schema *dynparquet.Schema, rowGroups []dynparquet.DynamicRowGroup) error {
+	merged, err := schema.MergeDynamicRowGroups(rowGroups)
+	if err != nil {
+		return err
+	}
+	pw, err := schema.GetWriter(w, merged.DynamicColumns())
+	if err != nil {
+		return err
+	}
+	defer schema.PutWriter(pw)
+
+	rows := merged.Rows()
+	defer rows.Close()
+
+	buf := make([]parquet.Row, serializeBatchSize)
+	for {
+		n, err := rows.ReadRows(buf)
+		if n > 0 {
+			if _, err := pw.WriteRows(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return pw.Close()
+}
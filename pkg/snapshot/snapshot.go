@@ -0,0 +1,329 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snapshot writes snapshots of the profile storage and the metastore
+// to a bucket and restores them, to move the profiles of a server to another
+// one or to recover them after losing its storage.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/polarsignals/frostdb"
+	"github.com/thanos-io/objstore"
+)
+
+const (
+	// metaFile is written last, snapshots without it are incomplete.
+	metaFile      = "meta.json"
+	metastoreFile = "metastore.badger"
+	tablesDir     = "tables"
+
+	// restoredKeyPrefix is the prefix of the keys marking the snapshots
+	// restored into the metastore, so that restarts don't restore them
+	// again.
+	restoredKeyPrefix = "v1/snapshots/restored/"
+
+	loadMaxPendingWrites = 256
+)
+
+// ErrNotFound is returned when restoring a snapshot that doesn't exist or is
+// incomplete.
+var ErrNotFound = errors.New("snapshot not found")
+
+// Meta describes a complete snapshot.
+type Meta struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Tables are the files of the tables, relative to the snapshot, by the
+	// names of the tables. Every file is a block of the table encoded as
+	// parquet.
+	Tables map[string][]string `json:"tables"`
+}
+
+// Snapshotter takes snapshots of the tables of a database and the metastore.
+//
+// The rows of a table are the rows of its active block and the rows of its
+// blocks persisted to the blocks bucket, if any. Blocks are persisted in the
+// background after they are rotated, so the rows of a block that is rotated
+// while the snapshot is taken are only part of it if the block was persisted
+// by the time the persisted blocks are listed.
+type Snapshotter struct {
+	logger    log.Logger
+	bucket    objstore.Bucket
+	metastore *badger.DB
+	db        *frostdb.DB
+	config    *frostdb.TableConfig
+	tables    func() map[string]*frostdb.Table
+	register  func(table string) error
+	blocks    objstore.Bucket
+
+	// mtx serializes snapshots and restores.
+	mtx sync.Mutex
+}
+
+// New returns a snapshotter writing snapshots to the bucket. The tables
+// function returns the tables to snapshot by their names, it must return
+// every table of the database. Tables are created in the database with the
+// config when they are restored, and passed to the register function, if
+// any, by their names, so that the tables function returns them. The blocks
+// bucket holds the blocks of the database persisted by frostdb, laid out as
+// <table>/<block ULID>/data.parquet, it is nil if blocks aren't persisted.
+func New(
+	logger log.Logger,
+	bucket objstore.Bucket,
+	metastore *badger.DB,
+	db *frostdb.DB,
+	config *frostdb.TableConfig,
+	tables func() map[string]*frostdb.Table,
+	register func(table string) error,
+	blocks objstore.Bucket,
+) *Snapshotter {
+	return &Snapshotter{
+		logger:    log.With(logger, "component", "snapshot"),
+		bucket:    bucket,
+		metastore: metastore,
+		db:        db,
+		config:    config,
+		tables:    tables,
+		register:  register,
+		blocks:    blocks,
+	}
+}
+
+// Snapshot writes a snapshot to the bucket and returns its name, a ULID of
+// the time it was taken.
+func (s *Snapshotter) Snapshot(ctx context.Context) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	name := ulid.MustNew(ulid.Timestamp(now), rand.Reader).String()
+	logger := log.With(s.logger, "snapshot", name)
+	level.Info(logger).Log("msg", "taking snapshot")
+
+	if err := s.upload(ctx, path.Join(name, metastoreFile), func(w io.Writer) error {
+		_, err := s.metastore.Backup(w, 0)
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("failed to snapshot metastore: %w", err)
+	}
+
+	meta := Meta{Version: 1, Created: now.UTC(), Tables: map[string][]string{}}
+
+	// Active blocks are written first, so that a block rotated meanwhile
+	// rather ends up in the snapshot twice than not at all.
+	for table, t := range s.tables() {
+		block := t.ActiveBlock()
+		if block == nil {
+			continue
+		}
+		rowGroups, err := blockRowGroups(block)
+		if err != nil {
+			return "", fmt.Errorf("failed to read table %s: %w", table, err)
+		}
+		if len(rowGroups) == 0 {
+			continue
+		}
+		file := path.Join(tablesDir, table, "active.parquet")
+		if err := s.upload(ctx, path.Join(name, file), func(w io.Writer) error {
+			return serialize(w, t.Schema(), rowGroups)
+		}); err != nil {
+			return "", fmt.Errorf("failed to snapshot table %s: %w", table, err)
+		}
+		meta.Tables[table] = append(meta.Tables[table], file)
+	}
+
+	if s.blocks != nil {
+		if err := s.copyBlocks(ctx, name, meta.Tables); err != nil {
+			return "", err
+		}
+	}
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	if err := s.bucket.Upload(ctx, path.Join(name, metaFile), bytes.NewReader(b)); err != nil {
+		return "", fmt.Errorf("failed to upload snapshot meta: %w", err)
+	}
+
+	level.Info(logger).Log("msg", "took snapshot", "duration", time.Since(now))
+	return name, nil
+}
+
+// copyBlocks copies the persisted blocks of every table into the snapshot of
+// the given name and adds them to the files of the tables.
+func (s *Snapshotter) copyBlocks(ctx context.Context, name string, tables map[string][]string) error {
+	return s.blocks.Iter(ctx, "", func(tableDir string) error {
+		table := strings.TrimSuffix(tableDir, objstore.DirDelim)
+		return s.blocks.Iter(ctx, tableDir, func(blockDir string) error {
+			id, err := ulid.Parse(path.Base(blockDir))
+			if err != nil {
+				// Not a block.
+				return nil
+			}
+
+			src := path.Join(blockDir, "data.parquet")
+			r, err := s.blocks.Get(ctx, src)
+			if err != nil {
+				return fmt.Errorf("failed to read block %s: %w", src, err)
+			}
+			defer r.Close()
+
+			file := path.Join(tablesDir, table, id.String()+".parquet")
+			if err := s.bucket.Upload(ctx, path.Join(name, file), r); err != nil {
+				return fmt.Errorf("failed to snapshot block %s: %w", src, err)
+			}
+			tables[table] = append(tables[table], file)
+			return nil
+		})
+	})
+}
+
+// upload uploads what the write function writes as the object of the name.
+func (s *Snapshotter) upload(ctx context.Context, name string, write func(w io.Writer) error) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(write(w))
+	}()
+	defer r.Close()
+
+	return s.bucket.Upload(ctx, name, r)
+}
+
+// Restore loads the snapshot of the given name into the metastore and the
+// tables. It must be restored before profiles are written, and into a
+// server that doesn't hold the profiles of the snapshot already, as they
+// would be stored twice otherwise. Snapshots that were restored before are
+// skipped.
+func (s *Snapshotter) Restore(ctx context.Context, name string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	logger := log.With(s.logger, "snapshot", name)
+	restoredKey := []byte(restoredKeyPrefix + name)
+	restored, err := s.restored(restoredKey)
+	if err != nil {
+		return err
+	}
+	if restored {
+		level.Info(logger).Log("msg", "snapshot was restored before, skipping")
+		return nil
+	}
+
+	meta, err := s.meta(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "restoring snapshot", "created", meta.Created)
+	start := time.Now()
+
+	r, err := s.bucket.Get(ctx, path.Join(name, metastoreFile))
+	if err != nil {
+		return fmt.Errorf("failed to read metastore snapshot: %w", err)
+	}
+	err = s.metastore.Load(r, loadMaxPendingWrites)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("failed to restore metastore: %w", err)
+	}
+
+	for table, files := range meta.Tables {
+		t, err := s.db.Table(table, s.config)
+		if err != nil {
+			return fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+		for _, file := range files {
+			if err := s.insert(ctx, t, path.Join(name, file)); err != nil {
+				return fmt.Errorf("failed to restore table %s: %w", table, err)
+			}
+		}
+		if s.register != nil {
+			if err := s.register(table); err != nil {
+				return fmt.Errorf("failed to register restored table %s: %w", table, err)
+			}
+		}
+	}
+
+	if err := s.metastore.Update(func(txn *badger.Txn) error {
+		return txn.Set(restoredKey, nil)
+	}); err != nil {
+		return fmt.Errorf("failed to mark snapshot as restored: %w", err)
+	}
+
+	level.Info(logger).Log("msg", "restored snapshot", "duration", time.Since(start))
+	return nil
+}
+
+func (s *Snapshotter) restored(key []byte) (bool, error) {
+	err := s.metastore.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Snapshotter) meta(ctx context.Context, name string) (Meta, error) {
+	var meta Meta
+	r, err := s.bucket.Get(ctx, path.Join(name, metaFile))
+	if s.bucket.IsObjNotFoundErr(err) {
+		return meta, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return meta, fmt.Errorf("failed to read snapshot meta: %w", err)
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return meta, fmt.Errorf("failed to decode snapshot meta: %w", err)
+	}
+	if meta.Version != 1 {
+		return meta, fmt.Errorf("unsupported snapshot version %d", meta.Version)
+	}
+	return meta, nil
+}
+
+// insert inserts the rows of the block in the file into the table.
+func (s *Snapshotter) insert(ctx context.Context, table *frostdb.Table, file string) error {
+	r, err := s.bucket.Get(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = table.Insert(ctx, b)
+	return err
+}
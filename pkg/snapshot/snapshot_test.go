@@ -0,0 +1,234 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v8/arrow/memory"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/go-kit/log"
+	"github.com/polarsignals/frostdb"
+	"github.com/polarsignals/frostdb/query"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/objstore"
+	"go.opentelemetry.io/otel/trace"
+
+	pprofpb "github.com/parca-dev/parca/gen/proto/go/google/pprof"
+	"github.com/parca-dev/parca/pkg/metastore"
+	"github.com/parca-dev/parca/pkg/parcacol"
+)
+
+type testStorage struct {
+	metastore *badger.DB
+	db        *frostdb.DB
+	config    *frostdb.TableConfig
+	querier   func(table string) *parcacol.Querier
+	ingest    func(table, job string)
+}
+
+// newTestStorage returns a storage persisting blocks to the bucket, if any.
+func newTestStorage(t *testing.T, bucket objstore.Bucket) *testStorage {
+	t.Helper()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	reg := prometheus.NewRegistry()
+	tracer := trace.NewNoopTracerProvider().Tracer("")
+
+	mdb, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(&metastore.BadgerLogger{Logger: logger}))
+	require.NoError(t, err)
+	t.Cleanup(func() { mdb.Close() })
+	m := metastore.NewInProcessClient(metastore.NewBadgerMetastore(logger, reg, tracer, mdb))
+
+	var opts []frostdb.Option
+	if bucket != nil {
+		opts = append(opts, frostdb.WithBucketStorage(bucket))
+	}
+	col, err := frostdb.New(logger, reg, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { col.Close() })
+	db, err := col.DB(ctx, "parca")
+	require.NoError(t, err)
+	schema, err := parcacol.Schema()
+	require.NoError(t, err)
+	config := frostdb.NewTableConfig(schema)
+
+	f, err := os.Open("../query/testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	return &testStorage{
+		metastore: mdb,
+		db:        db,
+		config:    config,
+		querier: func(table string) *parcacol.Querier {
+			return parcacol.NewQuerier(tracer, query.NewEngine(memory.DefaultAllocator, db.TableProvider()), table, m)
+		},
+		ingest: func(table, job string) {
+			tbl, err := db.Table(table, config)
+			require.NoError(t, err)
+			p := &pprofpb.Profile{}
+			require.NoError(t, p.UnmarshalVT(b))
+			ingester := parcacol.NewIngester(logger, parcacol.NewNormalizer(m), tbl, schema)
+			require.NoError(t, ingester.Ingest(ctx, labels.Labels{{Name: "__name__", Value: "memory"}, {Name: "job", Value: job}}, p, false))
+		},
+	}
+}
+
+func (s *testStorage) tables(names ...string) func() map[string]*frostdb.Table {
+	return func() map[string]*frostdb.Table {
+		tables := map[string]*frostdb.Table{}
+		for _, name := range names {
+			table, err := s.db.GetTable(name)
+			if err == nil {
+				tables[name] = table
+			}
+		}
+		return tables
+	}
+}
+
+// total returns the total value of the merge of all profiles of the job.
+func (s *testStorage) total(t *testing.T, table, job string) int64 {
+	t.Helper()
+
+	p, err := s.querier(table).QueryMerge(context.Background(), `memory:alloc_objects:count:space:bytes{job="`+job+`"}`, time.Unix(0, 0), time.UnixMilli(math.MaxInt64))
+	require.NoError(t, err)
+
+	var total int64
+	for _, sample := range p.Samples {
+		// Restored stacks must resolve against the restored metastore.
+		require.NotEmpty(t, sample.Locations)
+		total += sample.Value
+	}
+	return total
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snapshots := objstore.NewInMemBucket()
+	blocks := objstore.NewInMemBucket()
+
+	src := newTestStorage(t, blocks)
+	src.ingest("stacktraces", "persisted")
+	table, err := src.db.GetTable("stacktraces")
+	require.NoError(t, err)
+	require.NoError(t, table.RotateBlock(table.ActiveBlock()))
+	require.Eventually(t, func() bool {
+		persisted := 0
+		require.NoError(t, blocks.Iter(ctx, "parca/stacktraces/", func(string) error {
+			persisted++
+			return nil
+		}))
+		return persisted == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	src.ingest("stacktraces", "active")
+	src.ingest("team-a.stacktraces", "tenant")
+
+	expected := map[string]int64{
+		"persisted": src.total(t, "stacktraces", "persisted"),
+		"active":    src.total(t, "stacktraces", "active"),
+		"tenant":    src.total(t, "team-a.stacktraces", "tenant"),
+	}
+	for _, v := range expected {
+		require.NotZero(t, v)
+	}
+
+	s := New(log.NewNopLogger(), snapshots, src.metastore, src.db, src.config, src.tables("stacktraces", "team-a.stacktraces"), nil, objstore.NewPrefixedBucket(blocks, "parca"))
+	name, err := s.Snapshot(ctx)
+	require.NoError(t, err)
+
+	dst := newTestStorage(t, nil)
+	registered := []string{}
+	r := New(log.NewNopLogger(), snapshots, dst.metastore, dst.db, dst.config, dst.tables(), func(table string) error {
+		registered = append(registered, table)
+		return nil
+	}, nil)
+	require.NoError(t, r.Restore(ctx, name))
+	require.ElementsMatch(t, []string{"stacktraces", "team-a.stacktraces"}, registered)
+
+	require.Equal(t, expected["persisted"], dst.total(t, "stacktraces", "persisted"))
+	require.Equal(t, expected["active"], dst.total(t, "stacktraces", "active"))
+	require.Equal(t, expected["tenant"], dst.total(t, "team-a.stacktraces", "tenant"))
+
+	// Restoring it again is skipped.
+	require.NoError(t, r.Restore(ctx, name))
+	require.Equal(t, expected["active"], dst.total(t, "stacktraces", "active"))
+
+	require.ErrorIs(t, r.Restore(ctx, "01GBVCAM9Z0000000000000000"), ErrNotFound)
+}
+
+func TestSnapshotIncomplete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	snapshots := objstore.NewInMemBucket()
+	src := newTestStorage(t, nil)
+	src.ingest("stacktraces", "a")
+
+	s := New(log.NewNopLogger(), snapshots, src.metastore, src.db, src.config, src.tables("stacktraces"), nil, nil)
+	name, err := s.Snapshot(ctx)
+	require.NoError(t, err)
+	require.NoError(t, snapshots.Delete(ctx, name+"/"+metaFile))
+
+	dst := newTestStorage(t, nil)
+	r := New(log.NewNopLogger(), snapshots, dst.metastore, dst.db, dst.config, dst.tables(), nil, nil)
+	require.ErrorIs(t, r.Restore(ctx, name), ErrNotFound)
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	snapshots := objstore.NewInMemBucket()
+	src := newTestStorage(t, nil)
+	s := New(log.NewNopLogger(), snapshots, src.metastore, src.db, src.config, src.tables(), nil, nil)
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(srv.URL, "", nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(body), `"name":"`)
+
+	exists, err := snapshots.Exists(context.Background(), string(bytes.TrimSuffix(body[len(`{"name":"`):], []byte("\"}\n")))+"/"+metaFile)
+	require.NoError(t, err)
+	require.True(t, exists)
+}
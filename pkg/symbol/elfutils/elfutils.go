@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"debug/elf"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -159,6 +160,50 @@ func HasSymbols(path string) (bool, error) {
 	return false, nil
 }
 
+// BuildIDs returns the hex encoded GNU and Go build IDs of the specified executable or library file.
+// Files built by the Go toolchain with external linking have both, stripped files might have neither.
+func BuildIDs(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open elf: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	for _, name := range []string{".note.gnu.build-id", ".note.go.buildid"} {
+		sec := f.Section(name)
+		if sec == nil {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s section: %w", name, err)
+		}
+		id, err := noteDesc(f.ByteOrder, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s section: %w", name, err)
+		}
+		ids = append(ids, hex.EncodeToString(id))
+	}
+	return ids, nil
+}
+
+// noteDesc returns the descriptor of the first note of a note section.
+// A note is the name and descriptor sizes and the type, followed by the name and the descriptor,
+// each aligned to 4 bytes.
+func noteDesc(order binary.ByteOrder, data []byte) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("note is too short")
+	}
+	nameSize := uint64(order.Uint32(data[0:4]))
+	descSize := uint64(order.Uint32(data[4:8]))
+	start := 12 + (nameSize+3)&^3
+	if start+descSize > uint64(len(data)) {
+		return nil, errors.New("note descriptor is out of bounds")
+	}
+	return data[start : start+descSize], nil
+}
+
 // ValidateFile returns an error if the given object file is not valid.
 func ValidateFile(path string) error {
 	elfFile, err := elf.Open(path)
@@ -460,6 +460,7 @@ func setup(t *testing.T) (*grpc.ClientConn, pb.MetastoreServiceClient, *Symboliz
 	metadata := debuginfo.NewObjectStoreMetadata(logger, bucket)
 	dbgStr, err := debuginfo.NewStore(
 		logger,
+		prometheus.NewRegistry(),
 		debugInfoCacheDir,
 		metadata,
 		bucket,
@@ -478,6 +479,7 @@ func setup(t *testing.T) (*grpc.ClientConn, pb.MetastoreServiceClient, *Symboliz
 
 	pStr := profilestore.NewProfileColumnStore(
 		logger,
+		prometheus.NewRegistry(),
 		tracer,
 		metastore,
 		table,
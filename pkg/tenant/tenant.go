@@ -0,0 +1,55 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tenant carries the tenant requests are made by, for deployments
+// isolating the profiles of tenants from each other.
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+// MaxLength is the maximum length of a tenant ID.
+const MaxLength = 64
+
+// validID matches the tenant IDs that can be used in table names and object
+// storage paths.
+var validID = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type tenantKey struct{}
+
+// WithTenant returns a context of requests made by the tenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant of the context. Requests without a tenant
+// are made by the default tenant, which is all requests of single-tenant
+// deployments.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Validate returns an error if the tenant ID is invalid.
+func Validate(id string) error {
+	if len(id) > MaxLength {
+		return fmt.Errorf("tenant ID is longer than %d characters", MaxLength)
+	}
+	if !validID.MatchString(id) {
+		return fmt.Errorf("tenant ID %q must only consist of letters, digits, underscores and dashes", id)
+	}
+	return nil
+}
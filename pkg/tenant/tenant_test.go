@@ -0,0 +1,47 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, Validate("team-a_1"))
+	require.NoError(t, Validate(strings.Repeat("a", MaxLength)))
+
+	for _, id := range []string{"", "a/b", "a.b", "../a", strings.Repeat("a", MaxLength+1)} {
+		require.Error(t, Validate(id), id)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	_, ok := FromContext(context.Background())
+	require.False(t, ok)
+
+	id, ok := FromContext(WithTenant(context.Background(), "team-a"))
+	require.True(t, ok)
+	require.Equal(t, "team-a", id)
+
+	_, ok = FromContext(WithTenant(context.Background(), ""))
+	require.False(t, ok)
+}
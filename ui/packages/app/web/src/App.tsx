@@ -26,23 +26,7 @@ import TargetsPage from './pages/targets';
 import Component404 from './pages/layouts/Component404';
 import {isDevMode} from '@parca/functions';
 import {Provider} from 'react-redux';
-
-declare global {
-  interface Window {
-    PATH_PREFIX: string;
-    APP_VERSION: string;
-  }
-}
-
-function getBasename() {
-  if (!window.PATH_PREFIX) {
-    return '/';
-  }
-  if (window.PATH_PREFIX.startsWith('{{')) {
-    return '/';
-  }
-  return window.PATH_PREFIX;
-}
+import {getBasename} from './pathPrefix';
 
 const {store: reduxStore, persistor} = store();
 
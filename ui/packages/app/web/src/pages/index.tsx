@@ -16,12 +16,12 @@ import {QueryServiceClient} from '@parca/client';
 import {useLocation, useNavigate} from 'react-router-dom';
 import {parseParams, convertToQueryParams} from '@parca/functions';
 import {ProfileExplorer} from '@parca/components';
+import {getApiBaseUrl} from '../pathPrefix';
 
-const apiEndpoint = process.env.REACT_APP_PUBLIC_API_ENDPOINT;
 
 const queryClient = new QueryServiceClient(
   new GrpcWebFetchTransport({
-    baseUrl: apiEndpoint === undefined ? '/api' : `${apiEndpoint}/api`,
+    baseUrl: getApiBaseUrl(),
   })
 );
 
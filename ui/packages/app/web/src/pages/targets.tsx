@@ -17,8 +17,8 @@ import {RpcError} from '@protobuf-ts/runtime-rpc';
 import {EmptyState} from '@parca/components';
 import TargetsTable from '../components/Targets/TargetsTable';
 import {GrpcWebFetchTransport} from '@protobuf-ts/grpcweb-transport';
+import {getApiBaseUrl} from '../pathPrefix';
 
-const apiEndpoint = process.env.REACT_APP_PUBLIC_API_ENDPOINT;
 
 export interface ITargetsResult {
   response: TargetsResponse | null;
@@ -46,7 +46,7 @@ export const useTargets = (client: ScrapeServiceClient): ITargetsResult => {
 
 const scrapeClient = new ScrapeServiceClient(
   new GrpcWebFetchTransport({
-    baseUrl: apiEndpoint === undefined ? '/api' : `${apiEndpoint}/api`,
+    baseUrl: getApiBaseUrl(),
   })
 );
 
@@ -0,0 +1,35 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+declare global {
+  interface Window {
+    PATH_PREFIX: string;
+    APP_VERSION: string;
+  }
+}
+
+// getPathPrefix returns the prefix the server serves the UI and the API under,
+// empty when they are served at the root or the template wasn't rendered.
+export const getPathPrefix = (): string => {
+  if (!window.PATH_PREFIX || window.PATH_PREFIX.startsWith('{{')) {
+    return '';
+  }
+  return window.PATH_PREFIX;
+};
+
+export const getBasename = (): string => getPathPrefix() || '/';
+
+export const getApiBaseUrl = (): string => {
+  const apiEndpoint = process.env.REACT_APP_PUBLIC_API_ENDPOINT;
+  return apiEndpoint === undefined ? `${getPathPrefix()}/api` : `${apiEndpoint}/api`;
+};
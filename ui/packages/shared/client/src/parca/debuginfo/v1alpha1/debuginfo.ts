@@ -43,6 +43,13 @@ export interface ExistsResponse {
      * @generated from protobuf field: bool exists = 1;
      */
     exists: boolean;
+    /**
+     * uploaded_size is the number of bytes received of an interrupted upload of the debug info with the given hash,
+     * the upload can be resumed from this offset
+     *
+     * @generated from protobuf field: uint64 uploaded_size = 2;
+     */
+    uploadedSize: string;
 }
 /**
  * UploadRequest upload debug info
@@ -91,6 +98,13 @@ export interface UploadInfo {
      * @generated from protobuf field: string hash = 2;
      */
     hash: string;
+    /**
+     * offset is the number of bytes of the debug info already uploaded, as returned by Exists,
+     * the chunks resume the interrupted upload from there, which requires the hash
+     *
+     * @generated from protobuf field: uint64 offset = 3;
+     */
+    offset: string;
 }
 /**
  * UploadResponse returns the build_id and the size of the uploaded debug info
@@ -249,11 +263,12 @@ export const ExistsRequest = new ExistsRequest$Type();
 class ExistsResponse$Type extends MessageType<ExistsResponse> {
     constructor() {
         super("parca.debuginfo.v1alpha1.ExistsResponse", [
-            { no: 1, name: "exists", kind: "scalar", T: 8 /*ScalarType.BOOL*/ }
+            { no: 1, name: "exists", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 2, name: "uploaded_size", kind: "scalar", T: 4 /*ScalarType.UINT64*/ }
         ]);
     }
     create(value?: PartialMessage<ExistsResponse>): ExistsResponse {
-        const message = { exists: false };
+        const message = { exists: false, uploadedSize: "0" };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<ExistsResponse>(this, message, value);
@@ -267,6 +282,9 @@ class ExistsResponse$Type extends MessageType<ExistsResponse> {
                 case /* bool exists */ 1:
                     message.exists = reader.bool();
                     break;
+                case /* uint64 uploaded_size */ 2:
+                    message.uploadedSize = reader.uint64().toString();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -282,6 +300,9 @@ class ExistsResponse$Type extends MessageType<ExistsResponse> {
         /* bool exists = 1; */
         if (message.exists !== false)
             writer.tag(1, WireType.Varint).bool(message.exists);
+        /* uint64 uploaded_size = 2; */
+        if (message.uploadedSize !== "0")
+            writer.tag(2, WireType.Varint).uint64(message.uploadedSize);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -357,11 +378,12 @@ class UploadInfo$Type extends MessageType<UploadInfo> {
     constructor() {
         super("parca.debuginfo.v1alpha1.UploadInfo", [
             { no: 1, name: "build_id", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
-            { no: 2, name: "hash", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
+            { no: 2, name: "hash", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 3, name: "offset", kind: "scalar", T: 4 /*ScalarType.UINT64*/ }
         ]);
     }
     create(value?: PartialMessage<UploadInfo>): UploadInfo {
-        const message = { buildId: "", hash: "" };
+        const message = { buildId: "", hash: "", offset: "0" };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<UploadInfo>(this, message, value);
@@ -378,6 +400,9 @@ class UploadInfo$Type extends MessageType<UploadInfo> {
                 case /* string hash */ 2:
                     message.hash = reader.string();
                     break;
+                case /* uint64 offset */ 3:
+                    message.offset = reader.uint64().toString();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -396,6 +421,9 @@ class UploadInfo$Type extends MessageType<UploadInfo> {
         /* string hash = 2; */
         if (message.hash !== "")
             writer.tag(2, WireType.LengthDelimited).string(message.hash);
+        /* uint64 offset = 3; */
+        if (message.offset !== "0")
+            writer.tag(3, WireType.Varint).uint64(message.offset);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -4,6 +4,10 @@
 import type { RpcTransport } from "@protobuf-ts/runtime-rpc";
 import type { ServiceInfo } from "@protobuf-ts/runtime-rpc";
 import { ProfileStoreService } from "./profilestore";
+import type { WriteRawStreamRequest } from "./profilestore";
+import type { WriteTraceResponse } from "./profilestore";
+import type { WriteTraceRequest } from "./profilestore";
+import type { ClientStreamingCall } from "@protobuf-ts/runtime-rpc";
 import { stackIntercept } from "@protobuf-ts/runtime-rpc";
 import type { WriteRawResponse } from "./profilestore";
 import type { WriteRawRequest } from "./profilestore";
@@ -21,6 +25,20 @@ export interface IProfileStoreServiceClient {
      * @generated from protobuf rpc: WriteRaw(parca.profilestore.v1alpha1.WriteRawRequest) returns (parca.profilestore.v1alpha1.WriteRawResponse);
      */
     writeRaw(input: WriteRawRequest, options?: RpcOptions): UnaryCall<WriteRawRequest, WriteRawResponse>;
+    /**
+     * WriteTrace accepts a Go execution trace in chunks and stores the profiles
+     * derived from it
+     *
+     * @generated from protobuf rpc: WriteTrace(stream parca.profilestore.v1alpha1.WriteTraceRequest) returns (parca.profilestore.v1alpha1.WriteTraceResponse);
+     */
+    writeTrace(options?: RpcOptions): ClientStreamingCall<WriteTraceRequest, WriteTraceResponse>;
+    /**
+     * WriteRawStream accepts a raw pprof profile in chunks, for profiles larger
+     * than the maximum size of a gRPC message
+     *
+     * @generated from protobuf rpc: WriteRawStream(stream parca.profilestore.v1alpha1.WriteRawStreamRequest) returns (parca.profilestore.v1alpha1.WriteRawResponse);
+     */
+    writeRawStream(options?: RpcOptions): ClientStreamingCall<WriteRawStreamRequest, WriteRawResponse>;
 }
 /**
  * ProfileStoreService is the service the accepts pprof writes
@@ -42,4 +60,24 @@ export class ProfileStoreServiceClient implements IProfileStoreServiceClient, Se
         const method = this.methods[0], opt = this._transport.mergeOptions(options);
         return stackIntercept<WriteRawRequest, WriteRawResponse>("unary", this._transport, method, opt, input);
     }
+    /**
+     * WriteTrace accepts a Go execution trace in chunks and stores the profiles
+     * derived from it
+     *
+     * @generated from protobuf rpc: WriteTrace(stream parca.profilestore.v1alpha1.WriteTraceRequest) returns (parca.profilestore.v1alpha1.WriteTraceResponse);
+     */
+    writeTrace(options?: RpcOptions): ClientStreamingCall<WriteTraceRequest, WriteTraceResponse> {
+        const method = this.methods[1], opt = this._transport.mergeOptions(options);
+        return stackIntercept<WriteTraceRequest, WriteTraceResponse>("clientStreaming", this._transport, method, opt);
+    }
+    /**
+     * WriteRawStream accepts a raw pprof profile in chunks, for profiles larger
+     * than the maximum size of a gRPC message
+     *
+     * @generated from protobuf rpc: WriteRawStream(stream parca.profilestore.v1alpha1.WriteRawStreamRequest) returns (parca.profilestore.v1alpha1.WriteRawResponse);
+     */
+    writeRawStream(options?: RpcOptions): ClientStreamingCall<WriteRawStreamRequest, WriteRawResponse> {
+        const method = this.methods[2], opt = this._transport.mergeOptions(options);
+        return stackIntercept<WriteRawStreamRequest, WriteRawResponse>("clientStreaming", this._transport, method, opt);
+    }
 }
@@ -39,11 +39,114 @@ export interface WriteRawRequest {
     normalized: boolean;
 }
 /**
- * WriteRawResponse is the empty response
+ * WriteRawResponse is the response to a WriteRaw request
  *
  * @generated from protobuf message parca.profilestore.v1alpha1.WriteRawResponse
  */
 export interface WriteRawResponse {
+    /**
+     * accepted_samples is the number of raw profile samples that were stored,
+     * each of them stored as a profile per sample type
+     *
+     * @generated from protobuf field: uint64 accepted_samples = 1;
+     */
+    acceptedSamples: string;
+    /**
+     * skipped_samples is the number of raw profile samples that were not
+     * stored in the profile table, because none of their sample types was
+     * selected, the sample limit was exceeded or they were quarantined
+     *
+     * @generated from protobuf field: uint64 skipped_samples = 2;
+     */
+    skippedSamples: string;
+}
+/**
+ * WriteRawStreamRequest is a chunk of a raw pprof profile, the options are
+ * only read from the first chunk
+ *
+ * @generated from protobuf message parca.profilestore.v1alpha1.WriteRawStreamRequest
+ */
+export interface WriteRawStreamRequest {
+    /**
+     * labels are the labels of the series of the profile
+     *
+     * @generated from protobuf field: parca.profilestore.v1alpha1.LabelSet labels = 1;
+     */
+    labels?: LabelSet;
+    /**
+     * chunk is the next part of the raw profile
+     *
+     * @generated from protobuf field: bytes chunk = 2;
+     */
+    chunk: Uint8Array;
+    /**
+     * content_encoding is how the raw profile is compressed, like the
+     * content_encoding of a RawSample
+     *
+     * @generated from protobuf field: string content_encoding = 3;
+     */
+    contentEncoding: string;
+    /**
+     * sample_types are the sample types of the profile to store, like the
+     * sample_types of a RawProfileSeries
+     *
+     * @generated from protobuf field: repeated parca.profilestore.v1alpha1.SampleType sample_types = 4;
+     */
+    sampleTypes: SampleType[];
+    /**
+     * normalized is a flag indicating if the addresses in the profile is normalized for position independent code
+     *
+     * @generated from protobuf field: bool normalized = 5;
+     */
+    normalized: boolean;
+    /**
+     * format is the format of the raw profile, like the format of a RawSample
+     *
+     * @generated from protobuf field: string format = 6;
+     */
+    format: string;
+}
+/**
+ * WriteTraceRequest is a chunk of a Go execution trace written by
+ * runtime/trace, the options are only read from the first chunk
+ *
+ * @generated from protobuf message parca.profilestore.v1alpha1.WriteTraceRequest
+ */
+export interface WriteTraceRequest {
+    /**
+     * labels are the labels of the series of the derived profiles, the name of
+     * each derived profile is added as the __name__ label
+     *
+     * @generated from protobuf field: parca.profilestore.v1alpha1.LabelSet labels = 1;
+     */
+    labels?: LabelSet;
+    /**
+     * chunk is the next part of the trace
+     *
+     * @generated from protobuf field: bytes chunk = 2;
+     */
+    chunk: Uint8Array;
+    /**
+     * runtime_metrics derives the garbage collection and goroutine creation
+     * profiles in addition to the CPU profile
+     *
+     * @generated from protobuf field: bool runtime_metrics = 3;
+     */
+    runtimeMetrics: boolean;
+    /**
+     * cpu_profile_rate is the rate in Hz the CPU profiler sampled at while
+     * tracing, 100 if unset
+     *
+     * @generated from protobuf field: int64 cpu_profile_rate = 4;
+     */
+    cpuProfileRate: string;
+}
+/**
+ * WriteTraceResponse is the empty response
+ *
+ * @generated from protobuf message parca.profilestore.v1alpha1.WriteTraceResponse
+ */
+export interface WriteTraceResponse {
 }
 /**
  * RawProfileSeries represents the pprof profile and its associated labels
@@ -63,6 +166,34 @@ export interface RawProfileSeries {
      * @generated from protobuf field: repeated parca.profilestore.v1alpha1.RawSample samples = 2;
      */
     samples: RawSample[];
+    /**
+     * sample_types are the sample types of the profiles of the series to
+     * store, the others are skipped, like to only store the allocated bytes of
+     * heap profiles. All sample types are stored if empty. Both the type and
+     * the unit must match, case sensitively.
+     *
+     * @generated from protobuf field: repeated parca.profilestore.v1alpha1.SampleType sample_types = 3;
+     */
+    sampleTypes: SampleType[];
+}
+/**
+ * SampleType is a sample type of a profile.
+ *
+ * @generated from protobuf message parca.profilestore.v1alpha1.SampleType
+ */
+export interface SampleType {
+    /**
+     * type is the type of the sample values, like alloc_space.
+     *
+     * @generated from protobuf field: string type = 1;
+     */
+    type: string;
+    /**
+     * unit is the unit of the sample values, like bytes.
+     *
+     * @generated from protobuf field: string unit = 2;
+     */
+    unit: string;
 }
 /**
  * Label is a key value pair of identifiers
@@ -108,6 +239,63 @@ export interface RawSample {
      * @generated from protobuf field: bytes raw_profile = 1;
      */
     rawProfile: Uint8Array;
+    /**
+     * content_encoding is how the raw profile is compressed, one of none, gzip
+     * or zstd. If unset, profiles starting with the gzip magic bytes are
+     * decompressed as gzip, others are parsed as they are.
+     *
+     * @generated from protobuf field: string content_encoding = 2;
+     */
+    contentEncoding: string;
+    /**
+     * symbols names the functions at the addresses of locations of the raw
+     * profile that have no lines, for agents that symbolize profiles
+     * themselves. Locations whose address has no symbol stay unsymbolized.
+     *
+     * @generated from protobuf field: repeated parca.profilestore.v1alpha1.AddressSymbol symbols = 3;
+     */
+    symbols: AddressSymbol[];
+    /**
+     * format is the format of the raw profile once decompressed, pprof if
+     * unset. folded are collapsed stacks like written by the stackcollapse
+     * scripts of FlameGraph, a line per stack of semicolon separated frames,
+     * root first, followed by a space and the number of samples.
+     *
+     * @generated from protobuf field: string format = 4;
+     */
+    format: string;
+}
+/**
+ * AddressSymbol is a function at an address of a raw profile.
+ *
+ * @generated from protobuf message parca.profilestore.v1alpha1.AddressSymbol
+ */
+export interface AddressSymbol {
+    /**
+     * address is the address of the location, as it is in the profile.
+     *
+     * @generated from protobuf field: uint64 address = 1;
+     */
+    address: string;
+    /**
+     * function_name is the name of the function. Addresses of inlined
+     * functions have a symbol per function, innermost first.
+     *
+     * @generated from protobuf field: string function_name = 2;
+     */
+    functionName: string;
+    /**
+     * file_name is the source file of the function.
+     *
+     * @generated from protobuf field: string file_name = 3;
+     */
+    fileName: string;
+    /**
+     * line is the line number in the source file.
+     *
+     * @generated from protobuf field: int64 line = 4;
+     */
+    line: string;
 }
 // @generated message type with reflection information, may provide speed optimized methods
 class WriteRawRequest$Type extends MessageType<WriteRawRequest> {
@@ -173,19 +361,47 @@ export const WriteRawRequest = new WriteRawRequest$Type();
 // @generated message type with reflection information, may provide speed optimized methods
 class WriteRawResponse$Type extends MessageType<WriteRawResponse> {
     constructor() {
-        super("parca.profilestore.v1alpha1.WriteRawResponse", []);
+        super("parca.profilestore.v1alpha1.WriteRawResponse", [
+            { no: 1, name: "accepted_samples", kind: "scalar", T: 4 /*ScalarType.UINT64*/ },
+            { no: 2, name: "skipped_samples", kind: "scalar", T: 4 /*ScalarType.UINT64*/ }
+        ]);
     }
     create(value?: PartialMessage<WriteRawResponse>): WriteRawResponse {
-        const message = {};
+        const message = { acceptedSamples: "0", skippedSamples: "0" };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<WriteRawResponse>(this, message, value);
         return message;
     }
     internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: WriteRawResponse): WriteRawResponse {
-        return target ?? this.create();
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* uint64 accepted_samples */ 1:
+                    message.acceptedSamples = reader.uint64().toString();
+                    break;
+                case /* uint64 skipped_samples */ 2:
+                    message.skippedSamples = reader.uint64().toString();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
     }
     internalBinaryWrite(message: WriteRawResponse, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* uint64 accepted_samples = 1; */
+        if (message.acceptedSamples !== "0")
+            writer.tag(1, WireType.Varint).uint64(message.acceptedSamples);
+        /* uint64 skipped_samples = 2; */
+        if (message.skippedSamples !== "0")
+            writer.tag(2, WireType.Varint).uint64(message.skippedSamples);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -197,15 +413,192 @@ class WriteRawResponse$Type extends MessageType<WriteRawResponse> {
  */
 export const WriteRawResponse = new WriteRawResponse$Type();
 // @generated message type with reflection information, may provide speed optimized methods
+class WriteRawStreamRequest$Type extends MessageType<WriteRawStreamRequest> {
+    constructor() {
+        super("parca.profilestore.v1alpha1.WriteRawStreamRequest", [
+            { no: 1, name: "labels", kind: "message", T: () => LabelSet },
+            { no: 2, name: "chunk", kind: "scalar", T: 12 /*ScalarType.BYTES*/ },
+            { no: 3, name: "content_encoding", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 4, name: "sample_types", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => SampleType },
+            { no: 5, name: "normalized", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 6, name: "format", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
+        ]);
+    }
+    create(value?: PartialMessage<WriteRawStreamRequest>): WriteRawStreamRequest {
+        const message = { chunk: new Uint8Array(0), contentEncoding: "", sampleTypes: [], normalized: false, format: "" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<WriteRawStreamRequest>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: WriteRawStreamRequest): WriteRawStreamRequest {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* parca.profilestore.v1alpha1.LabelSet labels */ 1:
+                    message.labels = LabelSet.internalBinaryRead(reader, reader.uint32(), options, message.labels);
+                    break;
+                case /* bytes chunk */ 2:
+                    message.chunk = reader.bytes();
+                    break;
+                case /* string content_encoding */ 3:
+                    message.contentEncoding = reader.string();
+                    break;
+                case /* repeated parca.profilestore.v1alpha1.SampleType sample_types */ 4:
+                    message.sampleTypes.push(SampleType.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
+                case /* bool normalized */ 5:
+                    message.normalized = reader.bool();
+                    break;
+                case /* string format */ 6:
+                    message.format = reader.string();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: WriteRawStreamRequest, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* parca.profilestore.v1alpha1.LabelSet labels = 1; */
+        if (message.labels)
+            LabelSet.internalBinaryWrite(message.labels, writer.tag(1, WireType.LengthDelimited).fork(), options).join();
+        /* bytes chunk = 2; */
+        if (message.chunk.length)
+            writer.tag(2, WireType.LengthDelimited).bytes(message.chunk);
+        /* string content_encoding = 3; */
+        if (message.contentEncoding !== "")
+            writer.tag(3, WireType.LengthDelimited).string(message.contentEncoding);
+        /* repeated parca.profilestore.v1alpha1.SampleType sample_types = 4; */
+        for (let i = 0; i < message.sampleTypes.length; i++)
+            SampleType.internalBinaryWrite(message.sampleTypes[i], writer.tag(4, WireType.LengthDelimited).fork(), options).join();
+        /* bool normalized = 5; */
+        if (message.normalized !== false)
+            writer.tag(5, WireType.Varint).bool(message.normalized);
+        /* string format = 6; */
+        if (message.format !== "")
+            writer.tag(6, WireType.LengthDelimited).string(message.format);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.profilestore.v1alpha1.WriteRawStreamRequest
+ */
+export const WriteRawStreamRequest = new WriteRawStreamRequest$Type();
+// @generated message type with reflection information, may provide speed optimized methods
+class WriteTraceRequest$Type extends MessageType<WriteTraceRequest> {
+    constructor() {
+        super("parca.profilestore.v1alpha1.WriteTraceRequest", [
+            { no: 1, name: "labels", kind: "message", T: () => LabelSet },
+            { no: 2, name: "chunk", kind: "scalar", T: 12 /*ScalarType.BYTES*/ },
+            { no: 3, name: "runtime_metrics", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 4, name: "cpu_profile_rate", kind: "scalar", T: 3 /*ScalarType.INT64*/ }
+        ]);
+    }
+    create(value?: PartialMessage<WriteTraceRequest>): WriteTraceRequest {
+        const message = { chunk: new Uint8Array(0), runtimeMetrics: false, cpuProfileRate: "0" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<WriteTraceRequest>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: WriteTraceRequest): WriteTraceRequest {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* parca.profilestore.v1alpha1.LabelSet labels */ 1:
+                    message.labels = LabelSet.internalBinaryRead(reader, reader.uint32(), options, message.labels);
+                    break;
+                case /* bytes chunk */ 2:
+                    message.chunk = reader.bytes();
+                    break;
+                case /* bool runtime_metrics */ 3:
+                    message.runtimeMetrics = reader.bool();
+                    break;
+                case /* int64 cpu_profile_rate */ 4:
+                    message.cpuProfileRate = reader.int64().toString();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: WriteTraceRequest, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* parca.profilestore.v1alpha1.LabelSet labels = 1; */
+        if (message.labels)
+            LabelSet.internalBinaryWrite(message.labels, writer.tag(1, WireType.LengthDelimited).fork(), options).join();
+        /* bytes chunk = 2; */
+        if (message.chunk.length)
+            writer.tag(2, WireType.LengthDelimited).bytes(message.chunk);
+        /* bool runtime_metrics = 3; */
+        if (message.runtimeMetrics !== false)
+            writer.tag(3, WireType.Varint).bool(message.runtimeMetrics);
+        /* int64 cpu_profile_rate = 4; */
+        if (message.cpuProfileRate !== "0")
+            writer.tag(4, WireType.Varint).int64(message.cpuProfileRate);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.profilestore.v1alpha1.WriteTraceRequest
+ */
+export const WriteTraceRequest = new WriteTraceRequest$Type();
+// @generated message type with reflection information, may provide speed optimized methods
+class WriteTraceResponse$Type extends MessageType<WriteTraceResponse> {
+    constructor() {
+        super("parca.profilestore.v1alpha1.WriteTraceResponse", []);
+    }
+    create(value?: PartialMessage<WriteTraceResponse>): WriteTraceResponse {
+        const message = {};
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<WriteTraceResponse>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: WriteTraceResponse): WriteTraceResponse {
+        return target ?? this.create();
+    }
+    internalBinaryWrite(message: WriteTraceResponse, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.profilestore.v1alpha1.WriteTraceResponse
+ */
+export const WriteTraceResponse = new WriteTraceResponse$Type();
+// @generated message type with reflection information, may provide speed optimized methods
 class RawProfileSeries$Type extends MessageType<RawProfileSeries> {
     constructor() {
         super("parca.profilestore.v1alpha1.RawProfileSeries", [
             { no: 1, name: "labels", kind: "message", T: () => LabelSet },
-            { no: 2, name: "samples", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => RawSample }
+            { no: 2, name: "samples", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => RawSample },
+            { no: 3, name: "sample_types", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => SampleType }
         ]);
     }
     create(value?: PartialMessage<RawProfileSeries>): RawProfileSeries {
-        const message = { samples: [] };
+        const message = { samples: [], sampleTypes: [] };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<RawProfileSeries>(this, message, value);
@@ -222,6 +615,9 @@ class RawProfileSeries$Type extends MessageType<RawProfileSeries> {
                 case /* repeated parca.profilestore.v1alpha1.RawSample samples */ 2:
                     message.samples.push(RawSample.internalBinaryRead(reader, reader.uint32(), options));
                     break;
+                case /* repeated parca.profilestore.v1alpha1.SampleType sample_types */ 3:
+                    message.sampleTypes.push(SampleType.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -240,6 +636,9 @@ class RawProfileSeries$Type extends MessageType<RawProfileSeries> {
         /* repeated parca.profilestore.v1alpha1.RawSample samples = 2; */
         for (let i = 0; i < message.samples.length; i++)
             RawSample.internalBinaryWrite(message.samples[i], writer.tag(2, WireType.LengthDelimited).fork(), options).join();
+        /* repeated parca.profilestore.v1alpha1.SampleType sample_types = 3; */
+        for (let i = 0; i < message.sampleTypes.length; i++)
+            SampleType.internalBinaryWrite(message.sampleTypes[i], writer.tag(3, WireType.LengthDelimited).fork(), options).join();
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -250,6 +649,59 @@ class RawProfileSeries$Type extends MessageType<RawProfileSeries> {
  * @generated MessageType for protobuf message parca.profilestore.v1alpha1.RawProfileSeries
  */
 export const RawProfileSeries = new RawProfileSeries$Type();
+class SampleType$Type extends MessageType<SampleType> {
+    constructor() {
+        super("parca.profilestore.v1alpha1.SampleType", [
+            { no: 1, name: "type", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 2, name: "unit", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
+        ]);
+    }
+    create(value?: PartialMessage<SampleType>): SampleType {
+        const message = { type: "", unit: "" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<SampleType>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: SampleType): SampleType {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* string type */ 1:
+                    message.type = reader.string();
+                    break;
+                case /* string unit */ 2:
+                    message.unit = reader.string();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: SampleType, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* string type = 1; */
+        if (message.type !== "")
+            writer.tag(1, WireType.LengthDelimited).string(message.type);
+        /* string unit = 2; */
+        if (message.unit !== "")
+            writer.tag(2, WireType.LengthDelimited).string(message.unit);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.profilestore.v1alpha1.SampleType
+ */
+export const SampleType = new SampleType$Type();
 // @generated message type with reflection information, may provide speed optimized methods
 class Label$Type extends MessageType<Label> {
     constructor() {
@@ -355,11 +807,14 @@ export const LabelSet = new LabelSet$Type();
 class RawSample$Type extends MessageType<RawSample> {
     constructor() {
         super("parca.profilestore.v1alpha1.RawSample", [
-            { no: 1, name: "raw_profile", kind: "scalar", T: 12 /*ScalarType.BYTES*/ }
+            { no: 1, name: "raw_profile", kind: "scalar", T: 12 /*ScalarType.BYTES*/ },
+            { no: 2, name: "content_encoding", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 3, name: "symbols", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => AddressSymbol },
+            { no: 4, name: "format", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
         ]);
     }
     create(value?: PartialMessage<RawSample>): RawSample {
-        const message = { rawProfile: new Uint8Array(0) };
+        const message = { rawProfile: new Uint8Array(0), contentEncoding: "", symbols: [], format: "" };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<RawSample>(this, message, value);
@@ -373,6 +828,15 @@ class RawSample$Type extends MessageType<RawSample> {
                 case /* bytes raw_profile */ 1:
                     message.rawProfile = reader.bytes();
                     break;
+                case /* string content_encoding */ 2:
+                    message.contentEncoding = reader.string();
+                    break;
+                case /* repeated parca.profilestore.v1alpha1.AddressSymbol symbols */ 3:
+                    message.symbols.push(AddressSymbol.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
+                case /* string format */ 4:
+                    message.format = reader.string();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -388,6 +852,15 @@ class RawSample$Type extends MessageType<RawSample> {
         /* bytes raw_profile = 1; */
         if (message.rawProfile.length)
             writer.tag(1, WireType.LengthDelimited).bytes(message.rawProfile);
+        /* string content_encoding = 2; */
+        if (message.contentEncoding !== "")
+            writer.tag(2, WireType.LengthDelimited).string(message.contentEncoding);
+        /* repeated parca.profilestore.v1alpha1.AddressSymbol symbols = 3; */
+        for (let i = 0; i < message.symbols.length; i++)
+            AddressSymbol.internalBinaryWrite(message.symbols[i], writer.tag(3, WireType.LengthDelimited).fork(), options).join();
+        /* string format = 4; */
+        if (message.format !== "")
+            writer.tag(4, WireType.LengthDelimited).string(message.format);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -398,9 +871,79 @@ class RawSample$Type extends MessageType<RawSample> {
  * @generated MessageType for protobuf message parca.profilestore.v1alpha1.RawSample
  */
 export const RawSample = new RawSample$Type();
+// @generated message type with reflection information, may provide speed optimized methods
+class AddressSymbol$Type extends MessageType<AddressSymbol> {
+    constructor() {
+        super("parca.profilestore.v1alpha1.AddressSymbol", [
+            { no: 1, name: "address", kind: "scalar", T: 4 /*ScalarType.UINT64*/ },
+            { no: 2, name: "function_name", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 3, name: "file_name", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 4, name: "line", kind: "scalar", T: 3 /*ScalarType.INT64*/ }
+        ]);
+    }
+    create(value?: PartialMessage<AddressSymbol>): AddressSymbol {
+        const message = { address: "0", functionName: "", fileName: "", line: "0" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<AddressSymbol>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: AddressSymbol): AddressSymbol {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* uint64 address */ 1:
+                    message.address = reader.uint64().toString();
+                    break;
+                case /* string function_name */ 2:
+                    message.functionName = reader.string();
+                    break;
+                case /* string file_name */ 3:
+                    message.fileName = reader.string();
+                    break;
+                case /* int64 line */ 4:
+                    message.line = reader.int64().toString();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: AddressSymbol, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* uint64 address = 1; */
+        if (message.address !== "0")
+            writer.tag(1, WireType.Varint).uint64(message.address);
+        /* string function_name = 2; */
+        if (message.functionName !== "")
+            writer.tag(2, WireType.LengthDelimited).string(message.functionName);
+        /* string file_name = 3; */
+        if (message.fileName !== "")
+            writer.tag(3, WireType.LengthDelimited).string(message.fileName);
+        /* int64 line = 4; */
+        if (message.line !== "0")
+            writer.tag(4, WireType.Varint).int64(message.line);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.profilestore.v1alpha1.AddressSymbol
+ */
+export const AddressSymbol = new AddressSymbol$Type();
 /**
  * @generated ServiceType for protobuf service parca.profilestore.v1alpha1.ProfileStoreService
  */
 export const ProfileStoreService = new ServiceType("parca.profilestore.v1alpha1.ProfileStoreService", [
-    { name: "WriteRaw", options: { "google.api.http": { post: "/profiles/writeraw", body: "*" } }, I: WriteRawRequest, O: WriteRawResponse }
+    { name: "WriteRaw", options: { "google.api.http": { post: "/profiles/writeraw", body: "*" } }, I: WriteRawRequest, O: WriteRawResponse },
+    { name: "WriteTrace", clientStreaming: true, options: {}, I: WriteTraceRequest, O: WriteTraceResponse },
+    { name: "WriteRawStream", clientStreaming: true, options: {}, I: WriteRawStreamRequest, O: WriteRawResponse }
 ]);
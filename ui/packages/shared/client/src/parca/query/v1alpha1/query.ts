@@ -17,6 +17,7 @@ import { Function } from "../../metastore/v1alpha1/metastore";
 import { Mapping } from "../../metastore/v1alpha1/metastore";
 import { Location } from "../../metastore/v1alpha1/metastore";
 import { LabelSet } from "../../profilestore/v1alpha1/profilestore";
+import { Duration } from "../../../google/protobuf/duration";
 import { Timestamp } from "../../../google/protobuf/timestamp";
 /**
  * ProfileTypesRequest is the request to retrieve the list of available profile types.
@@ -111,6 +112,36 @@ export interface QueryRangeRequest {
      * @generated from protobuf field: uint32 limit = 4;
      */
     limit: number;
+    /**
+     * primary_sample_type selects the samples of the primary sample type of
+     * the stored profiles, their default sample type, rather than the sample
+     * type of the query
+     *
+     * @generated from protobuf field: bool primary_sample_type = 5;
+     */
+    primarySampleType: boolean;
+    /**
+     * step buckets the samples of each series into buckets of the step, aligned
+     * to start, whose value is the sum of the values of the samples in them.
+     * Samples are returned as stored if unset
+     *
+     * @generated from protobuf field: google.protobuf.Duration step = 6;
+     */
+    step?: Duration;
+    /**
+     * fill_zeros returns buckets without samples with a value of zero instead
+     * of leaving a gap, only applies if step is set
+     *
+     * @generated from protobuf field: bool fill_zeros = 7;
+     */
+    fillZeros: boolean;
+    /**
+     * quarantined selects the quarantined profiles, those written profiles
+     * failing soft checks that are excluded from queries otherwise
+     *
+     * @generated from protobuf field: bool quarantined = 8;
+     */
+    quarantined: boolean;
 }
 /**
  * QueryRangeResponse is the set of matching profile values
@@ -163,13 +194,15 @@ export interface MetricsSeries {
  */
 export interface MetricsSample {
     /**
-     * timestamp is the time the profile was ingested
+     * timestamp is the time the profile was ingested, or the start of the
+     * bucket if the query has a step
      *
      * @generated from protobuf field: google.protobuf.Timestamp timestamp = 1;
      */
     timestamp?: Timestamp;
     /**
-     * value is the cumulative value for the profile
+     * value is the cumulative value for the profile, or the sum of the values
+     * of the profiles in the bucket if the query has a step
      *
      * @generated from protobuf field: int64 value = 2;
      */
@@ -219,6 +252,58 @@ export interface SingleProfile {
      */
     query: string;
 }
+/**
+ * UnionProfile contains parameters for a union query
+ *
+ * @generated from protobuf message parca.query.v1alpha1.UnionProfile
+ */
+export interface UnionProfile {
+    /**
+     * merges are the merges whose profiles are united, the sources of flame
+     * graph nodes are indexes into them
+     *
+     * @generated from protobuf field: repeated parca.query.v1alpha1.MergeProfile merges = 1;
+     */
+    merges: MergeProfile[];
+}
+/**
+ * TraceProfile contains parameters for a request of the samples captured during a trace
+ *
+ * @generated from protobuf message parca.query.v1alpha1.TraceProfile
+ */
+export interface TraceProfile {
+    /**
+     * query is the query string to match profiles against
+     *
+     * @generated from protobuf field: string query = 1;
+     */
+    query: string;
+    /**
+     * trace_id is the ID of the trace the samples were captured during
+     *
+     * @generated from protobuf field: string trace_id = 2;
+     */
+    traceId: string;
+    /**
+     * start is the beginning of the evaluation time window
+     *
+     * @generated from protobuf field: google.protobuf.Timestamp start = 3;
+     */
+    start?: Timestamp;
+    /**
+     * end is the end of the evaluation time window
+     *
+     * @generated from protobuf field: google.protobuf.Timestamp end = 4;
+     */
+    end?: Timestamp;
+    /**
+     * span_id is the ID of a span of the trace, if set only the samples
+     * captured during the span are returned
+     *
+     * @generated from protobuf field: string span_id = 5;
+     */
+    spanId: string;
+}
 /**
  * DiffProfile contains parameters for a profile diff request
  *
@@ -237,6 +322,13 @@ export interface DiffProfile {
      * @generated from protobuf field: parca.query.v1alpha1.ProfileDiffSelection b = 2;
      */
     b?: ProfileDiffSelection;
+    /**
+     * function_changes requests the functions that appeared and disappeared
+     * between a and b in addition to the report
+     *
+     * @generated from protobuf field: bool function_changes = 3;
+     */
+    functionChanges: boolean;
 }
 /**
  * ProfileDiffSelection contains the parameters of a diff selection
@@ -331,6 +423,22 @@ export interface QueryRequest {
          * @generated from protobuf field: parca.query.v1alpha1.SingleProfile single = 4;
          */
         single: SingleProfile;
+    } | {
+        oneofKind: "trace";
+        /**
+         * trace contains the trace query options
+         *
+         * @generated from protobuf field: parca.query.v1alpha1.TraceProfile trace = 6;
+         */
+        trace: TraceProfile;
+    } | {
+        oneofKind: "union";
+        /**
+         * union contains the union query options
+         *
+         * @generated from protobuf field: parca.query.v1alpha1.UnionProfile union = 9;
+         */
+        union: UnionProfile;
     } | {
         oneofKind: undefined;
     };
@@ -340,6 +448,66 @@ export interface QueryRequest {
      * @generated from protobuf field: parca.query.v1alpha1.QueryRequest.ReportType report_type = 5;
      */
     reportType: QueryRequest_ReportType;
+    /**
+     * subtree re-roots the flame graph at a function, if set
+     *
+     * @generated from protobuf field: parca.query.v1alpha1.Subtree subtree = 7;
+     */
+    subtree?: Subtree;
+    /**
+     * percent_only returns the values of the report relative to the total of
+     * the profile, in basis points (hundredths of a percent), so that profiles
+     * of different totals are comparable. The sides of a diff are each relative
+     * to their own total.
+     *
+     * @generated from protobuf field: bool percent_only = 8;
+     */
+    percentOnly: boolean;
+    /**
+     * primary_sample_type selects the samples of the primary sample type of
+     * the stored profiles, their default sample type, rather than the sample
+     * type of the queries
+     *
+     * @generated from protobuf field: bool primary_sample_type = 10;
+     */
+    primarySampleType: boolean;
+    /**
+     * leaves_only limits the top table to the functions at the leaves of
+     * stacks, those with a flat value, ranked by it. It is only supported for
+     * the top table.
+     *
+     * @generated from protobuf field: bool leaves_only = 11;
+     */
+    leavesOnly: boolean;
+    /**
+     * quarantined selects the quarantined profiles, those written profiles
+     * failing soft checks that are excluded from queries otherwise
+     *
+     * @generated from protobuf field: bool quarantined = 12;
+     */
+    quarantined: boolean;
+    /**
+     * focus limits the report to the stacks with a function whose name matches
+     * the regex, like the focus option of pprof
+     *
+     * @generated from protobuf field: string focus = 13;
+     */
+    focus: string;
+    /**
+     * ignore excludes the stacks with a function whose name matches the regex
+     * from the report, like the ignore option of pprof
+     *
+     * @generated from protobuf field: string ignore = 14;
+     */
+    ignore: string;
+    /**
+     * show_from drops the callers of the outermost function of every stack
+     * whose name matches the regex, and the stacks without such a function,
+     * like the show_from option of pprof
+     *
+     * @generated from protobuf field: string show_from = 15;
+     */
+    showFrom: string;
 }
 /**
  * Mode is the type of query request
@@ -364,7 +532,20 @@ export enum QueryRequest_Mode {
      *
      * @generated from protobuf enum value: MODE_MERGE = 2;
      */
-    MERGE = 2
+    MERGE = 2,
+    /**
+     * MODE_TRACE is a query of the samples captured during a trace
+     *
+     * @generated from protobuf enum value: MODE_TRACE = 3;
+     */
+    TRACE = 3,
+    /**
+     * MODE_UNION is a merge of multiple queries that keeps which of them
+     * each node of the flame graph came from
+     *
+     * @generated from protobuf enum value: MODE_UNION = 4;
+     */
+    UNION = 4
 }
 /**
  * ReportType is the type of report to return
@@ -397,6 +578,45 @@ export enum QueryRequest_ReportType {
      */
     CALLGRAPH = 3
 }
+/**
+ * Subtree selects the part of a flame graph below a function
+ *
+ * @generated from protobuf message parca.query.v1alpha1.Subtree
+ */
+export interface Subtree {
+    /**
+     * function_name is the name of the function to re-root the flame graph at
+     *
+     * @generated from protobuf field: string function_name = 1;
+     */
+    functionName: string;
+    /**
+     * mode specifies how occurrences of the function are returned
+     *
+     * @generated from protobuf field: parca.query.v1alpha1.Subtree.Mode mode = 2;
+     */
+    mode: Subtree_Mode;
+}
+/**
+ * Mode specifies how occurrences of the function at different stacks are
+ * returned
+ *
+ * @generated from protobuf enum parca.query.v1alpha1.Subtree.Mode
+ */
+export enum Subtree_Mode {
+    /**
+     * MODE_MERGE_UNSPECIFIED merges all occurrences into a single subtree
+     *
+     * @generated from protobuf enum value: MODE_MERGE_UNSPECIFIED = 0;
+     */
+    MERGE_UNSPECIFIED = 0,
+    /**
+     * MODE_LIST returns one subtree per occurrence
+     *
+     * @generated from protobuf enum value: MODE_LIST = 1;
+     */
+    LIST = 1
+}
 /**
  * Top is the top report type
  *
@@ -576,6 +796,13 @@ export interface FlamegraphNode {
      * @generated from protobuf field: repeated parca.query.v1alpha1.FlamegraphNode children = 4;
      */
     children: FlamegraphNode[];
+    /**
+     * sources are the indexes of the merges of a union query whose profiles
+     * contain the node, in ascending order
+     *
+     * @generated from protobuf field: repeated int32 sources = 5;
+     */
+    sources: number[];
 }
 /**
  * FlamegraphNodeMeta is the metadata for a given node
@@ -770,6 +997,72 @@ export interface QueryResponse {
     } | {
         oneofKind: undefined;
     };
+    /**
+     * function_changes are the functions that appeared and disappeared between
+     * the profiles of a diff, if requested
+     *
+     * @generated from protobuf field: parca.query.v1alpha1.FunctionChanges function_changes = 9;
+     */
+    functionChanges?: FunctionChanges;
+    /**
+     * warnings are issues with the query that didn't prevent answering it, like
+     * a merge of samples spanning more time than expected
+     *
+     * @generated from protobuf field: repeated string warnings = 10;
+     */
+    warnings: string[];
+}
+/**
+ * FunctionChanges are the functions that are only present in one of two
+ * diffed profiles
+ *
+ * @generated from protobuf message parca.query.v1alpha1.FunctionChanges
+ */
+export interface FunctionChanges {
+    /**
+     * added are the functions present in b but not in a
+     *
+     * @generated from protobuf field: repeated parca.query.v1alpha1.FunctionChange added = 1;
+     */
+    added: FunctionChange[];
+    /**
+     * removed are the functions present in a but not in b
+     *
+     * @generated from protobuf field: repeated parca.query.v1alpha1.FunctionChange removed = 2;
+     */
+    removed: FunctionChange[];
+    /**
+     * unit is the unit of the values
+     *
+     * @generated from protobuf field: string unit = 3;
+     */
+    unit: string;
+}
+/**
+ * FunctionChange is a function only present in one of two diffed profiles
+ *
+ * @generated from protobuf message parca.query.v1alpha1.FunctionChange
+ */
+export interface FunctionChange {
+    /**
+     * function is the function information
+     *
+     * @generated from protobuf field: parca.metastore.v1alpha1.Function function = 1;
+     */
+    function?: Function;
+    /**
+     * cumulative is the cumulative value of the function in the profile it is
+     * present in
+     *
+     * @generated from protobuf field: int64 cumulative = 2;
+     */
+    cumulative: string;
+    /**
+     * flat is the flat value of the function in the profile it is present in
+     *
+     * @generated from protobuf field: int64 flat = 3;
+     */
+    flat: string;
 }
 /**
  * SeriesRequest is unimplemented
@@ -1110,11 +1403,15 @@ class QueryRangeRequest$Type extends MessageType<QueryRangeRequest> {
             { no: 1, name: "query", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
             { no: 2, name: "start", kind: "message", T: () => Timestamp },
             { no: 3, name: "end", kind: "message", T: () => Timestamp },
-            { no: 4, name: "limit", kind: "scalar", T: 13 /*ScalarType.UINT32*/ }
+            { no: 4, name: "limit", kind: "scalar", T: 13 /*ScalarType.UINT32*/ },
+            { no: 5, name: "primary_sample_type", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 6, name: "step", kind: "message", T: () => Duration },
+            { no: 7, name: "fill_zeros", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 8, name: "quarantined", kind: "scalar", T: 8 /*ScalarType.BOOL*/ }
         ]);
     }
     create(value?: PartialMessage<QueryRangeRequest>): QueryRangeRequest {
-        const message = { query: "", limit: 0 };
+        const message = { query: "", limit: 0, primarySampleType: false, fillZeros: false, quarantined: false };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<QueryRangeRequest>(this, message, value);
@@ -1137,6 +1434,18 @@ class QueryRangeRequest$Type extends MessageType<QueryRangeRequest> {
                 case /* uint32 limit */ 4:
                     message.limit = reader.uint32();
                     break;
+                case /* bool primary_sample_type */ 5:
+                    message.primarySampleType = reader.bool();
+                    break;
+                case /* google.protobuf.Duration step */ 6:
+                    message.step = Duration.internalBinaryRead(reader, reader.uint32(), options, message.step);
+                    break;
+                case /* bool fill_zeros */ 7:
+                    message.fillZeros = reader.bool();
+                    break;
+                case /* bool quarantined */ 8:
+                    message.quarantined = reader.bool();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -1161,6 +1470,18 @@ class QueryRangeRequest$Type extends MessageType<QueryRangeRequest> {
         /* uint32 limit = 4; */
         if (message.limit !== 0)
             writer.tag(4, WireType.Varint).uint32(message.limit);
+        /* bool primary_sample_type = 5; */
+        if (message.primarySampleType !== false)
+            writer.tag(5, WireType.Varint).bool(message.primarySampleType);
+        /* google.protobuf.Duration step = 6; */
+        if (message.step)
+            Duration.internalBinaryWrite(message.step, writer.tag(6, WireType.LengthDelimited).fork(), options).join();
+        /* bool fill_zeros = 7; */
+        if (message.fillZeros !== false)
+            writer.tag(7, WireType.Varint).bool(message.fillZeros);
+        /* bool quarantined = 8; */
+        if (message.quarantined !== false)
+            writer.tag(8, WireType.Varint).bool(message.quarantined);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -1456,15 +1777,138 @@ class SingleProfile$Type extends MessageType<SingleProfile> {
  */
 export const SingleProfile = new SingleProfile$Type();
 // @generated message type with reflection information, may provide speed optimized methods
+class UnionProfile$Type extends MessageType<UnionProfile> {
+    constructor() {
+        super("parca.query.v1alpha1.UnionProfile", [
+            { no: 1, name: "merges", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => MergeProfile }
+        ]);
+    }
+    create(value?: PartialMessage<UnionProfile>): UnionProfile {
+        const message = { merges: [] };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<UnionProfile>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: UnionProfile): UnionProfile {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* repeated parca.query.v1alpha1.MergeProfile merges */ 1:
+                    message.merges.push(MergeProfile.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: UnionProfile, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* repeated parca.query.v1alpha1.MergeProfile merges = 1; */
+        for (let i = 0; i < message.merges.length; i++)
+            MergeProfile.internalBinaryWrite(message.merges[i], writer.tag(1, WireType.LengthDelimited).fork(), options).join();
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.query.v1alpha1.UnionProfile
+ */
+export const UnionProfile = new UnionProfile$Type();
+// @generated message type with reflection information, may provide speed optimized methods
+class TraceProfile$Type extends MessageType<TraceProfile> {
+    constructor() {
+        super("parca.query.v1alpha1.TraceProfile", [
+            { no: 1, name: "query", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 2, name: "trace_id", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 3, name: "start", kind: "message", T: () => Timestamp },
+            { no: 4, name: "end", kind: "message", T: () => Timestamp },
+            { no: 5, name: "span_id", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
+        ]);
+    }
+    create(value?: PartialMessage<TraceProfile>): TraceProfile {
+        const message = { query: "", traceId: "", spanId: "" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<TraceProfile>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: TraceProfile): TraceProfile {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* string query */ 1:
+                    message.query = reader.string();
+                    break;
+                case /* string trace_id */ 2:
+                    message.traceId = reader.string();
+                    break;
+                case /* google.protobuf.Timestamp start */ 3:
+                    message.start = Timestamp.internalBinaryRead(reader, reader.uint32(), options, message.start);
+                    break;
+                case /* google.protobuf.Timestamp end */ 4:
+                    message.end = Timestamp.internalBinaryRead(reader, reader.uint32(), options, message.end);
+                    break;
+                case /* string span_id */ 5:
+                    message.spanId = reader.string();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: TraceProfile, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* string query = 1; */
+        if (message.query !== "")
+            writer.tag(1, WireType.LengthDelimited).string(message.query);
+        /* string trace_id = 2; */
+        if (message.traceId !== "")
+            writer.tag(2, WireType.LengthDelimited).string(message.traceId);
+        /* google.protobuf.Timestamp start = 3; */
+        if (message.start)
+            Timestamp.internalBinaryWrite(message.start, writer.tag(3, WireType.LengthDelimited).fork(), options).join();
+        /* google.protobuf.Timestamp end = 4; */
+        if (message.end)
+            Timestamp.internalBinaryWrite(message.end, writer.tag(4, WireType.LengthDelimited).fork(), options).join();
+        /* string span_id = 5; */
+        if (message.spanId !== "")
+            writer.tag(5, WireType.LengthDelimited).string(message.spanId);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.query.v1alpha1.TraceProfile
+ */
+export const TraceProfile = new TraceProfile$Type();
+// @generated message type with reflection information, may provide speed optimized methods
 class DiffProfile$Type extends MessageType<DiffProfile> {
     constructor() {
         super("parca.query.v1alpha1.DiffProfile", [
             { no: 1, name: "a", kind: "message", T: () => ProfileDiffSelection },
-            { no: 2, name: "b", kind: "message", T: () => ProfileDiffSelection }
+            { no: 2, name: "b", kind: "message", T: () => ProfileDiffSelection },
+            { no: 3, name: "function_changes", kind: "scalar", T: 8 /*ScalarType.BOOL*/ }
         ]);
     }
     create(value?: PartialMessage<DiffProfile>): DiffProfile {
-        const message = {};
+        const message = { functionChanges: false };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<DiffProfile>(this, message, value);
@@ -1481,6 +1925,9 @@ class DiffProfile$Type extends MessageType<DiffProfile> {
                 case /* parca.query.v1alpha1.ProfileDiffSelection b */ 2:
                     message.b = ProfileDiffSelection.internalBinaryRead(reader, reader.uint32(), options, message.b);
                     break;
+                case /* bool function_changes */ 3:
+                    message.functionChanges = reader.bool();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -1499,6 +1946,9 @@ class DiffProfile$Type extends MessageType<DiffProfile> {
         /* parca.query.v1alpha1.ProfileDiffSelection b = 2; */
         if (message.b)
             ProfileDiffSelection.internalBinaryWrite(message.b, writer.tag(2, WireType.LengthDelimited).fork(), options).join();
+        /* bool function_changes = 3; */
+        if (message.functionChanges !== false)
+            writer.tag(3, WireType.Varint).bool(message.functionChanges);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -1584,11 +2034,21 @@ class QueryRequest$Type extends MessageType<QueryRequest> {
             { no: 2, name: "diff", kind: "message", oneof: "options", T: () => DiffProfile },
             { no: 3, name: "merge", kind: "message", oneof: "options", T: () => MergeProfile },
             { no: 4, name: "single", kind: "message", oneof: "options", T: () => SingleProfile },
-            { no: 5, name: "report_type", kind: "enum", T: () => ["parca.query.v1alpha1.QueryRequest.ReportType", QueryRequest_ReportType, "REPORT_TYPE_"] }
+            { no: 6, name: "trace", kind: "message", oneof: "options", T: () => TraceProfile },
+            { no: 9, name: "union", kind: "message", oneof: "options", T: () => UnionProfile },
+            { no: 5, name: "report_type", kind: "enum", T: () => ["parca.query.v1alpha1.QueryRequest.ReportType", QueryRequest_ReportType, "REPORT_TYPE_"] },
+            { no: 7, name: "subtree", kind: "message", T: () => Subtree },
+            { no: 8, name: "percent_only", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 10, name: "primary_sample_type", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 11, name: "leaves_only", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 12, name: "quarantined", kind: "scalar", T: 8 /*ScalarType.BOOL*/ },
+            { no: 13, name: "focus", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 14, name: "ignore", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 15, name: "show_from", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
         ]);
     }
     create(value?: PartialMessage<QueryRequest>): QueryRequest {
-        const message = { mode: 0, options: { oneofKind: undefined }, reportType: 0 };
+        const message = { mode: 0, options: { oneofKind: undefined }, reportType: 0, percentOnly: false, primarySampleType: false, leavesOnly: false, quarantined: false, focus: "", ignore: "", showFrom: "" };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<QueryRequest>(this, message, value);
@@ -1620,9 +2080,45 @@ class QueryRequest$Type extends MessageType<QueryRequest> {
                         single: SingleProfile.internalBinaryRead(reader, reader.uint32(), options, (message.options as any).single)
                     };
                     break;
+                case /* parca.query.v1alpha1.TraceProfile trace */ 6:
+                    message.options = {
+                        oneofKind: "trace",
+                        trace: TraceProfile.internalBinaryRead(reader, reader.uint32(), options, (message.options as any).trace)
+                    };
+                    break;
+                case /* parca.query.v1alpha1.UnionProfile union */ 9:
+                    message.options = {
+                        oneofKind: "union",
+                        union: UnionProfile.internalBinaryRead(reader, reader.uint32(), options, (message.options as any).union)
+                    };
+                    break;
                 case /* parca.query.v1alpha1.QueryRequest.ReportType report_type */ 5:
                     message.reportType = reader.int32();
                     break;
+                case /* parca.query.v1alpha1.Subtree subtree */ 7:
+                    message.subtree = Subtree.internalBinaryRead(reader, reader.uint32(), options, message.subtree);
+                    break;
+                case /* bool percent_only */ 8:
+                    message.percentOnly = reader.bool();
+                    break;
+                case /* bool primary_sample_type */ 10:
+                    message.primarySampleType = reader.bool();
+                    break;
+                case /* bool leaves_only */ 11:
+                    message.leavesOnly = reader.bool();
+                    break;
+                case /* bool quarantined */ 12:
+                    message.quarantined = reader.bool();
+                    break;
+                case /* string focus */ 13:
+                    message.focus = reader.string();
+                    break;
+                case /* string ignore */ 14:
+                    message.ignore = reader.string();
+                    break;
+                case /* string show_from */ 15:
+                    message.showFrom = reader.string();
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -1647,9 +2143,39 @@ class QueryRequest$Type extends MessageType<QueryRequest> {
         /* parca.query.v1alpha1.SingleProfile single = 4; */
         if (message.options.oneofKind === "single")
             SingleProfile.internalBinaryWrite(message.options.single, writer.tag(4, WireType.LengthDelimited).fork(), options).join();
+        /* parca.query.v1alpha1.TraceProfile trace = 6; */
+        if (message.options.oneofKind === "trace")
+            TraceProfile.internalBinaryWrite(message.options.trace, writer.tag(6, WireType.LengthDelimited).fork(), options).join();
+        /* parca.query.v1alpha1.UnionProfile union = 9; */
+        if (message.options.oneofKind === "union")
+            UnionProfile.internalBinaryWrite(message.options.union, writer.tag(9, WireType.LengthDelimited).fork(), options).join();
         /* parca.query.v1alpha1.QueryRequest.ReportType report_type = 5; */
         if (message.reportType !== 0)
             writer.tag(5, WireType.Varint).int32(message.reportType);
+        /* parca.query.v1alpha1.Subtree subtree = 7; */
+        if (message.subtree)
+            Subtree.internalBinaryWrite(message.subtree, writer.tag(7, WireType.LengthDelimited).fork(), options).join();
+        /* bool percent_only = 8; */
+        if (message.percentOnly !== false)
+            writer.tag(8, WireType.Varint).bool(message.percentOnly);
+        /* bool primary_sample_type = 10; */
+        if (message.primarySampleType !== false)
+            writer.tag(10, WireType.Varint).bool(message.primarySampleType);
+        /* bool leaves_only = 11; */
+        if (message.leavesOnly !== false)
+            writer.tag(11, WireType.Varint).bool(message.leavesOnly);
+        /* bool quarantined = 12; */
+        if (message.quarantined !== false)
+            writer.tag(12, WireType.Varint).bool(message.quarantined);
+        /* string focus = 13; */
+        if (message.focus !== "")
+            writer.tag(13, WireType.LengthDelimited).string(message.focus);
+        /* string ignore = 14; */
+        if (message.ignore !== "")
+            writer.tag(14, WireType.LengthDelimited).string(message.ignore);
+        /* string show_from = 15; */
+        if (message.showFrom !== "")
+            writer.tag(15, WireType.LengthDelimited).string(message.showFrom);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -1661,6 +2187,60 @@ class QueryRequest$Type extends MessageType<QueryRequest> {
  */
 export const QueryRequest = new QueryRequest$Type();
 // @generated message type with reflection information, may provide speed optimized methods
+class Subtree$Type extends MessageType<Subtree> {
+    constructor() {
+        super("parca.query.v1alpha1.Subtree", [
+            { no: 1, name: "function_name", kind: "scalar", T: 9 /*ScalarType.STRING*/ },
+            { no: 2, name: "mode", kind: "enum", T: () => ["parca.query.v1alpha1.Subtree.Mode", Subtree_Mode, "MODE_"] }
+        ]);
+    }
+    create(value?: PartialMessage<Subtree>): Subtree {
+        const message = { functionName: "", mode: 0 };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<Subtree>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: Subtree): Subtree {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* string function_name */ 1:
+                    message.functionName = reader.string();
+                    break;
+                case /* parca.query.v1alpha1.Subtree.Mode mode */ 2:
+                    message.mode = reader.int32();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: Subtree, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* string function_name = 1; */
+        if (message.functionName !== "")
+            writer.tag(1, WireType.LengthDelimited).string(message.functionName);
+        /* parca.query.v1alpha1.Subtree.Mode mode = 2; */
+        if (message.mode !== 0)
+            writer.tag(2, WireType.Varint).int32(message.mode);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.query.v1alpha1.Subtree
+ */
+export const Subtree = new Subtree$Type();
+// @generated message type with reflection information, may provide speed optimized methods
 class Top$Type extends MessageType<Top> {
     constructor() {
         super("parca.query.v1alpha1.Top", [
@@ -2000,11 +2580,12 @@ class FlamegraphNode$Type extends MessageType<FlamegraphNode> {
             { no: 1, name: "meta", kind: "message", T: () => FlamegraphNodeMeta },
             { no: 2, name: "cumulative", kind: "scalar", T: 3 /*ScalarType.INT64*/ },
             { no: 3, name: "diff", kind: "scalar", T: 3 /*ScalarType.INT64*/ },
-            { no: 4, name: "children", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => FlamegraphNode }
+            { no: 4, name: "children", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => FlamegraphNode },
+            { no: 5, name: "sources", kind: "scalar", repeat: 1 /*RepeatType.PACKED*/, T: 5 /*ScalarType.INT32*/ }
         ]);
     }
     create(value?: PartialMessage<FlamegraphNode>): FlamegraphNode {
-        const message = { cumulative: "0", diff: "0", children: [] };
+        const message = { cumulative: "0", diff: "0", children: [], sources: [] };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<FlamegraphNode>(this, message, value);
@@ -2027,6 +2608,13 @@ class FlamegraphNode$Type extends MessageType<FlamegraphNode> {
                 case /* repeated parca.query.v1alpha1.FlamegraphNode children */ 4:
                     message.children.push(FlamegraphNode.internalBinaryRead(reader, reader.uint32(), options));
                     break;
+                case /* repeated int32 sources */ 5:
+                    if (wireType === WireType.LengthDelimited)
+                        for (let e = reader.int32() + reader.pos; reader.pos < e;)
+                            message.sources.push(reader.int32());
+                    else
+                        message.sources.push(reader.int32());
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -2051,6 +2639,13 @@ class FlamegraphNode$Type extends MessageType<FlamegraphNode> {
         /* repeated parca.query.v1alpha1.FlamegraphNode children = 4; */
         for (let i = 0; i < message.children.length; i++)
             FlamegraphNode.internalBinaryWrite(message.children[i], writer.tag(4, WireType.LengthDelimited).fork(), options).join();
+        /* repeated int32 sources = 5; */
+        if (message.sources.length) {
+            writer.tag(5, WireType.LengthDelimited).fork();
+            for (let i = 0; i < message.sources.length; i++)
+                writer.int32(message.sources[i]);
+            writer.join();
+        }
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -2401,11 +2996,13 @@ class QueryResponse$Type extends MessageType<QueryResponse> {
             { no: 5, name: "flamegraph", kind: "message", oneof: "report", T: () => Flamegraph },
             { no: 6, name: "pprof", kind: "scalar", oneof: "report", T: 12 /*ScalarType.BYTES*/ },
             { no: 7, name: "top", kind: "message", oneof: "report", T: () => Top },
-            { no: 8, name: "callgraph", kind: "message", oneof: "report", T: () => Callgraph }
+            { no: 8, name: "callgraph", kind: "message", oneof: "report", T: () => Callgraph },
+            { no: 9, name: "function_changes", kind: "message", T: () => FunctionChanges },
+            { no: 10, name: "warnings", kind: "scalar", repeat: 2 /*RepeatType.UNPACKED*/, T: 9 /*ScalarType.STRING*/ }
         ]);
     }
     create(value?: PartialMessage<QueryResponse>): QueryResponse {
-        const message = { report: { oneofKind: undefined } };
+        const message = { report: { oneofKind: undefined }, warnings: [] };
         globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
         if (value !== undefined)
             reflectionMergePartial<QueryResponse>(this, message, value);
@@ -2440,6 +3037,12 @@ class QueryResponse$Type extends MessageType<QueryResponse> {
                         callgraph: Callgraph.internalBinaryRead(reader, reader.uint32(), options, (message.report as any).callgraph)
                     };
                     break;
+                case /* parca.query.v1alpha1.FunctionChanges function_changes */ 9:
+                    message.functionChanges = FunctionChanges.internalBinaryRead(reader, reader.uint32(), options, message.functionChanges);
+                    break;
+                case /* repeated string warnings */ 10:
+                    message.warnings.push(reader.string());
+                    break;
                 default:
                     let u = options.readUnknownField;
                     if (u === "throw")
@@ -2464,6 +3067,12 @@ class QueryResponse$Type extends MessageType<QueryResponse> {
         /* parca.query.v1alpha1.Callgraph callgraph = 8; */
         if (message.report.oneofKind === "callgraph")
             Callgraph.internalBinaryWrite(message.report.callgraph, writer.tag(8, WireType.LengthDelimited).fork(), options).join();
+        /* parca.query.v1alpha1.FunctionChanges function_changes = 9; */
+        if (message.functionChanges)
+            FunctionChanges.internalBinaryWrite(message.functionChanges, writer.tag(9, WireType.LengthDelimited).fork(), options).join();
+        /* repeated string warnings = 10; */
+        for (let i = 0; i < message.warnings.length; i++)
+            writer.tag(10, WireType.LengthDelimited).string(message.warnings[i]);
         let u = options.writeUnknownFields;
         if (u !== false)
             (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
@@ -2475,6 +3084,128 @@ class QueryResponse$Type extends MessageType<QueryResponse> {
  */
 export const QueryResponse = new QueryResponse$Type();
 // @generated message type with reflection information, may provide speed optimized methods
+class FunctionChanges$Type extends MessageType<FunctionChanges> {
+    constructor() {
+        super("parca.query.v1alpha1.FunctionChanges", [
+            { no: 1, name: "added", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => FunctionChange },
+            { no: 2, name: "removed", kind: "message", repeat: 1 /*RepeatType.PACKED*/, T: () => FunctionChange },
+            { no: 3, name: "unit", kind: "scalar", T: 9 /*ScalarType.STRING*/ }
+        ]);
+    }
+    create(value?: PartialMessage<FunctionChanges>): FunctionChanges {
+        const message = { added: [], removed: [], unit: "" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<FunctionChanges>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: FunctionChanges): FunctionChanges {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* repeated parca.query.v1alpha1.FunctionChange added */ 1:
+                    message.added.push(FunctionChange.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
+                case /* repeated parca.query.v1alpha1.FunctionChange removed */ 2:
+                    message.removed.push(FunctionChange.internalBinaryRead(reader, reader.uint32(), options));
+                    break;
+                case /* string unit */ 3:
+                    message.unit = reader.string();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: FunctionChanges, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* repeated parca.query.v1alpha1.FunctionChange added = 1; */
+        for (let i = 0; i < message.added.length; i++)
+            FunctionChange.internalBinaryWrite(message.added[i], writer.tag(1, WireType.LengthDelimited).fork(), options).join();
+        /* repeated parca.query.v1alpha1.FunctionChange removed = 2; */
+        for (let i = 0; i < message.removed.length; i++)
+            FunctionChange.internalBinaryWrite(message.removed[i], writer.tag(2, WireType.LengthDelimited).fork(), options).join();
+        /* string unit = 3; */
+        if (message.unit !== "")
+            writer.tag(3, WireType.LengthDelimited).string(message.unit);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.query.v1alpha1.FunctionChanges
+ */
+export const FunctionChanges = new FunctionChanges$Type();
+// @generated message type with reflection information, may provide speed optimized methods
+class FunctionChange$Type extends MessageType<FunctionChange> {
+    constructor() {
+        super("parca.query.v1alpha1.FunctionChange", [
+            { no: 1, name: "function", kind: "message", T: () => Function },
+            { no: 2, name: "cumulative", kind: "scalar", T: 3 /*ScalarType.INT64*/ },
+            { no: 3, name: "flat", kind: "scalar", T: 3 /*ScalarType.INT64*/ }
+        ]);
+    }
+    create(value?: PartialMessage<FunctionChange>): FunctionChange {
+        const message = { cumulative: "0", flat: "0" };
+        globalThis.Object.defineProperty(message, MESSAGE_TYPE, { enumerable: false, value: this });
+        if (value !== undefined)
+            reflectionMergePartial<FunctionChange>(this, message, value);
+        return message;
+    }
+    internalBinaryRead(reader: IBinaryReader, length: number, options: BinaryReadOptions, target?: FunctionChange): FunctionChange {
+        let message = target ?? this.create(), end = reader.pos + length;
+        while (reader.pos < end) {
+            let [fieldNo, wireType] = reader.tag();
+            switch (fieldNo) {
+                case /* parca.metastore.v1alpha1.Function function */ 1:
+                    message.function = Function.internalBinaryRead(reader, reader.uint32(), options, message.function);
+                    break;
+                case /* int64 cumulative */ 2:
+                    message.cumulative = reader.int64().toString();
+                    break;
+                case /* int64 flat */ 3:
+                    message.flat = reader.int64().toString();
+                    break;
+                default:
+                    let u = options.readUnknownField;
+                    if (u === "throw")
+                        throw new globalThis.Error(`Unknown field ${fieldNo} (wire type ${wireType}) for ${this.typeName}`);
+                    let d = reader.skip(wireType);
+                    if (u !== false)
+                        (u === true ? UnknownFieldHandler.onRead : u)(this.typeName, message, fieldNo, wireType, d);
+            }
+        }
+        return message;
+    }
+    internalBinaryWrite(message: FunctionChange, writer: IBinaryWriter, options: BinaryWriteOptions): IBinaryWriter {
+        /* parca.metastore.v1alpha1.Function function = 1; */
+        if (message.function)
+            Function.internalBinaryWrite(message.function, writer.tag(1, WireType.LengthDelimited).fork(), options).join();
+        /* int64 cumulative = 2; */
+        if (message.cumulative !== "0")
+            writer.tag(2, WireType.Varint).int64(message.cumulative);
+        /* int64 flat = 3; */
+        if (message.flat !== "0")
+            writer.tag(3, WireType.Varint).int64(message.flat);
+        let u = options.writeUnknownFields;
+        if (u !== false)
+            (u == true ? UnknownFieldHandler.onWrite : u)(this.typeName, message, writer);
+        return writer;
+    }
+}
+/**
+ * @generated MessageType for protobuf message parca.query.v1alpha1.FunctionChange
+ */
+export const FunctionChange = new FunctionChange$Type();
+// @generated message type with reflection information, may provide speed optimized methods
 class SeriesRequest$Type extends MessageType<SeriesRequest> {
     constructor() {
         super("parca.query.v1alpha1.SeriesRequest", [
@@ -39,6 +39,10 @@ describe('valueFormatter', () => {
     expect(valueFormatter(9654321, 'bytes', 1)).toBe('9.7MB');
   });
 
+  it('formats the value passed in basis points', () => {
+    expect(valueFormatter(1234, 'basis_points', 1)).toBe('12.3%');
+  });
+
   it('formats a negative value passed correctly', () => {
     expect(valueFormatter(-987654321, 'bytes', 1)).toBe('-987.7MB');
   });
@@ -144,10 +144,15 @@ const unitsInCount = {
   exa: {multiplier: 1e18, symbol: 'E'},
 };
 
+const unitsInBasisPoints = {
+  percent: {multiplier: 100, symbol: '%'},
+};
+
 const knownValueFormatters = {
   bytes: unitsInBytes,
   nanoseconds: unitsInTime,
   count: unitsInCount,
+  basis_points: unitsInBasisPoints,
 };
 
 export const valueFormatter = (num: number, unit: string, digits: number): string => {